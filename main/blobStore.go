@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/appengine/file"
+)
+
+// errBlobNotFound is returned by a BlobStore when nothing is stored at a key
+var errBlobNotFound = errors.New("Blob not found")
+
+// BlobAttrs describes a stored blob
+type BlobAttrs struct {
+	Key         string
+	ContentType string
+	Size        int64
+	Updated     time.Time
+}
+
+// BlobWriter writes a blob, which is only saved once closed
+type BlobWriter interface {
+	io.Writer
+	// Close saves what was written
+	Close() error
+	// Abort discards what was written, leaving nothing at the key
+	Abort()
+}
+
+// BlobStore keeps content under keys, in Cloud Storage, an S3-compatible service, or a local directory
+type BlobStore interface {
+	Stat(ctx context.Context, key string) (*BlobAttrs, error)
+	Read(ctx context.Context, key string) (io.ReadCloser, error)
+	// ReadRange reads length bytes from offset, or to the end if length is negative
+	ReadRange(ctx context.Context, key string, offset int64, length int64) (io.ReadCloser, error)
+	NewWriter(ctx context.Context, key string, contentType string) (BlobWriter, error)
+	Copy(ctx context.Context, fromKey string, toKey string) error
+	Delete(ctx context.Context, key string) error
+	// ListPage calls each with up to limit blobs whose keys start with the prefix, stopping at the first error it returns.
+	// It starts from a page token it returned before, or from the first blob if that is empty,
+	// and returns the token of the next page, which is empty after the last page.
+	ListPage(ctx context.Context, prefix string, pageToken string, limit int, each func(attrs *BlobAttrs) error) (nextPageToken string, err error)
+}
+
+// writeBlob saves all of r at the key
+func writeBlob(ctx context.Context, store BlobStore, key string, contentType string, r io.Reader) error {
+	writer, err := store.NewWriter(ctx, key, contentType)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(writer, r)
+	if err != nil {
+		writer.Abort()
+		return err
+	}
+
+	return writer.Close()
+}
+
+// checkBlobKey refuses keys that could refer outside of the store, such as in a local directory
+func checkBlobKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") {
+		return fmt.Errorf("Invalid blob key %q", key)
+	}
+
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return fmt.Errorf("Invalid blob key %q", key)
+		}
+	}
+
+	return nil
+}
+
+var sharedBlobStore struct {
+	sync.Mutex
+	store BlobStore
+}
+
+// SharedBlobStore returns the store set with STORAGE_BACKEND, which is made once and then used by every request.
+// If making it fails, the next request tries again.
+func SharedBlobStore(ctx context.Context) (BlobStore, error) {
+	sharedBlobStore.Lock()
+	defer sharedBlobStore.Unlock()
+
+	if sharedBlobStore.store == nil {
+		store, err := newBlobStoreFromEnv(ctx)
+		if err != nil {
+			return nil, err
+		}
+		sharedBlobStore.store = store
+	}
+
+	return sharedBlobStore.store, nil
+}
+
+// newBlobStoreFromEnv makes the store for STORAGE_BACKEND, which is gcs (the default), s3, or local.
+// The context only finds the default bucket, as the store outlives the request.
+func newBlobStoreFromEnv(ctx context.Context) (BlobStore, error) {
+	backend := strings.ToLower(strings.TrimSpace(os.Getenv("STORAGE_BACKEND")))
+	bucket := strings.TrimSpace(os.Getenv("STORAGE_BUCKET"))
+
+	switch backend {
+	case "", "gcs":
+		if bucket == "" {
+			defaultBucket, err := file.DefaultBucketName(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("STORAGE_BUCKET is not set, and there is no default bucket: %v", err)
+			}
+			bucket = defaultBucket
+		}
+		return newGCSBlobStore(bucket)
+	case "s3":
+		if bucket == "" {
+			return nil, errors.New("STORAGE_BUCKET must be set to use S3 storage")
+		}
+		return newS3BlobStore(bucket, os.Getenv("S3_REGION"), os.Getenv("S3_ENDPOINT"))
+	case "local":
+		dir := strings.TrimSpace(os.Getenv("STORAGE_LOCAL_DIR"))
+		if dir == "" {
+			dir = ".storage"
+		}
+		return newLocalBlobStore(dir)
+	}
+
+	return nil, fmt.Errorf("STORAGE_BACKEND must be gcs, s3, or local, not %q", backend)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// gcsBlobStore keeps blobs as objects in a Cloud Storage bucket.
+// Only the bucket is kept, as App Engine’s credentials and URL Fetch only work within a request,
+// so each call makes a client from its request’s context.
+type gcsBlobStore struct {
+	bucket string
+}
+
+func newGCSBlobStore(bucket string) (*gcsBlobStore, error) {
+	return &gcsBlobStore{
+		bucket: bucket,
+	}, nil
+}
+
+func (store *gcsBlobStore) bucketHandle(ctx context.Context) (*storage.BucketHandle, error) {
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot make storage client: %v", err)
+	}
+
+	return client.Bucket(store.bucket), nil
+}
+
+func (store *gcsBlobStore) object(ctx context.Context, key string) (*storage.ObjectHandle, error) {
+	bucket, err := store.bucketHandle(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return bucket.Object(key), nil
+}
+
+func gcsBlobError(err error) error {
+	if err == storage.ErrObjectNotExist {
+		return errBlobNotFound
+	}
+	return err
+}
+
+func (store *gcsBlobStore) Stat(ctx context.Context, key string) (*BlobAttrs, error) {
+	object, err := store.object(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs, err := object.Attrs(ctx)
+	if err != nil {
+		return nil, gcsBlobError(err)
+	}
+
+	return &BlobAttrs{
+		Key:         key,
+		ContentType: attrs.ContentType,
+		Size:        attrs.Size,
+		Updated:     attrs.Updated,
+	}, nil
+}
+
+func (store *gcsBlobStore) Read(ctx context.Context, key string) (io.ReadCloser, error) {
+	object, err := store.object(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := object.NewReader(ctx)
+	if err != nil {
+		return nil, gcsBlobError(err)
+	}
+	return reader, nil
+}
+
+func (store *gcsBlobStore) ReadRange(ctx context.Context, key string, offset int64, length int64) (io.ReadCloser, error) {
+	object, err := store.object(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := object.NewRangeReader(ctx, offset, length)
+	if err != nil {
+		return nil, gcsBlobError(err)
+	}
+	return reader, nil
+}
+
+// gcsBlobWriter abandons the upload if its context is cancelled before it is closed
+type gcsBlobWriter struct {
+	*storage.Writer
+	cancel context.CancelFunc
+}
+
+func (w *gcsBlobWriter) Close() error {
+	defer w.cancel()
+	return w.Writer.Close()
+}
+
+func (w *gcsBlobWriter) Abort() {
+	w.cancel()
+}
+
+func (store *gcsBlobStore) NewWriter(ctx context.Context, key string, contentType string) (BlobWriter, error) {
+	object, err := store.object(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	writeCtx, cancel := context.WithCancel(ctx)
+
+	writer := object.NewWriter(writeCtx)
+	writer.ContentType = contentType
+
+	return &gcsBlobWriter{Writer: writer, cancel: cancel}, nil
+}
+
+func (store *gcsBlobStore) Copy(ctx context.Context, fromKey string, toKey string) error {
+	bucket, err := store.bucketHandle(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = bucket.Object(toKey).CopierFrom(bucket.Object(fromKey)).Run(ctx)
+	return gcsBlobError(err)
+}
+
+func (store *gcsBlobStore) Delete(ctx context.Context, key string) error {
+	object, err := store.object(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	return gcsBlobError(object.Delete(ctx))
+}
+
+func (store *gcsBlobStore) ListPage(ctx context.Context, prefix string, pageToken string, limit int, each func(attrs *BlobAttrs) error) (string, error) {
+	bucket, err := store.bucketHandle(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	objects := bucket.Objects(ctx, &storage.Query{Prefix: prefix})
+
+	var page []*storage.ObjectAttrs
+	nextPageToken, err := iterator.NewPager(objects, limit, pageToken).NextPage(&page)
+	if err != nil {
+		return "", err
+	}
+
+	for _, attrs := range page {
+		err := each(&BlobAttrs{
+			Key:         attrs.Name,
+			ContentType: attrs.ContentType,
+			Size:        attrs.Size,
+			Updated:     attrs.Updated,
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return nextPageToken, nil
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// localBlobStore keeps blobs as files in a directory, for developing without a cloud bucket.
+// Content types are kept in a second tree of files beside the blobs.
+type localBlobStore struct {
+	blobsDir string
+	typesDir string
+}
+
+func newLocalBlobStore(dir string) (*localBlobStore, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &localBlobStore{
+		blobsDir: filepath.Join(dir, "blobs"),
+		typesDir: filepath.Join(dir, "types"),
+	}, nil
+}
+
+func (store *localBlobStore) paths(key string) (string, string, error) {
+	err := checkBlobKey(key)
+	if err != nil {
+		return "", "", err
+	}
+
+	keyPath := filepath.FromSlash(key)
+	return filepath.Join(store.blobsDir, keyPath), filepath.Join(store.typesDir, keyPath), nil
+}
+
+func localBlobError(err error) error {
+	if os.IsNotExist(err) {
+		return errBlobNotFound
+	}
+	return err
+}
+
+func (store *localBlobStore) Stat(ctx context.Context, key string) (*BlobAttrs, error) {
+	blobPath, typePath, err := store.paths(key)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(blobPath)
+	if err != nil {
+		return nil, localBlobError(err)
+	}
+
+	contentType, _ := ioutil.ReadFile(typePath)
+
+	return &BlobAttrs{
+		Key:         key,
+		ContentType: string(contentType),
+		Size:        info.Size(),
+		Updated:     info.ModTime(),
+	}, nil
+}
+
+func (store *localBlobStore) Read(ctx context.Context, key string) (io.ReadCloser, error) {
+	blobPath, _, err := store.paths(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(blobPath)
+	if err != nil {
+		return nil, localBlobError(err)
+	}
+	return f, nil
+}
+
+// localBlobRangeReader reads part of a blob’s file
+type localBlobRangeReader struct {
+	io.Reader
+	io.Closer
+}
+
+func (store *localBlobStore) ReadRange(ctx context.Context, key string, offset int64, length int64) (io.ReadCloser, error) {
+	reader, err := store.Read(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	f := reader.(*os.File)
+	_, err = f.Seek(offset, io.SeekStart)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if length < 0 {
+		return f, nil
+	}
+	return &localBlobRangeReader{Reader: io.LimitReader(f, length), Closer: f}, nil
+}
+
+// localBlobWriter writes to a temporary file that is moved into place once closed
+type localBlobWriter struct {
+	*os.File
+	blobPath    string
+	typePath    string
+	contentType string
+}
+
+func (w *localBlobWriter) Close() error {
+	err := w.File.Close()
+	if err != nil {
+		os.Remove(w.File.Name())
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(w.typePath), 0700)
+	if err == nil {
+		err = ioutil.WriteFile(w.typePath, []byte(w.contentType), 0600)
+	}
+	if err == nil {
+		err = os.Rename(w.File.Name(), w.blobPath)
+	}
+	if err != nil {
+		os.Remove(w.File.Name())
+	}
+	return err
+}
+
+func (w *localBlobWriter) Abort() {
+	w.File.Close()
+	os.Remove(w.File.Name())
+}
+
+func (store *localBlobStore) NewWriter(ctx context.Context, key string, contentType string) (BlobWriter, error) {
+	blobPath, typePath, err := store.paths(key)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(filepath.Dir(blobPath), 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(blobPath), ".upload-")
+	if err != nil {
+		return nil, err
+	}
+
+	return &localBlobWriter{
+		File:        f,
+		blobPath:    blobPath,
+		typePath:    typePath,
+		contentType: contentType,
+	}, nil
+}
+
+func (store *localBlobStore) Copy(ctx context.Context, fromKey string, toKey string) error {
+	attrs, err := store.Stat(ctx, fromKey)
+	if err != nil {
+		return err
+	}
+
+	reader, err := store.Read(ctx, fromKey)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	return writeBlob(ctx, store, toKey, attrs.ContentType, reader)
+}
+
+func (store *localBlobStore) Delete(ctx context.Context, key string) error {
+	blobPath, typePath, err := store.paths(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(blobPath)
+	if err != nil {
+		return localBlobError(err)
+	}
+
+	os.Remove(typePath)
+	return nil
+}
+
+// ListPage pages through the keys in order, with the last key listed as the token of the next page
+func (store *localBlobStore) ListPage(ctx context.Context, prefix string, pageToken string, limit int, each func(attrs *BlobAttrs) error) (string, error) {
+	var keys []string
+	err := filepath.Walk(store.blobsDir, func(path string, info os.FileInfo, err error) error {
+		// Nothing has been stored yet, or the blob was deleted while listing
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// Files still being written are not blobs yet
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".upload-") {
+			return nil
+		}
+
+		relativePath, err := filepath.Rel(store.blobsDir, path)
+		if err != nil {
+			return err
+		}
+
+		key := filepath.ToSlash(relativePath)
+		if strings.HasPrefix(key, prefix) && key > pageToken {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// Directories are walked in order of their names, which is not quite the order of keys
+	sort.Strings(keys)
+
+	nextPageToken := ""
+	if len(keys) > limit {
+		keys = keys[:limit]
+		nextPageToken = keys[limit-1]
+	}
+
+	for _, key := range keys {
+		attrs, err := store.Stat(ctx, key)
+		if err == errBlobNotFound {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+
+		err = each(attrs)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return nextPageToken, nil
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"google.golang.org/appengine/urlfetch"
+)
+
+// s3BlobStore keeps blobs as objects in an S3 bucket, or with another service such as MinIO that has the same API
+type s3BlobStore struct {
+	sess   *session.Session
+	bucket string
+}
+
+// newS3BlobStore uses AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, and the endpoint if it is not AWS
+func newS3BlobStore(bucket string, region string, endpoint string) (*s3BlobStore, error) {
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	config := &aws.Config{
+		Region:      aws.String(region),
+		Credentials: credentials.NewEnvCredentials(),
+	}
+	if endpoint != "" {
+		config.Endpoint = aws.String(endpoint)
+		// MinIO and similar services do not have a subdomain per bucket
+		config.S3ForcePathStyle = aws.Bool(true)
+	}
+
+	sess, err := session.NewSession(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &s3BlobStore{
+		sess:   sess,
+		bucket: bucket,
+	}, nil
+}
+
+// service uses the request’s context to make HTTP requests, while sharing the session’s configuration
+func (store *s3BlobStore) service(ctx context.Context) *s3.S3 {
+	return s3.New(store.sess, &aws.Config{HTTPClient: urlfetch.Client(ctx)})
+}
+
+func s3BlobError(err error) error {
+	if awsErr, ok := err.(awserr.Error); ok {
+		switch awsErr.Code() {
+		case "NoSuchKey", "NotFound":
+			return errBlobNotFound
+		}
+	}
+	return err
+}
+
+func (store *s3BlobStore) Stat(ctx context.Context, key string) (*BlobAttrs, error) {
+	output, err := store.service(ctx).HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(store.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, s3BlobError(err)
+	}
+
+	attrs := &BlobAttrs{
+		Key:         key,
+		ContentType: aws.StringValue(output.ContentType),
+		Size:        aws.Int64Value(output.ContentLength),
+	}
+	if output.LastModified != nil {
+		attrs.Updated = *output.LastModified
+	}
+
+	return attrs, nil
+}
+
+func (store *s3BlobStore) Read(ctx context.Context, key string) (io.ReadCloser, error) {
+	return store.ReadRange(ctx, key, 0, -1)
+}
+
+func (store *s3BlobStore) ReadRange(ctx context.Context, key string, offset int64, length int64) (io.ReadCloser, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(store.bucket),
+		Key:    aws.String(key),
+	}
+	if length >= 0 {
+		input.Range = aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	} else if offset > 0 {
+		input.Range = aws.String(fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	output, err := store.service(ctx).GetObject(input)
+	if err != nil {
+		return nil, s3BlobError(err)
+	}
+
+	return output.Body, nil
+}
+
+// errS3BlobWriteAborted ends an upload that was aborted
+var errS3BlobWriteAborted = errors.New("Upload was aborted")
+
+// s3BlobWriter streams the blob to S3 as it is written, holding one part of a multipart upload in memory at a time
+type s3BlobWriter struct {
+	pipe *io.PipeWriter
+	done chan error
+}
+
+func (w *s3BlobWriter) Write(p []byte) (int, error) {
+	return w.pipe.Write(p)
+}
+
+func (w *s3BlobWriter) Close() error {
+	w.pipe.Close()
+	return <-w.done
+}
+
+// Abort fails the upload, so a multipart upload’s parts are deleted and nothing is put at the key
+func (w *s3BlobWriter) Abort() {
+	w.pipe.CloseWithError(errS3BlobWriteAborted)
+	<-w.done
+}
+
+func (store *s3BlobStore) NewWriter(ctx context.Context, key string, contentType string) (BlobWriter, error) {
+	uploader := s3manager.NewUploaderWithClient(store.service(ctx), func(u *s3manager.Uploader) {
+		u.Concurrency = 1
+	})
+
+	reader, writer := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		_, err := uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+			Bucket:      aws.String(store.bucket),
+			Key:         aws.String(key),
+			Body:        reader,
+			ContentType: aws.String(contentType),
+		})
+		// Writes fail rather than wait for an upload that has stopped
+		if err != nil {
+			reader.CloseWithError(err)
+		}
+		done <- err
+	}()
+
+	return &s3BlobWriter{pipe: writer, done: done}, nil
+}
+
+func (store *s3BlobStore) Copy(ctx context.Context, fromKey string, toKey string) error {
+	_, err := store.service(ctx).CopyObject(&s3.CopyObjectInput{
+		Bucket:     aws.String(store.bucket),
+		CopySource: aws.String(url.PathEscape(store.bucket) + "/" + (&url.URL{Path: fromKey}).EscapedPath()),
+		Key:        aws.String(toKey),
+	})
+	return s3BlobError(err)
+}
+
+func (store *s3BlobStore) Delete(ctx context.Context, key string) error {
+	_, err := store.service(ctx).DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(store.bucket),
+		Key:    aws.String(key),
+	})
+	return s3BlobError(err)
+}
+
+func (store *s3BlobStore) ListPage(ctx context.Context, prefix string, pageToken string, limit int, each func(attrs *BlobAttrs) error) (string, error) {
+	input := &s3.ListObjectsV2Input{
+		Bucket:  aws.String(store.bucket),
+		Prefix:  aws.String(prefix),
+		MaxKeys: aws.Int64(int64(limit)),
+	}
+	if pageToken != "" {
+		input.ContinuationToken = aws.String(pageToken)
+	}
+
+	output, err := store.service(ctx).ListObjectsV2(input)
+	if err != nil {
+		return "", s3BlobError(err)
+	}
+
+	for _, object := range output.Contents {
+		// Listing does not include content types
+		attrs := &BlobAttrs{
+			Key:  aws.StringValue(object.Key),
+			Size: aws.Int64Value(object.Size),
+		}
+		if object.LastModified != nil {
+			attrs.Updated = *object.LastModified
+		}
+
+		err := each(attrs)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if !aws.BoolValue(output.IsTruncated) {
+		return "", nil
+	}
+	return aws.StringValue(output.NextContinuationToken), nil
+}
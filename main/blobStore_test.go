@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSharedBlobStoreRetriesAfterError(t *testing.T) {
+	defer os.Unsetenv("STORAGE_BACKEND")
+	defer os.Unsetenv("STORAGE_LOCAL_DIR")
+	defer func() { sharedBlobStore.store = nil }()
+
+	os.Setenv("STORAGE_BACKEND", "floppy")
+	_, err := SharedBlobStore(context.Background())
+	if err == nil {
+		t.Fatal("SharedBlobStore() succeeded with an unknown backend")
+	}
+
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Setenv("STORAGE_BACKEND", "local")
+	os.Setenv("STORAGE_LOCAL_DIR", dir)
+	store, err := SharedBlobStore(context.Background())
+	if err != nil {
+		t.Fatalf("SharedBlobStore() = %v once the backend was fixed", err)
+	}
+
+	again, err := SharedBlobStore(context.Background())
+	if err != nil || again != store {
+		t.Errorf("SharedBlobStore() made another store, want the first one shared")
+	}
+}
+
+func TestLocalBlobStoreListPage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := newLocalBlobStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	for _, key := range []string{"uploads/c", "uploads/a", "uploads/b/1", "uploads/b-2", "posts/d"} {
+		err := writeBlob(ctx, store, key, "text/plain", strings.NewReader(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var listed []string
+	pageToken := ""
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatalf("ListPage() did not finish, listed %v", listed)
+		}
+
+		pageToken, err = store.ListPage(ctx, "uploads/", pageToken, 2, func(attrs *BlobAttrs) error {
+			if attrs.Size != int64(len(attrs.Key)) {
+				t.Errorf("%s has size %d, want %d", attrs.Key, attrs.Size, len(attrs.Key))
+			}
+			listed = append(listed, attrs.Key)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("ListPage() = %v", err)
+		}
+		if pageToken == "" {
+			break
+		}
+	}
+
+	want := "uploads/a uploads/b-2 uploads/b/1 uploads/c"
+	if strings.Join(listed, " ") != want {
+		t.Errorf("Listed %v, want %s", listed, want)
+	}
+}
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"strings"
+	textTemplate "text/template"
 )
 
 // A Command can be run to see a result
@@ -11,12 +13,87 @@ type Command interface {
 	Run(ctx context.Context) (CommandResult, error)
 }
 
+// A PipedCommand can also be run with the result of the previous command in a pipeline
+type PipedCommand interface {
+	Command
+	RunWithInput(ctx context.Context, input CommandResult) (CommandResult, error)
+}
+
 type CommandParamVariables interface {
 	GitHubOAuthToken() string
+	GitLabOAuthToken() string
+	TrelloAPI() *TrelloAPI
+	FigmaAPI() *FigmaAPI
+}
+
+type commandParamVariablesKey struct{}
+
+// ContextWithCommandParamVariables lets commands use the viewer’s variables while running
+func ContextWithCommandParamVariables(ctx context.Context, commandParamVars CommandParamVariables) context.Context {
+	return context.WithValue(ctx, commandParamVariablesKey{}, commandParamVars)
+}
+
+// CommandParamVariablesFromContext returns the variables of the viewer running a command, if there are some
+func CommandParamVariablesFromContext(ctx context.Context) CommandParamVariables {
+	commandParamVars, _ := ctx.Value(commandParamVariablesKey{}).(CommandParamVariables)
+	return commandParamVars
+}
+
+// RunCommandInput parses and runs a /… command on behalf of the viewer with the provided variables
+func RunCommandInput(ctx context.Context, input string, commandParamVars CommandParamVariables) (CommandResult, error) {
+	command, err := ParseCommandInput(input, MakeCommandParamsPreprocessor(commandParamVars))
+	if err != nil {
+		return nil, err
+	}
+
+	return command.Run(ContextWithCommandParamVariables(ctx, commandParamVars))
+}
+
+// MakeCommandParamsPreprocessor makes a preprocessor for ParseCommandInput that fills in {{ .GitHubOAuthToken }}, {{ .GitLabOAuthToken }} etc
+func MakeCommandParamsPreprocessor(commandParamVars CommandParamVariables) func(string) (string, error) {
+	return func(params string) (string, error) {
+		t := textTemplate.New("commandParams")
+		t, err := t.Parse(params)
+		if err != nil {
+			return "", err
+		}
+
+		var buffer bytes.Buffer
+		err = t.Execute(&buffer, commandParamVars)
+		if err != nil {
+			return "", err
+		}
+
+		return buffer.String(), nil
+	}
 }
 
-// ParseCommandInput parses a /… command
+// usesServerCredentials is whether the command acts with the server’s own credentials, such as /aws with AWS_ACCESS_KEY_ID
+func usesServerCredentials(command Command) bool {
+	switch command := command.(type) {
+	case *AWSS3Command, *AWSS3ObjectCommand:
+		return true
+	case *PipelineCommand:
+		for _, stage := range command.stages {
+			if stage.command != nil && usesServerCredentials(stage.command) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ParseCommandInput parses a /… command, or a pipeline of commands separated by lines starting with `| /…`
 func ParseCommandInput(input string, preprocessParams func(string) (string, error)) (Command, error) {
+	stageInputs := splitPipelineStages(input)
+	if len(stageInputs) > 1 {
+		return parsePipelineCommand(stageInputs, preprocessParams)
+	}
+
+	return parseCommandStage(input, preprocessParams)
+}
+
+func parseCommandStage(input string, preprocessParams func(string) (string, error)) (Command, error) {
 	input = strings.TrimLeft(input, "/")
 	request := strings.SplitN(input, "\n", 2)
 
@@ -29,13 +106,17 @@ func ParseCommandInput(input string, preprocessParams func(string) (string, erro
 		params = request[1]
 	}
 
-	params, err := preprocessParams(params)
-	if err != nil {
-		return nil, err
-	}
-
 	commands := parseSubcommands(request[0])
 
+	// /template’s params are a template themselves, rendered later with the piped input
+	if len(commands) == 0 || commands[0] != "template" {
+		var err error
+		params, err = preprocessParams(params)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return parseCommand(commands, params)
 }
 
@@ -74,5 +155,33 @@ func parseCommand(commands []string, params string) (Command, error) {
 		return ParseGraphiqlCommand(commands[1:], params)
 	}
 
+	if commands[0] == "github" && len(commands) >= 2 {
+		return ParseGitHubCommand(commands[1:], params)
+	}
+
+	if commands[0] == "gitlab" && len(commands) >= 2 {
+		return ParseGitLabCommand(commands[1:], params)
+	}
+
+	if commands[0] == "trello" && len(commands) >= 2 {
+		return ParseTrelloCommand(commands[1:], params)
+	}
+
+	if commands[0] == "figma" && len(commands) >= 2 {
+		return ParseFigmaCommand(commands[1:], params)
+	}
+
+	if commands[0] == "csv" {
+		return ParseCSVCommand(commands[1:], params)
+	}
+
+	if commands[0] == "json" {
+		return ParseJSONCommand(commands[1:], params)
+	}
+
+	if commands[0] == "template" {
+		return ParseTemplateCommand(commands[1:], params)
+	}
+
 	return nil, fmt.Errorf("Unknown command %v", commands)
 }
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"html"
+	"strings"
 
 	"google.golang.org/appengine/urlfetch"
 
@@ -85,17 +87,28 @@ func (cmd *AWSS3Command) Run(ctx context.Context) (CommandResult, error) {
 		return nil, fmt.Errorf("Cannot list objects %v", err)
 	}
 
+	keys := make([]string, 0, len(output.Contents))
+
 	var htmlBuffer bytes.Buffer
 	htmlBuffer.WriteString(`<pre>`)
 
 	for _, o := range output.Contents {
-		htmlBuffer.WriteString(*o.Key)
+		keys = append(keys, *o.Key)
+		htmlBuffer.WriteString(html.EscapeString(*o.Key))
 		htmlBuffer.WriteString("<br>")
 		// fmt.Printf("* %s created on %s\n", aws.StringValue(b.Name), aws.TimeValue(b.CreationDate))
 	}
 	htmlBuffer.WriteString(`</pre>`)
 
 	result := DangerousHTMLCommandResultFromSafe(htmlBuffer.String())
+	result.SetPlainText(strings.Join(keys, "\n"))
+	result.SetJSON(&struct {
+		Bucket string   `json:"bucket"`
+		Keys   []string `json:"keys"`
+	}{
+		Bucket: cmd.Bucket,
+		Keys:   keys,
+	})
 
 	return result, nil
 }
@@ -153,12 +166,32 @@ func (cmd *AWSS3ObjectCommand) Run(ctx context.Context) (CommandResult, error) {
 		return nil, fmt.Errorf("Cannot get object '%s'", cmd.Key)
 	}
 
+	var bodyBuffer bytes.Buffer
+	_, err = bodyBuffer.ReadFrom(output.Body)
+	output.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read object '%s'", cmd.Key)
+	}
+	body := bodyBuffer.String()
+
 	var htmlBuffer bytes.Buffer
 	htmlBuffer.WriteString(`<pre>`)
-	htmlBuffer.ReadFrom(output.Body)
+	htmlBuffer.WriteString(html.EscapeString(body))
 	htmlBuffer.WriteString(`</pre>`)
 
 	result := DangerousHTMLCommandResultFromSafe(htmlBuffer.String())
+	result.SetPlainText(body)
+	result.SetJSON(&struct {
+		Bucket      string  `json:"bucket"`
+		Key         string  `json:"key"`
+		ContentType *string `json:"contentType"`
+		Body        string  `json:"body"`
+	}{
+		Bucket:      cmd.Bucket,
+		Key:         cmd.Key,
+		ContentType: output.ContentType,
+		Body:        body,
+	})
 
 	return result, nil
 }
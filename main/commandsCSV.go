@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// ParseCSVCommand parses a /csv … command
+func ParseCSVCommand(subcommands []string, params string) (Command, error) {
+	if len(subcommands) == 0 {
+		return ParseCSVTableCommand(params)
+	}
+
+	return nil, fmt.Errorf("Unknown csv subcommand(s) %v", subcommands)
+}
+
+// A CSVTableCommand represents the `/csv` command
+type CSVTableCommand struct {
+	Path    string   `toml:"path"`
+	Columns []string `toml:"columns"`
+}
+
+// ParseCSVTableCommand creates a new `/csv` command
+func ParseCSVTableCommand(params string) (*CSVTableCommand, error) {
+	var cmd CSVTableCommand
+
+	_, err := toml.Decode(params, &cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cmd, nil
+}
+
+// CSVTableCommandResult is the structured result of the `/csv` command
+type CSVTableCommandResult struct {
+	Header []string   `json:"header"`
+	Rows   [][]string `json:"rows"`
+}
+
+func csvCellFromValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return ""
+		}
+		return string(data)
+	}
+}
+
+// tableFromValue converts an array of objects, or an array of arrays, into a header and rows
+func tableFromValue(value interface{}, columns []string) (*CSVTableCommandResult, error) {
+	items, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("/csv needs an array, or CSV text, as input")
+	}
+
+	table := CSVTableCommandResult{Header: columns, Rows: make([][]string, 0, len(items))}
+
+	if len(table.Header) == 0 {
+		keySet := make(map[string]bool)
+		for _, item := range items {
+			if object, ok := item.(map[string]interface{}); ok {
+				for key := range object {
+					if !keySet[key] {
+						keySet[key] = true
+						table.Header = append(table.Header, key)
+					}
+				}
+			}
+		}
+		sort.Strings(table.Header)
+	}
+
+	for _, item := range items {
+		switch v := item.(type) {
+		case map[string]interface{}:
+			row := make([]string, 0, len(table.Header))
+			for _, key := range table.Header {
+				row = append(row, csvCellFromValue(v[key]))
+			}
+			table.Rows = append(table.Rows, row)
+		case []interface{}:
+			row := make([]string, 0, len(v))
+			for _, cell := range v {
+				row = append(row, csvCellFromValue(cell))
+			}
+			table.Rows = append(table.Rows, row)
+		default:
+			table.Rows = append(table.Rows, []string{csvCellFromValue(v)})
+		}
+	}
+
+	return &table, nil
+}
+
+// tableFromCSVText parses CSV text, using its first record as the header
+func tableFromCSVText(text string) (*CSVTableCommandResult, error) {
+	reader := csv.NewReader(strings.NewReader(text))
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("Invalid CSV: %s", err.Error())
+	}
+
+	table := CSVTableCommandResult{Header: []string{}, Rows: [][]string{}}
+	if len(records) > 0 {
+		table.Header = records[0]
+		table.Rows = records[1:]
+	}
+
+	return &table, nil
+}
+
+// Run needs input piped from another command
+func (cmd *CSVTableCommand) Run(ctx context.Context) (CommandResult, error) {
+	return nil, fmt.Errorf("/csv needs input piped from another command")
+}
+
+// RunWithInput converts the result of the previous command into a table
+func (cmd *CSVTableCommand) RunWithInput(ctx context.Context, input CommandResult) (CommandResult, error) {
+	value, err := commandResultValue(input)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err = selectJSONPath(value, cmd.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var table *CSVTableCommandResult
+	if _, isArray := value.([]interface{}); isArray || cmd.Path != "" {
+		table, err = tableFromValue(value, cmd.Columns)
+	} else {
+		table, err = tableFromCSVText(input.PlainText())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var csvBuffer bytes.Buffer
+	csvWriter := csv.NewWriter(&csvBuffer)
+	if len(table.Header) > 0 {
+		csvWriter.Write(table.Header)
+	}
+	csvWriter.WriteAll(table.Rows)
+
+	var htmlBuffer bytes.Buffer
+	writeTable(&htmlBuffer, table.Header, func(t *tableWriter) {
+		for _, row := range table.Rows {
+			t.textRow(row...)
+		}
+	})
+
+	result := DangerousHTMLCommandResultFromSafe(htmlBuffer.String())
+	result.SetPlainText(csvBuffer.String())
+	result.SetJSON(table)
+
+	return result, nil
+}
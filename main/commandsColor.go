@@ -3,7 +3,9 @@ package main
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"html/template"
 	"strings"
 
 	"github.com/lucasb-eyer/go-colorful"
@@ -65,6 +67,30 @@ func (cmd *ColorCommand) Result() *ColorCommandResult {
 	return &srgb
 }
 
+// MarshalJSON encodes the result with the same shape as in GraphQL
+func (result *ColorCommandResult) MarshalJSON() ([]byte, error) {
+	srgb := result.SRGB()
+	lab := result.Lab()
+	return json.Marshal(&struct {
+		SRGB interface{} `json:"srgb"`
+		Lab  interface{} `json:"lab"`
+	}{
+		SRGB: &struct {
+			ColorSpaceName string `json:"colorSpaceName"`
+			Hex            string `json:"hex"`
+			Red8Bit        int32  `json:"red8Bit"`
+			Green8Bit      int32  `json:"green8Bit"`
+			Blue8Bit       int32  `json:"blue8Bit"`
+		}{srgb.ColorSpaceName(), srgb.Hex(), srgb.Red8Bit(), srgb.Green8Bit(), srgb.Blue8Bit()},
+		Lab: &struct {
+			ColorSpaceName string  `json:"colorSpaceName"`
+			L              float64 `json:"l"`
+			A              float64 `json:"a"`
+			B              float64 `json:"b"`
+		}{lab.ColorSpaceName(), lab.L(), lab.A(), lab.B()},
+	})
+}
+
 // ColorCommandRGB is named RGBColor in GraphQL
 type ColorCommandRGB struct {
 	color colorful.Color
@@ -136,21 +162,46 @@ func (lab *ColorCommandLab) B() float64 {
 	return lab.b
 }
 
+// colorCommandTemplates render color previews, escaping each value for the style attribute or text it appears in
+var colorCommandTemplates = template.Must(template.New("color command").Parse(`
+{{define "color"}}
+<div style="width: 12em; height: 12em; background-color: {{.Hex}}"></div>
+<dl class="mt-4">
+<dt class="mt-2 font-bold">Hex</dt><dd>{{.Hex}}</dd>
+<dt class="mt-2 font-bold">sRGB</dt><dd>rgb({{.Red}}, {{.Green}}, {{.Blue}})</dd>
+<dt class="mt-2 font-bold">Lab</dt><dd>lab({{.L}} {{.A}} {{.B}})</dd>
+</dl>
+{{end}}
+
+{{define "gradient"}}
+<div style="width: 12em; height: 12em; background: linear-gradient({{range $index, $stop := .Stops}}{{if $index}}, {{end}}{{$stop}}{{end}})"></div>
+<dl class="mt-4">
+<dt class="mt-2 font-bold">Hex</dt><dd>{{range $index, $stop := .Stops}}{{if $index}}, {{end}}{{$stop}}{{end}}</dd>
+<dt class="mt-2 font-bold">CSS Linear Gradient</dt><dd><code>{{.CSSLinearGradient}}</code></dd>
+</dl>
+{{end}}
+`))
+
 // Run converts the color to a preview
 func (cmd *ColorCommand) Run(ctx context.Context) (CommandResult, error) {
 	hex := cmd.color.Hex()
-
-	var htmlBuffer bytes.Buffer
-	htmlBuffer.WriteString(`<div style="width: 12em; height: 12em; background-color:` + hex + `"></div>`)
-	htmlBuffer.WriteString(`<dl class="mt-4">`)
-	htmlBuffer.WriteString(fmt.Sprintf(`<dt class="mt-2 font-bold">Hex</dt><dd>%s</dd>`, hex))
 	red, green, blue := cmd.color.RGB255()
-	htmlBuffer.WriteString(fmt.Sprintf(`<dt class="mt-2 font-bold">sRGB</dt><dd>rgb(%v, %v, %v)</dd>`, red, green, blue))
 	l, a, b := cmd.color.Lab()
-	htmlBuffer.WriteString(fmt.Sprintf(`<dt class="mt-2 font-bold">Lab</dt><dd>lab(%v %v %v)</dd>`, l, a, b))
-	htmlBuffer.WriteString(`</dl>`)
+
+	var htmlBuffer bytes.Buffer
+	colorCommandTemplates.ExecuteTemplate(&htmlBuffer, "color", &struct {
+		Hex              string
+		Red, Green, Blue uint8
+		L, A, B          float64
+	}{
+		Hex: hex,
+		Red: red, Green: green, Blue: blue,
+		L: l, A: a, B: b,
+	})
 
 	result := DangerousHTMLCommandResultFromSafe(htmlBuffer.String())
+	result.SetPlainText(fmt.Sprintf("Hex: %s\nsRGB: rgb(%v, %v, %v)\nLab: lab(%v %v %v)", hex, red, green, blue, l, a, b))
+	result.SetJSON(cmd.Result())
 
 	return result, nil
 }
@@ -194,13 +245,23 @@ func (cmd *ColorGradientCommand) Run(ctx context.Context) (CommandResult, error)
 	cssLinearGradient := `linear-gradient(` + strings.Join(gradientStops, ", ") + `)`
 
 	var htmlBuffer bytes.Buffer
-	htmlBuffer.WriteString(`<div style="width: 12em; height: 12em; background: ` + cssLinearGradient + `"></div>`)
-	htmlBuffer.WriteString(`<dl class="mt-4">`)
-	htmlBuffer.WriteString(fmt.Sprintf(`<dt class="mt-2 font-bold">Hex</dt><dd>%s</dd>`, strings.Join(gradientStops, ", ")))
-	htmlBuffer.WriteString(fmt.Sprintf(`<dt class="mt-2 font-bold">CSS Linear Gradient</dt><dd><code>%s</code></dd>`, cssLinearGradient))
-	htmlBuffer.WriteString(`</dl>`)
+	colorCommandTemplates.ExecuteTemplate(&htmlBuffer, "gradient", &struct {
+		Stops             []string
+		CSSLinearGradient string
+	}{
+		Stops:             gradientStops,
+		CSSLinearGradient: cssLinearGradient,
+	})
 
 	result := DangerousHTMLCommandResultFromSafe(htmlBuffer.String())
+	result.SetPlainText(fmt.Sprintf("Hex: %s\nCSS Linear Gradient: %s", strings.Join(gradientStops, ", "), cssLinearGradient))
+	result.SetJSON(&struct {
+		Stops             []string `json:"stops"`
+		CSSLinearGradient string   `json:"cssLinearGradient"`
+	}{
+		Stops:             gradientStops,
+		CSSLinearGradient: cssLinearGradient,
+	})
 
 	return result, nil
 }
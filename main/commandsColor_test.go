@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestColorCommandHTML(t *testing.T) {
+	cmd, err := ParseColorHexCommand("#3b7ea1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := cmd.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	html := SafeHTMLForCommandResult(result)
+	for _, want := range []string{
+		`style="width: 12em; height: 12em; background-color: #3b7ea1"`,
+		`<dt class="mt-2 font-bold">Hex</dt><dd>#3b7ea1</dd>`,
+		`<dt class="mt-2 font-bold">sRGB</dt><dd>rgb(59, 126, 161)</dd>`,
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("HTML = %s, want %s", html, want)
+		}
+	}
+}
+
+func TestColorGradientCommandHTML(t *testing.T) {
+	cmd, err := ParseColorGradientCommand("#ff0000\n#0000ff")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := cmd.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	html := SafeHTMLForCommandResult(result)
+	for _, want := range []string{
+		`style="width: 12em; height: 12em; background: linear-gradient(#ff0000, #0000ff)"`,
+		`<dd><code>linear-gradient(#ff0000, #0000ff)</code></dd>`,
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("HTML = %s, want %s", html, want)
+		}
+	}
+}
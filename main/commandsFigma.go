@@ -0,0 +1,478 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"google.golang.org/appengine/log"
+)
+
+const (
+	defaultFigmaImageScale = 1
+	minFigmaImageScale     = 0.01
+	maxFigmaImageScale     = 4
+)
+
+var figmaImageMediaTypes = map[string]string{
+	"png": "image/png",
+	"jpg": "image/jpeg",
+}
+
+// ParseFigmaCommand parses a /figma … command
+func ParseFigmaCommand(subcommands []string, params string) (Command, error) {
+	if len(subcommands) == 1 {
+		switch subcommands[0] {
+		case "file":
+			return ParseFigmaFileCommand(params)
+		case "components":
+			return ParseFigmaComponentsCommand(params)
+		case "styles":
+			return ParseFigmaStylesCommand(params)
+		case "image":
+			return ParseFigmaImageCommand(params)
+		case "tokens":
+			return ParseFigmaTokensCommand(params)
+		}
+	}
+
+	return nil, fmt.Errorf("Unknown figma subcommand(s) %v", subcommands)
+}
+
+func figmaAPIForCommand(ctx context.Context) (*FigmaAPI, error) {
+	figma := GetFigmaAPIFromContext(ctx)
+	if figma == nil {
+		return nil, errors.New("Sign in with Figma to use /figma commands")
+	}
+
+	return figma, nil
+}
+
+func figmaFileURL(key string) string {
+	return "https://www.figma.com/file/" + url.PathEscape(key)
+}
+
+func figmaNodeURL(key string, nodeID string) string {
+	return figmaFileURL(key) + "?node-id=" + url.QueryEscape(nodeID)
+}
+
+// A FigmaFileCommand represents the `/figma file` command
+type FigmaFileCommand struct {
+	File string `toml:"file"`
+}
+
+// ParseFigmaFileCommand creates a new `/figma file` command
+func ParseFigmaFileCommand(params string) (*FigmaFileCommand, error) {
+	var cmd FigmaFileCommand
+
+	_, err := toml.Decode(params, &cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cmd, nil
+}
+
+// Run loads the file with its pages and their frames
+func (cmd *FigmaFileCommand) Run(ctx context.Context) (CommandResult, error) {
+	figma, err := figmaAPIForCommand(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := figma.File(cmd.File)
+	if err != nil {
+		return nil, err
+	}
+
+	var htmlBuffer bytes.Buffer
+	var textBuffer bytes.Buffer
+
+	htmlBuffer.WriteString(`<h3 class="mb-2"><a href="` + html.EscapeString(figmaFileURL(file.Key)) + `" class="text-blue-dark">` + html.EscapeString(file.Name) + `</a></h3>`)
+	textBuffer.WriteString(file.Name + "\t" + figmaFileURL(file.Key) + "\n")
+	if file.ThumbnailURL != "" {
+		htmlBuffer.WriteString(`<img src="` + html.EscapeString(file.ThumbnailURL) + `" alt="" class="block max-w-sm mb-2 border border-grey-light rounded">`)
+	}
+	writeDescriptionList(&htmlBuffer, func(dl *descriptionListWriter) {
+		dl.key("Last modified")
+		dl.value(formatShortDate(file.LastModified))
+		textBuffer.WriteString("Last modified: " + formatShortDate(file.LastModified) + "\n")
+	})
+
+	writeTable(&htmlBuffer, []string{"Page", "Frame", "Type"}, func(t *tableWriter) {
+		for _, page := range file.Pages {
+			t.row(linkCell(page.Name, figmaNodeURL(file.Key, page.ID)), textCell(""), textCell(page.Type))
+			textBuffer.WriteString("\n" + page.Name + "\n")
+			for _, frame := range page.Children {
+				t.row(textCell(""), linkCell(frame.Name, figmaNodeURL(file.Key, frame.ID)), textCell(frame.Type))
+				textBuffer.WriteString("- " + frame.Name + "\t" + frame.ID + "\n")
+			}
+		}
+	})
+
+	result := DangerousHTMLCommandResultFromSafe(htmlBuffer.String())
+	result.SetPlainText(textBuffer.String())
+	result.SetJSON(file)
+
+	return result, nil
+}
+
+// A FigmaComponentsCommand represents the `/figma components` command
+type FigmaComponentsCommand struct {
+	File string `toml:"file"`
+}
+
+// ParseFigmaComponentsCommand creates a new `/figma components` command
+func ParseFigmaComponentsCommand(params string) (*FigmaComponentsCommand, error) {
+	var cmd FigmaComponentsCommand
+
+	_, err := toml.Decode(params, &cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cmd, nil
+}
+
+// Run lists the file’s published components
+func (cmd *FigmaComponentsCommand) Run(ctx context.Context) (CommandResult, error) {
+	figma, err := figmaAPIForCommand(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	components, err := figma.Components(cmd.File)
+	if err != nil {
+		return nil, err
+	}
+
+	var htmlBuffer bytes.Buffer
+	var textBuffer bytes.Buffer
+	writeTable(&htmlBuffer, []string{"Component", "Frame", "Page", "Description"}, func(t *tableWriter) {
+		for _, component := range components {
+			var frameName, pageName string
+			if component.ContainingFrame != nil {
+				frameName = component.ContainingFrame.Name
+				pageName = component.ContainingFrame.PageName
+			}
+
+			t.row(linkCell(component.Name, figmaNodeURL(component.FileKey, component.NodeID)), textCell(frameName), textCell(pageName), textCell(firstLine(component.Description)))
+			textBuffer.WriteString(strings.Join([]string{component.Name, frameName, pageName, component.NodeID}, "\t") + "\n")
+		}
+	})
+
+	result := DangerousHTMLCommandResultFromSafe(htmlBuffer.String())
+	result.SetPlainText(textBuffer.String())
+	result.SetJSON(components)
+
+	return result, nil
+}
+
+// A FigmaStylesCommand represents the `/figma styles` command
+type FigmaStylesCommand struct {
+	File string `toml:"file"`
+}
+
+// ParseFigmaStylesCommand creates a new `/figma styles` command
+func ParseFigmaStylesCommand(params string) (*FigmaStylesCommand, error) {
+	var cmd FigmaStylesCommand
+
+	_, err := toml.Decode(params, &cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cmd, nil
+}
+
+// Run lists the file’s published styles
+func (cmd *FigmaStylesCommand) Run(ctx context.Context) (CommandResult, error) {
+	figma, err := figmaAPIForCommand(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	styles, err := figma.Styles(cmd.File)
+	if err != nil {
+		return nil, err
+	}
+
+	var htmlBuffer bytes.Buffer
+	var textBuffer bytes.Buffer
+	writeTable(&htmlBuffer, []string{"Style", "Type", "Description"}, func(t *tableWriter) {
+		for _, style := range styles {
+			t.row(linkCell(style.Name, figmaNodeURL(style.FileKey, style.NodeID)), textCell(style.StyleType), textCell(firstLine(style.Description)))
+			textBuffer.WriteString(strings.Join([]string{style.Name, style.StyleType, style.NodeID}, "\t") + "\n")
+		}
+	})
+
+	result := DangerousHTMLCommandResultFromSafe(htmlBuffer.String())
+	result.SetPlainText(textBuffer.String())
+	result.SetJSON(styles)
+
+	return result, nil
+}
+
+// A FigmaImageCommand represents the `/figma image` command
+type FigmaImageCommand struct {
+	File   string  `toml:"file"`
+	Node   string  `toml:"node"`
+	Format string  `toml:"format"`
+	Scale  float64 `toml:"scale"`
+}
+
+// ParseFigmaImageCommand creates a new `/figma image` command
+func ParseFigmaImageCommand(params string) (*FigmaImageCommand, error) {
+	var cmd FigmaImageCommand
+
+	_, err := toml.Decode(params, &cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	if cmd.Format == "" {
+		cmd.Format = "png"
+	}
+	if _, ok := figmaImageMediaTypes[cmd.Format]; !ok {
+		return nil, fmt.Errorf("Figma image format must be png or jpg, not %q", cmd.Format)
+	}
+
+	if cmd.Scale == 0 {
+		cmd.Scale = defaultFigmaImageScale
+	}
+	if cmd.Scale < minFigmaImageScale || cmd.Scale > maxFigmaImageScale {
+		return nil, fmt.Errorf("Figma image scale must be between %v and %v", minFigmaImageScale, maxFigmaImageScale)
+	}
+
+	return &cmd, nil
+}
+
+// FigmaStoredImage is a rendered node copied into storage, as Figma’s own image URLs expire
+type FigmaStoredImage struct {
+	FileKey   string `json:"fileKey"`
+	NodeID    string `json:"nodeID"`
+	MediaType string `json:"mediaType"`
+	SHA256    string `json:"sha256"`
+	URL       string `json:"url"`
+}
+
+func storeFigmaImage(ctx context.Context, image FigmaImage, mediaType string) (string, error) {
+	res, err := NewOutboundFetcher(ctx).Get(image.URL)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Could not download rendered image from Figma: %s", res.Status)
+	}
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+
+	digest := sha256.Sum256(data)
+	sha256Hex := hex.EncodeToString(digest[:])
+
+	storageRepo := NewStorageRepo(ctx)
+	_, err = storageRepo.addContentWithMediaTypeAndSHA256(mediaType, sha256Hex, bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+
+	return sha256Hex, nil
+}
+
+// renderedImageSHA256 returns the stored image of the node, only rendering it again once the file has changed or the image is gone.
+// The file’s version is still asked for each time, which also checks the viewer can see the file.
+func (cmd *FigmaImageCommand) renderedImageSHA256(ctx context.Context, figma *FigmaAPI, fileKey string, nodeID string, mediaType string) (string, error) {
+	fileVersion, err := figma.FileVersion(fileKey)
+	if err != nil {
+		return "", err
+	}
+
+	key := figmaRenderedImageKey(ctx, fileKey, nodeID, cmd.Format, cmd.Scale)
+	storageRepo := NewStorageRepo(ctx)
+
+	rendered, err := getFigmaRenderedImage(ctx, key)
+	if err != nil {
+		log.Warningf(ctx, "Could not load rendered Figma image %s: %v", key.StringID(), err)
+	}
+	if rendered != nil && rendered.FileVersion == fileVersion && rendered.MediaType == mediaType {
+		_, err = storageRepo.statContentWithMediaTypeAndSHA256(mediaType, rendered.SHA256)
+		if err == nil {
+			// Still in use, so not to be garbage collected
+			err = storageRepo.unmarkUnreferencedBlobs([]string{storageKeyForContent(mediaType, rendered.SHA256)})
+			if err != nil {
+				log.Warningf(ctx, "Could not unmark rendered Figma image %s: %v", key.StringID(), err)
+			}
+			return rendered.SHA256, nil
+		}
+	}
+
+	images, err := figma.Images(fileKey, []string{nodeID}, cmd.Format, cmd.Scale)
+	if err != nil {
+		return "", err
+	}
+
+	sha256Hex, err := storeFigmaImage(ctx, images[0], mediaType)
+	if err != nil {
+		return "", err
+	}
+
+	err = putFigmaRenderedImage(ctx, key, &FigmaRenderedImage{
+		FileVersion: fileVersion,
+		MediaType:   mediaType,
+		SHA256:      sha256Hex,
+		RenderedAt:  time.Now().UTC(),
+	})
+	if err != nil {
+		log.Warningf(ctx, "Could not remember rendered Figma image %s: %v", key.StringID(), err)
+	}
+
+	return sha256Hex, nil
+}
+
+// Run renders the node, copies the image into storage, and shows it inline
+func (cmd *FigmaImageCommand) Run(ctx context.Context) (CommandResult, error) {
+	figma, err := figmaAPIForCommand(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	fileKey, nodeID := figmaFileKeyFromURL(cmd.File)
+	if cmd.Node != "" {
+		nodeID = cmd.Node
+	}
+	if nodeID == "" {
+		return nil, errors.New("Figma node must be given, either as node or within the file’s URL")
+	}
+
+	mediaType := figmaImageMediaTypes[cmd.Format]
+	sha256Hex, err := cmd.renderedImageSHA256(ctx, figma, fileKey, nodeID, mediaType)
+	if err != nil {
+		return nil, err
+	}
+
+	stored := FigmaStoredImage{
+		FileKey:   fileKey,
+		NodeID:    nodeID,
+		MediaType: mediaType,
+		SHA256:    sha256Hex,
+		// Signed each time the post is shown, so the image can be seen without signing in
+		URL: signedStorageContentURL(ctx, mediaType, sha256Hex),
+	}
+
+	var htmlBuffer bytes.Buffer
+	htmlBuffer.WriteString(`<a href="` + html.EscapeString(figmaNodeURL(fileKey, nodeID)) + `">`)
+	htmlBuffer.WriteString(`<img src="` + html.EscapeString(stored.URL) + `" alt="Figma node ` + html.EscapeString(nodeID) + `" class="block max-w-full">`)
+	htmlBuffer.WriteString(`</a>`)
+
+	result := DangerousHTMLCommandResultFromSafe(htmlBuffer.String())
+	result.SetPlainText(absoluteHTMLURL(ctx, stored.URL) + "\n")
+	result.SetJSON(stored)
+
+	return result, nil
+}
+
+// A FigmaTokensCommand represents the `/figma tokens` command
+type FigmaTokensCommand struct {
+	File string `toml:"file"`
+}
+
+// ParseFigmaTokensCommand creates a new `/figma tokens` command
+func ParseFigmaTokensCommand(params string) (*FigmaTokensCommand, error) {
+	var cmd FigmaTokensCommand
+
+	_, err := toml.Decode(params, &cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cmd, nil
+}
+
+// writeDownloadableCode shows the code with a link to save it as a file
+func writeDownloadableCode(htmlWriter *bytes.Buffer, title string, filename string, mediaType string, code string) {
+	dataURL := "data:" + mediaType + ";charset=utf-8;base64," + base64.StdEncoding.EncodeToString([]byte(code))
+
+	htmlWriter.WriteString(`<div class="flex flex-row items-baseline justify-between mt-4 mb-1">`)
+	htmlWriter.WriteString(`<h4>` + html.EscapeString(title) + `</h4>`)
+	htmlWriter.WriteString(`<a href="` + html.EscapeString(dataURL) + `" download="` + html.EscapeString(filename) + `" class="text-blue-dark">Download ` + html.EscapeString(filename) + `</a>`)
+	htmlWriter.WriteString(`</div>`)
+	htmlWriter.WriteString(`<pre class="p-2 overflow-x-auto bg-grey-lightest border border-grey-light rounded text-sm">` + html.EscapeString(code) + `</pre>`)
+}
+
+// Run converts the file’s published fill and text styles into design tokens
+func (cmd *FigmaTokensCommand) Run(ctx context.Context) (CommandResult, error) {
+	figma, err := figmaAPIForCommand(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	fileKey, err := figmaFileKey(cmd.File)
+	if err != nil {
+		return nil, err
+	}
+
+	styles, err := figma.Styles(fileKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var nodeIDs []string
+	for _, style := range styles {
+		if style.StyleType == "FILL" || style.StyleType == "TEXT" {
+			nodeIDs = append(nodeIDs, style.NodeID)
+		}
+	}
+
+	nodes, err := figma.StyleNodes(fileKey, nodeIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := DesignTokensFromFigmaStyles(fileKey, styles, nodes)
+
+	tokensJSON, err := tokens.TokensJSON()
+	if err != nil {
+		return nil, err
+	}
+	css := tokens.CSS()
+
+	var htmlBuffer bytes.Buffer
+	htmlBuffer.WriteString(fmt.Sprintf(`<p>%d colours and %d type styles from <a href="%s" class="text-blue-dark">%s</a></p>`,
+		len(tokens.Colors), len(tokens.Typography), html.EscapeString(figmaFileURL(fileKey)), html.EscapeString(fileKey)))
+	if len(tokens.Colors) > 0 {
+		htmlBuffer.WriteString(`<div class="flex flex-row flex-wrap mt-2">`)
+		for _, token := range tokens.Colors {
+			htmlBuffer.WriteString(`<div class="mr-2 mb-2 w-24 text-sm">`)
+			htmlBuffer.WriteString(`<div class="h-12 border border-grey-light rounded" style="background-color: ` + html.EscapeString(token.cssValue()) + `"></div>`)
+			htmlBuffer.WriteString(`<p class="mt-1 truncate">` + html.EscapeString(token.Name) + `</p>`)
+			htmlBuffer.WriteString(`</div>`)
+		}
+		htmlBuffer.WriteString(`</div>`)
+	}
+	writeDownloadableCode(&htmlBuffer, "CSS custom properties", "tokens.css", "text/css", css)
+	writeDownloadableCode(&htmlBuffer, "Design tokens", "tokens.json", "application/json", string(tokensJSON)+"\n")
+	writeDownloadableCode(&htmlBuffer, "Tailwind config", "tailwind.config.js", "text/javascript", tokens.TailwindConfig())
+
+	result := DangerousHTMLCommandResultFromSafe(htmlBuffer.String())
+	result.SetFullWidth(true)
+	result.SetPlainText(css)
+	result.SetJSON(tokens)
+
+	return result, nil
+}
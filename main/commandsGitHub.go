@@ -0,0 +1,546 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/google/go-github/github"
+	"golang.org/x/oauth2"
+)
+
+const (
+	defaultGitHubCommandLimit = 10
+	maxGitHubCommandLimit     = 100
+)
+
+// ParseGitHubCommand parses a /github … command
+func ParseGitHubCommand(subcommands []string, params string) (Command, error) {
+	if len(subcommands) == 1 {
+		switch subcommands[0] {
+		case "issues":
+			return ParseGitHubIssuesCommand(params)
+		case "pr":
+			return ParseGitHubPullRequestsCommand(params)
+		case "repo":
+			return ParseGitHubRepoCommand(params)
+		case "releases":
+			return ParseGitHubReleasesCommand(params)
+		case "commits":
+			return ParseGitHubCommitsCommand(params)
+		}
+	}
+
+	return nil, fmt.Errorf("Unknown github subcommand(s) %v", subcommands)
+}
+
+// splitGitHubRepo splits `owner/name` into its owner and name
+func splitGitHubRepo(repo string) (string, string, error) {
+	parts := strings.Split(strings.Trim(strings.TrimSpace(repo), "/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("GitHub repo must look like owner/name, not %q", repo)
+	}
+
+	return parts[0], parts[1], nil
+}
+
+// gitHubClientForCommand uses the provided token, or else the GitHub token of the viewer running the command
+func gitHubClientForCommand(ctx context.Context, token string) (*github.Client, error) {
+	if token != "" {
+		return github.NewClient(gitHubOAuthProvider.ClientForToken(ctx, &oauth2.Token{AccessToken: token})), nil
+	}
+
+	client := GetGitHubClientFromContext(ctx)
+	if client == nil {
+		return nil, errors.New("Sign in with GitHub to use /github commands")
+	}
+
+	return client, nil
+}
+
+func gitHubCommandLimit(limit int) int {
+	if limit <= 0 {
+		return defaultGitHubCommandLimit
+	}
+	if limit > maxGitHubCommandLimit {
+		return maxGitHubCommandLimit
+	}
+	return limit
+}
+
+func gitHubStateParam(state string, allowed ...string) (string, error) {
+	state = strings.ToLower(strings.TrimSpace(state))
+	if state == "" {
+		return allowed[0], nil
+	}
+
+	for _, allowedState := range allowed {
+		if state == allowedState {
+			return state, nil
+		}
+	}
+
+	return "", fmt.Errorf("GitHub state must be one of %s, not %q", strings.Join(allowed, ", "), state)
+}
+
+// gitHubTableResult makes a result with an HTML table, a plain text line per row, and the structured summaries
+func gitHubTableResult(header []string, rows [][]tableCell, summaries interface{}) *HTMLCommandResult {
+	var htmlBuffer bytes.Buffer
+	var textBuffer bytes.Buffer
+
+	writeTable(&htmlBuffer, header, func(t *tableWriter) {
+		for _, row := range rows {
+			t.row(row...)
+
+			texts := make([]string, 0, len(row))
+			for _, cell := range row {
+				texts = append(texts, cell.text)
+			}
+			textBuffer.WriteString(strings.Join(texts, "\t") + "\n")
+		}
+	})
+
+	result := DangerousHTMLCommandResultFromSafe(htmlBuffer.String())
+	result.SetPlainText(textBuffer.String())
+	result.SetJSON(summaries)
+
+	return result
+}
+
+// A GitHubIssuesCommand represents the `/github issues` command
+type GitHubIssuesCommand struct {
+	Repo   string   `toml:"repo"`
+	State  string   `toml:"state"`
+	Labels []string `toml:"labels"`
+	Limit  int      `toml:"limit"`
+	Token  string   `toml:"token"`
+}
+
+// ParseGitHubIssuesCommand creates a new `/github issues` command
+func ParseGitHubIssuesCommand(params string) (*GitHubIssuesCommand, error) {
+	var cmd GitHubIssuesCommand
+
+	_, err := toml.Decode(params, &cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cmd, nil
+}
+
+// GitHubIssueSummary is the structured result of an issue
+type GitHubIssueSummary struct {
+	Number    int       `json:"number"`
+	Title     string    `json:"title"`
+	State     string    `json:"state"`
+	URL       string    `json:"url"`
+	Author    string    `json:"author"`
+	Labels    []string  `json:"labels"`
+	Comments  int       `json:"comments"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Run lists the issues of the repo, leaving out pull requests
+func (cmd *GitHubIssuesCommand) Run(ctx context.Context) (CommandResult, error) {
+	owner, repo, err := splitGitHubRepo(cmd.Repo)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := gitHubStateParam(cmd.State, "open", "closed", "all")
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := gitHubClientForCommand(ctx, cmd.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := gitHubCommandLimit(cmd.Limit)
+	issues, _, err := client.Issues.ListByRepo(ctx, owner, repo, &github.IssueListByRepoOptions{
+		State:       state,
+		Labels:      cmd.Labels,
+		ListOptions: github.ListOptions{PerPage: limit},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := make([]GitHubIssueSummary, 0, len(issues))
+	rows := make([][]tableCell, 0, len(issues))
+	for _, issue := range issues {
+		if issue.IsPullRequest() {
+			continue
+		}
+
+		summary := GitHubIssueSummary{
+			Number:    issue.GetNumber(),
+			Title:     issue.GetTitle(),
+			State:     issue.GetState(),
+			URL:       issue.GetHTMLURL(),
+			Author:    issue.GetUser().GetLogin(),
+			Labels:    make([]string, 0, len(issue.Labels)),
+			Comments:  issue.GetComments(),
+			CreatedAt: issue.GetCreatedAt(),
+		}
+		for _, label := range issue.Labels {
+			summary.Labels = append(summary.Labels, label.GetName())
+		}
+		summaries = append(summaries, summary)
+
+		rows = append(rows, []tableCell{
+			linkCell("#"+strconv.Itoa(summary.Number), summary.URL),
+			textCell(summary.Title),
+			textCell(summary.State),
+			textCell(strings.Join(summary.Labels, ", ")),
+			textCell(summary.Author),
+			textCell(formatShortDate(summary.CreatedAt)),
+		})
+	}
+
+	return gitHubTableResult([]string{"Issue", "Title", "State", "Labels", "Author", "Created"}, rows, summaries), nil
+}
+
+// A GitHubPullRequestsCommand represents the `/github pr` command
+type GitHubPullRequestsCommand struct {
+	Repo  string `toml:"repo"`
+	State string `toml:"state"`
+	Limit int    `toml:"limit"`
+	Token string `toml:"token"`
+}
+
+// ParseGitHubPullRequestsCommand creates a new `/github pr` command
+func ParseGitHubPullRequestsCommand(params string) (*GitHubPullRequestsCommand, error) {
+	var cmd GitHubPullRequestsCommand
+
+	_, err := toml.Decode(params, &cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cmd, nil
+}
+
+// GitHubPullRequestSummary is the structured result of a pull request
+type GitHubPullRequestSummary struct {
+	Number    int       `json:"number"`
+	Title     string    `json:"title"`
+	State     string    `json:"state"`
+	URL       string    `json:"url"`
+	Author    string    `json:"author"`
+	Branch    string    `json:"branch"`
+	Merged    bool      `json:"merged"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Run lists the pull requests of the repo
+func (cmd *GitHubPullRequestsCommand) Run(ctx context.Context) (CommandResult, error) {
+	owner, repo, err := splitGitHubRepo(cmd.Repo)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := gitHubStateParam(cmd.State, "open", "closed", "all")
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := gitHubClientForCommand(ctx, cmd.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := gitHubCommandLimit(cmd.Limit)
+	pullRequests, _, err := client.PullRequests.List(ctx, owner, repo, &github.PullRequestListOptions{
+		State:       state,
+		ListOptions: github.ListOptions{PerPage: limit},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := make([]GitHubPullRequestSummary, 0, len(pullRequests))
+	rows := make([][]tableCell, 0, len(pullRequests))
+	for _, pullRequest := range pullRequests {
+		summary := GitHubPullRequestSummary{
+			Number:    pullRequest.GetNumber(),
+			Title:     pullRequest.GetTitle(),
+			State:     pullRequest.GetState(),
+			URL:       pullRequest.GetHTMLURL(),
+			Author:    pullRequest.GetUser().GetLogin(),
+			Branch:    pullRequest.GetHead().GetRef(),
+			Merged:    pullRequest.MergedAt != nil,
+			CreatedAt: pullRequest.GetCreatedAt(),
+		}
+		summaries = append(summaries, summary)
+
+		displayState := summary.State
+		if summary.Merged {
+			displayState = "merged"
+		}
+
+		rows = append(rows, []tableCell{
+			linkCell("#"+strconv.Itoa(summary.Number), summary.URL),
+			textCell(summary.Title),
+			textCell(displayState),
+			textCell(summary.Branch),
+			textCell(summary.Author),
+			textCell(formatShortDate(summary.CreatedAt)),
+		})
+	}
+
+	return gitHubTableResult([]string{"Pull request", "Title", "State", "Branch", "Author", "Created"}, rows, summaries), nil
+}
+
+// A GitHubRepoCommand represents the `/github repo` command
+type GitHubRepoCommand struct {
+	Repo  string `toml:"repo"`
+	Token string `toml:"token"`
+}
+
+// ParseGitHubRepoCommand creates a new `/github repo` command
+func ParseGitHubRepoCommand(params string) (*GitHubRepoCommand, error) {
+	var cmd GitHubRepoCommand
+
+	_, err := toml.Decode(params, &cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cmd, nil
+}
+
+// GitHubRepoSummary is the structured result of a repo
+type GitHubRepoSummary struct {
+	FullName      string    `json:"fullName"`
+	Description   string    `json:"description"`
+	URL           string    `json:"url"`
+	DefaultBranch string    `json:"defaultBranch"`
+	Language      string    `json:"language"`
+	Stars         int       `json:"stars"`
+	Forks         int       `json:"forks"`
+	OpenIssues    int       `json:"openIssues"`
+	PushedAt      time.Time `json:"pushedAt"`
+}
+
+// Run loads the repo
+func (cmd *GitHubRepoCommand) Run(ctx context.Context) (CommandResult, error) {
+	owner, repo, err := splitGitHubRepo(cmd.Repo)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := gitHubClientForCommand(ctx, cmd.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	repository, _, err := client.Repositories.Get(ctx, owner, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := GitHubRepoSummary{
+		FullName:      repository.GetFullName(),
+		Description:   repository.GetDescription(),
+		URL:           repository.GetHTMLURL(),
+		DefaultBranch: repository.GetDefaultBranch(),
+		Language:      repository.GetLanguage(),
+		Stars:         repository.GetStargazersCount(),
+		Forks:         repository.GetForksCount(),
+		OpenIssues:    repository.GetOpenIssuesCount(),
+		PushedAt:      repository.GetPushedAt().Time,
+	}
+
+	var htmlBuffer bytes.Buffer
+	writeTable(&htmlBuffer, nil, func(t *tableWriter) {
+		t.row(textCell("Repo"), linkCell(summary.FullName, summary.URL))
+		t.textRow("Description", summary.Description)
+		t.textRow("Default branch", summary.DefaultBranch)
+		t.textRow("Language", summary.Language)
+		t.textRow("Stars", strconv.Itoa(summary.Stars))
+		t.textRow("Forks", strconv.Itoa(summary.Forks))
+		t.textRow("Open issues", strconv.Itoa(summary.OpenIssues))
+		t.textRow("Last pushed", formatShortDate(summary.PushedAt))
+	})
+
+	var textBuffer bytes.Buffer
+	textBuffer.WriteString(summary.FullName + "\n")
+	if summary.Description != "" {
+		textBuffer.WriteString(summary.Description + "\n")
+	}
+	textBuffer.WriteString(fmt.Sprintf("%d stars, %d forks, %d open issues\n", summary.Stars, summary.Forks, summary.OpenIssues))
+
+	result := DangerousHTMLCommandResultFromSafe(htmlBuffer.String())
+	result.SetPlainText(textBuffer.String())
+	result.SetJSON(summary)
+
+	return result, nil
+}
+
+// A GitHubReleasesCommand represents the `/github releases` command
+type GitHubReleasesCommand struct {
+	Repo  string `toml:"repo"`
+	Limit int    `toml:"limit"`
+	Token string `toml:"token"`
+}
+
+// ParseGitHubReleasesCommand creates a new `/github releases` command
+func ParseGitHubReleasesCommand(params string) (*GitHubReleasesCommand, error) {
+	var cmd GitHubReleasesCommand
+
+	_, err := toml.Decode(params, &cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cmd, nil
+}
+
+// GitHubReleaseSummary is the structured result of a release
+type GitHubReleaseSummary struct {
+	Tag         string    `json:"tag"`
+	Name        string    `json:"name"`
+	URL         string    `json:"url"`
+	Draft       bool      `json:"draft"`
+	Prerelease  bool      `json:"prerelease"`
+	PublishedAt time.Time `json:"publishedAt"`
+}
+
+// Run lists the latest releases of the repo
+func (cmd *GitHubReleasesCommand) Run(ctx context.Context) (CommandResult, error) {
+	owner, repo, err := splitGitHubRepo(cmd.Repo)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := gitHubClientForCommand(ctx, cmd.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := gitHubCommandLimit(cmd.Limit)
+	releases, _, err := client.Repositories.ListReleases(ctx, owner, repo, &github.ListOptions{PerPage: limit})
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := make([]GitHubReleaseSummary, 0, len(releases))
+	rows := make([][]tableCell, 0, len(releases))
+	for _, release := range releases {
+		summary := GitHubReleaseSummary{
+			Tag:         release.GetTagName(),
+			Name:        release.GetName(),
+			URL:         release.GetHTMLURL(),
+			Draft:       release.GetDraft(),
+			Prerelease:  release.GetPrerelease(),
+			PublishedAt: release.GetPublishedAt().Time,
+		}
+		summaries = append(summaries, summary)
+
+		kind := "release"
+		if summary.Draft {
+			kind = "draft"
+		} else if summary.Prerelease {
+			kind = "prerelease"
+		}
+
+		rows = append(rows, []tableCell{
+			linkCell(summary.Tag, summary.URL),
+			textCell(summary.Name),
+			textCell(kind),
+			textCell(formatShortDate(summary.PublishedAt)),
+		})
+	}
+
+	return gitHubTableResult([]string{"Tag", "Name", "Kind", "Published"}, rows, summaries), nil
+}
+
+// A GitHubCommitsCommand represents the `/github commits` command
+type GitHubCommitsCommand struct {
+	Repo   string `toml:"repo"`
+	Branch string `toml:"branch"`
+	Path   string `toml:"path"`
+	Limit  int    `toml:"limit"`
+	Token  string `toml:"token"`
+}
+
+// ParseGitHubCommitsCommand creates a new `/github commits` command
+func ParseGitHubCommitsCommand(params string) (*GitHubCommitsCommand, error) {
+	var cmd GitHubCommitsCommand
+
+	_, err := toml.Decode(params, &cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cmd, nil
+}
+
+// GitHubCommitSummary is the structured result of a commit
+type GitHubCommitSummary struct {
+	SHA     string    `json:"sha"`
+	Message string    `json:"message"`
+	URL     string    `json:"url"`
+	Author  string    `json:"author"`
+	Date    time.Time `json:"date"`
+}
+
+// Run lists the latest commits of the repo’s default branch, or of the provided branch
+func (cmd *GitHubCommitsCommand) Run(ctx context.Context) (CommandResult, error) {
+	owner, repo, err := splitGitHubRepo(cmd.Repo)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := gitHubClientForCommand(ctx, cmd.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := gitHubCommandLimit(cmd.Limit)
+	commits, _, err := client.Repositories.ListCommits(ctx, owner, repo, &github.CommitsListOptions{
+		SHA:         cmd.Branch,
+		Path:        cmd.Path,
+		ListOptions: github.ListOptions{PerPage: limit},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := make([]GitHubCommitSummary, 0, len(commits))
+	rows := make([][]tableCell, 0, len(commits))
+	for _, commit := range commits {
+		summary := GitHubCommitSummary{
+			SHA:     commit.GetSHA(),
+			Message: commit.GetCommit().GetMessage(),
+			URL:     commit.GetHTMLURL(),
+			Author:  commit.GetAuthor().GetLogin(),
+			Date:    commit.GetCommit().GetAuthor().GetDate(),
+		}
+		if summary.Author == "" {
+			summary.Author = commit.GetCommit().GetAuthor().GetName()
+		}
+		summaries = append(summaries, summary)
+
+		shortSHA := summary.SHA
+		if len(shortSHA) > 7 {
+			shortSHA = shortSHA[:7]
+		}
+
+		rows = append(rows, []tableCell{
+			linkCell(shortSHA, summary.URL),
+			textCell(firstLine(summary.Message)),
+			textCell(summary.Author),
+			textCell(formatShortDate(summary.Date)),
+		})
+	}
+
+	return gitHubTableResult([]string{"Commit", "Message", "Author", "Date"}, rows, summaries), nil
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"golang.org/x/oauth2"
+)
+
+// ParseGitLabCommand parses a /gitlab … command
+func ParseGitLabCommand(subcommands []string, params string) (Command, error) {
+	if len(subcommands) == 1 {
+		switch subcommands[0] {
+		case "merge-requests":
+			return ParseGitLabMergeRequestsCommand(params)
+		case "issues":
+			return ParseGitLabIssuesCommand(params)
+		case "pipelines":
+			return ParseGitLabPipelinesCommand(params)
+		}
+	}
+
+	return nil, fmt.Errorf("Unknown gitlab subcommand(s) %v", subcommands)
+}
+
+// gitLabAPIForCommand uses the provided token, or else the GitLab token of the viewer running the command
+func gitLabAPIForCommand(ctx context.Context, token string) (*GitLabAPI, error) {
+	if token != "" {
+		return NewGitLabAPI(gitLabOAuthProvider.ClientForToken(ctx, &oauth2.Token{AccessToken: token}), gitLabBaseURL()), nil
+	}
+
+	gitLab := GetGitLabAPIFromContext(ctx)
+	if gitLab == nil {
+		return nil, errors.New("Sign in with GitLab to use /gitlab commands")
+	}
+
+	return gitLab, nil
+}
+
+func gitLabStateParam(state string, allowed ...string) (string, error) {
+	state = strings.ToLower(strings.TrimSpace(state))
+	if state == "" {
+		return allowed[0], nil
+	}
+
+	for _, allowedState := range allowed {
+		if state == allowedState {
+			return state, nil
+		}
+	}
+
+	return "", fmt.Errorf("GitLab state must be one of %s, not %q", strings.Join(allowed, ", "), state)
+}
+
+// A GitLabMergeRequestsCommand represents the `/gitlab merge-requests` command
+type GitLabMergeRequestsCommand struct {
+	Project string `toml:"project"`
+	State   string `toml:"state"`
+	Limit   int    `toml:"limit"`
+	Token   string `toml:"token"`
+}
+
+// ParseGitLabMergeRequestsCommand creates a new `/gitlab merge-requests` command
+func ParseGitLabMergeRequestsCommand(params string) (*GitLabMergeRequestsCommand, error) {
+	var cmd GitLabMergeRequestsCommand
+
+	_, err := toml.Decode(params, &cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cmd, nil
+}
+
+// GitLabMergeRequestSummary is the structured result of a merge request
+type GitLabMergeRequestSummary struct {
+	IID          int       `json:"iid"`
+	Title        string    `json:"title"`
+	State        string    `json:"state"`
+	URL          string    `json:"url"`
+	Author       string    `json:"author"`
+	SourceBranch string    `json:"sourceBranch"`
+	TargetBranch string    `json:"targetBranch"`
+	Draft        bool      `json:"draft"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// Run lists the merge requests of the project
+func (cmd *GitLabMergeRequestsCommand) Run(ctx context.Context) (CommandResult, error) {
+	project, err := gitLabProjectPath(cmd.Project)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := gitLabStateParam(cmd.State, "opened", "closed", "merged", "locked", "all")
+	if err != nil {
+		return nil, err
+	}
+
+	gitLab, err := gitLabAPIForCommand(ctx, cmd.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	mergeRequests, err := gitLab.MergeRequests(project, state, gitHubCommandLimit(cmd.Limit))
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := make([]GitLabMergeRequestSummary, 0, len(mergeRequests))
+	rows := make([][]tableCell, 0, len(mergeRequests))
+	for _, mergeRequest := range mergeRequests {
+		summary := GitLabMergeRequestSummary{
+			IID:          mergeRequest.IID,
+			Title:        mergeRequest.Title,
+			State:        mergeRequest.State,
+			URL:          mergeRequest.WebURL,
+			Author:       mergeRequest.Author.Username,
+			SourceBranch: mergeRequest.SourceBranch,
+			TargetBranch: mergeRequest.TargetBranch,
+			Draft:        mergeRequest.Draft,
+			CreatedAt:    mergeRequest.CreatedAt,
+		}
+		summaries = append(summaries, summary)
+
+		displayState := summary.State
+		if summary.Draft && summary.State == "opened" {
+			displayState = "draft"
+		}
+
+		rows = append(rows, []tableCell{
+			linkCell("!"+strconv.Itoa(summary.IID), summary.URL),
+			textCell(summary.Title),
+			textCell(displayState),
+			textCell(summary.SourceBranch + " → " + summary.TargetBranch),
+			textCell(summary.Author),
+			textCell(formatShortDate(summary.CreatedAt)),
+		})
+	}
+
+	return gitHubTableResult([]string{"Merge request", "Title", "State", "Branches", "Author", "Created"}, rows, summaries), nil
+}
+
+// A GitLabIssuesCommand represents the `/gitlab issues` command
+type GitLabIssuesCommand struct {
+	Project string   `toml:"project"`
+	State   string   `toml:"state"`
+	Labels  []string `toml:"labels"`
+	Limit   int      `toml:"limit"`
+	Token   string   `toml:"token"`
+}
+
+// ParseGitLabIssuesCommand creates a new `/gitlab issues` command
+func ParseGitLabIssuesCommand(params string) (*GitLabIssuesCommand, error) {
+	var cmd GitLabIssuesCommand
+
+	_, err := toml.Decode(params, &cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cmd, nil
+}
+
+// GitLabIssueSummary is the structured result of an issue
+type GitLabIssueSummary struct {
+	IID       int       `json:"iid"`
+	Title     string    `json:"title"`
+	State     string    `json:"state"`
+	URL       string    `json:"url"`
+	Author    string    `json:"author"`
+	Labels    []string  `json:"labels"`
+	Comments  int       `json:"comments"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Run lists the issues of the project
+func (cmd *GitLabIssuesCommand) Run(ctx context.Context) (CommandResult, error) {
+	project, err := gitLabProjectPath(cmd.Project)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := gitLabStateParam(cmd.State, "opened", "closed", "all")
+	if err != nil {
+		return nil, err
+	}
+
+	gitLab, err := gitLabAPIForCommand(ctx, cmd.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	issues, err := gitLab.Issues(project, state, cmd.Labels, gitHubCommandLimit(cmd.Limit))
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := make([]GitLabIssueSummary, 0, len(issues))
+	rows := make([][]tableCell, 0, len(issues))
+	for _, issue := range issues {
+		summary := GitLabIssueSummary{
+			IID:       issue.IID,
+			Title:     issue.Title,
+			State:     issue.State,
+			URL:       issue.WebURL,
+			Author:    issue.Author.Username,
+			Labels:    issue.Labels,
+			Comments:  issue.UserNotesCount,
+			CreatedAt: issue.CreatedAt,
+		}
+		if summary.Labels == nil {
+			summary.Labels = []string{}
+		}
+		summaries = append(summaries, summary)
+
+		rows = append(rows, []tableCell{
+			linkCell("#"+strconv.Itoa(summary.IID), summary.URL),
+			textCell(summary.Title),
+			textCell(summary.State),
+			textCell(strings.Join(summary.Labels, ", ")),
+			textCell(summary.Author),
+			textCell(formatShortDate(summary.CreatedAt)),
+		})
+	}
+
+	return gitHubTableResult([]string{"Issue", "Title", "State", "Labels", "Author", "Created"}, rows, summaries), nil
+}
+
+// A GitLabPipelinesCommand represents the `/gitlab pipelines` command
+type GitLabPipelinesCommand struct {
+	Project string `toml:"project"`
+	Ref     string `toml:"ref"`
+	Status  string `toml:"status"`
+	Limit   int    `toml:"limit"`
+	Token   string `toml:"token"`
+}
+
+// ParseGitLabPipelinesCommand creates a new `/gitlab pipelines` command
+func ParseGitLabPipelinesCommand(params string) (*GitLabPipelinesCommand, error) {
+	var cmd GitLabPipelinesCommand
+
+	_, err := toml.Decode(params, &cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cmd, nil
+}
+
+// GitLabPipelineSummary is the structured result of a pipeline
+type GitLabPipelineSummary struct {
+	ID        int       `json:"id"`
+	Status    string    `json:"status"`
+	Ref       string    `json:"ref"`
+	SHA       string    `json:"sha"`
+	URL       string    `json:"url"`
+	Source    string    `json:"source"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Run lists the recent pipelines of the project
+func (cmd *GitLabPipelinesCommand) Run(ctx context.Context) (CommandResult, error) {
+	project, err := gitLabProjectPath(cmd.Project)
+	if err != nil {
+		return nil, err
+	}
+
+	status := strings.ToLower(strings.TrimSpace(cmd.Status))
+	if status != "" {
+		// Checked so that typos are not silently an empty list
+		status, err = gitLabStateParam(status, "running", "pending", "success", "failed", "canceled", "skipped", "created", "manual", "scheduled", "preparing", "waiting_for_resource")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	gitLab, err := gitLabAPIForCommand(ctx, cmd.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	pipelines, err := gitLab.Pipelines(project, strings.TrimSpace(cmd.Ref), status, gitHubCommandLimit(cmd.Limit))
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := make([]GitLabPipelineSummary, 0, len(pipelines))
+	rows := make([][]tableCell, 0, len(pipelines))
+	for _, pipeline := range pipelines {
+		summary := GitLabPipelineSummary{
+			ID:        pipeline.ID,
+			Status:    pipeline.Status,
+			Ref:       pipeline.Ref,
+			SHA:       pipeline.SHA,
+			URL:       pipeline.WebURL,
+			Source:    pipeline.Source,
+			CreatedAt: pipeline.CreatedAt,
+			UpdatedAt: pipeline.UpdatedAt,
+		}
+		summaries = append(summaries, summary)
+
+		shortSHA := summary.SHA
+		if len(shortSHA) > 8 {
+			shortSHA = shortSHA[:8]
+		}
+
+		rows = append(rows, []tableCell{
+			linkCell("#"+strconv.Itoa(summary.ID), summary.URL),
+			textCell(summary.Status),
+			textCell(summary.Ref),
+			textCell(shortSHA),
+			textCell(summary.Source),
+			textCell(formatShortDate(summary.CreatedAt)),
+		})
+	}
+
+	return gitHubTableResult([]string{"Pipeline", "Status", "Ref", "Commit", "Source", "Created"}, rows, summaries), nil
+}
@@ -8,7 +8,6 @@ import (
 	"net/http"
 
 	// "golang.org/x/net/html"
-	"google.golang.org/appengine/urlfetch"
 
 	"github.com/BurntSushi/toml"
 )
@@ -43,7 +42,7 @@ func ParseGraphQLRemoteQueryCommand(params string) (*GraphQLRemoteQueryCommand,
 
 // Run queries the remote GraphQL server
 func (cmd *GraphQLRemoteQueryCommand) Run(ctx context.Context) (CommandResult, error) {
-	client := urlfetch.Client(ctx)
+	fetcher := NewOutboundFetcher(ctx)
 
 	bodyJSON := &struct {
 		Query string `json:"query"`
@@ -69,7 +68,7 @@ func (cmd *GraphQLRemoteQueryCommand) Run(ctx context.Context) (CommandResult, e
 	request.Header = headers
 
 	// Perform the GraphQL query
-	res, err := client.Do(request)
+	res, err := fetcher.Do(request)
 	if err != nil {
 		return nil, err
 	}
@@ -96,6 +95,8 @@ func (cmd *GraphQLRemoteQueryCommand) Run(ctx context.Context) (CommandResult, e
 	htmlBuffer.WriteString("</pre>")
 
 	result := DangerousHTMLCommandResultFromSafe(htmlBuffer.String())
+	result.SetPlainText(string(resultJSONBytes))
+	result.SetJSON(resultJSON)
 
 	return result, nil
 }
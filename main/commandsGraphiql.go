@@ -38,11 +38,17 @@ func ParseGraphiqlMainCommand(params string) (*GraphiqlMainCommand, error) {
 
 // Run shows a Graphiql editor for the passed endpoint
 func (cmd *GraphiqlMainCommand) Run(ctx context.Context) (CommandResult, error) {
+	// The browser makes the requests, but it should not be pointed at internal addresses either
+	_, err := NewOutboundFetcher(ctx).CheckURL(cmd.EndpointURL)
+	if err != nil {
+		return nil, err
+	}
+
 	t := template.New("graphiql command")
 	t = template.Must(t.Parse(`
 {{define "result"}}
 <div id="collected-graphiql-command-result" style="height: 1000px;"></div>
-<script>
+<script nonce="{{ .CSPNonce }}">
 window.collectedTasks.push({
 	method: 'renderGraphiqlForURL',
 	params: {
@@ -56,8 +62,20 @@ window.collectedTasks.push({
 	`))
 
 	var htmlBuffer bytes.Buffer
-	t.ExecuteTemplate(&htmlBuffer, "result", cmd)
+	t.ExecuteTemplate(&htmlBuffer, "result", &struct {
+		*GraphiqlMainCommand
+		CSPNonce string
+	}{
+		GraphiqlMainCommand: cmd,
+		CSPNonce:            CSPNonceFromContext(ctx),
+	})
 	result := DangerousHTMLCommandResultFromSafe(htmlBuffer.String())
+	result.SetPlainText(cmd.EndpointURL)
+	result.SetJSON(&struct {
+		EndpointURL string `json:"endpoint"`
+	}{
+		EndpointURL: cmd.EndpointURL,
+	})
 
 	// result.wantsFullWidth = true
 	result.SetFullWidth(true)
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+
+	"github.com/BurntSushi/toml"
+)
+
+// ParseJSONCommand parses a /json … command
+func ParseJSONCommand(subcommands []string, params string) (Command, error) {
+	if len(subcommands) == 0 {
+		return ParseJSONFormatCommand(params)
+	}
+
+	return nil, fmt.Errorf("Unknown json subcommand(s) %v", subcommands)
+}
+
+// A JSONFormatCommand represents the `/json` command
+type JSONFormatCommand struct {
+	Path string `toml:"path"`
+}
+
+// ParseJSONFormatCommand creates a new `/json` command
+func ParseJSONFormatCommand(params string) (*JSONFormatCommand, error) {
+	var cmd JSONFormatCommand
+
+	_, err := toml.Decode(params, &cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cmd, nil
+}
+
+// Run needs input piped from another command
+func (cmd *JSONFormatCommand) Run(ctx context.Context) (CommandResult, error) {
+	return nil, fmt.Errorf("/json needs input piped from another command")
+}
+
+// RunWithInput formats the structured result of the previous command as JSON
+func (cmd *JSONFormatCommand) RunWithInput(ctx context.Context, input CommandResult) (CommandResult, error) {
+	value, err := commandResultValue(input)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err = selectJSONPath(value, cmd.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	jsonBytes, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	var htmlBuffer bytes.Buffer
+	htmlBuffer.WriteString(`<pre class="whitespace-pre-wrap break-words">`)
+	htmlBuffer.WriteString(html.EscapeString(string(jsonBytes)))
+	htmlBuffer.WriteString("</pre>")
+
+	result := DangerousHTMLCommandResultFromSafe(htmlBuffer.String())
+	result.SetPlainText(string(jsonBytes))
+	result.SetJSON(value)
+
+	return result, nil
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+)
+
+// splitPipelineStages splits command input at each line starting with `| /`,
+// except inside TOML multiline strings, which may contain such lines
+func splitPipelineStages(input string) []string {
+	lines := strings.Split(input, "\n")
+
+	var stages []string
+	var current []string
+	openDelimiter := ""
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if openDelimiter == "" && strings.HasPrefix(trimmed, "|") && strings.HasPrefix(strings.TrimSpace(trimmed[1:]), "/") {
+			stages = append(stages, strings.Join(current, "\n"))
+			current = []string{strings.TrimSpace(trimmed[1:])}
+			continue
+		}
+		current = append(current, line)
+		openDelimiter = tomlMultilineStringOpenAfter(line, openDelimiter)
+	}
+	stages = append(stages, strings.Join(current, "\n"))
+
+	return stages
+}
+
+// tomlMultilineStringOpenAfter returns the delimiter of a multiline string still open at the end of the line,
+// given the one open at its start, or "" if none is
+func tomlMultilineStringOpenAfter(line string, openDelimiter string) string {
+	for i := 0; i < len(line); {
+		if openDelimiter != "" {
+			if openDelimiter == `"""` && line[i] == '\\' {
+				i += 2
+			} else if strings.HasPrefix(line[i:], openDelimiter) {
+				i += len(openDelimiter)
+				openDelimiter = ""
+			} else {
+				i++
+			}
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line[i:], `"""`), strings.HasPrefix(line[i:], "'''"):
+			openDelimiter = line[i : i+3]
+			i += 3
+		case line[i] == '#':
+			return ""
+		case line[i] == '"':
+			// A basic string, whose quotes may be escaped
+			for i++; i < len(line) && line[i] != '"'; i++ {
+				if line[i] == '\\' {
+					i++
+				}
+			}
+			i++
+		case line[i] == '\'':
+			// A literal string, which has no escapes
+			end := strings.IndexByte(line[i+1:], '\'')
+			if end == -1 {
+				return ""
+			}
+			i += end + 2
+		default:
+			i++
+		}
+	}
+
+	return openDelimiter
+}
+
+// commandLineForInput returns the `/command subcommand` line of a command’s input
+func commandLineForInput(input string) string {
+	commandLine := strings.SplitN(strings.TrimSpace(input), "\n", 2)[0]
+	return "/" + strings.TrimLeft(strings.TrimSpace(commandLine), "/")
+}
+
+type pipelineStage struct {
+	commandLine string
+	command     Command
+	parseErr    error
+}
+
+// A PipelineCommand runs each command with the result of the one before it
+type PipelineCommand struct {
+	stages []pipelineStage
+}
+
+func parsePipelineCommand(stageInputs []string, preprocessParams func(string) (string, error)) (*PipelineCommand, error) {
+	stages := make([]pipelineStage, 0, len(stageInputs))
+	for i, stageInput := range stageInputs {
+		stage := pipelineStage{
+			commandLine: commandLineForInput(stageInput),
+		}
+
+		stage.command, stage.parseErr = parseCommandStage(stageInput, preprocessParams)
+		if stage.parseErr == nil && i > 0 {
+			if _, ok := stage.command.(PipedCommand); !ok {
+				stage.parseErr = fmt.Errorf("%s cannot be piped into", stage.commandLine)
+			}
+		}
+
+		stages = append(stages, stage)
+	}
+
+	cmd := PipelineCommand{stages}
+	return &cmd, nil
+}
+
+// PipelineStageReport describes how a stage of a pipeline went
+type PipelineStageReport struct {
+	Command string `json:"command"`
+	Error   string `json:"error,omitempty"`
+	Skipped bool   `json:"skipped,omitempty"`
+}
+
+// Run runs each stage in order, stopping at the first stage that fails
+func (cmd *PipelineCommand) Run(ctx context.Context) (CommandResult, error) {
+	reports := make([]PipelineStageReport, 0, len(cmd.stages))
+
+	var output CommandResult
+	failed := false
+	for i, stage := range cmd.stages {
+		report := PipelineStageReport{Command: stage.commandLine}
+
+		if failed {
+			report.Skipped = true
+			reports = append(reports, report)
+			continue
+		}
+
+		err := stage.parseErr
+		if err == nil {
+			var result CommandResult
+			if i == 0 {
+				result, err = stage.command.Run(ctx)
+			} else {
+				result, err = stage.command.(PipedCommand).RunWithInput(ctx, output)
+			}
+			if err == nil {
+				output = result
+			}
+		}
+
+		if err != nil {
+			report.Error = err.Error()
+			failed = true
+		}
+
+		reports = append(reports, report)
+	}
+
+	result := PipelineCommandResult{
+		output:  output,
+		reports: reports,
+	}
+	return &result, nil
+}
+
+// PipelineCommandResult is the result of the last stage that succeeded, along with how every stage went
+type PipelineCommandResult struct {
+	output  CommandResult
+	reports []PipelineStageReport
+}
+
+func (r *PipelineCommandResult) failedReport() (int, *PipelineStageReport) {
+	for i := range r.reports {
+		if r.reports[i].Error != "" {
+			return i, &r.reports[i]
+		}
+	}
+	return -1, nil
+}
+
+func (r *PipelineCommandResult) failureMessage() string {
+	i, report := r.failedReport()
+	if report == nil {
+		return ""
+	}
+	return "Stage " + strconv.Itoa(i+1) + " (" + report.Command + ") failed: " + report.Error
+}
+
+// WantsFullWidth returns true for full width, false for slim width
+func (r *PipelineCommandResult) WantsFullWidth() bool {
+	if r.output == nil {
+		return false
+	}
+	return r.output.WantsFullWidth()
+}
+
+// PlainText returns the result as an unformatted string
+func (r *PipelineCommandResult) PlainText() string {
+	var lines []string
+	if r.output != nil {
+		lines = append(lines, r.output.PlainText())
+	}
+	if message := r.failureMessage(); message != "" {
+		lines = append(lines, message)
+	}
+	return strings.Join(lines, "\n\n")
+}
+
+// HTML returns the result as HTML
+func (r *PipelineCommandResult) HTML() *CommandResultHTML {
+	var htmlBuffer bytes.Buffer
+	if message := r.failureMessage(); message != "" {
+		htmlBuffer.WriteString(`<p class="mb-2 px-3 py-2 bg-white border-t-4 border-red rounded-sm shadow"><span class="text-red-dark">Error: </span>` + html.EscapeString(message) + `</p>`)
+	}
+	if r.output != nil {
+		htmlBuffer.WriteString(SafeHTMLForCommandResult(r.output))
+	}
+	return &CommandResultHTML{unsafeHTML: htmlBuffer.String(), isActuallySafe: true}
+}
+
+// JSON returns the result as a value to be encoded as a JSON document
+func (r *PipelineCommandResult) JSON() interface{} {
+	var output interface{}
+	if r.output != nil {
+		output = r.output.JSON()
+	}
+
+	return &struct {
+		Stages []PipelineStageReport `json:"stages"`
+		Result interface{}           `json:"result"`
+	}{
+		Stages: r.reports,
+		Result: output,
+	}
+}
+
+// commandResultValue converts a result’s JSON into plain maps, slices, strings, float64s, and bools
+func commandResultValue(result CommandResult) (interface{}, error) {
+	data, err := json.Marshal(result.JSON())
+	if err != nil {
+		return nil, err
+	}
+
+	var value interface{}
+	err = json.Unmarshal(data, &value)
+	if err != nil {
+		return nil, err
+	}
+
+	return value, nil
+}
+
+// selectJSONPath finds the value at a dot separated path such as `data.repository.issues.0`
+func selectJSONPath(value interface{}, path string) (interface{}, error) {
+	path = strings.Trim(strings.TrimSpace(path), ".")
+	if path == "" {
+		return value, nil
+	}
+
+	for _, component := range strings.Split(path, ".") {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			child, ok := v[component]
+			if !ok {
+				return nil, fmt.Errorf("No %q found at path %q", component, path)
+			}
+			value = child
+		case []interface{}:
+			index, err := strconv.Atoi(component)
+			if err != nil || index < 0 || index >= len(v) {
+				return nil, fmt.Errorf("No index %q found at path %q", component, path)
+			}
+			value = v[index]
+		default:
+			return nil, fmt.Errorf("Cannot find %q at path %q", component, path)
+		}
+	}
+
+	return value, nil
+}
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"html"
+	"strings"
+
 	"github.com/microcosm-cc/bluemonday"
 )
 
@@ -15,6 +18,7 @@ type CommandResult interface {
 	WantsFullWidth() bool
 	PlainText() string
 	HTML() *CommandResultHTML
+	JSON() interface{}
 }
 
 // SafeHTMLForCommandResult santizes HTML
@@ -30,9 +34,18 @@ func SafeHTMLForCommandResult(r CommandResult) string {
 	return safe
 }
 
+// plainTextFromHTML strips all tags from HTML, leaving its text
+func plainTextFromHTML(unsafeHTML string) string {
+	p := bluemonday.StrictPolicy()
+	text := html.UnescapeString(p.Sanitize(unsafeHTML))
+	return strings.TrimSpace(text)
+}
+
 // HTMLCommandResult is a standard HTML result
 type HTMLCommandResult struct {
 	html           *CommandResultHTML
+	plainText      *string
+	json           interface{}
 	wantsFullWidth bool
 }
 
@@ -53,6 +66,16 @@ func (r *HTMLCommandResult) SetFullWidth(flag bool) {
 	r.wantsFullWidth = flag
 }
 
+// SetPlainText sets the unformatted rendering of the result
+func (r *HTMLCommandResult) SetPlainText(text string) {
+	r.plainText = &text
+}
+
+// SetJSON sets the structured rendering of the result, which must be encodable with encoding/json
+func (r *HTMLCommandResult) SetJSON(value interface{}) {
+	r.json = value
+}
+
 // WantsFullWidth returns true for full width, false for slim width
 func (r *HTMLCommandResult) WantsFullWidth() bool {
 	return r.wantsFullWidth
@@ -60,10 +83,27 @@ func (r *HTMLCommandResult) WantsFullWidth() bool {
 
 // PlainText returns the result as an unformatted string
 func (r *HTMLCommandResult) PlainText() string {
-	return ""
+	if r.plainText != nil {
+		return *r.plainText
+	}
+
+	return plainTextFromHTML(r.html.unsafeHTML)
 }
 
 // HTML returns the result as HTML
 func (r *HTMLCommandResult) HTML() *CommandResultHTML {
 	return r.html
 }
+
+// JSON returns the result as a value to be encoded as a JSON document
+func (r *HTMLCommandResult) JSON() interface{} {
+	if r.json != nil {
+		return r.json
+	}
+
+	return &struct {
+		Text string `json:"text"`
+	}{
+		Text: r.PlainText(),
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	baseLog "log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron"
+	"github.com/sergi/go-diff/diffmatchpatch"
+	"golang.org/x/oauth2"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+)
+
+const (
+	maxDueCommandSchedules = 50
+
+	commandSchedulesRunPath = "/_commands/schedules/run"
+)
+
+// scheduledCommandParamVariables are used when there is no viewer, such as when run on a schedule,
+// using the services connected to the account that set the schedule
+type scheduledCommandParamVariables struct {
+	ctx        context.Context
+	accountKey *datastore.Key
+}
+
+// GitHubOAuthToken returns the account’s GitHub access token, if it has connected GitHub
+func (vars *scheduledCommandParamVariables) GitHubOAuthToken() string {
+	return accessTokenForAccount(vars.ctx, gitHubOAuthProvider, vars.accountKey)
+}
+
+// GitLabOAuthToken returns the account’s GitLab access token, if it has connected GitLab
+func (vars *scheduledCommandParamVariables) GitLabOAuthToken() string {
+	return accessTokenForAccount(vars.ctx, gitLabOAuthProvider, vars.accountKey)
+}
+
+// TrelloAPI returns the Trello API for the account, if it has connected Trello
+func (vars *scheduledCommandParamVariables) TrelloAPI() *TrelloAPI {
+	accessToken := trelloOAuthProvider.AccessTokenForAccount(vars.ctx, vars.accountKey)
+	if accessToken == nil {
+		return nil
+	}
+
+	client, err := trelloOAuthProvider.Client(vars.ctx, accessToken)
+	if err != nil {
+		return nil
+	}
+
+	return &TrelloAPI{client: client}
+}
+
+// FigmaAPI returns the Figma API for the account, if it has connected Figma
+func (vars *scheduledCommandParamVariables) FigmaAPI() *FigmaAPI {
+	tokenSource := figmaOAuthProvider.TokenSourceForAccount(vars.ctx, vars.accountKey)
+	if tokenSource == nil {
+		return nil
+	}
+
+	return &FigmaAPI{client: oauth2.NewClient(vars.ctx, tokenSource)}
+}
+
+func accessTokenForAccount(ctx context.Context, provider *OAuth2Provider, accountKey *datastore.Key) string {
+	tokenSource := provider.TokenSourceForAccount(ctx, accountKey)
+	if tokenSource == nil {
+		return ""
+	}
+
+	token, err := tokenSource.Token()
+	if err != nil {
+		log.Warningf(ctx, "Could not get %s token: %v", provider.Name(), err)
+		return ""
+	}
+
+	return token.AccessToken
+}
+
+// lineDiff describes what changed between two outputs, one line at a time
+func lineDiff(before string, after string) string {
+	dmp := diffmatchpatch.New()
+	a, b, lines := dmp.DiffLinesToChars(before, after)
+	diffs := dmp.DiffCharsToLines(dmp.DiffMain(a, b, false), lines)
+
+	var buffer bytes.Buffer
+	for _, diff := range diffs {
+		prefix := "  "
+		if diff.Type == diffmatchpatch.DiffInsert {
+			prefix = "+ "
+		} else if diff.Type == diffmatchpatch.DiffDelete {
+			prefix = "- "
+		}
+
+		for _, line := range strings.SplitAfter(diff.Text, "\n") {
+			if line == "" {
+				continue
+			}
+			buffer.WriteString(prefix + strings.TrimSuffix(line, "\n") + "\n")
+		}
+	}
+
+	return buffer.String()
+}
+
+func fencedCodeBlock(language string, code string) string {
+	return "```" + language + "\n" + strings.TrimRight(code, "\n") + "\n```\n"
+}
+
+// scheduledRunMarkdown makes the body of the reply for a scheduled run, returning "" if nothing needs posting
+func scheduledRunMarkdown(schedule *CommandSchedule, output string, runErr error) string {
+	if runErr != nil {
+		if runErr.Error() == schedule.LastError {
+			return ""
+		}
+		return "Scheduled run failed: " + runErr.Error() + "\n"
+	}
+
+	hasRunBefore := !schedule.LastRunAt.IsZero()
+
+	if schedule.OnlyWhenChanged && hasRunBefore && output == schedule.LastOutput {
+		return ""
+	}
+
+	var buffer bytes.Buffer
+	if schedule.OnlyWhenChanged && hasRunBefore {
+		buffer.WriteString("Output changed since " + schedule.LastRunAt.Format(time.RFC822) + ":\n\n")
+		buffer.WriteString(fencedCodeBlock("diff", lineDiff(schedule.LastOutput, output)))
+	} else {
+		buffer.WriteString("Scheduled run:\n\n")
+		buffer.WriteString(fencedCodeBlock("", output))
+	}
+
+	return buffer.String()
+}
+
+func runCommandPost(ctx context.Context, post *Post, commandParamVars CommandParamVariables) (CommandResult, error) {
+	return RunCommandInput(ctx, post.Content.Source, commandParamVars)
+}
+
+// RunCommandSchedule runs the command post for a schedule and replies with the result
+func RunCommandSchedule(ctx context.Context, schedule *CommandSchedule, now time.Time) error {
+	orgRepo := NewOrgRepo(ctx, schedule.OrgSlug)
+	channelsRepo := NewChannelsRepo(ctx, orgRepo)
+	schedulesRepo := NewCommandSchedulesRepo(ctx)
+
+	postID := schedule.PostKey.Encode()
+	post, err := channelsRepo.GetPostWithIDInChannel(schedule.ChannelSlug, postID)
+	if err != nil {
+		return err
+	}
+
+	var output string
+	commandParamVars := &scheduledCommandParamVariables{ctx: ctx, accountKey: schedule.UserAccountKey}
+	result, runErr := runCommandPost(ctx, post, commandParamVars)
+	if runErr == nil {
+		output = result.PlainText()
+	}
+
+	markdownSource := scheduledRunMarkdown(schedule, output, runErr)
+	if markdownSource != "" {
+		reply, err := CreatePostMirroringReply(ctx, channelsRepo, commandParamVars.TrelloAPI(), CreatePostInput{
+			ChannelSlug:          schedule.ChannelSlug,
+			ParentPostKeyEncoded: &postID,
+			MarkdownSource:       markdownSource,
+		})
+		if reply == nil {
+			return err
+		}
+		if err != nil {
+			log.Warningf(ctx, "Could not mirror scheduled run of %s to Trello: %v", postID, err)
+		}
+	}
+
+	return schedulesRepo.RecordRun(schedule.Key, now, output, runErr)
+}
+
+// RunDueCommandSchedules runs every schedule that is due, returning how many ran
+func RunDueCommandSchedules(ctx context.Context, now time.Time) (int, error) {
+	schedulesRepo := NewCommandSchedulesRepo(ctx)
+
+	dueSchedules, err := schedulesRepo.ListDue(now, maxDueCommandSchedules)
+	if err != nil {
+		return 0, err
+	}
+
+	ranCount := 0
+	for _, dueSchedule := range dueSchedules {
+		schedule, claimed, err := schedulesRepo.Claim(dueSchedule.Key, now)
+		if err != nil {
+			log.Errorf(ctx, "Could not claim command schedule %v: %v", dueSchedule.Key, err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		err = RunCommandSchedule(ctx, schedule, now)
+		if err != nil {
+			log.Errorf(ctx, "Could not run command schedule %v: %v", dueSchedule.Key, err)
+			continue
+		}
+
+		ranCount++
+	}
+
+	return ranCount, nil
+}
+
+// StartLocalCommandScheduler calls the schedule dispatch route every minute, as cron.yaml is not run by the development server
+func StartLocalCommandScheduler() {
+	baseURL := os.Getenv("LOCAL_SCHEDULER_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:8080"
+	}
+
+	c := cron.New()
+	c.AddFunc("@every 1m", func() {
+		req, err := http.NewRequest("GET", baseURL+commandSchedulesRunPath, nil)
+		if err != nil {
+			return
+		}
+		req.Header.Set("X-Appengine-Cron", "true")
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			baseLog.Printf("Local command scheduler: %v", err)
+			return
+		}
+		res.Body.Close()
+	})
+	c.Start()
+}
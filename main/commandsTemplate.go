@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html"
+	textTemplate "text/template"
+
+	"github.com/BurntSushi/toml"
+)
+
+// ParseTemplateCommand parses a /template … command
+func ParseTemplateCommand(subcommands []string, params string) (Command, error) {
+	if len(subcommands) == 0 {
+		return ParseTemplateTextCommand(params)
+	}
+
+	return nil, fmt.Errorf("Unknown template subcommand(s) %v", subcommands)
+}
+
+// A TemplateTextCommand represents the `/template` command
+type TemplateTextCommand struct {
+	Path     string `toml:"path"`
+	Template string `toml:"template"`
+}
+
+// ParseTemplateTextCommand creates a new `/template` command
+func ParseTemplateTextCommand(params string) (*TemplateTextCommand, error) {
+	var cmd TemplateTextCommand
+
+	_, err := toml.Decode(params, &cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	if cmd.Template == "" {
+		return nil, fmt.Errorf("/template needs a template param")
+	}
+
+	return &cmd, nil
+}
+
+// Run needs input piped from another command
+func (cmd *TemplateTextCommand) Run(ctx context.Context) (CommandResult, error) {
+	return nil, fmt.Errorf("/template needs input piped from another command")
+}
+
+// RunWithInput renders the template with the structured result of the previous command
+func (cmd *TemplateTextCommand) RunWithInput(ctx context.Context, input CommandResult) (CommandResult, error) {
+	value, err := commandResultValue(input)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err = selectJSONPath(value, cmd.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := textTemplate.New("template command").Parse(cmd.Template)
+	if err != nil {
+		return nil, err
+	}
+
+	var textBuffer bytes.Buffer
+	err = t.Execute(&textBuffer, value)
+	if err != nil {
+		return nil, err
+	}
+	text := textBuffer.String()
+
+	result := DangerousHTMLCommandResultFromSafe(`<p class="whitespace-pre-wrap">` + html.EscapeString(text) + `</p>`)
+	result.SetPlainText(text)
+	result.SetJSON(&struct {
+		Text string `json:"text"`
+	}{
+		Text: text,
+	})
+
+	return result, nil
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+const (
+	defaultTrelloSearchLimit = 10
+	maxTrelloSearchLimit     = 100
+)
+
+// ParseTrelloCommand parses a /trello … command
+func ParseTrelloCommand(subcommands []string, params string) (Command, error) {
+	if len(subcommands) == 1 {
+		switch subcommands[0] {
+		case "boards":
+			return ParseTrelloBoardsCommand(params)
+		case "board":
+			return ParseTrelloBoardCommand(params)
+		case "card":
+			return ParseTrelloCardCommand(params)
+		case "search":
+			return ParseTrelloSearchCommand(params)
+		}
+	}
+
+	return nil, fmt.Errorf("Unknown trello subcommand(s) %v", subcommands)
+}
+
+func trelloAPIForCommand(ctx context.Context) (*TrelloAPI, error) {
+	trello := GetTrelloAPIFromContext(ctx)
+	if trello == nil {
+		return nil, errors.New("Sign in with Trello to use /trello commands")
+	}
+
+	return trello, nil
+}
+
+func formatTrelloDue(card *TrelloCard) string {
+	if card.Due == nil {
+		return ""
+	}
+
+	due := formatShortDate(*card.Due)
+	if card.DueComplete {
+		due += " (complete)"
+	}
+	return due
+}
+
+func trelloLabelNames(labels []TrelloLabel) string {
+	names := make([]string, 0, len(labels))
+	for _, label := range labels {
+		name := label.Name
+		if name == "" {
+			name = label.Color
+		}
+		names = append(names, name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// A TrelloBoardsCommand represents the `/trello boards` command
+type TrelloBoardsCommand struct{}
+
+// ParseTrelloBoardsCommand creates a new `/trello boards` command
+func ParseTrelloBoardsCommand(params string) (*TrelloBoardsCommand, error) {
+	var cmd TrelloBoardsCommand
+
+	_, err := toml.Decode(params, &cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cmd, nil
+}
+
+// Run lists the viewer’s open boards
+func (cmd *TrelloBoardsCommand) Run(ctx context.Context) (CommandResult, error) {
+	trello, err := trelloAPIForCommand(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	boards, err := trello.Boards()
+	if err != nil {
+		return nil, err
+	}
+
+	var htmlBuffer bytes.Buffer
+	var textBuffer bytes.Buffer
+	writeTable(&htmlBuffer, []string{"Board", "Description"}, func(t *tableWriter) {
+		for _, board := range boards {
+			t.row(linkCell(board.Name, board.URL), textCell(firstLine(board.Desc)))
+			textBuffer.WriteString(board.Name + "\t" + board.URL + "\n")
+		}
+	})
+
+	result := DangerousHTMLCommandResultFromSafe(htmlBuffer.String())
+	result.SetPlainText(textBuffer.String())
+	result.SetJSON(boards)
+
+	return result, nil
+}
+
+// A TrelloBoardCommand represents the `/trello board` command
+type TrelloBoardCommand struct {
+	Board string `toml:"board"`
+}
+
+// ParseTrelloBoardCommand creates a new `/trello board` command
+func ParseTrelloBoardCommand(params string) (*TrelloBoardCommand, error) {
+	var cmd TrelloBoardCommand
+
+	_, err := toml.Decode(params, &cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cmd, nil
+}
+
+// Run loads the board’s lists and cards, showing each list as a column
+func (cmd *TrelloBoardCommand) Run(ctx context.Context) (CommandResult, error) {
+	trello, err := trelloAPIForCommand(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	board, err := trello.Board(cmd.Board)
+	if err != nil {
+		return nil, err
+	}
+
+	var htmlBuffer bytes.Buffer
+	var textBuffer bytes.Buffer
+
+	htmlBuffer.WriteString(`<h3 class="mb-2"><a href="` + html.EscapeString(board.URL) + `" class="text-blue-dark">` + html.EscapeString(board.Name) + `</a></h3>`)
+	htmlBuffer.WriteString(`<div class="flex flex-row overflow-x-auto pb-2">`)
+	textBuffer.WriteString(board.Name + "\n")
+	for _, list := range board.Lists {
+		htmlBuffer.WriteString(`<div class="flex-none w-64 mr-2 p-2 bg-grey-lighter rounded">`)
+		htmlBuffer.WriteString(`<h4 class="mb-2">` + html.EscapeString(list.Name) + `</h4>`)
+		textBuffer.WriteString("\n" + list.Name + "\n")
+		for _, card := range list.Cards {
+			htmlBuffer.WriteString(`<div class="mb-2 p-2 bg-white rounded shadow">`)
+			htmlBuffer.WriteString(`<a href="` + html.EscapeString(card.URL) + `" class="text-black no-underline hover:underline">` + html.EscapeString(card.Name) + `</a>`)
+			if labels := trelloLabelNames(card.Labels); labels != "" {
+				htmlBuffer.WriteString(`<p class="mt-1 text-sm text-grey-darker">` + html.EscapeString(labels) + `</p>`)
+			}
+			if due := formatTrelloDue(&card); due != "" {
+				htmlBuffer.WriteString(`<p class="mt-1 text-sm text-grey-darker">Due ` + html.EscapeString(due) + `</p>`)
+			}
+			htmlBuffer.WriteString(`</div>`)
+			textBuffer.WriteString("- " + card.Name + "\n")
+		}
+		htmlBuffer.WriteString(`</div>`)
+	}
+	htmlBuffer.WriteString(`</div>`)
+
+	result := DangerousHTMLCommandResultFromSafe(htmlBuffer.String())
+	result.SetFullWidth(true)
+	result.SetPlainText(textBuffer.String())
+	result.SetJSON(board)
+
+	return result, nil
+}
+
+// A TrelloCardCommand represents the `/trello card` command
+type TrelloCardCommand struct {
+	Card string `toml:"card"`
+}
+
+// ParseTrelloCardCommand creates a new `/trello card` command
+func ParseTrelloCardCommand(params string) (*TrelloCardCommand, error) {
+	var cmd TrelloCardCommand
+
+	_, err := toml.Decode(params, &cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cmd, nil
+}
+
+// Run loads the card
+func (cmd *TrelloCardCommand) Run(ctx context.Context) (CommandResult, error) {
+	trello, err := trelloAPIForCommand(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	card, err := trello.Card(cmd.Card)
+	if err != nil {
+		return nil, err
+	}
+
+	var htmlBuffer bytes.Buffer
+	var textBuffer bytes.Buffer
+
+	htmlBuffer.WriteString(`<h3 class="mb-2"><a href="` + html.EscapeString(card.URL) + `" class="text-blue-dark">` + html.EscapeString(card.Name) + `</a></h3>`)
+	textBuffer.WriteString(card.Name + "\n")
+	writeDescriptionList(&htmlBuffer, func(dl *descriptionListWriter) {
+		if card.Board != nil {
+			dl.key("Board")
+			dl.value(card.Board.Name)
+			textBuffer.WriteString("Board: " + card.Board.Name + "\n")
+		}
+		if card.List != nil {
+			dl.key("List")
+			dl.value(card.List.Name)
+			textBuffer.WriteString("List: " + card.List.Name + "\n")
+		}
+		if labels := trelloLabelNames(card.Labels); labels != "" {
+			dl.key("Labels")
+			dl.value(labels)
+			textBuffer.WriteString("Labels: " + labels + "\n")
+		}
+		if due := formatTrelloDue(card); due != "" {
+			dl.key("Due")
+			dl.value(due)
+			textBuffer.WriteString("Due: " + due + "\n")
+		}
+	})
+	if card.Desc != "" {
+		htmlBuffer.WriteString(`<p class="mt-2 whitespace-pre-wrap">` + html.EscapeString(card.Desc) + `</p>`)
+		textBuffer.WriteString("\n" + card.Desc + "\n")
+	}
+
+	result := DangerousHTMLCommandResultFromSafe(htmlBuffer.String())
+	result.SetPlainText(textBuffer.String())
+	result.SetJSON(card)
+
+	return result, nil
+}
+
+// A TrelloSearchCommand represents the `/trello search` command
+type TrelloSearchCommand struct {
+	Query string `toml:"query"`
+	Limit int    `toml:"limit"`
+}
+
+// ParseTrelloSearchCommand creates a new `/trello search` command
+func ParseTrelloSearchCommand(params string) (*TrelloSearchCommand, error) {
+	var cmd TrelloSearchCommand
+
+	_, err := toml.Decode(params, &cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cmd, nil
+}
+
+func trelloSearchLimit(limit int) int {
+	if limit <= 0 {
+		return defaultTrelloSearchLimit
+	}
+	if limit > maxTrelloSearchLimit {
+		return maxTrelloSearchLimit
+	}
+	return limit
+}
+
+// Run finds cards matching the query
+func (cmd *TrelloSearchCommand) Run(ctx context.Context) (CommandResult, error) {
+	trello, err := trelloAPIForCommand(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cards, err := trello.SearchCards(cmd.Query, trelloSearchLimit(cmd.Limit))
+	if err != nil {
+		return nil, err
+	}
+
+	var htmlBuffer bytes.Buffer
+	var textBuffer bytes.Buffer
+	writeTable(&htmlBuffer, []string{"Card", "Board", "List", "Due"}, func(t *tableWriter) {
+		for i := range cards {
+			card := &cards[i]
+
+			var boardName, listName string
+			if card.Board != nil {
+				boardName = card.Board.Name
+			}
+			if card.List != nil {
+				listName = card.List.Name
+			}
+
+			t.row(linkCell(card.Name, card.URL), textCell(boardName), textCell(listName), textCell(formatTrelloDue(card)))
+			textBuffer.WriteString(strings.Join([]string{card.Name, boardName, listName, card.URL}, "\t") + "\n")
+		}
+	})
+
+	result := DangerousHTMLCommandResultFromSafe(htmlBuffer.String())
+	result.SetPlainText(textBuffer.String())
+	result.SetJSON(cards)
+
+	return result, nil
+}
@@ -3,6 +3,8 @@ package main
 import (
 	"bytes"
 	"fmt"
+	"strings"
+	"time"
 
 	"golang.org/x/net/html"
 )
@@ -25,3 +27,68 @@ func (dl *descriptionListWriter) key(key string) {
 func (dl *descriptionListWriter) value(value string) {
 	dl.htmlWriter.WriteString(fmt.Sprintf(`<dd class="mb-2">%s</dd>`, html.EscapeString(value)))
 }
+
+type tableCell struct {
+	text string
+	href string
+}
+
+func textCell(text string) tableCell {
+	return tableCell{text: text}
+}
+
+func linkCell(text string, href string) tableCell {
+	return tableCell{text: text, href: href}
+}
+
+type tableWriter struct {
+	htmlWriter *bytes.Buffer
+}
+
+func writeTable(htmlWriter *bytes.Buffer, header []string, f func(t *tableWriter)) {
+	htmlWriter.WriteString(`<div class="overflow-auto"><table class="w-full text-left border-collapse">`)
+	if len(header) > 0 {
+		htmlWriter.WriteString(`<thead><tr>`)
+		for _, cell := range header {
+			htmlWriter.WriteString(fmt.Sprintf(`<th class="px-2 py-1 font-bold border-b-2 border-grey">%s</th>`, html.EscapeString(cell)))
+		}
+		htmlWriter.WriteString(`</tr></thead>`)
+	}
+	htmlWriter.WriteString(`<tbody>`)
+	t := tableWriter{htmlWriter}
+	f(&t)
+	htmlWriter.WriteString(`</tbody></table></div>`)
+}
+
+func (t *tableWriter) row(cells ...tableCell) {
+	t.htmlWriter.WriteString(`<tr>`)
+	for _, cell := range cells {
+		t.htmlWriter.WriteString(`<td class="px-2 py-1 border-b border-grey-light">`)
+		if cell.href != "" {
+			t.htmlWriter.WriteString(fmt.Sprintf(`<a href="%s" class="text-blue-dark">%s</a>`, html.EscapeString(cell.href), html.EscapeString(cell.text)))
+		} else {
+			t.htmlWriter.WriteString(html.EscapeString(cell.text))
+		}
+		t.htmlWriter.WriteString(`</td>`)
+	}
+	t.htmlWriter.WriteString(`</tr>`)
+}
+
+func (t *tableWriter) textRow(cells ...string) {
+	tableCells := make([]tableCell, 0, len(cells))
+	for _, cell := range cells {
+		tableCells = append(tableCells, textCell(cell))
+	}
+	t.row(tableCells...)
+}
+
+func formatShortDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format("2006-01-02")
+}
+
+func firstLine(s string) string {
+	return strings.TrimSpace(strings.SplitN(s, "\n", 2)[0])
+}
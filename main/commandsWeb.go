@@ -4,11 +4,9 @@ import (
 	"bytes"
 	"context"
 	"fmt"
-	"net/url"
 	"strings"
 
 	"golang.org/x/net/html"
-	"google.golang.org/appengine/urlfetch"
 
 	"github.com/BurntSushi/toml"
 	"github.com/PuerkitoBio/goquery"
@@ -47,13 +45,13 @@ func ParseWebSnippetCommand(params string) (*WebSnippetCommand, error) {
 
 // Run fetches the web page and extracts a snippet of it
 func (cmd *WebSnippetCommand) Run(ctx context.Context) (CommandResult, error) {
-	client := urlfetch.Client(ctx)
-	url, err := url.Parse(cmd.URL)
+	fetcher := NewOutboundFetcher(ctx)
+	url, err := fetcher.CheckURL(cmd.URL)
 	if err != nil {
 		return nil, err
 	}
 	// Request the HTML page.
-	res, err := client.Get(cmd.URL)
+	res, err := fetcher.Get(cmd.URL)
 	if err != nil {
 		return nil, err
 	}
@@ -90,10 +88,25 @@ func (cmd *WebSnippetCommand) Run(ctx context.Context) (CommandResult, error) {
 	}
 
 	result := HTMLCommandResultFrom(htmlBuffer.String())
+	result.SetPlainText(strings.TrimSpace(dom.Text()))
+	result.SetJSON(&WebSnippetCommandResult{
+		URL:      cmd.URL,
+		Selector: cmd.Selector,
+		Text:     strings.TrimSpace(dom.Text()),
+		HTML:     SafeHTMLForCommandResult(result),
+	})
 
 	return result, nil
 }
 
+// WebSnippetCommandResult is the structured result of the `/web snippet` command
+type WebSnippetCommandResult struct {
+	URL      string  `json:"url"`
+	Selector *string `json:"selector"`
+	Text     string  `json:"text"`
+	HTML     string  `json:"html"`
+}
+
 // A WebMetaCommand represents the `/web meta` command
 type WebMetaCommand struct {
 	URL string `toml:"url"`
@@ -113,9 +126,9 @@ func ParseWebMetaCommand(params string) (*WebMetaCommand, error) {
 
 // Run fetches the web page and extracts the meta tags from it
 func (cmd *WebMetaCommand) Run(ctx context.Context) (CommandResult, error) {
-	client := urlfetch.Client(ctx)
+	fetcher := NewOutboundFetcher(ctx)
 	// Request the HTML page.
-	res, err := client.Get(cmd.URL)
+	res, err := fetcher.Get(cmd.URL)
 	if err != nil {
 		return nil, err
 	}
@@ -127,6 +140,12 @@ func (cmd *WebMetaCommand) Run(ctx context.Context) (CommandResult, error) {
 		return nil, err
 	}
 
+	metaResult := WebMetaCommandResult{
+		Titles:   []string{},
+		MetaTags: []WebMetaCommandResultMetaTag{},
+	}
+
+	var textBuffer bytes.Buffer
 	var htmlBuffer bytes.Buffer
 	htmlBuffer.WriteString(`<ol>`)
 	titleTags := doc.Find("title")
@@ -134,36 +153,69 @@ func (cmd *WebMetaCommand) Run(ctx context.Context) (CommandResult, error) {
 		if node.FirstChild == nil {
 			break
 		}
+		var valueElements []string
+		child := node.FirstChild
+		for child != nil {
+			if child.Type == html.TextNode {
+				valueElements = append(valueElements, child.Data)
+			}
+			child = child.NextSibling
+		}
+		title := strings.Join(valueElements, "")
+		metaResult.Titles = append(metaResult.Titles, title)
+		textBuffer.WriteString("title: " + title + "\n")
+
 		htmlBuffer.WriteString(`<div class="mb-2">`)
 		writeDescriptionList(&htmlBuffer, func(dl *descriptionListWriter) {
 			dl.key("title")
-			var valueElements []string
-			child := node.FirstChild
-			for child != nil {
-				if child.Type == html.TextNode {
-					valueElements = append(valueElements, child.Data)
-				}
-				child = child.NextSibling
-			}
-			dl.value(strings.Join(valueElements, ""))
+			dl.value(title)
 		})
 		htmlBuffer.WriteString(`</div>`)
 	}
 
 	metaTags := doc.Find("head meta")
 	for _, node := range metaTags.Nodes {
+		metaTag := WebMetaCommandResultMetaTag{
+			Attributes: make([]WebMetaCommandResultAttribute, 0, len(node.Attr)),
+		}
+		textBuffer.WriteString("\n")
+
 		htmlBuffer.WriteString(`<li class="mb-2">`)
 		writeDescriptionList(&htmlBuffer, func(dl *descriptionListWriter) {
 			for _, attr := range node.Attr {
+				metaTag.Attributes = append(metaTag.Attributes, WebMetaCommandResultAttribute{Key: attr.Key, Value: attr.Val})
+				textBuffer.WriteString(attr.Key + ": " + attr.Val + "\n")
+
 				dl.key(attr.Key)
 				dl.value(attr.Val)
 			}
 		})
 		htmlBuffer.WriteString(`</li>`)
+
+		metaResult.MetaTags = append(metaResult.MetaTags, metaTag)
 	}
 	htmlBuffer.WriteString(`</ol>`)
 
 	result := DangerousHTMLCommandResultFromSafe(htmlBuffer.String())
+	result.SetPlainText(strings.TrimSpace(textBuffer.String()))
+	result.SetJSON(&metaResult)
 
 	return result, nil
 }
+
+// WebMetaCommandResultAttribute is named the same in GraphQL
+type WebMetaCommandResultAttribute struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// WebMetaCommandResultMetaTag is named the same in GraphQL
+type WebMetaCommandResultMetaTag struct {
+	Attributes []WebMetaCommandResultAttribute `json:"attributes"`
+}
+
+// WebMetaCommandResult is named the same in GraphQL
+type WebMetaCommandResult struct {
+	Titles   []string                      `json:"titles"`
+	MetaTags []WebMetaCommandResultMetaTag `json:"metaTags"`
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/icza/session"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+)
+
+const (
+	csrfTokenSessionKey = "csrfToken"
+	// csrfTokenFormName is the hidden input that HTML forms send the token with
+	csrfTokenFormName = "csrfToken"
+	// csrfTokenHeader is how scripts send the token, having read it from /auth/status
+	csrfTokenHeader = "X-CSRF-Token"
+	// csrfCookieName holds the token of viewers without a session, so pages seen before signing in store nothing
+	csrfCookieName = "csrf"
+)
+
+var (
+	errCSRFToken       = errors.New("This form has expired. Please go back, reload the page, and try again.")
+	errCSRFTokenHeader = errors.New("Requests with a session must send the X-CSRF-Token header, whose value is the csrfToken from /auth/status")
+)
+
+// CSRFTokenForSession returns the session’s token for checking POSTs came from our own pages, creating it if needed
+func CSRFTokenForSession(sess session.Session) (string, error) {
+	if sess == nil {
+		return "", errNoSignInSession
+	}
+
+	token, ok := sess.Attr(csrfTokenSessionKey).(string)
+	if ok && token != "" {
+		return token, nil
+	}
+
+	token, err := randomURLSafeString(32)
+	if err != nil {
+		return "", err
+	}
+
+	sess.SetAttr(csrfTokenSessionKey, token)
+	return token, nil
+}
+
+// CSRFToken returns the token that forms posted by the viewer must include, or "" if they have no session
+func (v *Viewer) CSRFToken() string {
+	if v.sess == nil {
+		return ""
+	}
+
+	token, err := CSRFTokenForSession(v.sess)
+	if err != nil {
+		log.Errorf(v.ctx, "Could not create CSRF token: %v", err)
+		return ""
+	}
+
+	return token
+}
+
+// csrfCookieToken returns the token of a viewer without a session, or "" if they have not been given one
+func csrfCookieToken(r *http.Request) string {
+	cookie, err := r.Cookie(csrfCookieName)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+func setCSRFCookie(w http.ResponseWriter, token string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookieName,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(sessionDuration / time.Second),
+		Secure:   !IsDev(),
+		HttpOnly: true,
+	})
+}
+
+// expectedCSRFToken is the session’s token, or the cookie’s for viewers whose session has none
+func expectedCSRFToken(sess session.Session, r *http.Request) string {
+	if sess != nil {
+		if token, _ := sess.Attr(csrfTokenSessionKey).(string); token != "" {
+			return token
+		}
+	}
+	return csrfCookieToken(r)
+}
+
+// verifyCSRFToken checks the request sent the session’s token, either as a form value or header
+func verifyCSRFToken(sess session.Session, r *http.Request) error {
+	expected := expectedCSRFToken(sess, r)
+	if expected == "" {
+		return errCSRFToken
+	}
+
+	actual := r.Header.Get(csrfTokenHeader)
+	if actual == "" {
+		actual = r.PostFormValue(csrfTokenFormName)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(actual)) != 1 {
+		return errCSRFToken
+	}
+
+	return nil
+}
+
+func isSafeHTTPMethod(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS":
+		return true
+	}
+	return false
+}
+
+// csrfResponseWriter carries the token to the helpers that render forms
+type csrfResponseWriter struct {
+	http.ResponseWriter
+	csrfToken string
+}
+
+func (w *csrfResponseWriter) CSRFToken() string {
+	return w.csrfToken
+}
+
+func (w *csrfResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// csrfTokenFromWriter returns the token of a writer passed through WithCSRF, or "" otherwise
+func csrfTokenFromWriter(w io.Writer) string {
+	token := ""
+	findInResponseWriter(w, func(w io.Writer) bool {
+		tokenWriter, ok := w.(interface {
+			CSRFToken() string
+		})
+		if ok {
+			token = tokenWriter.CSRFToken()
+		}
+		return ok
+	})
+	return token
+}
+
+// csrfInputHTML is the hidden input to add to a form that is posted
+func csrfInputHTML(csrfToken string) string {
+	return string(renderHTMLPartial("csrfInput", csrfToken))
+}
+
+// WithCSRF rejects POSTs without the viewer’s CSRF token, and passes the token to form helpers via the writer.
+// Viewers without a session are given the token in a cookie, so forms on pages seen before signing in can be posted
+// without saving a session for every page view.
+func WithCSRF(f http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := appengine.NewContext(r)
+		sessmgr := GetSessionManager(ctx)
+
+		sess := sessmgr.Get(r)
+
+		if !isSafeHTTPMethod(r.Method) {
+			err := verifyCSRFToken(sess, r)
+			if err != nil {
+				sessmgr.Close()
+				log.Warningf(ctx, "Rejected %s %s: %v", r.Method, r.URL.Path, err)
+				writeCSRFErrorPage(w, r, err)
+				return
+			}
+		}
+
+		token := expectedCSRFToken(sess, r)
+		if sess != nil {
+			// Signing in keeps the token from before, so forms already open can still be posted
+			if _, ok := sess.Attr(csrfTokenSessionKey).(string); !ok && token != "" {
+				sess.SetAttr(csrfTokenSessionKey, token)
+			}
+
+			var err error
+			token, err = CSRFTokenForSession(sess)
+			if err != nil {
+				log.Errorf(ctx, "Could not create CSRF token: %v", err)
+			}
+		} else if token == "" {
+			var err error
+			token, err = randomURLSafeString(32)
+			if err != nil {
+				log.Errorf(ctx, "Could not create CSRF token: %v", err)
+			} else {
+				setCSRFCookie(w, token)
+			}
+		}
+
+		// Saved before the handler loads and changes the session, so neither overwrites the other
+		sessmgr.Close()
+
+		f(&csrfResponseWriter{ResponseWriter: w, csrfToken: token}, r)
+	})
+}
+
+// WithCSRFHeader rejects requests that change things unless they send the session’s token in the X-CSRF-Token header.
+// Other sites’ forms cannot set headers, so JSON APIs that browsers send the session cookie to use this.
+func WithCSRFHeader(f http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isSafeHTTPMethod(r.Method) {
+			f(w, r)
+			return
+		}
+
+		ctx := appengine.NewContext(r)
+		sessmgr := GetSessionManager(ctx)
+		sess := sessmgr.Get(r)
+		sessmgr.Close()
+
+		// Without a session, the request has nothing another site could make use of
+		if sess != nil {
+			expected, _ := sess.Attr(csrfTokenSessionKey).(string)
+			actual := r.Header.Get(csrfTokenHeader)
+			if expected == "" || subtle.ConstantTimeCompare([]byte(expected), []byte(actual)) != 1 {
+				log.Warningf(ctx, "Rejected %s %s: %v", r.Method, r.URL.Path, errCSRFTokenHeader)
+				writeErrorJSONWithStatus(w, http.StatusForbidden, errCSRFTokenHeader)
+				return
+			}
+		}
+
+		f(w, r)
+	})
+}
+
+// writeCSRFErrorPage explains the form could not be accepted, linking back to the page it came from
+func writeCSRFErrorPage(w http.ResponseWriter, r *http.Request, err error) {
+	backURL := "/"
+	if referer, parseErr := url.Parse(r.Referer()); parseErr == nil && referer.Host == r.Host && referer.Path != "" {
+		backURL = referer.RequestURI()
+	}
+
+	// Form posts that are only redirected have not been given the security headers yet
+	if cspNonceFromWriter(w) == "" {
+		w = setHTMLSecurityHeaders(w, r)
+	}
+
+	header := w.Header()
+	header.Set("Content-Type", "text/html; charset=utf-8")
+	header.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusForbidden)
+
+	htmlLayoutStart(w, htmlLayout{Title: "Form expired · Collected", CSPNonce: cspNonceFromWriter(w)})
+
+	template.Must(template.New("csrfError").Parse(`
+<main class="max-w-md mx-auto my-16">
+	<h1 class="mb-4">Please try that again</h1>
+	<p class="mb-4 text-lg leading-normal">{{.Message}}</p>
+	<a href="{{.BackURL}}" class="px-4 py-2 font-bold text-white bg-purple-dark border border-purple-darker rounded shadow no-underline hover:bg-purple hover:border-purple-dark">Go back</a>
+</main>
+`)).Execute(w, struct {
+		Message string
+		BackURL string
+	}{
+		Message: err.Error(),
+		BackURL: backURL,
+	})
+
+	htmlLayoutEnd(w)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"os"
+	"strings"
+)
+
+// tokenEncryptionDefaultKeyID is the ID of a TOKEN_ENCRYPTION_KEY given as a key alone
+const tokenEncryptionDefaultKeyID = "default"
+
+var (
+	errNoEncryptionKey      = errors.New("TOKEN_ENCRYPTION_KEY must be set to 32 base64-encoded bytes, or id:key pairs of them")
+	errCiphertextShort      = errors.New("Encrypted value is too short")
+	errCiphertextNoKeyID    = errors.New("Encrypted value has no key ID")
+	errCiphertextUnknownKey = errors.New("Encrypted value uses a key no longer in TOKEN_ENCRYPTION_KEY")
+)
+
+type tokenEncryptionKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// tokenEncryptionKeys makes AES-256-GCM for each key in TOKEN_ENCRYPTION_KEY, a comma-separated list of id:key pairs, or a single key.
+// The first key encrypts while all decrypt, so keys can be rotated by adding a new one first,
+// and removing the old one once every token has been connected again or refreshed.
+func tokenEncryptionKeys() ([]tokenEncryptionKey, error) {
+	var keys []tokenEncryptionKey
+	for _, pair := range strings.Split(os.Getenv("TOKEN_ENCRYPTION_KEY"), ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		// Base64 has no colons, so a key alone is told apart from a pair
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) == 1 {
+			parts = []string{tokenEncryptionDefaultKeyID, parts[0]}
+		}
+		if parts[0] == "" || strings.Trim(parts[0], storageURLKeyIDCharacters) != "" {
+			return nil, errNoEncryptionKey
+		}
+
+		secret, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil || len(secret) != 32 {
+			return nil, errNoEncryptionKey
+		}
+
+		block, err := aes.NewCipher(secret)
+		if err != nil {
+			return nil, err
+		}
+
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, tokenEncryptionKey{id: parts[0], aead: aead})
+	}
+
+	if len(keys) == 0 {
+		return nil, errNoEncryptionKey
+	}
+
+	return keys, nil
+}
+
+// encryptSecret seals the plaintext with the first key and a random nonce, binding it to the associated data.
+// The result is the key’s ID and a colon, then the nonce, then the sealed plaintext.
+func encryptSecret(plaintext []byte, associatedData []byte) ([]byte, error) {
+	keys, err := tokenEncryptionKeys()
+	if err != nil {
+		return nil, err
+	}
+	key := keys[0]
+
+	nonce := make([]byte, key.aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := append([]byte(key.id+":"), nonce...)
+	return key.aead.Seal(prefix, nonce, plaintext, associatedData), nil
+}
+
+// decryptSecret opens a value sealed by encryptSecret with the same associated data, using the key it names
+func decryptSecret(ciphertext []byte, associatedData []byte) ([]byte, error) {
+	keys, err := tokenEncryptionKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	separator := bytes.IndexByte(ciphertext, ':')
+	if separator == -1 {
+		return nil, errCiphertextNoKeyID
+	}
+	keyID := string(ciphertext[:separator])
+	ciphertext = ciphertext[separator+1:]
+
+	for _, key := range keys {
+		if key.id != keyID {
+			continue
+		}
+
+		if len(ciphertext) < key.aead.NonceSize() {
+			return nil, errCiphertextShort
+		}
+
+		nonce := ciphertext[:key.aead.NonceSize()]
+		return key.aead.Open(nil, nonce, ciphertext[key.aead.NonceSize():], associatedData)
+	}
+
+	return nil, errCiphertextUnknownKey
+}
@@ -2,38 +2,30 @@ package main
 
 import (
 	"context"
-	"crypto/rand"
-	"encoding/base64"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/icza/session"
 	"golang.org/x/oauth2"
 	"google.golang.org/appengine"
-	"google.golang.org/appengine/log"
-	"google.golang.org/appengine/urlfetch"
 )
 
-const (
-	figmaStateKey = "figmaState"
-	figmaTokenKey = "figmaToken"
-
-	figmaAPIBaseURL = "https://api.figma.com"
-)
-
-var (
-	figmaOauthCfg *oauth2.Config
-	figmaScopes   = []string{"file_read"}
-)
+const figmaAPIBaseURL = "https://api.figma.com"
 
 // FigmaAPI allows retrieving data from the Figma API
 type FigmaAPI struct {
+	// client authorizes requests, refreshing the token when expired
 	client *http.Client
-	token  oauth2.Token
 }
 
 func (figma *FigmaAPI) get(path string) (*http.Response, error) {
@@ -42,8 +34,6 @@ func (figma *FigmaAPI) get(path string) (*http.Response, error) {
 		return nil, errors.New("Unable to make request for Figma. " + err.Error())
 	}
 
-	req.Header.Set("Authorization", "Bearer "+figma.token.AccessToken)
-
 	return figma.client.Do(req)
 }
 
@@ -52,109 +42,372 @@ func (figma *FigmaAPI) ReadFile(key string) (*http.Response, error) {
 	return figma.get("/v1/files/" + key)
 }
 
-func init() {
-	clientID := os.Getenv("FIGMA_CLIENT_ID")
-	clientSecret := os.Getenv("FIGMA_CLIENT_SECRET")
+// FigmaNode is a page, frame, or other layer within a file
+type FigmaNode struct {
+	ID       string      `json:"id"`
+	Name     string      `json:"name"`
+	Type     string      `json:"type"`
+	Children []FigmaNode `json:"children,omitempty"`
+}
 
-	tokenURLQuery := url.Values{}
-	tokenURLQuery.Set("client_id", clientID)
-	tokenURLQuery.Add("client_secret", clientSecret)
-	tokenURL := "https://www.figma.com/api/oauth/token?" + tokenURLQuery.Encode()
+// FigmaFile is a file’s details with its pages and their top-level frames
+type FigmaFile struct {
+	Key          string      `json:"key"`
+	Name         string      `json:"name"`
+	LastModified time.Time   `json:"lastModified"`
+	ThumbnailURL string      `json:"thumbnailUrl"`
+	Version      string      `json:"version"`
+	Pages        []FigmaNode `json:"pages"`
+}
 
-	figmaOauthCfg = &oauth2.Config{
-		ClientID:     clientID,
-		ClientSecret: clientSecret,
-		Endpoint: oauth2.Endpoint{
-			AuthURL:  "https://www.figma.com/oauth",
-			TokenURL: tokenURL,
-		},
-		RedirectURL: os.Getenv("FIGMA_REDIRECT_URL"),
-		Scopes:      figmaScopes,
+// FigmaFrameInfo is the frame and page a component lives in
+type FigmaFrameInfo struct {
+	Name     string `json:"name"`
+	NodeID   string `json:"nodeId"`
+	PageID   string `json:"pageId"`
+	PageName string `json:"pageName"`
+}
+
+// FigmaComponent is a published component in a file
+type FigmaComponent struct {
+	Key             string          `json:"key"`
+	FileKey         string          `json:"file_key"`
+	NodeID          string          `json:"node_id"`
+	ThumbnailURL    string          `json:"thumbnail_url"`
+	Name            string          `json:"name"`
+	Description     string          `json:"description"`
+	ContainingFrame *FigmaFrameInfo `json:"containing_frame,omitempty"`
+}
+
+// FigmaStyle is a published fill, text, effect, or grid style in a file
+type FigmaStyle struct {
+	Key          string `json:"key"`
+	FileKey      string `json:"file_key"`
+	NodeID       string `json:"node_id"`
+	StyleType    string `json:"style_type"`
+	ThumbnailURL string `json:"thumbnail_url"`
+	Name         string `json:"name"`
+	Description  string `json:"description"`
+}
+
+// FigmaColor is an RGBA colour with channels from 0 to 1
+type FigmaColor struct {
+	R float64 `json:"r"`
+	G float64 `json:"g"`
+	B float64 `json:"b"`
+	A float64 `json:"a"`
+}
+
+// FigmaPaint is one fill of a node, such as a solid colour or gradient
+type FigmaPaint struct {
+	Type    string      `json:"type"`
+	Visible *bool       `json:"visible,omitempty"`
+	Opacity *float64    `json:"opacity,omitempty"`
+	Color   *FigmaColor `json:"color,omitempty"`
+}
+
+// FigmaTypeStyle is the font and spacing of a text node
+type FigmaTypeStyle struct {
+	FontFamily    string  `json:"fontFamily"`
+	FontWeight    float64 `json:"fontWeight"`
+	FontSize      float64 `json:"fontSize"`
+	LineHeightPx  float64 `json:"lineHeightPx"`
+	LetterSpacing float64 `json:"letterSpacing"`
+	TextCase      string  `json:"textCase,omitempty"`
+}
+
+// FigmaStyleNode is the node a published style is defined by, with the properties the style sets
+type FigmaStyleNode struct {
+	ID    string          `json:"id"`
+	Name  string          `json:"name"`
+	Type  string          `json:"type"`
+	Fills []FigmaPaint    `json:"fills,omitempty"`
+	Style *FigmaTypeStyle `json:"style,omitempty"`
+}
+
+// FigmaImage is a rendered export of a node, which Figma hosts temporarily
+type FigmaImage struct {
+	NodeID string `json:"nodeID"`
+	URL    string `json:"url"`
+}
+
+func (figma *FigmaAPI) getJSON(path string, query url.Values, out interface{}) error {
+	if len(query) > 0 {
+		path += "?" + query.Encode()
 	}
+
+	res, err := figma.get(path)
+	if err != nil {
+		return errors.New("Unable to communicate with Figma. " + err.Error())
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("Figma responded with %d: %s", res.StatusCode, strings.TrimSpace(string(message)))
+	}
+
+	return json.NewDecoder(res.Body).Decode(out)
 }
 
-func figmaOauthStartHandle(w http.ResponseWriter, r *http.Request) {
-	ctx := appengine.NewContext(r)
+// figmaFileKeyFromURL accepts a file key, or a file URL such as https://www.figma.com/file/{key}/name?node-id=1%3A2, returning the key and any node ID
+func figmaFileKeyFromURL(keyOrURL string) (string, string) {
+	keyOrURL = strings.TrimSpace(keyOrURL)
+	u, err := url.Parse(keyOrURL)
+	if err != nil || u.Host == "" {
+		return keyOrURL, ""
+	}
+
+	nodeID := u.Query().Get("node-id")
+	// Newer URLs write node IDs such as 1:2 as 1-2
+	if !strings.Contains(nodeID, ":") {
+		nodeID = strings.Replace(nodeID, "-", ":", 1)
+	}
+
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) >= 2 && (parts[0] == "file" || parts[0] == "design" || parts[0] == "proto") {
+		return parts[1], nodeID
+	}
+
+	return keyOrURL, nodeID
+}
+
+func figmaFileKey(keyOrURL string) (string, error) {
+	key, _ := figmaFileKeyFromURL(keyOrURL)
+	if key == "" {
+		return "", errors.New("Figma file cannot be empty")
+	}
+	return key, nil
+}
 
-	stateBytes := make([]byte, 16)
-	_, err := rand.Read(stateBytes)
+// File loads a file’s details, its pages, and each page’s top-level frames
+func (figma *FigmaAPI) File(keyOrURL string) (*FigmaFile, error) {
+	key, err := figmaFileKey(keyOrURL)
 	if err != nil {
-		log.Errorf(ctx, "Could not generate random state")
-		writeErrorJSON(w, err)
-		return
+		return nil, err
 	}
 
-	state := base64.URLEncoding.EncodeToString(stateBytes)
+	query := url.Values{}
+	query.Set("depth", "2")
 
-	sessmgr := GetSessionManager(ctx)
-	defer sessmgr.Close()
+	var result struct {
+		Name         string    `json:"name"`
+		LastModified time.Time `json:"lastModified"`
+		ThumbnailURL string    `json:"thumbnailUrl"`
+		Version      string    `json:"version"`
+		Document     FigmaNode `json:"document"`
+	}
+	err = figma.getJSON("/v1/files/"+url.PathEscape(key), query, &result)
+	if err != nil {
+		return nil, err
+	}
 
-	sess := sessmgr.Get(r)
-	if sess != nil {
-		sess.SetAttr(figmaStateKey, state)
-	} else {
-		sess = session.NewSessionOptions(&session.SessOptions{
-			CAttrs: map[string]interface{}{},
-			Attrs:  map[string]interface{}{figmaStateKey: state},
-		})
-		sessmgr.Add(sess, w)
+	return &FigmaFile{
+		Key:          key,
+		Name:         result.Name,
+		LastModified: result.LastModified,
+		ThumbnailURL: result.ThumbnailURL,
+		Version:      result.Version,
+		Pages:        result.Document.Children,
+	}, nil
+}
+
+// FileVersion loads just the file’s current version, which changes whenever the file is edited
+func (figma *FigmaAPI) FileVersion(keyOrURL string) (string, error) {
+	key, err := figmaFileKey(keyOrURL)
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{}
+	query.Set("depth", "1")
+
+	var result struct {
+		Version string `json:"version"`
+	}
+	err = figma.getJSON("/v1/files/"+url.PathEscape(key), query, &result)
+	if err != nil {
+		return "", err
 	}
 
-	url := figmaOauthCfg.AuthCodeURL(state)
-	http.Redirect(w, r, url, 302)
+	return result.Version, nil
 }
 
-func oAuthCallbackHandle(w http.ResponseWriter, r *http.Request) {
-	ctx := appengine.NewContext(r)
+// Components lists the components published from a file
+func (figma *FigmaAPI) Components(keyOrURL string) ([]FigmaComponent, error) {
+	key, err := figmaFileKey(keyOrURL)
+	if err != nil {
+		return nil, err
+	}
 
-	sessmgr := GetSessionManager(ctx)
-	defer sessmgr.Close()
+	var result struct {
+		Meta struct {
+			Components []FigmaComponent `json:"components"`
+		} `json:"meta"`
+	}
+	err = figma.getJSON("/v1/files/"+url.PathEscape(key)+"/components", nil, &result)
+	return result.Meta.Components, err
+}
 
-	sess := sessmgr.Get(r)
-	if sess == nil {
-		http.Error(w, "No session present for signing in. Please try again.", http.StatusExpectationFailed)
-		return
+// Styles lists the styles published from a file
+func (figma *FigmaAPI) Styles(keyOrURL string) ([]FigmaStyle, error) {
+	key, err := figmaFileKey(keyOrURL)
+	if err != nil {
+		return nil, err
 	}
 
-	expectedState := sess.Attr(figmaStateKey)
-	givenState := r.URL.Query().Get("state")
-	if expectedState != givenState {
-		http.Error(w, "State does not match one at start of sign in flow. Please try again.", http.StatusExpectationFailed)
-		return
+	var result struct {
+		Meta struct {
+			Styles []FigmaStyle `json:"styles"`
+		} `json:"meta"`
 	}
+	err = figma.getJSON("/v1/files/"+url.PathEscape(key)+"/styles", nil, &result)
+	return result.Meta.Styles, err
+}
 
-	code := r.URL.Query().Get("code")
-	token, err := figmaOauthCfg.Exchange(ctx, code)
+// StyleNodes loads the nodes that define styles, keyed by node ID, leaving out any that no longer exist
+func (figma *FigmaAPI) StyleNodes(keyOrURL string, nodeIDs []string) (map[string]FigmaStyleNode, error) {
+	key, err := figmaFileKey(keyOrURL)
 	if err != nil {
-		http.Error(w, "Could not get Figma token. Please try again. "+err.Error(), http.StatusBadRequest)
-		return
+		return nil, err
 	}
 
-	if !token.Valid() {
-		http.Error(w, "Figma token is invalid. Please try again.", http.StatusBadRequest)
-		return
+	nodes := make(map[string]FigmaStyleNode, len(nodeIDs))
+	if len(nodeIDs) == 0 {
+		return nodes, nil
+	}
+
+	query := url.Values{}
+	query.Set("ids", strings.Join(nodeIDs, ","))
+
+	var result struct {
+		Nodes map[string]*struct {
+			Document FigmaStyleNode `json:"document"`
+		} `json:"nodes"`
+	}
+	err = figma.getJSON("/v1/files/"+url.PathEscape(key)+"/nodes", query, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	for nodeID, node := range result.Nodes {
+		if node != nil {
+			nodes[nodeID] = node.Document
+		}
+	}
+
+	return nodes, nil
+}
+
+// Images renders nodes of a file as png or jpg, in the order of the node IDs given
+func (figma *FigmaAPI) Images(keyOrURL string, nodeIDs []string, format string, scale float64) ([]FigmaImage, error) {
+	key, err := figmaFileKey(keyOrURL)
+	if err != nil {
+		return nil, err
+	}
+	if len(nodeIDs) == 0 {
+		return nil, errors.New("Figma nodes to render cannot be empty")
+	}
+
+	query := url.Values{}
+	query.Set("ids", strings.Join(nodeIDs, ","))
+	query.Set("format", format)
+	query.Set("scale", strconv.FormatFloat(scale, 'f', -1, 64))
+
+	var result struct {
+		Err    *string            `json:"err"`
+		Images map[string]*string `json:"images"`
+	}
+	err = figma.getJSON("/v1/images/"+url.PathEscape(key), query, &result)
+	if err != nil {
+		return nil, err
+	}
+	if result.Err != nil {
+		return nil, errors.New("Figma could not render images: " + *result.Err)
+	}
+
+	images := make([]FigmaImage, 0, len(nodeIDs))
+	for _, nodeID := range nodeIDs {
+		imageURL := result.Images[nodeID]
+		if imageURL == nil {
+			return nil, fmt.Errorf("Figma could not render node %s", nodeID)
+		}
+		images = append(images, FigmaImage{NodeID: nodeID, URL: *imageURL})
+	}
+
+	return images, nil
+}
+
+func makeFigmaOAuthConfig() *oauth2.Config {
+	clientID := os.Getenv("FIGMA_CLIENT_ID")
+	clientSecret := os.Getenv("FIGMA_CLIENT_SECRET")
+
+	tokenURLQuery := url.Values{}
+	tokenURLQuery.Set("client_id", clientID)
+	tokenURLQuery.Add("client_secret", clientSecret)
+	tokenURL := "https://www.figma.com/api/oauth/token?" + tokenURLQuery.Encode()
+
+	return &oauth2.Config{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  "https://www.figma.com/oauth",
+			TokenURL: tokenURL,
+		},
+		RedirectURL: os.Getenv("FIGMA_REDIRECT_URL"),
+		Scopes:      []string{"file_read"},
 	}
+}
 
-	sess.SetAttr(figmaTokenKey, token)
+// Figma has no endpoint for revoking tokens, so disconnecting only forgets it
+var figmaOAuthProvider = &OAuth2Provider{
+	id:       "figma",
+	name:     "Figma",
+	config:   makeFigmaOAuthConfig(),
+	pkce:     true,
+	identify: identifyFigmaUser,
+}
 
-	afterSignInHandle(w, r)
+// identifyFigmaUser reads the signed in user’s ID and handle
+func identifyFigmaUser(ctx context.Context, client *http.Client) (string, string, error) {
+	var user struct {
+		ID     string `json:"id"`
+		Handle string `json:"handle"`
+	}
+	err := getOAuthJSON(client, figmaAPIBaseURL+"/v1/me", &user)
+	if err != nil {
+		return "", "", err
+	}
+
+	return user.ID, user.Handle, nil
+}
+
+func init() {
+	registerOAuthProvider(figmaOAuthProvider)
 }
 
-// GetFigmaAPIFromSession returns a http.Client from a session
+// GetFigmaAPIFromSession returns a FigmaAPI for the session’s account
 func GetFigmaAPIFromSession(ctx context.Context, sess session.Session) *FigmaAPI {
-	token, ok := sess.Attr(figmaTokenKey).(oauth2.Token)
-	if !ok {
+	client := figmaOAuthProvider.Client(ctx, sess)
+	if client == nil {
 		return nil
 	}
 
-	client := urlfetch.Client(ctx)
-
 	return &FigmaAPI{
 		client: client,
-		token:  token,
 	}
 }
 
+// GetFigmaAPIFromContext returns a FigmaAPI for the viewer whose command param variables are in the context
+func GetFigmaAPIFromContext(ctx context.Context) *FigmaAPI {
+	commandParamVars := CommandParamVariablesFromContext(ctx)
+	if commandParamVars == nil {
+		return nil
+	}
+
+	return commandParamVars.FigmaAPI()
+}
+
 func figmaReadDocumentHandle(w http.ResponseWriter, r *http.Request) {
 	ctx := appengine.NewContext(r)
 
@@ -188,14 +441,8 @@ func figmaReadDocumentHandle(w http.ResponseWriter, r *http.Request) {
 	w.Write(data)
 }
 
-// AddFigmaRoutes adds routes for signing in and reading from GitHub
+// AddFigmaRoutes adds routes for reading from Figma
 func AddFigmaRoutes(r *mux.Router) {
-	r.Path("/signin/figma").Methods("GET").
-		HandlerFunc(figmaOauthStartHandle)
-
-	r.Path("/signin/figma/callback").Methods("GET").
-		HandlerFunc(oAuthCallbackHandle)
-
 	r.Path("/figma/files/{key}").Methods("GET").
 		HandlerFunc(figmaReadDocumentHandle)
 }
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/lucasb-eyer/go-colorful"
+)
+
+// ColorToken is a colour design token, converted from a Figma fill style
+type ColorToken struct {
+	Name  string  `json:"name"`
+	Style string  `json:"style"`
+	Hex   string  `json:"hex"`
+	Alpha float64 `json:"alpha"`
+}
+
+// TypographyToken is a type design token, converted from a Figma text style
+type TypographyToken struct {
+	Name          string  `json:"name"`
+	Style         string  `json:"style"`
+	FontFamily    string  `json:"fontFamily"`
+	FontWeight    int     `json:"fontWeight"`
+	FontSize      float64 `json:"fontSize"`
+	LineHeight    float64 `json:"lineHeight,omitempty"`
+	LetterSpacing float64 `json:"letterSpacing"`
+}
+
+// DesignTokens are the colours and type styles of a Figma file
+type DesignTokens struct {
+	FileKey    string            `json:"fileKey"`
+	Colors     []ColorToken      `json:"colors"`
+	Typography []TypographyToken `json:"typography"`
+}
+
+// designTokenName makes a style name such as “Brand / Blue 500” into brand-blue-500
+func designTokenName(styleName string) string {
+	var buffer bytes.Buffer
+	pendingDash := false
+	for _, r := range strings.ToLower(styleName) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if pendingDash && buffer.Len() > 0 {
+				buffer.WriteRune('-')
+			}
+			buffer.WriteRune(r)
+			pendingDash = false
+		} else {
+			pendingDash = true
+		}
+	}
+
+	if buffer.Len() == 0 {
+		return "unnamed"
+	}
+	return buffer.String()
+}
+
+// uniqueDesignTokenName adds a number to names already used, as different styles can slug to the same name
+func uniqueDesignTokenName(name string, used map[string]bool) string {
+	unique := name
+	for i := 2; used[unique]; i++ {
+		unique = name + "-" + strconv.Itoa(i)
+	}
+	used[unique] = true
+	return unique
+}
+
+// colorTokenFromFills converts the single visible solid fill, returning false for gradients, images, or layered fills
+func colorTokenFromFills(fills []FigmaPaint) (ColorToken, bool) {
+	var solid *FigmaPaint
+	for i := range fills {
+		fill := &fills[i]
+		if fill.Visible != nil && !*fill.Visible {
+			continue
+		}
+		if solid != nil || fill.Type != "SOLID" || fill.Color == nil {
+			return ColorToken{}, false
+		}
+		solid = fill
+	}
+	if solid == nil {
+		return ColorToken{}, false
+	}
+
+	alpha := solid.Color.A
+	if solid.Opacity != nil {
+		alpha *= *solid.Opacity
+	}
+
+	color := colorful.Color{R: solid.Color.R, G: solid.Color.G, B: solid.Color.B}.Clamped()
+	return ColorToken{Hex: color.Hex(), Alpha: alpha}, true
+}
+
+// DesignTokensFromFigmaStyles converts fill and text styles into tokens, skipping styles that cannot be expressed as one
+func DesignTokensFromFigmaStyles(fileKey string, styles []FigmaStyle, nodes map[string]FigmaStyleNode) *DesignTokens {
+	tokens := DesignTokens{
+		FileKey:    fileKey,
+		Colors:     []ColorToken{},
+		Typography: []TypographyToken{},
+	}
+
+	sorted := make([]FigmaStyle, len(styles))
+	copy(sorted, styles)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	usedColorNames := make(map[string]bool)
+	usedTypographyNames := make(map[string]bool)
+	for _, style := range sorted {
+		node, ok := nodes[style.NodeID]
+		if !ok {
+			continue
+		}
+
+		switch style.StyleType {
+		case "FILL":
+			token, ok := colorTokenFromFills(node.Fills)
+			if !ok {
+				continue
+			}
+			token.Name = uniqueDesignTokenName(designTokenName(style.Name), usedColorNames)
+			token.Style = style.Name
+			tokens.Colors = append(tokens.Colors, token)
+		case "TEXT":
+			if node.Style == nil {
+				continue
+			}
+			tokens.Typography = append(tokens.Typography, TypographyToken{
+				Name:          uniqueDesignTokenName(designTokenName(style.Name), usedTypographyNames),
+				Style:         style.Name,
+				FontFamily:    node.Style.FontFamily,
+				FontWeight:    int(node.Style.FontWeight),
+				FontSize:      node.Style.FontSize,
+				LineHeight:    node.Style.LineHeightPx,
+				LetterSpacing: node.Style.LetterSpacing,
+			})
+		}
+	}
+
+	return &tokens
+}
+
+func formatPx(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64) + "px"
+}
+
+// cssValue is the hex colour, or rgba() when it is translucent
+func (token ColorToken) cssValue() string {
+	if token.Alpha >= 1 {
+		return token.Hex
+	}
+
+	color, err := colorful.Hex(token.Hex)
+	if err != nil {
+		return token.Hex
+	}
+	r, g, b := color.RGB255()
+	return fmt.Sprintf("rgba(%d, %d, %d, %s)", r, g, b, strconv.FormatFloat(token.Alpha, 'g', 3, 64))
+}
+
+func cssFontFamily(family string) string {
+	return strconv.Quote(family) + ", sans-serif"
+}
+
+// CSS renders the tokens as custom properties on :root
+func (tokens *DesignTokens) CSS() string {
+	var buffer bytes.Buffer
+	buffer.WriteString(":root {\n")
+	for _, token := range tokens.Colors {
+		buffer.WriteString("  --color-" + token.Name + ": " + token.cssValue() + ";\n")
+	}
+	for _, token := range tokens.Typography {
+		prefix := "  --font-" + token.Name
+		buffer.WriteString(prefix + "-family: " + cssFontFamily(token.FontFamily) + ";\n")
+		buffer.WriteString(prefix + "-weight: " + strconv.Itoa(token.FontWeight) + ";\n")
+		buffer.WriteString(prefix + "-size: " + formatPx(token.FontSize) + ";\n")
+		if token.LineHeight > 0 {
+			buffer.WriteString(prefix + "-line-height: " + formatPx(token.LineHeight) + ";\n")
+		}
+		buffer.WriteString(prefix + "-letter-spacing: " + formatPx(token.LetterSpacing) + ";\n")
+	}
+	buffer.WriteString("}\n")
+	return buffer.String()
+}
+
+type designTokenValue struct {
+	Type  string      `json:"$type"`
+	Value interface{} `json:"$value"`
+}
+
+// TokensJSON renders the tokens in the W3C design tokens format
+func (tokens *DesignTokens) TokensJSON() ([]byte, error) {
+	colors := make(map[string]designTokenValue, len(tokens.Colors))
+	for _, token := range tokens.Colors {
+		colors[token.Name] = designTokenValue{Type: "color", Value: token.cssValue()}
+	}
+
+	typography := make(map[string]designTokenValue, len(tokens.Typography))
+	for _, token := range tokens.Typography {
+		value := map[string]interface{}{
+			"fontFamily":    token.FontFamily,
+			"fontWeight":    token.FontWeight,
+			"fontSize":      formatPx(token.FontSize),
+			"letterSpacing": formatPx(token.LetterSpacing),
+		}
+		if token.LineHeight > 0 {
+			value["lineHeight"] = formatPx(token.LineHeight)
+		}
+		typography[token.Name] = designTokenValue{Type: "typography", Value: value}
+	}
+
+	return json.MarshalIndent(map[string]interface{}{
+		"color":      colors,
+		"typography": typography,
+	}, "", "  ")
+}
+
+func jsString(s string) string {
+	return "'" + strings.Replace(strings.Replace(s, `\`, `\\`, -1), "'", `\'`, -1) + "'"
+}
+
+// TailwindConfig renders the tokens as a tailwind.config.js theme extension
+func (tokens *DesignTokens) TailwindConfig() string {
+	var buffer bytes.Buffer
+	buffer.WriteString("module.exports = {\n  theme: {\n    extend: {\n")
+
+	buffer.WriteString("      colors: {\n")
+	for _, token := range tokens.Colors {
+		buffer.WriteString("        " + jsString(token.Name) + ": " + jsString(token.cssValue()) + ",\n")
+	}
+	buffer.WriteString("      },\n")
+
+	buffer.WriteString("      fontFamily: {\n")
+	for _, token := range tokens.Typography {
+		buffer.WriteString("        " + jsString(token.Name) + ": [" + jsString(token.FontFamily) + ", 'sans-serif'],\n")
+	}
+	buffer.WriteString("      },\n")
+
+	buffer.WriteString("      fontSize: {\n")
+	for _, token := range tokens.Typography {
+		buffer.WriteString("        " + jsString(token.Name) + ": [" + jsString(formatPx(token.FontSize)) + ", { ")
+		if token.LineHeight > 0 {
+			buffer.WriteString("lineHeight: " + jsString(formatPx(token.LineHeight)) + ", ")
+		}
+		buffer.WriteString("letterSpacing: " + jsString(formatPx(token.LetterSpacing)) + ", fontWeight: " + jsString(strconv.Itoa(token.FontWeight)) + " }],\n")
+	}
+	buffer.WriteString("      },\n")
+
+	buffer.WriteString("    },\n  },\n}\n")
+	return buffer.String()
+}
@@ -1,11 +1,14 @@
 package main
 
 import (
+	"bytes"
 	"context"
-	"crypto/rand"
-	"encoding/base64"
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
 
 	// "cloud.google.com/go/datastore"
 	"github.com/google/go-github/github"
@@ -13,115 +16,115 @@ import (
 	"github.com/icza/session"
 	"golang.org/x/oauth2"
 	oauthGitHub "golang.org/x/oauth2/github"
-	"google.golang.org/appengine"
 	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/urlfetch"
 )
 
-const (
-	gitHubStateKey = "gitHubState"
-	gitHubTokenKey = "gitHubToken"
-)
-
-var (
-	githubOauthCfg *oauth2.Config
-	githubScopes   = []string{"user", "repo"}
-)
-
-func init() {
-	githubOauthCfg = &oauth2.Config{
+var gitHubOAuthProvider = &OAuth2Provider{
+	id:   "github",
+	name: "GitHub",
+	config: &oauth2.Config{
 		ClientID:     os.Getenv("GITHUB_CLIENT_ID"),
 		ClientSecret: os.Getenv("GITHUB_CLIENT_SECRET"),
 		Endpoint:     oauthGitHub.Endpoint,
 		RedirectURL:  os.Getenv("GITHUB_REDIRECT_URL"),
-		Scopes:       githubScopes,
-	}
+		Scopes:       []string{"user", "repo"},
+	},
+	pkce:     true,
+	identify: identifyGitHubUser,
+	revoke:   revokeGitHubToken,
 }
 
-func githubOAuthStartHandle(w http.ResponseWriter, r *http.Request) {
-	ctx := appengine.NewContext(r)
+func init() {
+	registerOAuthProvider(gitHubOAuthProvider)
+}
 
-	stateBytes := make([]byte, 16)
-	_, err := rand.Read(stateBytes)
-	if err != nil {
-		log.Errorf(ctx, "Could not generate random state")
-		writeErrorJSON(w, err)
-		return
+// identifyGitHubUser reads the signed in user’s numeric ID, which unlike their login never changes
+func identifyGitHubUser(ctx context.Context, client *http.Client) (string, string, error) {
+	var user struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
 	}
-
-	state := base64.URLEncoding.EncodeToString(stateBytes)
-
-	sessmgr := GetSessionManager(ctx)
-	defer sessmgr.Close()
-
-	sess := sessmgr.Get(r)
-	if sess != nil {
-		sess.SetAttr(gitHubStateKey, state)
-	} else {
-		sess = session.NewSessionOptions(&session.SessOptions{
-			CAttrs: map[string]interface{}{},
-			Attrs:  map[string]interface{}{gitHubStateKey: state},
-		})
-		sessmgr.Add(sess, w)
+	err := getOAuthJSON(client, "https://api.github.com/user", &user)
+	if err != nil {
+		return "", "", err
 	}
 
-	url := githubOauthCfg.AuthCodeURL(state)
-	http.Redirect(w, r, url, 302)
+	return strconv.FormatInt(user.ID, 10), user.Login, nil
 }
 
-func githubOAuthCallbackHandle(w http.ResponseWriter, r *http.Request) {
-	ctx := appengine.NewContext(r)
-
-	sessmgr := GetSessionManager(ctx)
-	defer sessmgr.Close()
-
-	sess := sessmgr.Get(r)
-	if sess == nil {
-		http.Error(w, "No session present for signing in. Please try again.", http.StatusExpectationFailed)
-		return
+// revokeGitHubToken deletes the app’s grant, which revokes every token the user gave it
+func revokeGitHubToken(ctx context.Context, config *oauth2.Config, token *oauth2.Token) error {
+	body, err := json.Marshal(&struct {
+		AccessToken string `json:"access_token"`
+	}{
+		AccessToken: token.AccessToken,
+	})
+	if err != nil {
+		return err
 	}
 
-	expectedState := sess.Attr(gitHubStateKey)
-	givenState := r.URL.Query().Get("state")
-	if expectedState != givenState {
-		http.Error(w, "State does not match one at start of sign in flow. Please try again.", http.StatusExpectationFailed)
-		return
+	req, err := http.NewRequest("DELETE", "https://api.github.com/applications/"+url.PathEscape(config.ClientID)+"/grant", bytes.NewReader(body))
+	if err != nil {
+		return err
 	}
+	req.SetBasicAuth(config.ClientID, config.ClientSecret)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Content-Type", "application/json")
 
-	code := r.URL.Query().Get("code")
-	token, err := githubOauthCfg.Exchange(ctx, code)
+	res, err := urlfetch.Client(ctx).Do(req)
 	if err != nil {
-		http.Error(w, "Could not get GitHub token. Please try again.", http.StatusBadRequest)
-		return
+		return err
 	}
+	defer res.Body.Close()
 
-	if !token.Valid() {
-		http.Error(w, "GitHub token is invalid. Please try again.", http.StatusBadRequest)
-		return
+	// Already revoked grants are not found
+	if res.StatusCode != http.StatusNoContent && res.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("GitHub responded with %d when revoking token", res.StatusCode)
 	}
 
-	sess.SetAttr(gitHubTokenKey, token)
-
-	afterSignInHandle(w, r)
+	return nil
 }
 
-// GetGitHubTokenFromSession returns a oauth2.Token for GitHub from a session
+// GetGitHubTokenFromSession returns a current oauth2.Token for GitHub for the session’s account
 func GetGitHubTokenFromSession(ctx context.Context, sess session.Session) *oauth2.Token {
-	token, ok := sess.Attr(gitHubTokenKey).(oauth2.Token)
-	if !ok {
+	tokenSource := gitHubOAuthProvider.TokenSource(ctx, sess)
+	if tokenSource == nil {
 		return nil
 	}
 
-	return &token
+	token, err := tokenSource.Token()
+	if err != nil {
+		log.Warningf(ctx, "Could not get GitHub token: %v", err)
+		return nil
+	}
+
+	return token
 }
 
-// GetGitHubClientFromSession returns a github.Client from a session
+// GetGitHubClientFromSession returns a github.Client for the session’s account
 func GetGitHubClientFromSession(ctx context.Context, sess session.Session) *github.Client {
-	token := GetGitHubTokenFromSession(ctx, sess)
-	if token == nil {
+	client := gitHubOAuthProvider.Client(ctx, sess)
+	if client == nil {
 		return nil
 	}
 
-	return github.NewClient(githubOauthCfg.Client(ctx, token))
+	return github.NewClient(client)
+}
+
+// GetGitHubClientFromContext returns a github.Client for the viewer whose command param variables are in the context
+func GetGitHubClientFromContext(ctx context.Context) *github.Client {
+	commandParamVars := CommandParamVariablesFromContext(ctx)
+	if commandParamVars == nil {
+		return nil
+	}
+
+	accessToken := commandParamVars.GitHubOAuthToken()
+	if accessToken == "" {
+		return nil
+	}
+
+	return github.NewClient(gitHubOAuthProvider.ClientForToken(ctx, &oauth2.Token{AccessToken: accessToken}))
 }
 
 // WithGitHubClient adds github.Client as extra arguments to a SessHandlerFunc
@@ -158,14 +161,8 @@ func githubListReposHandle(ctx context.Context, w http.ResponseWriter, r *http.R
 	writeJSON(w, repos)
 }
 
-// AddGitHubRoutes adds routes for signing in and reading from GitHub
+// AddGitHubRoutes adds routes for reading from GitHub
 func AddGitHubRoutes(r *mux.Router) {
-	r.Path("/signin/github").Methods("GET").
-		HandlerFunc(githubOAuthStartHandle)
-
-	r.Path("/signin/github/callback").Methods("GET").
-		HandlerFunc(githubOAuthCallbackHandle)
-
 	r.Path("/github/repos").Methods("GET").
 		HandlerFunc(WithSessionMgr(WithGitHubClient(githubListReposHandle)))
 }
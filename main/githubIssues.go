@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-github/github"
+	"google.golang.org/appengine/log"
+)
+
+const maxGitHubIssueTitleLength = 120
+
+var (
+	errSignInWithGitHub     = errors.New("Sign in with GitHub to create issues")
+	errPostHasNoGitHubIssue = errors.New("Post has no GitHub issue")
+)
+
+// GitHubIssueStatus is the live state of the GitHub issue created from a post
+type GitHubIssueStatus struct {
+	Repo   string `json:"repo"`
+	Number int    `json:"number"`
+	URL    string `json:"url"`
+	Title  string `json:"title,omitempty"`
+	State  string `json:"state,omitempty"`
+}
+
+// CreateGitHubIssueFromPostInput is used to create a GitHub issue from a post
+type CreateGitHubIssueFromPostInput struct {
+	ChannelSlug    string
+	PostKeyEncoded string
+	Repo           string
+}
+
+func gitHubIssueURL(gitHubRepo string, issueNumber int) string {
+	return fmt.Sprintf("https://github.com/%s/issues/%d", gitHubRepo, issueNumber)
+}
+
+// CreateGitHubIssueFromPost creates an issue from the post using the viewer’s GitHub token, and records it on the post
+func CreateGitHubIssueFromPost(ctx context.Context, channelsRepo ChannelsRepo, client *github.Client, input CreateGitHubIssueFromPostInput) (*Post, *GitHubIssueStatus, error) {
+	if client == nil {
+		return nil, nil, errSignInWithGitHub
+	}
+
+	owner, repo, err := splitGitHubRepo(input.Repo)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	post, err := channelsRepo.GetPostWithIDInChannel(input.ChannelSlug, input.PostKeyEncoded)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if post.GitHubIssueNumber != 0 {
+		return nil, nil, fmt.Errorf("Post already has GitHub issue %s#%d", post.GitHubIssueRepo, post.GitHubIssueNumber)
+	}
+
+	title, body := post.Content.TitleAndBody()
+	title = truncateText(title, maxGitHubIssueTitleLength)
+	if title == "" {
+		return nil, nil, errors.New("Post has no content to make an issue from")
+	}
+
+	if body != "" {
+		body += "\n\n---\n"
+	}
+	body += "Created from [this post](" + postHTMLURL(ctx, channelsRepo, input.ChannelSlug, post.Key) + ")"
+
+	// Claimed first, so submitting twice cannot create two issues
+	_, err = channelsRepo.ClaimPostForGitHubIssue(post.Key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	issue, _, err := client.Issues.Create(ctx, owner, repo, &github.IssueRequest{
+		Title: &title,
+		Body:  &body,
+	})
+	if err != nil {
+		if releaseErr := channelsRepo.ReleasePostForGitHubIssue(post.Key); releaseErr != nil {
+			log.Warningf(ctx, "Could not release post %v for GitHub issue: %v", post.Key, releaseErr)
+		}
+		return nil, nil, err
+	}
+
+	gitHubRepo := owner + "/" + repo
+	post, err = channelsRepo.SetGitHubIssueForPost(post.Key, gitHubRepo, issue.GetNumber())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	status := GitHubIssueStatus{
+		Repo:   gitHubRepo,
+		Number: issue.GetNumber(),
+		URL:    issue.GetHTMLURL(),
+		Title:  issue.GetTitle(),
+		State:  issue.GetState(),
+	}
+	return post, &status, nil
+}
+
+// GetGitHubIssueStatusForPost loads the live state of the post’s GitHub issue, returning nil if it has none
+func GetGitHubIssueStatusForPost(ctx context.Context, client *github.Client, post *Post) (*GitHubIssueStatus, error) {
+	if post.GitHubIssueNumber == 0 {
+		return nil, nil
+	}
+
+	status := GitHubIssueStatus{
+		Repo:   post.GitHubIssueRepo,
+		Number: post.GitHubIssueNumber,
+		URL:    gitHubIssueURL(post.GitHubIssueRepo, post.GitHubIssueNumber),
+	}
+
+	// Without a token, all that is known is which issue it is
+	if client == nil {
+		return &status, nil
+	}
+
+	owner, repo, err := splitGitHubRepo(post.GitHubIssueRepo)
+	if err != nil {
+		return &status, err
+	}
+
+	issue, _, err := client.Issues.Get(ctx, owner, repo, post.GitHubIssueNumber)
+	if err != nil {
+		return &status, err
+	}
+
+	status.URL = issue.GetHTMLURL()
+	status.Title = issue.GetTitle()
+	status.State = issue.GetState()
+
+	return &status, nil
+}
+
+// gitHubIssuesQueryResponse is the response to the query made by GetGitHubIssueStatusesForPosts, with a field per issue
+type gitHubIssuesQueryResponse struct {
+	Data map[string]*struct {
+		Issue *struct {
+			URL   string `json:"url"`
+			Title string `json:"title"`
+			State string `json:"state"`
+		} `json:"issue"`
+	} `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// GetGitHubIssueStatusesForPosts loads the live state of every post’s GitHub issue with one request to GitHub’s GraphQL API,
+// returning them by their URL
+func GetGitHubIssueStatusesForPosts(ctx context.Context, client *github.Client, posts []*Post) (map[string]*GitHubIssueStatus, error) {
+	statuses := make(map[string]*GitHubIssueStatus)
+
+	var params []string
+	var fields []string
+	variables := make(map[string]interface{})
+	urlsByField := make(map[string]string)
+
+	for _, post := range posts {
+		if post.GitHubIssueNumber == 0 {
+			continue
+		}
+
+		url := gitHubIssueURL(post.GitHubIssueRepo, post.GitHubIssueNumber)
+		if statuses[url] != nil {
+			continue
+		}
+
+		// Without a token, all that is known is which issue it is
+		statuses[url] = &GitHubIssueStatus{
+			Repo:   post.GitHubIssueRepo,
+			Number: post.GitHubIssueNumber,
+			URL:    url,
+		}
+
+		owner, repo, err := splitGitHubRepo(post.GitHubIssueRepo)
+		if client == nil || err != nil {
+			continue
+		}
+
+		i := len(fields)
+		field := fmt.Sprintf("issue%d", i)
+		params = append(params, fmt.Sprintf("$owner%d: String!, $name%d: String!, $number%d: Int!", i, i, i))
+		fields = append(fields, fmt.Sprintf("%s: repository(owner: $owner%d, name: $name%d) { issue(number: $number%d) { url title state } }", field, i, i, i))
+		variables[fmt.Sprintf("owner%d", i)] = owner
+		variables[fmt.Sprintf("name%d", i)] = repo
+		variables[fmt.Sprintf("number%d", i)] = post.GitHubIssueNumber
+		urlsByField[field] = url
+	}
+
+	if len(fields) == 0 {
+		return statuses, nil
+	}
+
+	req, err := client.NewRequest("POST", "graphql", &struct {
+		Query     string                 `json:"query"`
+		Variables map[string]interface{} `json:"variables"`
+	}{
+		Query:     "query(" + strings.Join(params, ", ") + ") { " + strings.Join(fields, " ") + " }",
+		Variables: variables,
+	})
+	if err != nil {
+		return statuses, err
+	}
+
+	var res gitHubIssuesQueryResponse
+	_, err = client.Do(ctx, req, &res)
+	if err != nil {
+		return statuses, err
+	}
+
+	for field, url := range urlsByField {
+		repository := res.Data[field]
+		if repository == nil || repository.Issue == nil {
+			continue
+		}
+
+		status := statuses[url]
+		status.URL = repository.Issue.URL
+		status.Title = repository.Issue.Title
+		// Matching the REST API’s open and closed
+		status.State = strings.ToLower(repository.Issue.State)
+	}
+
+	// Issues that could not be read, such as deleted ones, are reported while the rest still load
+	if len(res.Errors) > 0 {
+		return statuses, errors.New(res.Errors[0].Message)
+	}
+
+	return statuses, nil
+}
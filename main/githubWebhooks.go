@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+const gitHubWebhookSignaturePrefix = "sha256="
+
+var (
+	supportedGitHubWebhookEvents = []string{"push", "pull_request", "issues", "release"}
+
+	errInvalidGitHubWebhookSignature = errors.New("Invalid X-Hub-Signature-256")
+)
+
+// isSupportedGitHubWebhookEventFilter accepts an event such as `issues`, or an event and action such as `issues.opened`
+func isSupportedGitHubWebhookEventFilter(filter string) bool {
+	event := strings.SplitN(filter, ".", 2)[0]
+	for _, supportedEvent := range supportedGitHubWebhookEvents {
+		if event == supportedEvent {
+			return true
+		}
+	}
+	return false
+}
+
+// WantsEvent is true if the integration’s filters allow the event and action, with no filters allowing every supported event
+func (integration *GitHubWebhookIntegration) WantsEvent(event string, action string) bool {
+	if !isSupportedGitHubWebhookEventFilter(event) {
+		return false
+	}
+
+	if len(integration.Events) == 0 {
+		return true
+	}
+
+	for _, filter := range integration.Events {
+		if filter == event || (action != "" && filter == event+"."+action) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// VerifySignature checks the X-Hub-Signature-256 header against the HMAC of the payload using the integration’s secret
+func (integration *GitHubWebhookIntegration) VerifySignature(signatureHeader string, payload []byte) error {
+	if !strings.HasPrefix(signatureHeader, gitHubWebhookSignaturePrefix) {
+		return errInvalidGitHubWebhookSignature
+	}
+
+	signature, err := hex.DecodeString(strings.TrimPrefix(signatureHeader, gitHubWebhookSignaturePrefix))
+	if err != nil {
+		return errInvalidGitHubWebhookSignature
+	}
+
+	mac := hmac.New(sha256.New, []byte(integration.Secret))
+	mac.Write(payload)
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return errInvalidGitHubWebhookSignature
+	}
+
+	return nil
+}
+
+func gitHubWebhookAction(payload interface{}) string {
+	switch event := payload.(type) {
+	case *github.PullRequestEvent:
+		return event.GetAction()
+	case *github.IssuesEvent:
+		return event.GetAction()
+	case *github.ReleaseEvent:
+		return event.GetAction()
+	}
+	return ""
+}
+
+// markdownEscaper backslash escapes Markdown’s punctuation, so text from a payload cannot add links, images or formatting
+var markdownEscaper = strings.NewReplacer(
+	"\\", "\\\\", "`", "\\`", "*", "\\*", "_", "\\_", "{", "\\{", "}", "\\}", "[", "\\[", "]", "\\]",
+	"(", "\\(", ")", "\\)", "<", "\\<", ">", "\\>", "#", "\\#", "+", "\\+", "-", "\\-", ".", "\\.",
+	"!", "\\!", "|", "\\|", "~", "\\~", "&", "\\&", "\r", " ", "\n", " ",
+)
+
+func escapeMarkdown(text string) string {
+	return markdownEscaper.Replace(text)
+}
+
+// markdownCode puts text in a code span, fenced with more backticks than it contains
+func markdownCode(text string) string {
+	text = strings.NewReplacer("\r", " ", "\n", " ").Replace(text)
+
+	longestRun, run := 0, 0
+	for _, c := range text {
+		if c == '`' {
+			run++
+			if run > longestRun {
+				longestRun = run
+			}
+		} else {
+			run = 0
+		}
+	}
+
+	fence := strings.Repeat("`", longestRun+1)
+	if longestRun > 0 {
+		return fence + " " + text + " " + fence
+	}
+	return fence + text + fence
+}
+
+// markdownURLEscaper percent-encodes what would end a link’s destination
+var markdownURLEscaper = strings.NewReplacer(" ", "%20", "(", "%28", ")", "%29", "<", "%3C", ">", "%3E", "\\", "%5C", "\r", "", "\n", "")
+
+// markdownLink links already escaped Markdown
+func markdownLink(markdown string, url string) string {
+	if url == "" {
+		return markdown
+	}
+	return "[" + markdown + "](" + markdownURLEscaper.Replace(url) + ")"
+}
+
+func shortCommitSHA(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
+
+func pushEventMarkdown(event *github.PushEvent) string {
+	branch := strings.TrimPrefix(strings.TrimPrefix(event.GetRef(), "refs/heads/"), "refs/tags/")
+	repo := markdownLink(escapeMarkdown(event.GetRepo().GetFullName()), event.GetRepo().GetHTMLURL())
+	sender := escapeMarkdown(event.GetSender().GetLogin())
+
+	if event.GetDeleted() {
+		return fmt.Sprintf("**%s** deleted %s in %s\n", sender, markdownCode(branch), repo)
+	}
+
+	var buffer bytes.Buffer
+	commitsNoun := "commits"
+	if len(event.Commits) == 1 {
+		commitsNoun = "commit"
+	}
+	buffer.WriteString(fmt.Sprintf("**%s** pushed %s to %s in %s\n", sender, markdownLink(fmt.Sprintf("%d %s", len(event.Commits), commitsNoun), event.GetCompare()), markdownCode(branch), repo))
+
+	if len(event.Commits) > 0 {
+		buffer.WriteString("\n")
+	}
+	for _, commit := range event.Commits {
+		sha := commit.GetID()
+		if sha == "" {
+			sha = commit.GetSHA()
+		}
+		buffer.WriteString(fmt.Sprintf("- %s %s\n", markdownLink(markdownCode(shortCommitSHA(sha)), commit.GetURL()), escapeMarkdown(firstLine(commit.GetMessage()))))
+	}
+
+	return buffer.String()
+}
+
+func pullRequestEventMarkdown(event *github.PullRequestEvent) string {
+	pullRequest := event.GetPullRequest()
+
+	action := strings.Replace(event.GetAction(), "_", " ", -1)
+	if event.GetAction() == "closed" && pullRequest.GetMerged() {
+		action = "merged"
+	}
+
+	return fmt.Sprintf("**%s** %s pull request %s in %s\n",
+		escapeMarkdown(event.GetSender().GetLogin()),
+		escapeMarkdown(action),
+		markdownLink(fmt.Sprintf("\\#%d %s", pullRequest.GetNumber(), escapeMarkdown(pullRequest.GetTitle())), pullRequest.GetHTMLURL()),
+		markdownLink(escapeMarkdown(event.GetRepo().GetFullName()), event.GetRepo().GetHTMLURL()),
+	)
+}
+
+func issuesEventMarkdown(event *github.IssuesEvent) string {
+	issue := event.GetIssue()
+
+	return fmt.Sprintf("**%s** %s issue %s in %s\n",
+		escapeMarkdown(event.GetSender().GetLogin()),
+		escapeMarkdown(strings.Replace(event.GetAction(), "_", " ", -1)),
+		markdownLink(fmt.Sprintf("\\#%d %s", issue.GetNumber(), escapeMarkdown(issue.GetTitle())), issue.GetHTMLURL()),
+		markdownLink(escapeMarkdown(event.GetRepo().GetFullName()), event.GetRepo().GetHTMLURL()),
+	)
+}
+
+func releaseEventMarkdown(event *github.ReleaseEvent) string {
+	release := event.GetRelease()
+
+	name := release.GetTagName()
+	if release.GetName() != "" && release.GetName() != name {
+		name += " " + release.GetName()
+	}
+
+	return fmt.Sprintf("**%s** %s release %s in %s\n",
+		escapeMarkdown(event.GetSender().GetLogin()),
+		escapeMarkdown(event.GetAction()),
+		markdownLink(escapeMarkdown(name), release.GetHTMLURL()),
+		markdownLink(escapeMarkdown(event.GetRepo().GetFullName()), event.GetRepo().GetHTMLURL()),
+	)
+}
+
+// GitHubWebhookEventMarkdown formats a parsed webhook payload as the content of a post
+func GitHubWebhookEventMarkdown(payload interface{}) (string, error) {
+	switch event := payload.(type) {
+	case *github.PushEvent:
+		return pushEventMarkdown(event), nil
+	case *github.PullRequestEvent:
+		return pullRequestEventMarkdown(event), nil
+	case *github.IssuesEvent:
+		return issuesEventMarkdown(event), nil
+	case *github.ReleaseEvent:
+		return releaseEventMarkdown(event), nil
+	}
+
+	return "", fmt.Errorf("Unsupported GitHub event %T", payload)
+}
+
+// HandleGitHubWebhookDelivery posts the delivered event into the integration’s channel, returning nil if its filters skip the event
+func HandleGitHubWebhookDelivery(ctx context.Context, integration *GitHubWebhookIntegration, event string, payloadBytes []byte) (*Post, error) {
+	if !isSupportedGitHubWebhookEventFilter(event) {
+		return nil, nil
+	}
+
+	payload, err := github.ParseWebHook(event, payloadBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	if !integration.WantsEvent(event, gitHubWebhookAction(payload)) {
+		return nil, nil
+	}
+
+	markdownSource, err := GitHubWebhookEventMarkdown(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	orgRepo := NewOrgRepo(ctx, integration.OrgSlug)
+	channelsRepo := NewChannelsRepo(ctx, orgRepo)
+
+	// Deliveries are never replies, so nothing is mirrored and no one’s Trello is needed
+	return CreatePostMirroringReply(ctx, channelsRepo, nil, CreatePostInput{
+		ChannelSlug:    integration.ChannelSlug,
+		MarkdownSource: markdownSource,
+	})
+}
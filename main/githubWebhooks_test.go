@@ -0,0 +1,92 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-github/github"
+)
+
+func TestEscapeMarkdown(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"Fix crash on start", "Fix crash on start"},
+		{"x](http://evil.example)", `x\]\(http://evil\.example\)`},
+		{"![](http://evil.example/pixel.png)", `\!\[\]\(http://evil\.example/pixel\.png\)`},
+		{"<img src=x>", `\<img src=x\>`},
+		{"**bold** _em_ `code`", `\*\*bold\*\* \_em\_ \` + "`" + `code\` + "`"},
+		{"one\ntwo", "one two"},
+		{`back\slash`, `back\\slash`},
+	}
+
+	for _, test := range tests {
+		if got := escapeMarkdown(test.text); got != test.want {
+			t.Errorf("escapeMarkdown(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestMarkdownCode(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"main", "`main`"},
+		{"feature/`x`](http://evil.example)", "`` feature/`x`](http://evil.example) ``"},
+		{"a``b", "``` a``b ```"},
+	}
+
+	for _, test := range tests {
+		if got := markdownCode(test.text); got != test.want {
+			t.Errorf("markdownCode(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestPullRequestEventMarkdownEscapesPayload(t *testing.T) {
+	event := &github.PullRequestEvent{
+		Action: github.String("opened"),
+		PullRequest: &github.PullRequest{
+			Number:  github.Int(12),
+			Title:   github.String("Tidy](http://evil.example) ![](http://evil.example/pixel.png)"),
+			HTMLURL: github.String("https://github.com/acme/app/pull/12"),
+		},
+		Repo: &github.Repository{
+			FullName: github.String("acme/app"),
+			HTMLURL:  github.String("https://github.com/acme/app"),
+		},
+		Sender: &github.User{Login: github.String("sam")},
+	}
+
+	want := `**sam** opened pull request [\#12 Tidy\]\(http://evil\.example\) \!\[\]\(http://evil\.example/pixel\.png\)](https://github.com/acme/app/pull/12) in [acme/app](https://github.com/acme/app)` + "\n"
+	if got := pullRequestEventMarkdown(event); got != want {
+		t.Errorf("pullRequestEventMarkdown() = %q, want %q", got, want)
+	}
+}
+
+func TestPushEventMarkdownEscapesPayload(t *testing.T) {
+	event := &github.PushEvent{
+		Ref:     github.String("refs/heads/fix`](http://evil.example)"),
+		Compare: github.String("https://github.com/acme/app/compare/a...b"),
+		Commits: []github.PushEventCommit{
+			{
+				ID:      github.String("0123456789abcdef"),
+				URL:     github.String("https://github.com/acme/app/commit/0123456789abcdef"),
+				Message: github.String("Add [link](javascript:alert(1))\n\nMore detail"),
+			},
+		},
+		Repo: &github.PushEventRepository{
+			FullName: github.String("acme/app"),
+			HTMLURL:  github.String("https://github.com/acme/app"),
+		},
+		Sender: &github.User{Login: github.String("sam")},
+	}
+
+	want := "**sam** pushed [1 commit](https://github.com/acme/app/compare/a...b) to `` fix`](http://evil.example) `` in [acme/app](https://github.com/acme/app)\n" +
+		"\n" +
+		"- [`0123456`](https://github.com/acme/app/commit/0123456789abcdef) Add \\[link\\]\\(javascript:alert\\(1\\)\\)\n"
+	if got := pushEventMarkdown(event); got != want {
+		t.Errorf("pushEventMarkdown() = %q, want %q", got, want)
+	}
+}
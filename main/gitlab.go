@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/icza/session"
+	"golang.org/x/oauth2"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/urlfetch"
+)
+
+const defaultGitLabBaseURL = "https://gitlab.com"
+
+// gitLabBaseURL is GitLab.com, or a self-hosted GitLab set with GITLAB_BASE_URL
+func gitLabBaseURL() string {
+	baseURL := strings.TrimRight(strings.TrimSpace(os.Getenv("GITLAB_BASE_URL")), "/")
+	if baseURL == "" {
+		return defaultGitLabBaseURL
+	}
+	return baseURL
+}
+
+func makeGitLabOAuthConfig() *oauth2.Config {
+	baseURL := gitLabBaseURL()
+
+	return &oauth2.Config{
+		ClientID:     os.Getenv("GITLAB_CLIENT_ID"),
+		ClientSecret: os.Getenv("GITLAB_CLIENT_SECRET"),
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  baseURL + "/oauth/authorize",
+			TokenURL: baseURL + "/oauth/token",
+		},
+		RedirectURL: os.Getenv("GITLAB_REDIRECT_URL"),
+		Scopes:      []string{"read_api"},
+	}
+}
+
+var gitLabOAuthProvider = &OAuth2Provider{
+	id:       "gitlab",
+	name:     "GitLab",
+	config:   makeGitLabOAuthConfig(),
+	pkce:     true,
+	identify: identifyGitLabUser,
+	revoke:   revokeGitLabToken,
+}
+
+func init() {
+	registerOAuthProvider(gitLabOAuthProvider)
+}
+
+// identifyGitLabUser reads the signed in user’s numeric ID and username
+func identifyGitLabUser(ctx context.Context, client *http.Client) (string, string, error) {
+	var user struct {
+		ID       int64  `json:"id"`
+		Username string `json:"username"`
+	}
+	err := getOAuthJSON(client, gitLabBaseURL()+"/api/v4/user", &user)
+	if err != nil {
+		return "", "", err
+	}
+
+	return strconv.FormatInt(user.ID, 10), user.Username, nil
+}
+
+// revokeGitLabToken revokes the access token, along with its refresh token
+func revokeGitLabToken(ctx context.Context, config *oauth2.Config, token *oauth2.Token) error {
+	form := url.Values{}
+	form.Set("client_id", config.ClientID)
+	form.Set("client_secret", config.ClientSecret)
+	form.Set("token", token.AccessToken)
+
+	res, err := urlfetch.Client(ctx).PostForm(gitLabBaseURL()+"/oauth/revoke", form)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("GitLab responded with %d when revoking token", res.StatusCode)
+	}
+
+	return nil
+}
+
+// GitLabAPI allows retrieving data from the GitLab API
+type GitLabAPI struct {
+	client  *http.Client
+	baseURL string
+}
+
+// NewGitLabAPI makes a GitLabAPI for the GitLab at baseURL, with a client that authorizes its requests
+func NewGitLabAPI(client *http.Client, baseURL string) *GitLabAPI {
+	return &GitLabAPI{
+		client:  client,
+		baseURL: strings.TrimRight(baseURL, "/"),
+	}
+}
+
+// GitLabUser is the author of a merge request or issue, or who triggered a pipeline
+type GitLabUser struct {
+	Username string `json:"username"`
+	Name     string `json:"name"`
+}
+
+// GitLabMergeRequest is a merge request in a project
+type GitLabMergeRequest struct {
+	IID          int        `json:"iid"`
+	Title        string     `json:"title"`
+	State        string     `json:"state"`
+	WebURL       string     `json:"web_url"`
+	Author       GitLabUser `json:"author"`
+	SourceBranch string     `json:"source_branch"`
+	TargetBranch string     `json:"target_branch"`
+	Draft        bool       `json:"draft"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// GitLabIssue is an issue in a project
+type GitLabIssue struct {
+	IID            int        `json:"iid"`
+	Title          string     `json:"title"`
+	State          string     `json:"state"`
+	WebURL         string     `json:"web_url"`
+	Author         GitLabUser `json:"author"`
+	Labels         []string   `json:"labels"`
+	UserNotesCount int        `json:"user_notes_count"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// GitLabPipeline is a CI pipeline run in a project
+type GitLabPipeline struct {
+	ID        int       `json:"id"`
+	Status    string    `json:"status"`
+	Ref       string    `json:"ref"`
+	SHA       string    `json:"sha"`
+	WebURL    string    `json:"web_url"`
+	Source    string    `json:"source"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// gitLabProjectPath accepts a project path such as group/subgroup/project, or its URL
+func gitLabProjectPath(project string) (string, error) {
+	project = strings.TrimSpace(project)
+	if u, err := url.Parse(project); err == nil && u.Host != "" {
+		project = u.Path
+		// Links to a project’s pages include /-/merge_requests etc
+		if i := strings.Index(project, "/-/"); i != -1 {
+			project = project[:i]
+		}
+	}
+
+	project = strings.Trim(project, "/")
+	if !strings.Contains(project, "/") {
+		return "", fmt.Errorf("GitLab project must look like group/name, not %q", project)
+	}
+
+	return project, nil
+}
+
+func (gitLab *GitLabAPI) getJSON(path string, query url.Values, out interface{}) error {
+	res, err := gitLab.client.Get(gitLab.baseURL + "/api/v4" + path + "?" + query.Encode())
+	if err != nil {
+		return errors.New("Unable to communicate with GitLab. " + err.Error())
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("GitLab responded with %d: %s", res.StatusCode, strings.TrimSpace(string(message)))
+	}
+
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+func gitLabProjectAPIPath(project string) string {
+	return "/projects/" + url.PathEscape(project)
+}
+
+// MergeRequests lists the merge requests of the project, newest first
+func (gitLab *GitLabAPI) MergeRequests(project string, state string, limit int) ([]GitLabMergeRequest, error) {
+	query := url.Values{}
+	query.Set("state", state)
+	query.Set("per_page", strconv.Itoa(limit))
+
+	mergeRequests := []GitLabMergeRequest{}
+	err := gitLab.getJSON(gitLabProjectAPIPath(project)+"/merge_requests", query, &mergeRequests)
+	return mergeRequests, err
+}
+
+// Issues lists the issues of the project with all the labels, newest first
+func (gitLab *GitLabAPI) Issues(project string, state string, labels []string, limit int) ([]GitLabIssue, error) {
+	query := url.Values{}
+	query.Set("state", state)
+	query.Set("per_page", strconv.Itoa(limit))
+	if len(labels) > 0 {
+		query.Set("labels", strings.Join(labels, ","))
+	}
+
+	issues := []GitLabIssue{}
+	err := gitLab.getJSON(gitLabProjectAPIPath(project)+"/issues", query, &issues)
+	return issues, err
+}
+
+// Pipelines lists the pipelines of the project, optionally only for the ref or with the status, newest first
+func (gitLab *GitLabAPI) Pipelines(project string, ref string, status string, limit int) ([]GitLabPipeline, error) {
+	query := url.Values{}
+	query.Set("per_page", strconv.Itoa(limit))
+	if ref != "" {
+		query.Set("ref", ref)
+	}
+	if status != "" {
+		query.Set("status", status)
+	}
+
+	pipelines := []GitLabPipeline{}
+	err := gitLab.getJSON(gitLabProjectAPIPath(project)+"/pipelines", query, &pipelines)
+	return pipelines, err
+}
+
+// GetGitLabTokenFromSession returns a current oauth2.Token for GitLab for the session’s account
+func GetGitLabTokenFromSession(ctx context.Context, sess session.Session) *oauth2.Token {
+	tokenSource := gitLabOAuthProvider.TokenSource(ctx, sess)
+	if tokenSource == nil {
+		return nil
+	}
+
+	token, err := tokenSource.Token()
+	if err != nil {
+		log.Warningf(ctx, "Could not get GitLab token: %v", err)
+		return nil
+	}
+
+	return token
+}
+
+// GetGitLabAPIFromContext returns a GitLabAPI for the viewer whose command param variables are in the context
+func GetGitLabAPIFromContext(ctx context.Context) *GitLabAPI {
+	commandParamVars := CommandParamVariablesFromContext(ctx)
+	if commandParamVars == nil {
+		return nil
+	}
+
+	accessToken := commandParamVars.GitLabOAuthToken()
+	if accessToken == "" {
+		return nil
+	}
+
+	return NewGitLabAPI(gitLabOAuthProvider.ClientForToken(ctx, &oauth2.Token{AccessToken: accessToken}), gitLabBaseURL())
+}
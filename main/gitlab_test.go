@@ -0,0 +1,171 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGitLabProjectPath(t *testing.T) {
+	tests := []struct {
+		project string
+		path    string
+	}{
+		{"group/project", "group/project"},
+		{"  group/project  ", "group/project"},
+		{"/group/project/", "group/project"},
+		{"group/subgroup/project", "group/subgroup/project"},
+		{"group/subgroup/deeper/project", "group/subgroup/deeper/project"},
+		{"https://gitlab.com/group/project", "group/project"},
+		{"https://gitlab.com/group/subgroup/project", "group/subgroup/project"},
+		{"https://gitlab.com/group/project/", "group/project"},
+		{"https://gitlab.com/group/subgroup/project/-/merge_requests/12", "group/subgroup/project"},
+		{"https://gitlab.com/group/project/-/issues?label_name=bug", "group/project"},
+		{"https://gitlab.example.com/group/project/-/pipelines", "group/project"},
+	}
+
+	for _, test := range tests {
+		path, err := gitLabProjectPath(test.project)
+		if err != nil {
+			t.Errorf("gitLabProjectPath(%q) = %v", test.project, err)
+			continue
+		}
+		if path != test.path {
+			t.Errorf("gitLabProjectPath(%q) = %q, want %q", test.project, path, test.path)
+		}
+	}
+
+	for _, project := range []string{"", "project", "/project/", "https://gitlab.com/project", "https://gitlab.com/"} {
+		if _, err := gitLabProjectPath(project); err == nil {
+			t.Errorf("gitLabProjectPath(%q) succeeded, want an error", project)
+		}
+	}
+}
+
+// newTestGitLabAPI serves GitLab’s API from handler, recording the escaped path of each request
+func newTestGitLabAPI(handler http.HandlerFunc) (*GitLabAPI, *[]string, func()) {
+	var requestedPaths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestedPaths = append(requestedPaths, r.URL.EscapedPath())
+		handler(w, r)
+	}))
+
+	// A trailing slash is trimmed from the base URL
+	return NewGitLabAPI(server.Client(), server.URL+"/"), &requestedPaths, server.Close
+}
+
+func TestGitLabAPIEscapesProjectIDs(t *testing.T) {
+	gitLab, requestedPaths, closeServer := newTestGitLabAPI(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("[]"))
+	})
+	defer closeServer()
+
+	_, err := gitLab.MergeRequests("group/subgroup/project", "opened", 20)
+	if err != nil {
+		t.Fatalf("MergeRequests() = %v", err)
+	}
+	_, err = gitLab.Issues("group/project", "opened", []string{"bug"}, 20)
+	if err != nil {
+		t.Fatalf("Issues() = %v", err)
+	}
+	_, err = gitLab.Pipelines("group/project with space", "main", "", 20)
+	if err != nil {
+		t.Fatalf("Pipelines() = %v", err)
+	}
+
+	want := []string{
+		"/api/v4/projects/group%2Fsubgroup%2Fproject/merge_requests",
+		"/api/v4/projects/group%2Fproject/issues",
+		"/api/v4/projects/group%2Fproject%20with%20space/pipelines",
+	}
+	if len(*requestedPaths) != len(want) {
+		t.Fatalf("Requested %v, want %v", *requestedPaths, want)
+	}
+	for i, path := range want {
+		if (*requestedPaths)[i] != path {
+			t.Errorf("Requested %s, want %s", (*requestedPaths)[i], path)
+		}
+	}
+}
+
+func TestGitLabAPISendsQuery(t *testing.T) {
+	var query map[string]string
+	gitLab, _, closeServer := newTestGitLabAPI(func(w http.ResponseWriter, r *http.Request) {
+		query = map[string]string{}
+		for name := range r.URL.Query() {
+			query[name] = r.URL.Query().Get(name)
+		}
+		w.Write([]byte(`[{"iid": 7, "title": "Crash on start", "state": "opened", "labels": ["bug", "p1"], "author": {"username": "sam"}}]`))
+	})
+	defer closeServer()
+
+	issues, err := gitLab.Issues("group/project", "opened", []string{"bug", "p1"}, 5)
+	if err != nil {
+		t.Fatalf("Issues() = %v", err)
+	}
+
+	if query["state"] != "opened" || query["per_page"] != "5" || query["labels"] != "bug,p1" {
+		t.Errorf("Sent query %v", query)
+	}
+	if len(issues) != 1 || issues[0].IID != 7 || issues[0].Title != "Crash on start" || issues[0].Author.Username != "sam" {
+		t.Errorf("Issues() = %+v", issues)
+	}
+}
+
+func TestGitLabAPIErrorBodies(t *testing.T) {
+	tests := []struct {
+		status int
+		body   string
+		want   string
+	}{
+		{http.StatusNotFound, `{"message":"404 Project Not Found"}`, `GitLab responded with 404: {"message":"404 Project Not Found"}`},
+		{http.StatusUnauthorized, "  {\"message\":\"401 Unauthorized\"}\n", `GitLab responded with 401: {"message":"401 Unauthorized"}`},
+		{http.StatusForbidden, "", "GitLab responded with 403: "},
+	}
+
+	for _, test := range tests {
+		gitLab, _, closeServer := newTestGitLabAPI(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(test.status)
+			w.Write([]byte(test.body))
+		})
+
+		_, err := gitLab.MergeRequests("group/project", "opened", 20)
+		if err == nil || err.Error() != test.want {
+			t.Errorf("With %d, MergeRequests() = %v, want %q", test.status, err, test.want)
+		}
+
+		closeServer()
+	}
+}
+
+func TestGitLabAPITruncatesLongErrorBodies(t *testing.T) {
+	gitLab, _, closeServer := newTestGitLabAPI(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(strings.Repeat("x", 2000)))
+	})
+	defer closeServer()
+
+	_, err := gitLab.Pipelines("group/project", "", "", 20)
+	if err == nil {
+		t.Fatal("Pipelines() succeeded, want an error")
+	}
+
+	want := "GitLab responded with 500: " + strings.Repeat("x", 512)
+	if err.Error() != want {
+		t.Errorf("Pipelines() = %d characters, want %d", len(err.Error()), len(want))
+	}
+}
+
+func TestGitLabAPIInvalidJSON(t *testing.T) {
+	gitLab, _, closeServer := newTestGitLabAPI(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html>Sign in</html>"))
+	})
+	defer closeServer()
+
+	_, err := gitLab.Issues("group/project", "opened", nil, 20)
+	if err == nil {
+		t.Error("Issues() succeeded with HTML, want an error")
+	}
+}
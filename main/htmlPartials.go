@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"io"
+)
+
+// htmlPartials are the shared pieces of pages, which escape each value for where it appears in the HTML
+var htmlPartials = template.Must(template.New("partials").Parse(`
+{{define "layoutStart"}}<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+{{.AssetsHeadHTML}}
+<title>{{.Title}}</title>
+<style>
+.grid-1\/3-2\/3 {
+	display: grid;
+	grid-template-columns: 33.333% 66.667%;
+}
+.grid-column-gap-1 {
+	grid-column-gap: 0.25rem;
+}
+.grid-row-gap-1 {
+	grid-row-gap: 0.25rem;
+}
+</style>
+<script nonce="{{.CSPNonce}}">
+window.collectedTasks = [];
+</script>
+</head>
+<body class="{{.BodyClass}}">{{end}}
+
+{{define "layoutEnd"}}{{.}}</body></html>{{end}}
+
+{{define "orgNav"}}
+<nav class="text-white bg-black">
+<div class="max-w-md mx-auto flex flex-col sm:flex-row items-center sm:items-start leading-normal">
+<strong class="py-1">
+	<a href="{{.HTMLURL}}" class="no-underline hover:underline text-white">{{.OrgSlug}}</a>
+</strong>
+</div>
+</nav>
+{{end}}
+
+{{define "channelHeader"}}
+<header class="pt-4 pb-3 bg-indigo-darker">
+	<div class="max-w-md mx-auto">
+		<div class="mx-2 md:mx-0 flex flex-wrap flex-col sm:flex-row items-center sm:items-start sm:justify-between">
+			<h1 class="{{.FontSize}} min-w-full sm:min-w-0 mb-2 sm:mb-0">
+				<a href="{{.Channel.HTMLPostsURL}}" class="text-white no-underline hover:underline">💬 {{.Channel.ChannelSlug}}</a>
+			</h1>
+			<input type="search" placeholder="Search {{.Channel.ChannelSlug}}" class="w-64 px-2 py-2 bg-indigo rounded">
+		</div>
+	</div>
+</header>
+{{end}}
+
+{{define "channelListItem"}}
+<li class="text-xl">
+	<a href="{{.HTMLPostsURL}}" class="block px-3 py-2 no-underline text-indigo-dark bg-white hover:text-white hover:bg-indigo">#{{.ChannelSlug}}</a>
+</li>
+{{end}}
+
+{{define "sectionInner"}}<div class="{{.InnerClass}}">{{.Content}}</div>{{end}}
+
+{{define "section"}}
+{{- if eq .OuterTagName "header"}}<header class="{{.OuterClass}}">{{template "sectionInner" .}}</header>
+{{- else if eq .OuterTagName "div"}}<div class="{{.OuterClass}}">{{template "sectionInner" .}}</div>
+{{- else if eq .OuterTagName "article"}}<article class="{{.OuterClass}}">{{template "sectionInner" .}}</article>
+{{- else if eq .OuterTagName "footer"}}<footer class="{{.OuterClass}}">{{template "sectionInner" .}}</footer>
+{{- else}}<section class="{{.OuterClass}}">{{template "sectionInner" .}}</section>
+{{- end}}
+{{- end}}
+
+{{define "csrfInput"}}<input type="hidden" name="csrfToken" value="{{.}}">{{end}}
+
+{{define "button"}}
+<button type="{{.ButtonType}}" class="mt-2 px-4 py-2 font-bold text-white bg-{{.Color}}-dark border border-{{.Color}}-darker rounded shadow no-underline hover:bg-{{.Color}} hover:border-{{.Color}}-dark">{{.Text}}</button>
+{{end}}
+
+{{define "buttonLink"}}
+<a href="{{.URL}}" class="mt-2 px-4 py-2 font-bold text-white bg-{{.Color}}-dark border border-{{.Color}}-darker rounded shadow no-underline hover:bg-{{.Color}} hover:border-{{.Color}}-dark">{{.Text}}</a>
+{{end}}
+
+{{define "field"}}
+<label class="block my-2">
+	<span class="font-bold">{{.Label}}</span>
+	<input name="{{.InputFormName}}" class="block w-full mt-1 p-2 bg-grey-lightest border border-grey rounded shadow-inner">
+</label>
+{{end}}
+
+{{define "alert"}}<p class="px-3 py-2 bg-white border-t-4 border-red rounded-sm shadow"><span class="text-red-dark">Error: </span>{{.}}</p>{{end}}
+
+{{define "errorMessage"}}<p class="py-1 px-2 bg-white text-red">{{.}}</p>{{end}}
+
+{{define "newChannelForm"}}
+<form method="post" action="{{.Org.HTMLChannelsURL}}" class="my-4">
+{{template "csrfInput" .CSRFToken}}
+<h2>New Channel</h2>
+<label class="block my-2">
+	Slug
+	<input name="channelSlug" placeholder="e.g. design, engineering, marketing" class="block w-full mt-1 p-2 bg-white border border-grey rounded shadow-inner">
+</label>
+<button type="submit" class="mt-2 px-4 py-2 font-bold text-white bg-indigo-darker border border-indigo-darker rounded shadow">Create Channel</button>
+</form>
+{{end}}
+
+{{define "createPostForm"}}
+<form data-target="posts.createForm" method="post" action="{{.Channel.HTMLPostsURL}}" class="my-4">
+{{template "csrfInput" .CSRFToken}}
+<textarea
+	data-target="posts.mainTextarea"
+	data-action="input->posts#markdownInputChanged"
+	name="markdownSource"
+	rows="4"
+	placeholder="Write…"
+	class="block w-full p-2 bg-white border border-grey rounded-sm shadow focus:h-64 focus:shadow-lg"
+></textarea>
+<div class="flex flex-row-reverse">
+<button type="submit" name="action" value="submitPost" data-target="posts.submitPostButton" class="mt-2 px-4 py-2 font-bold text-white bg-indigo-darker border border-indigo-darker rounded shadow">Post</button>
+<button type="submit" name="action" value="runCommand" data-target="posts.runCommandButton" class="mt-2 px-4 py-2 font-bold text-green-dark bg-white border border-green-dark rounded shadow hidden">Run</button>
+<button type="submit" name="action" value="beginDraft" data-target="posts.beginDraftButton" class="mt-2 px-4 py-2 font-bold text-white bg-purple-dark border border-purple-dark rounded shadow hidden">Begin Draft</button>
+<button type="submit" name="action" value="runGraphQLQuery" data-target="posts.runGraphQLQueryButton" class="mt-2 px-4 py-2 font-bold text-white bg-pink-dark border border-pink-dark rounded shadow hidden">Run GraphQL Query</button>
+</div>
+</form>
+{{end}}
+
+{{define "developerSection"}}
+<div data-controller="developer">
+<details class="mb-4 bg-indigo-darker">
+	<summary class="max-w-md mx-auto p-1 italic cursor-pointer text-center sm:text-right text-sm text-indigo-lighter bg-indigo-darker select-none">Developer</summary>
+	<div class="sm:max-h-screen overflow-auto bg-yellow-lightest">
+		<div class="flex flex-col sm:flex-row">
+			<pre class="sm:w-1/2 p-2 bg-indigo-lightest text-indigo-darkest"><code data-target="developer.queryCode">{{.}}</code></pre>
+			<div class="sm:w-1/2">
+				<button data-action="developer#runQuery" class="w-full mb-1 px-4 py-2 whitespace-pre-wrap break-words font-bold text-white bg-green-darker border border-green-darker">►</button>
+				<pre class="p-2 whitespace-pre-wrap break-words text-green-darkest"><code data-target="developer.result"></code></pre>
+			</div>
+		</div>
+	</div>
+</details>
+</div>
+{{end}}
+`))
+
+// htmlLayout is the data for the layoutStart partial
+type htmlLayout struct {
+	Title          string
+	BodyClass      string
+	AssetsHeadHTML template.HTML
+	// CSPNonce allows the inline script through the Content Security Policy
+	CSPNonce string
+}
+
+// htmlButtonProps is the data for the button and buttonLink partials
+type htmlButtonProps struct {
+	Text       string
+	Color      string
+	URL        string
+	ButtonType string
+}
+
+// htmlFieldProps is the data for the field partial
+type htmlFieldProps struct {
+	Label         string
+	InputFormName string
+}
+
+// htmlSection is the data for the section partial, whose tag must be header, div, article, footer or section
+type htmlSection struct {
+	OuterTagName string
+	OuterClass   string
+	InnerClass   string
+	Content      template.HTML
+}
+
+// executeHTMLPartial writes the named partial. The partials are checked when parsed, so only writing can fail.
+func executeHTMLPartial(w io.Writer, name string, data interface{}) {
+	htmlPartials.ExecuteTemplate(w, name, data)
+}
+
+// renderHTMLPartial returns the named partial for embedding in another template
+func renderHTMLPartial(name string, data interface{}) template.HTML {
+	var buffer bytes.Buffer
+	executeHTMLPartial(&buffer, name, data)
+	return template.HTML(buffer.String())
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/appengine/datastore"
+)
+
+// hostileInputs would break out of text, attributes or URLs if written unescaped
+var hostileInputs = []string{
+	`<script>alert(1)</script>`,
+	`"><img src=x onerror=alert(1)>`,
+	`' onmouseover='alert(1)`,
+	`javascript:alert(1)`,
+}
+
+// assertNoInjectedHTML fails if output contains an element or attribute that came from hostile input, which is harmless once escaped as text
+func assertNoInjectedHTML(t *testing.T, name string, output string) {
+	t.Helper()
+
+	for _, injected := range []string{"<script>alert", "<img", "' onmouseover='", `href="javascript:`} {
+		if strings.Contains(output, injected) {
+			t.Errorf("%s wrote %q unescaped:\n%s", name, injected, output)
+		}
+	}
+}
+
+func TestHTMLPartialsChannelList(t *testing.T) {
+	for _, slug := range hostileInputs {
+		m := OrgViewModel{OrgSlug: slug}.Channel(slug)
+
+		var output bytes.Buffer
+		executeHTMLPartial(&output, "channelListItem", m)
+		executeHTMLPartial(&output, "channelHeader", struct {
+			FontSize string
+			Channel  ChannelViewModel
+		}{
+			FontSize: "text-2xl",
+			Channel:  m,
+		})
+		executeHTMLPartial(&output, "orgNav", m.Org)
+
+		assertNoInjectedHTML(t, "Channel list with slug "+slug, output.String())
+	}
+
+	var output bytes.Buffer
+	executeHTMLPartial(&output, "channelListItem", OrgViewModel{OrgSlug: "acme"}.Channel(`"><img src=x onerror=alert(1)>`))
+	if want := `#&#34;&gt;&lt;img src=x onerror=alert(1)&gt;</a>`; !strings.Contains(output.String(), want) {
+		t.Errorf("Channel list item = %s, want the slug escaped as %s", output.String(), want)
+	}
+	if want := `href="/org:acme/channel:%22%3e%3cimg%20src=x%20onerror=alert%281%29%3e/posts"`; !strings.Contains(output.String(), want) {
+		t.Errorf("Channel list item = %s, want the URL escaped as %s", output.String(), want)
+	}
+}
+
+func TestHTMLPartialsAlertAndErrorMessage(t *testing.T) {
+	for _, message := range hostileInputs {
+		var output bytes.Buffer
+		executeHTMLPartial(&output, "alert", message)
+
+		w := bufio.NewWriter(&output)
+		viewErrorMessage(message, w)
+		w.Flush()
+
+		assertNoInjectedHTML(t, "Alert and error message "+message, output.String())
+	}
+
+	var output bytes.Buffer
+	executeHTMLPartial(&output, "alert", `<script>alert(1)</script>`)
+	want := `<span class="text-red-dark">Error: </span>&lt;script&gt;alert(1)&lt;/script&gt;</p>`
+	if !strings.Contains(output.String(), want) {
+		t.Errorf("Alert = %s, want %s", output.String(), want)
+	}
+
+	output.Reset()
+	w := bufio.NewWriter(&output)
+	viewErrorMessage(`"><img src=x onerror=alert(1)>`, w)
+	w.Flush()
+	want = `<p class="py-1 px-2 bg-white text-red">&#34;&gt;&lt;img src=x onerror=alert(1)&gt;</p>`
+	if output.String() != want {
+		t.Errorf("viewErrorMessage() = %s, want %s", output.String(), want)
+	}
+}
+
+func TestHTMLPartialsButtonsAndFields(t *testing.T) {
+	for _, input := range hostileInputs {
+		var output bytes.Buffer
+		w := bufio.NewWriter(&output)
+		section := &viewSectionWriter{w: w, outerTagName: "div", csrfToken: input}
+		section.writeTemplate(`
+{{props | setText .Text | setColor .Color | setIsSubmit | button}}
+{{props | setURL .URL | setText .Text | setColor .Color | buttonLink}}
+{{props | setLabel .Text | setInputFormName .Text | fieldWithLabel}}
+{{csrfInput}}
+`, struct {
+			Text  string
+			Color string
+			URL   string
+		}{
+			Text:  input,
+			Color: input,
+			URL:   input,
+		})
+		w.Flush()
+
+		assertNoInjectedHTML(t, "Buttons and fields with "+input, output.String())
+	}
+
+	var output bytes.Buffer
+	executeHTMLPartial(&output, "buttonLink", htmlButtonProps{
+		Text:  "Open",
+		Color: "blue",
+		URL:   "javascript:alert(1)",
+	})
+	if !strings.Contains(output.String(), `href="#ZgotmplZ"`) {
+		t.Errorf("Button link = %s, want the javascript: URL replaced", output.String())
+	}
+
+	output.Reset()
+	executeHTMLPartial(&output, "button", htmlButtonProps{
+		Text:       "Save",
+		Color:      `red" onclick="alert(1)`,
+		ButtonType: "submit",
+	})
+	if strings.Contains(output.String(), ` onclick="`) {
+		t.Errorf("Button = %s, want the color kept within the class attribute", output.String())
+	}
+
+	output.Reset()
+	executeHTMLPartial(&output, "field", htmlFieldProps{
+		Label:         "<b>Name</b>",
+		InputFormName: `name"><script>`,
+	})
+	if !strings.Contains(output.String(), `&lt;b&gt;Name&lt;/b&gt;`) || !strings.Contains(output.String(), `name="name&#34;&gt;&lt;script&gt;"`) {
+		t.Errorf("Field = %s, want the label and name escaped", output.String())
+	}
+}
+
+func TestHTMLPartialsSection(t *testing.T) {
+	var output bytes.Buffer
+	w := bufio.NewWriter(&output)
+	section := &viewSectionWriter{w: w, outerTagName: `script`}
+	section.class(`"><img src=x onerror=alert(1)>`).writeHTMLString("<p>Hello</p>")
+	w.Flush()
+
+	if !strings.HasPrefix(output.String(), `<section class="&#34;&gt;&lt;img src=x onerror=alert(1)&gt;">`) {
+		t.Errorf("Section = %s, want a section with its class escaped", output.String())
+	}
+	assertNoInjectedHTML(t, "Section", output.String())
+}
+
+func TestViewPostTemplateEscapesPosts(t *testing.T) {
+	// A key for Post 5 in the app dev~test, as it cannot be made without App Engine
+	postKey, err := datastore.DecodeKey("aghkZXZ-dGVzdHIKCxIEUG9zdBgFDA")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, input := range hostileInputs {
+		m := OrgViewModel{OrgSlug: input}.Channel(input)
+		post := Post{
+			Key:       postKey,
+			CreatedAt: time.Date(2018, 7, 1, 9, 30, 0, 0, time.UTC),
+			Content:   NewMarkdownDocument(input),
+			Replies: &[]Post{
+				{Key: postKey, Content: NewMarkdownDocument(input)},
+			},
+		}
+
+		var output bytes.Buffer
+		w := bufio.NewWriter(&output)
+		viewPostsInChannelHTMLHandle(context.Background(), []Post{post}, m, w)
+		viewCreatePostFormInChannelHTMLHandle(m, input, w)
+		viewDeveloperSectionForPostsInChannelHTMLHandle(m, w)
+		w.Flush()
+
+		assertNoInjectedHTML(t, "Post "+input, output.String())
+	}
+
+	var output bytes.Buffer
+	w := bufio.NewWriter(&output)
+	viewPostsInChannelHTMLHandle(context.Background(), []Post{
+		{Key: postKey, Content: NewMarkdownDocument(`<script>alert(1)</script>`)},
+	}, OrgViewModel{OrgSlug: "acme"}.Channel("design"), w)
+	w.Flush()
+
+	if !strings.Contains(output.String(), `&lt;script&gt;alert(1)&lt;/script&gt;`) {
+		t.Errorf("Post = %s, want its content escaped", output.String())
+	}
+	if !strings.Contains(output.String(), `href="/org:acme/channel:design/posts/aghkZXZ-dGVzdHIKCxIEUG9zdBgFDA"`) {
+		t.Errorf("Post = %s, want a link to the post", output.String())
+	}
+}
@@ -12,6 +12,8 @@ import (
 
 const (
 	sessionDuration = time.Hour * time.Duration(24*30*6) // 6 months
+
+	sessionIDCookieName = "sessid"
 )
 
 // SessHandlerFunc has context.Context, session.Manager as extra arguments to a http.HandlerFunc
@@ -23,8 +25,16 @@ func GetSessionManager(ctx context.Context) session.Manager {
 		OnlyMemcache:       false,
 		AsyncDatastoreSave: false,
 	}), &session.CookieMngrOptions{
-		CookieMaxAge: sessionDuration,
-		AllowHTTP:    IsDev(),
+		SessIDCookieName: sessionIDCookieName,
+		CookieMaxAge:     sessionDuration,
+		AllowHTTP:        IsDev(),
+	})
+}
+
+func newSession() session.Session {
+	return session.NewSessionOptions(&session.SessOptions{
+		CAttrs: map[string]interface{}{},
+		Attrs:  map[string]interface{}{},
 	})
 }
 
@@ -71,10 +81,7 @@ func WithViewerInSession(f func(context.Context, *Viewer, http.ResponseWriter, *
 
 		sess := sessmgr.Get(r)
 		if sess == nil {
-			sess = session.NewSessionOptions(&session.SessOptions{
-				CAttrs: map[string]interface{}{},
-				Attrs:  map[string]interface{}{},
-			})
+			sess = newSession()
 			sessmgr.Add(sess, w)
 		}
 
@@ -85,21 +92,29 @@ func WithViewerInSession(f func(context.Context, *Viewer, http.ResponseWriter, *
 }
 
 func writeJSON(w http.ResponseWriter, d interface{}) {
-	w.Header().Set("Content-Type", "application/json")
+	writeJSONWithStatus(w, http.StatusOK, d)
+}
 
+// writeJSONWithStatus encodes before writing any headers, so they are all sent along with the status
+func writeJSONWithStatus(w http.ResponseWriter, statusCode int, d interface{}) {
 	// TODO: use json.NewEncoder(w).Encode(...)
 	b, err := json.Marshal(d)
-
 	if err != nil {
-		http.Error(w, "{\"error\": \"Could not encode json\"}", http.StatusInternalServerError)
-		return
+		statusCode = http.StatusInternalServerError
+		b = []byte("{\"error\": \"Could not encode json\"}")
 	}
 
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
 	w.Write(b)
 }
 
 func writeErrorJSON(w http.ResponseWriter, e error) {
-	writeJSON(w, &struct {
+	writeErrorJSONWithStatus(w, http.StatusOK, e)
+}
+
+func writeErrorJSONWithStatus(w http.ResponseWriter, statusCode int, e error) {
+	writeJSONWithStatus(w, statusCode, &struct {
 		Error string `json:"error"`
 	}{
 		Error: e.Error(),
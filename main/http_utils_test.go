@@ -0,0 +1,35 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWriteErrorJSONWithStatus(t *testing.T) {
+	w := httptest.NewRecorder()
+	writeErrorJSONWithStatus(w, http.StatusForbidden, errors.New("Not allowed"))
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if contentType := w.Result().Header.Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", contentType)
+	}
+	if body := w.Body.String(); body != `{"error":"Not allowed"}` {
+		t.Errorf("Body = %s", body)
+	}
+}
+
+func TestWriteJSONWithStatusUnencodable(t *testing.T) {
+	w := httptest.NewRecorder()
+	writeJSONWithStatus(w, http.StatusCreated, func() {})
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Status = %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+	if contentType := w.Result().Header.Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", contentType)
+	}
+}
@@ -2,14 +2,12 @@ package main
 
 import (
 	"context"
-	"encoding/gob"
 	"net/http"
 	"os"
 
 	"github.com/gorilla/mux"
 	"github.com/graph-gophers/graphql-go/relay"
 	"github.com/icza/session"
-	"golang.org/x/oauth2"
 	"google.golang.org/appengine"
 	"google.golang.org/appengine/datastore"
 	"google.golang.org/appengine/log"
@@ -69,10 +67,6 @@ func afterSignInHandle(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, os.Getenv("POST_SIGN_IN_URL"), 302)
 }
 
-func init() {
-	gob.Register(oauth2.Token{})
-}
-
 func main() {
 	r := mux.NewRouter()
 
@@ -82,6 +76,8 @@ func main() {
 	r.Path("/user-credentials").Methods("POST").
 		HandlerFunc(createUserCredentialHandle)
 
+	AddOAuthProviderRoutes(r)
+	AddConnectedServicesRoutes(r)
 	AddGitHubRoutes(r)
 	AddTrelloRoutes(r)
 	AddFigmaRoutes(r)
@@ -95,6 +91,16 @@ func main() {
 	AddAPIOrgsRoutes(r)
 	AddAPIPostsRoutes(r)
 	AddAPIStorageRoutes(r)
+	AddStorageCollectionRoutes(r)
+	AddAPICommandsRoutes(r)
+	AddCommandSchedulesRoutes(r)
+	AddGitHubIssuesRoutes(r)
+	AddGitHubWebhooksRoutes(r)
+	AddTrelloCardsRoutes(r)
+	AddSecurityRoutes(r)
+
+	r.Path("/signout").Methods("POST").
+		HandlerFunc(SignOutHandle)
 
 	http.HandleFunc("/auth/status", AuthStatusHandle)
 
@@ -102,16 +108,21 @@ func main() {
 	schema := MakeSchema(&resolver)
 
 	graphqlHandler := relay.Handler{Schema: schema}
-	http.HandleFunc("/graphql", func(w http.ResponseWriter, r *http.Request) {
-		ctx := appengine.NewContext(r)
-		r = r.WithContext(ctx)
+	// Mutations act as the viewer, so like other JSON APIs they need the X-CSRF-Token header
+	http.HandleFunc("/graphql", WithCSRFHeader(WithViewer(func(ctx context.Context, v *Viewer, w http.ResponseWriter, r *http.Request) {
+		ctx = ContextSigningStorageURLsFor(ctx, v)
+		r = r.WithContext(ContextWithCommandParamVariables(ctx, v.GetCommandParamVariables()))
 		graphqlHandler.ServeHTTP(w, r)
-	})
+	})))
 
 	r.Path("/_sessions/purge").Methods("GET").
 		HandlerFunc(session.PurgeExpiredSessFromDSFunc(""))
 
 	http.Handle("/", r)
 
+	if IsDev() {
+		StartLocalCommandScheduler()
+	}
+
 	appengine.Main()
 }
@@ -0,0 +1,498 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/icza/session"
+	"github.com/mrjones/oauth"
+	"golang.org/x/oauth2"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+)
+
+var (
+	errNoSignInSession = errors.New("No session present for signing in. Please try again.")
+	errSignInState     = errors.New("State does not match one at start of sign in flow. Please try again.")
+
+	oauthProviders = map[string]OAuthProvider{}
+)
+
+func init() {
+	gob.Register(oauth.RequestToken{})
+}
+
+// OAuthProvider signs the viewer into a third-party service, whose token is stored with their account
+type OAuthProvider interface {
+	// ID is used in the /signin/{id} routes, session attribute names, and stored connections
+	ID() string
+	// Name is shown to the viewer
+	Name() string
+	// StartSignIn remembers what the callback must verify, and returns the service’s URL to send the viewer to
+	StartSignIn(ctx context.Context, sess session.Session) (string, error)
+	// ExchangeCallback verifies the callback and returns the token the service granted
+	ExchangeCallback(ctx context.Context, sess session.Session, r *http.Request) (*ConnectedServiceToken, error)
+	// Identify returns the service’s unique ID and login for the user the token belongs to
+	Identify(ctx context.Context, token *ConnectedServiceToken) (string, string, error)
+	// RevokeToken asks the service to invalidate the token, doing nothing if the service has no way to
+	RevokeToken(ctx context.Context, token *ConnectedServiceToken) error
+}
+
+// registerOAuthProvider makes the provider available for signing in
+func registerOAuthProvider(provider OAuthProvider) {
+	oauthProviders[provider.ID()] = provider
+}
+
+// OAuthProviders lists the registered providers, ordered by ID
+func OAuthProviders() []OAuthProvider {
+	ids := make([]string, 0, len(oauthProviders))
+	for id := range oauthProviders {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	providers := make([]OAuthProvider, 0, len(ids))
+	for _, id := range ids {
+		providers = append(providers, oauthProviders[id])
+	}
+	return providers
+}
+
+// GetOAuthProvider returns the provider with the ID, or nil if there is none
+func GetOAuthProvider(id string) OAuthProvider {
+	return oauthProviders[id]
+}
+
+func randomURLSafeString(byteCount int) (string, error) {
+	b := make([]byte, byteCount)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// getOAuthJSON reads a JSON response using an authorized client
+func getOAuthJSON(client *http.Client, url string, out interface{}) error {
+	res, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("%s responded with %d: %s", url, res.StatusCode, strings.TrimSpace(string(message)))
+	}
+
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+// CompleteOAuthSignIn stores the callback’s token with the account the service’s user belongs to, signing the session into it
+func CompleteOAuthSignIn(ctx context.Context, provider OAuthProvider, sess session.Session, r *http.Request) (*ConnectedService, error) {
+	token, err := provider.ExchangeCallback(ctx, sess, r)
+	if err != nil {
+		return nil, err
+	}
+
+	id, login, err := provider.Identify(ctx, token)
+	if err != nil {
+		return nil, errors.New("Could not read your " + provider.Name() + " account. " + err.Error())
+	}
+
+	identity := provider.ID() + ":" + id
+
+	repo := NewUserAccountsRepo(ctx)
+	accountKey, err := repo.FindOrCreateUserAccountForIdentity(UserAccountKeyFromSession(sess), identity)
+	if err != nil {
+		return nil, err
+	}
+
+	service, err := repo.SaveConnectedService(accountKey, ConnectedService{
+		ProviderID:   provider.ID(),
+		Identity:     identity,
+		AccountLogin: login,
+	}, token)
+	if err != nil {
+		return nil, err
+	}
+
+	sess.SetAttr(userAccountKeySessionKey, accountKey.Encode())
+	return service, nil
+}
+
+// DisconnectOAuthProvider forgets the account’s token for the provider, and revokes it with the service where supported
+func DisconnectOAuthProvider(ctx context.Context, provider OAuthProvider, sess session.Session) error {
+	accountKey := UserAccountKeyFromSession(sess)
+
+	repo := NewUserAccountsRepo(ctx)
+	_, token, err := repo.GetConnectedService(accountKey, provider.ID())
+	if err == errServiceNotConnected {
+		return nil
+	}
+	if err != nil {
+		log.Warningf(ctx, "Could not read %s token to revoke: %v", provider.Name(), err)
+	}
+
+	err = repo.DeleteConnectedService(accountKey, provider.ID())
+	if err != nil {
+		return err
+	}
+
+	if token == nil {
+		return nil
+	}
+
+	err = provider.RevokeToken(ctx, token)
+	if err != nil {
+		// The token is already forgotten, so the user may need to revoke it themselves
+		return errors.New("Disconnected, but " + provider.Name() + " could not revoke access. You may want to revoke it in your " + provider.Name() + " settings. " + err.Error())
+	}
+	return nil
+}
+
+// loadConnectedServiceToken returns the account’s token for the provider, or nil if not connected
+func loadConnectedServiceToken(ctx context.Context, providerID string, accountKey *datastore.Key) *ConnectedServiceToken {
+	if accountKey == nil {
+		return nil
+	}
+
+	_, token, err := NewUserAccountsRepo(ctx).GetConnectedService(accountKey, providerID)
+	if err != nil {
+		if err != errServiceNotConnected {
+			log.Errorf(ctx, "Could not load %s token: %v", providerID, err)
+		}
+		return nil
+	}
+
+	return token
+}
+
+// OAuth2Provider signs in with the OAuth 2 authorization code flow, optionally with PKCE
+type OAuth2Provider struct {
+	id     string
+	name   string
+	config *oauth2.Config
+	// pkce sends a S256 code challenge, which the service checks against the verifier when exchanging the code
+	pkce bool
+	// identify reads the service’s ID and login for the signed in user
+	identify func(ctx context.Context, client *http.Client) (string, string, error)
+	// revoke invalidates a token with the service, and is nil if the service has no way to
+	revoke func(ctx context.Context, config *oauth2.Config, token *oauth2.Token) error
+}
+
+func (p *OAuth2Provider) stateKey() string {
+	return p.id + "State"
+}
+
+func (p *OAuth2Provider) verifierKey() string {
+	return p.id + "CodeVerifier"
+}
+
+// ID of the provider
+func (p *OAuth2Provider) ID() string {
+	return p.id
+}
+
+// Name of the provider
+func (p *OAuth2Provider) Name() string {
+	return p.name
+}
+
+// StartSignIn generates the state and PKCE verifier, storing them in the session
+func (p *OAuth2Provider) StartSignIn(ctx context.Context, sess session.Session) (string, error) {
+	state, err := randomURLSafeString(16)
+	if err != nil {
+		return "", err
+	}
+	sess.SetAttr(p.stateKey(), state)
+
+	var options []oauth2.AuthCodeOption
+	if p.pkce {
+		verifier, err := randomURLSafeString(32)
+		if err != nil {
+			return "", err
+		}
+		sess.SetAttr(p.verifierKey(), verifier)
+
+		challenge := sha256.Sum256([]byte(verifier))
+		options = append(options,
+			oauth2.SetAuthURLParam("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:])),
+			oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+		)
+	}
+
+	return p.config.AuthCodeURL(state, options...), nil
+}
+
+// ExchangeCallback checks the state, and exchanges the code with the PKCE verifier
+func (p *OAuth2Provider) ExchangeCallback(ctx context.Context, sess session.Session, r *http.Request) (*ConnectedServiceToken, error) {
+	expectedState, _ := sess.Attr(p.stateKey()).(string)
+	sess.SetAttr(p.stateKey(), nil)
+	if expectedState == "" || expectedState != r.URL.Query().Get("state") {
+		return nil, errSignInState
+	}
+
+	var options []oauth2.AuthCodeOption
+	if p.pkce {
+		verifier, _ := sess.Attr(p.verifierKey()).(string)
+		sess.SetAttr(p.verifierKey(), nil)
+		if verifier == "" {
+			return nil, errSignInState
+		}
+		options = append(options, oauth2.SetAuthURLParam("code_verifier", verifier))
+	}
+
+	token, err := p.config.Exchange(ctx, r.URL.Query().Get("code"), options...)
+	if err != nil {
+		return nil, errors.New("Could not get " + p.name + " token. Please try again. " + err.Error())
+	}
+
+	if !token.Valid() {
+		return nil, errors.New(p.name + " token is invalid. Please try again.")
+	}
+
+	// Services report the scopes actually granted, which the user may have narrowed
+	scopes := p.config.Scopes
+	if granted, ok := token.Extra("scope").(string); ok && granted != "" {
+		scopes = strings.FieldsFunc(granted, func(r rune) bool {
+			return r == ',' || r == ' '
+		})
+	}
+
+	return &ConnectedServiceToken{OAuth2: token, Scopes: scopes}, nil
+}
+
+// Identify reads the service’s ID and login for the token’s user
+func (p *OAuth2Provider) Identify(ctx context.Context, token *ConnectedServiceToken) (string, string, error) {
+	return p.identify(ctx, p.ClientForToken(ctx, token.OAuth2))
+}
+
+// RevokeToken invalidates the token with the service if it can
+func (p *OAuth2Provider) RevokeToken(ctx context.Context, token *ConnectedServiceToken) error {
+	if p.revoke == nil || token.OAuth2 == nil {
+		return nil
+	}
+	return p.revoke(ctx, p.config, token.OAuth2)
+}
+
+// TokenSource returns the session account’s token, refreshing and saving it once expired, or nil if not connected
+func (p *OAuth2Provider) TokenSource(ctx context.Context, sess session.Session) oauth2.TokenSource {
+	return p.TokenSourceForAccount(ctx, UserAccountKeyFromSession(sess))
+}
+
+// TokenSourceForAccount returns the account’s token, refreshing and saving it once expired, or nil if not connected
+func (p *OAuth2Provider) TokenSourceForAccount(ctx context.Context, accountKey *datastore.Key) oauth2.TokenSource {
+	token := loadConnectedServiceToken(ctx, p.id, accountKey)
+	if token == nil || token.OAuth2 == nil {
+		return nil
+	}
+
+	return oauth2.ReuseTokenSource(token.OAuth2, &persistingTokenSource{
+		ctx:        ctx,
+		accountKey: accountKey,
+		providerID: p.id,
+		base:       p.config.TokenSource(ctx, token.OAuth2),
+		last:       token.OAuth2.AccessToken,
+	})
+}
+
+// Client makes a http.Client that authorizes requests as the account, or nil if not connected
+func (p *OAuth2Provider) Client(ctx context.Context, sess session.Session) *http.Client {
+	tokenSource := p.TokenSource(ctx, sess)
+	if tokenSource == nil {
+		return nil
+	}
+
+	return oauth2.NewClient(ctx, tokenSource)
+}
+
+// ClientForToken makes a http.Client that authorizes requests with a token from elsewhere
+func (p *OAuth2Provider) ClientForToken(ctx context.Context, token *oauth2.Token) *http.Client {
+	return p.config.Client(ctx, token)
+}
+
+// persistingTokenSource saves tokens that the service rotated when refreshing
+type persistingTokenSource struct {
+	ctx        context.Context
+	accountKey *datastore.Key
+	providerID string
+	base       oauth2.TokenSource
+
+	mu   sync.Mutex
+	last string
+}
+
+func (s *persistingTokenSource) Token() (*oauth2.Token, error) {
+	token, err := s.base.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	rotated := token.AccessToken != s.last
+	s.last = token.AccessToken
+	s.mu.Unlock()
+
+	if rotated {
+		err = NewUserAccountsRepo(s.ctx).UpdateConnectedServiceOAuth2Token(s.accountKey, s.providerID, token)
+		if err != nil {
+			// The refreshed token still works for this request, and will be refreshed again next time
+			log.Errorf(s.ctx, "Could not save refreshed %s token: %v", s.providerID, err)
+		}
+	}
+
+	return token, nil
+}
+
+// OAuth1Provider signs in with the OAuth 1.0a three-legged flow
+type OAuth1Provider struct {
+	id          string
+	name        string
+	callbackURL string
+	scopes      []string
+	consumer    func(ctx context.Context) *oauth.Consumer
+	// identify reads the service’s ID and login for the signed in user
+	identify func(ctx context.Context, client *http.Client) (string, string, error)
+	// revoke invalidates a token with the service, and is nil if the service has no way to
+	revoke func(ctx context.Context, client *http.Client, token *oauth.AccessToken) error
+}
+
+func (p *OAuth1Provider) requestTokenKey() string {
+	return p.id + "RequestToken"
+}
+
+// ID of the provider
+func (p *OAuth1Provider) ID() string {
+	return p.id
+}
+
+// Name of the provider
+func (p *OAuth1Provider) Name() string {
+	return p.name
+}
+
+// StartSignIn gets a request token, storing it in the session
+func (p *OAuth1Provider) StartSignIn(ctx context.Context, sess session.Session) (string, error) {
+	requestToken, url, err := p.consumer(ctx).GetRequestTokenAndUrl(p.callbackURL)
+	if err != nil {
+		return "", err
+	}
+
+	sess.SetAttr(p.requestTokenKey(), *requestToken)
+	return url, nil
+}
+
+// ExchangeCallback checks the callback is for the request token, and exchanges it for an access token
+func (p *OAuth1Provider) ExchangeCallback(ctx context.Context, sess session.Session, r *http.Request) (*ConnectedServiceToken, error) {
+	requestToken, ok := sess.Attr(p.requestTokenKey()).(oauth.RequestToken)
+	sess.SetAttr(p.requestTokenKey(), nil)
+	if !ok || requestToken.Token != r.URL.Query().Get("oauth_token") {
+		return nil, errSignInState
+	}
+
+	accessToken, err := p.consumer(ctx).AuthorizeToken(&requestToken, r.URL.Query().Get("oauth_verifier"))
+	if err != nil {
+		return nil, errors.New("Could not get " + p.name + " token. Please try again.")
+	}
+
+	return &ConnectedServiceToken{OAuth1: accessToken, Scopes: p.scopes}, nil
+}
+
+// Identify reads the service’s ID and login for the token’s user
+func (p *OAuth1Provider) Identify(ctx context.Context, token *ConnectedServiceToken) (string, string, error) {
+	client, err := p.Client(ctx, token.OAuth1)
+	if err != nil {
+		return "", "", err
+	}
+
+	return p.identify(ctx, client)
+}
+
+// RevokeToken invalidates the token with the service if it can
+func (p *OAuth1Provider) RevokeToken(ctx context.Context, token *ConnectedServiceToken) error {
+	if p.revoke == nil || token.OAuth1 == nil {
+		return nil
+	}
+
+	client, err := p.Client(ctx, token.OAuth1)
+	if err != nil {
+		return err
+	}
+	return p.revoke(ctx, client, token.OAuth1)
+}
+
+// AccessToken returns the session account’s token, or nil if not connected
+func (p *OAuth1Provider) AccessToken(ctx context.Context, sess session.Session) *oauth.AccessToken {
+	return p.AccessTokenForAccount(ctx, UserAccountKeyFromSession(sess))
+}
+
+// AccessTokenForAccount returns the account’s token, or nil if not connected
+func (p *OAuth1Provider) AccessTokenForAccount(ctx context.Context, accountKey *datastore.Key) *oauth.AccessToken {
+	token := loadConnectedServiceToken(ctx, p.id, accountKey)
+	if token == nil {
+		return nil
+	}
+
+	return token.OAuth1
+}
+
+// Client makes a http.Client that signs requests with the access token
+func (p *OAuth1Provider) Client(ctx context.Context, accessToken *oauth.AccessToken) (*http.Client, error) {
+	return p.consumer(ctx).MakeHttpClient(accessToken)
+}
+
+func oauthStartHandle(provider OAuthProvider) http.HandlerFunc {
+	return WithViewerInSession(func(ctx context.Context, v *Viewer, w http.ResponseWriter, r *http.Request) {
+		url, err := provider.StartSignIn(ctx, v.sess)
+		if err != nil {
+			log.Errorf(ctx, "Could not start signing in with %s: %v", provider.Name(), err)
+			http.Error(w, "Could not sign in with "+provider.Name()+". "+err.Error(), http.StatusFailedDependency)
+			return
+		}
+
+		http.Redirect(w, r, url, http.StatusFound)
+	})
+}
+
+func oauthCallbackHandle(provider OAuthProvider) http.HandlerFunc {
+	return WithViewer(func(ctx context.Context, v *Viewer, w http.ResponseWriter, r *http.Request) {
+		if v.sess == nil {
+			http.Error(w, errNoSignInSession.Error(), http.StatusExpectationFailed)
+			return
+		}
+
+		_, err := CompleteOAuthSignIn(ctx, provider, v.sess, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusExpectationFailed)
+			return
+		}
+
+		afterSignInHandle(w, r)
+	})
+}
+
+// AddOAuthProviderRoutes adds the /signin/{id} and /signin/{id}/callback routes for every provider
+func AddOAuthProviderRoutes(r *mux.Router) {
+	for _, provider := range OAuthProviders() {
+		r.Path("/signin/" + provider.ID()).Methods("GET").
+			HandlerFunc(oauthStartHandle(provider))
+
+		r.Path("/signin/" + provider.ID() + "/callback").Methods("GET").
+			HandlerFunc(oauthCallbackHandle(provider))
+	}
+}
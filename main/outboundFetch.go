@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/socket"
+)
+
+const (
+	defaultOutboundFetchTimeout  = 10 * time.Second
+	defaultOutboundFetchMaxBytes = 5 << 20 // 5 MB
+	maxOutboundFetchRedirects    = 5
+)
+
+var (
+	// ErrOutboundResponseTooLarge is returned when reading more than the allowed response size
+	ErrOutboundResponseTooLarge = errors.New("Response is larger than allowed")
+
+	blockedOutboundHosts = map[string]bool{
+		"localhost":                true,
+		"metadata":                 true,
+		"metadata.google.internal": true,
+	}
+
+	blockedOutboundNetworks = mustParseCIDRs(
+		"0.0.0.0/8",      // “this” network
+		"10.0.0.0/8",     // private
+		"100.64.0.0/10",  // carrier-grade NAT
+		"127.0.0.0/8",    // loopback
+		"169.254.0.0/16", // link-local, including cloud metadata
+		"172.16.0.0/12",  // private
+		"192.0.0.0/24",   // IETF protocol assignments
+		"192.168.0.0/16", // private
+		"198.18.0.0/15",  // benchmarking
+		"224.0.0.0/4",    // multicast
+		"240.0.0.0/4",    // reserved
+		"::/128",         // unspecified
+		"::1/128",        // loopback
+		"64:ff9b::/96",   // NAT64, which embeds any IPv4 address
+		"64:ff9b:1::/48", // local-use NAT64
+		"2002::/16",      // 6to4, which embeds any IPv4 address
+		"fc00::/7",       // unique local
+		"fe80::/10",      // link-local
+		"ff00::/8",       // multicast
+	)
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// BlockedRequestError explains why a command was not allowed to fetch a URL
+type BlockedRequestError struct {
+	URL    string
+	Reason string
+}
+
+func (e *BlockedRequestError) Error() string {
+	return fmt.Sprintf("Blocked request to %s: %s", e.URL, e.Reason)
+}
+
+// OutboundFetcherOptions limits what commands can fetch
+type OutboundFetcherOptions struct {
+	Timeout        time.Duration
+	MaxBytes       int64
+	AllowedSchemes []string
+}
+
+// DefaultOutboundFetcherOptions reads OUTBOUND_FETCH_TIMEOUT, OUTBOUND_FETCH_MAX_BYTES, and OUTBOUND_FETCH_ALLOWED_SCHEMES
+func DefaultOutboundFetcherOptions() OutboundFetcherOptions {
+	options := OutboundFetcherOptions{
+		Timeout:        defaultOutboundFetchTimeout,
+		MaxBytes:       defaultOutboundFetchMaxBytes,
+		AllowedSchemes: []string{"https", "http"},
+	}
+
+	if timeout, err := time.ParseDuration(os.Getenv("OUTBOUND_FETCH_TIMEOUT")); err == nil && timeout > 0 {
+		options.Timeout = timeout
+	}
+
+	if maxBytes, err := strconv.ParseInt(os.Getenv("OUTBOUND_FETCH_MAX_BYTES"), 10, 64); err == nil && maxBytes > 0 {
+		options.MaxBytes = maxBytes
+	}
+
+	if schemes := strings.TrimSpace(os.Getenv("OUTBOUND_FETCH_ALLOWED_SCHEMES")); schemes != "" {
+		options.AllowedSchemes = nil
+		for _, scheme := range strings.Split(schemes, ",") {
+			options.AllowedSchemes = append(options.AllowedSchemes, strings.ToLower(strings.TrimSpace(scheme)))
+		}
+	}
+
+	return options
+}
+
+// OutboundFetcher makes HTTP requests to user-provided URLs, refusing private destinations.
+// Connections are made to the addresses that were checked, so a host cannot resolve differently once checked.
+type OutboundFetcher struct {
+	ctx      context.Context
+	options  OutboundFetcherOptions
+	lookupIP func(ctx context.Context, host string) ([]net.IP, error)
+	dial     func(ctx context.Context, network string, addr string) (net.Conn, error)
+}
+
+// NewOutboundFetcher makes a fetcher with the default options
+func NewOutboundFetcher(ctx context.Context) *OutboundFetcher {
+	return NewOutboundFetcherWithOptions(ctx, DefaultOutboundFetcherOptions())
+}
+
+// NewOutboundFetcherWithOptions makes a fetcher with the provided options
+func NewOutboundFetcherWithOptions(ctx context.Context, options OutboundFetcherOptions) *OutboundFetcher {
+	return &OutboundFetcher{
+		ctx:      ctx,
+		options:  options,
+		lookupIP: socket.LookupIP,
+		dial:     dialSocket,
+	}
+}
+
+func dialSocket(ctx context.Context, network string, addr string) (net.Conn, error) {
+	return socket.Dial(ctx, network, addr)
+}
+
+func isBlockedOutboundIP(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	for _, network := range blockedOutboundNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CheckURL returns an error if the URL uses a disallowed scheme or resolves to a private, loopback, or link-local address
+func (f *OutboundFetcher) CheckURL(rawURL string) (*url.URL, error) {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return nil, &BlockedRequestError{URL: rawURL, Reason: "invalid URL"}
+	}
+
+	schemeAllowed := false
+	for _, scheme := range f.options.AllowedSchemes {
+		if strings.ToLower(u.Scheme) == scheme {
+			schemeAllowed = true
+			break
+		}
+	}
+	if !schemeAllowed {
+		return nil, &BlockedRequestError{URL: rawURL, Reason: fmt.Sprintf("scheme %q is not allowed", u.Scheme)}
+	}
+
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "" {
+		return nil, &BlockedRequestError{URL: rawURL, Reason: "no host"}
+	}
+	if blockedOutboundHosts[host] || strings.HasSuffix(host, ".localhost") || strings.HasSuffix(host, ".internal") {
+		return nil, &BlockedRequestError{URL: rawURL, Reason: fmt.Sprintf("host %s is internal", host)}
+	}
+
+	_, err = f.resolveAllowedIPs(f.ctx, rawURL, host)
+	if err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// resolveAllowedIPs looks up the host’s addresses, returning an error if any of them are blocked
+func (f *OutboundFetcher) resolveAllowedIPs(ctx context.Context, rawURL string, host string) ([]net.IP, error) {
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		var err error
+		ips, err = f.lookupIP(ctx, host)
+		if err != nil {
+			return nil, &BlockedRequestError{URL: rawURL, Reason: fmt.Sprintf("cannot resolve host %s", host)}
+		}
+		if len(ips) == 0 {
+			return nil, &BlockedRequestError{URL: rawURL, Reason: fmt.Sprintf("host %s has no addresses", host)}
+		}
+	}
+
+	for _, ip := range ips {
+		if isBlockedOutboundIP(ip) {
+			return nil, &BlockedRequestError{URL: rawURL, Reason: fmt.Sprintf("destination %s is a private, loopback, or link-local address", ip.String())}
+		}
+	}
+
+	return ips, nil
+}
+
+// dialAllowed resolves and checks the host again, then connects to one of the addresses it checked,
+// so what is connected to is what was allowed even if the host’s DNS has changed since
+func (f *OutboundFetcher) dialAllowed(ctx context.Context, network string, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	ips, err := f.resolveAllowedIPs(ctx, addr, strings.ToLower(strings.TrimSuffix(host, ".")))
+	if err != nil {
+		return nil, err
+	}
+
+	var conn net.Conn
+	for _, ip := range ips {
+		conn, err = f.dial(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+	}
+
+	return nil, err
+}
+
+// checkRedirect allows a limited number of redirects, to URLs that pass CheckURL
+func (f *OutboundFetcher) checkRedirect(redirectReq *http.Request, via []*http.Request) error {
+	if len(via) >= maxOutboundFetchRedirects {
+		return fmt.Errorf("Stopped after %d redirects", maxOutboundFetchRedirects)
+	}
+
+	_, err := f.CheckURL(redirectReq.URL.String())
+	return err
+}
+
+// client makes a http.Client that only connects to allowed addresses
+func (f *OutboundFetcher) client() *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext:         f.dialAllowed,
+			TLSHandshakeTimeout: f.options.Timeout,
+			// Each fetcher is short lived, so connections are not kept for reuse
+			DisableKeepAlives: true,
+		},
+		Timeout:       f.options.Timeout,
+		CheckRedirect: f.checkRedirect,
+	}
+}
+
+// limitedResponseBody errors once more than the allowed bytes are read, and cancels the request’s context when closed
+type limitedResponseBody struct {
+	body      io.ReadCloser
+	remaining int64
+	cancel    context.CancelFunc
+}
+
+func (b *limitedResponseBody) Read(p []byte) (int, error) {
+	// Read one more byte than remains, to tell whether the body goes over
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+
+	n, err := b.body.Read(p)
+	if int64(n) > b.remaining {
+		n = int(b.remaining)
+		b.remaining = 0
+		return n, ErrOutboundResponseTooLarge
+	}
+	b.remaining -= int64(n)
+	return n, err
+}
+
+func (b *limitedResponseBody) Close() error {
+	err := b.body.Close()
+	b.cancel()
+	return err
+}
+
+// Do sends the request once its URL, and those of any redirects, have been checked
+func (f *OutboundFetcher) Do(req *http.Request) (*http.Response, error) {
+	_, err := f.CheckURL(req.URL.String())
+	if err != nil {
+		log.Warningf(f.ctx, "%v", err)
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(f.ctx, f.options.Timeout)
+
+	res, err := f.client().Do(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		if urlErr, ok := err.(*url.Error); ok {
+			if blockedErr, ok := urlErr.Err.(*BlockedRequestError); ok {
+				log.Warningf(f.ctx, "%v", blockedErr)
+				return nil, blockedErr
+			}
+		}
+		return nil, err
+	}
+
+	if res.ContentLength > f.options.MaxBytes {
+		res.Body.Close()
+		cancel()
+		return nil, fmt.Errorf("Response from %s is %d bytes, more than the allowed %d bytes", req.URL.String(), res.ContentLength, f.options.MaxBytes)
+	}
+
+	res.Body = &limitedResponseBody{
+		body:      res.Body,
+		remaining: f.options.MaxBytes,
+		cancel:    cancel,
+	}
+
+	return res, nil
+}
+
+// Get fetches the URL with a GET request
+func (f *OutboundFetcher) Get(rawURL string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return f.Do(req)
+}
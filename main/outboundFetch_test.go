@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestIsBlockedOutboundIP(t *testing.T) {
+	tests := []struct {
+		ip      string
+		blocked bool
+	}{
+		{"0.0.0.0", true},
+		{"10.1.2.3", true},
+		{"100.64.0.1", true},
+		{"127.0.0.1", true},
+		{"169.254.169.254", true},
+		{"172.16.0.1", true},
+		{"172.31.255.255", true},
+		{"192.168.1.1", true},
+		{"198.18.0.1", true},
+		{"224.0.0.1", true},
+		{"255.255.255.255", true},
+		{"::", true},
+		{"::1", true},
+		{"::ffff:127.0.0.1", true},
+		{"::ffff:169.254.169.254", true},
+		{"64:ff9b::a9fe:a9fe", true},
+		{"64:ff9b::7f00:1", true},
+		{"64:ff9b:1::a00:1", true},
+		{"2002:7f00:1::1", true},
+		{"2002:a9fe:a9fe::1", true},
+		{"fd00::1", true},
+		{"fe80::1", true},
+		{"ff02::1", true},
+
+		{"8.8.8.8", false},
+		{"93.184.216.34", false},
+		{"172.32.0.1", false},
+		{"100.128.0.1", false},
+		{"::ffff:8.8.8.8", false},
+		{"2606:4700:4700::1111", false},
+		{"2001:4860:4860::8888", false},
+	}
+
+	for _, test := range tests {
+		ip := net.ParseIP(test.ip)
+		if ip == nil {
+			t.Fatalf("Could not parse %s", test.ip)
+		}
+
+		if blocked := isBlockedOutboundIP(ip); blocked != test.blocked {
+			t.Errorf("isBlockedOutboundIP(%s) = %v, want %v", test.ip, blocked, test.blocked)
+		}
+	}
+}
+
+// newTestOutboundFetcher makes a fetcher whose DNS is the hosts map, and which dials with dial
+func newTestOutboundFetcher(hosts map[string][]string, dial func(ctx context.Context, network string, addr string) (net.Conn, error)) *OutboundFetcher {
+	f := NewOutboundFetcherWithOptions(context.Background(), OutboundFetcherOptions{
+		Timeout:        defaultOutboundFetchTimeout,
+		MaxBytes:       defaultOutboundFetchMaxBytes,
+		AllowedSchemes: []string{"https", "http"},
+	})
+
+	f.lookupIP = func(ctx context.Context, host string) ([]net.IP, error) {
+		addrs, ok := hosts[host]
+		if !ok {
+			return nil, errors.New("no such host")
+		}
+
+		ips := make([]net.IP, 0, len(addrs))
+		for _, addr := range addrs {
+			ips = append(ips, net.ParseIP(addr))
+		}
+		return ips, nil
+	}
+	f.dial = dial
+
+	return f
+}
+
+func TestOutboundFetcherCheckURL(t *testing.T) {
+	f := newTestOutboundFetcher(map[string][]string{
+		"public.example":  {"93.184.216.34"},
+		"private.example": {"10.0.0.1"},
+		"mixed.example":   {"93.184.216.34", "127.0.0.1"},
+		"nat64.example":   {"64:ff9b::a9fe:a9fe"},
+		"6to4.example":    {"2002:a00:1::1"},
+		"empty.example":   {},
+	}, nil)
+
+	tests := []struct {
+		url     string
+		allowed bool
+	}{
+		{"https://public.example/path", true},
+		{"http://public.example:8080/", true},
+		{"https://PUBLIC.example./", true},
+		{"https://93.184.216.34/", true},
+		{"https://[2606:4700:4700::1111]/", true},
+
+		{"ftp://public.example/", false},
+		{"file:///etc/passwd", false},
+		{"gopher://public.example/", false},
+		{"https:///path", false},
+		{"https://localhost/", false},
+		{"https://api.localhost/", false},
+		{"https://metadata.google.internal/", false},
+		{"https://anything.internal/", false},
+		{"https://private.example/", false},
+		{"https://mixed.example/", false},
+		{"https://nat64.example/", false},
+		{"https://6to4.example/", false},
+		{"https://empty.example/", false},
+		{"https://unknown.example/", false},
+		{"http://127.0.0.1/", false},
+		{"http://169.254.169.254/computeMetadata/v1/", false},
+		{"http://[::1]/", false},
+		{"http://[::ffff:10.0.0.1]/", false},
+		{"http://[64:ff9b::a9fe:a9fe]/", false},
+		{"http://[2002:7f00:1::1]/", false},
+	}
+
+	for _, test := range tests {
+		_, err := f.CheckURL(test.url)
+		if test.allowed && err != nil {
+			t.Errorf("CheckURL(%s) = %v, want allowed", test.url, err)
+		}
+		if !test.allowed {
+			if _, ok := err.(*BlockedRequestError); !ok {
+				t.Errorf("CheckURL(%s) = %v, want a BlockedRequestError", test.url, err)
+			}
+		}
+	}
+}
+
+// dialTo connects every dial to the server, recording the addresses asked for
+func dialTo(server *httptest.Server, dialed *[]string) func(ctx context.Context, network string, addr string) (net.Conn, error) {
+	serverAddr := server.Listener.Addr().String()
+	return func(ctx context.Context, network string, addr string) (net.Conn, error) {
+		*dialed = append(*dialed, addr)
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, network, serverAddr)
+	}
+}
+
+func blockedRequestErrorFrom(err error) *BlockedRequestError {
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+	blockedErr, _ := err.(*BlockedRequestError)
+	return blockedErr
+}
+
+func TestOutboundFetcherConnectsToCheckedAddress(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	var dialed []string
+	f := newTestOutboundFetcher(map[string][]string{
+		"public.example": {"93.184.216.34"},
+	}, dialTo(server, &dialed))
+
+	res, err := f.client().Get("http://public.example:8080/")
+	if err != nil {
+		t.Fatalf("Get() = %v", err)
+	}
+	res.Body.Close()
+
+	if len(dialed) != 1 || dialed[0] != "93.184.216.34:8080" {
+		t.Errorf("Dialed %v, want [93.184.216.34:8080]", dialed)
+	}
+}
+
+func TestOutboundFetcherRefusesRebindingHost(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	var dialed []string
+	hosts := map[string][]string{
+		"rebinding.example": {"93.184.216.34"},
+	}
+	f := newTestOutboundFetcher(hosts, dialTo(server, &dialed))
+
+	_, err := f.CheckURL("http://rebinding.example/")
+	if err != nil {
+		t.Fatalf("CheckURL() = %v, want allowed", err)
+	}
+
+	// The host resolves to somewhere private once checked
+	hosts["rebinding.example"] = []string{"127.0.0.1"}
+
+	_, err = f.client().Get("http://rebinding.example/")
+	if blockedRequestErrorFrom(err) == nil {
+		t.Errorf("Get() = %v, want a BlockedRequestError", err)
+	}
+	if len(dialed) != 0 {
+		t.Errorf("Dialed %v, want nothing", dialed)
+	}
+}
+
+func TestOutboundFetcherChecksRedirects(t *testing.T) {
+	tests := []struct {
+		location string
+		allowed  bool
+	}{
+		{"http://other.example/done", true},
+		{"http://private.example/", false},
+		{"http://169.254.169.254/computeMetadata/v1/", false},
+		{"http://[::1]/", false},
+		{"http://localhost/", false},
+		{"file:///etc/passwd", false},
+	}
+
+	for _, test := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/done" {
+				w.Write([]byte("ok"))
+				return
+			}
+			http.Redirect(w, r, test.location, http.StatusFound)
+		}))
+
+		var dialed []string
+		f := newTestOutboundFetcher(map[string][]string{
+			"public.example":  {"93.184.216.34"},
+			"other.example":   {"93.184.216.35"},
+			"private.example": {"10.0.0.1"},
+		}, dialTo(server, &dialed))
+
+		res, err := f.client().Get("http://public.example/start")
+		if res != nil {
+			res.Body.Close()
+		}
+
+		if test.allowed && err != nil {
+			t.Errorf("Redirect to %s: Get() = %v, want allowed", test.location, err)
+		}
+		if !test.allowed {
+			if blockedRequestErrorFrom(err) == nil {
+				t.Errorf("Redirect to %s: Get() = %v, want a BlockedRequestError", test.location, err)
+			}
+			if len(dialed) != 1 {
+				t.Errorf("Redirect to %s: dialed %v, want only the first request", test.location, dialed)
+			}
+		}
+
+		server.Close()
+	}
+}
+
+func TestOutboundFetcherStopsRedirectLoops(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/again", http.StatusFound)
+	}))
+	defer server.Close()
+
+	var dialed []string
+	f := newTestOutboundFetcher(map[string][]string{
+		"public.example": {"93.184.216.34"},
+	}, dialTo(server, &dialed))
+
+	_, err := f.client().Get("http://public.example/")
+	if err == nil {
+		t.Fatal("Get() succeeded, want redirects to stop")
+	}
+	if len(dialed) != maxOutboundFetchRedirects {
+		t.Errorf("Made %d requests, want %d", len(dialed), maxOutboundFetchRedirects)
+	}
+}
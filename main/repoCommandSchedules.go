@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron"
+	"google.golang.org/appengine/datastore"
+)
+
+const (
+	commandScheduleType = "CommandSchedule"
+	commandScheduleName = "schedule"
+)
+
+// CommandSchedule re-runs the command in a post periodically, replying with its result.
+// It runs with the services connected to the account that last set it.
+type CommandSchedule struct {
+	Key             *datastore.Key `datastore:"-" json:"id"`
+	PostKey         *datastore.Key `json:"postID"`
+	OrgSlug         string         `json:"orgSlug"`
+	ChannelSlug     string         `json:"channelSlug"`
+	UserAccountKey  *datastore.Key `json:"-"`
+	Cron            string         `json:"cron"`
+	OnlyWhenChanged bool           `json:"onlyWhenChanged"`
+	CreatedAt       time.Time      `json:"createdAt"`
+	LastRunAt       time.Time      `json:"lastRunAt"`
+	NextRunAt       time.Time      `json:"nextRunAt"`
+	LastOutput      string         `datastore:",noindex" json:"-"`
+	LastError       string         `datastore:",noindex" json:"lastError,omitempty"`
+}
+
+// SetCommandScheduleInput is used to schedule a command post
+type SetCommandScheduleInput struct {
+	ChannelSlug     string
+	PostKeyEncoded  string
+	Cron            string
+	OnlyWhenChanged bool
+	UserAccountKey  *datastore.Key
+}
+
+// parseCommandScheduleCron parses a standard 5 field cron expression, or a descriptor like @hourly
+func parseCommandScheduleCron(expression string) (cron.Schedule, error) {
+	expression = strings.TrimSpace(expression)
+	if expression == "" {
+		return nil, errors.New("Schedule cannot be empty")
+	}
+
+	schedule, err := cron.ParseStandard(expression)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid schedule %q: %s", expression, err.Error())
+	}
+
+	return schedule, nil
+}
+
+// NextRunAfter returns when the schedule should next run after the provided time
+func (schedule *CommandSchedule) NextRunAfter(t time.Time) (time.Time, error) {
+	cronSchedule, err := parseCommandScheduleCron(schedule.Cron)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return cronSchedule.Next(t.UTC()), nil
+}
+
+func commandScheduleKeyForPost(ctx context.Context, postKey *datastore.Key) *datastore.Key {
+	return datastore.NewKey(ctx, commandScheduleType, commandScheduleName, 0, postKey)
+}
+
+// SetCommandScheduleForPost creates or replaces the schedule of a command post
+func (repo ChannelsRepo) SetCommandScheduleForPost(input SetCommandScheduleInput) (*CommandSchedule, error) {
+	post, err := repo.GetPostWithIDInChannel(input.ChannelSlug, input.PostKeyEncoded)
+	if err != nil {
+		return nil, err
+	}
+
+	if post.CommandType != "v0" {
+		return nil, errors.New("Only command posts can be scheduled")
+	}
+
+	now := time.Now().UTC()
+	schedule := CommandSchedule{
+		PostKey:         post.Key,
+		OrgSlug:         repo.orgRepo.orgSlug,
+		ChannelSlug:     input.ChannelSlug,
+		UserAccountKey:  input.UserAccountKey,
+		Cron:            strings.TrimSpace(input.Cron),
+		OnlyWhenChanged: input.OnlyWhenChanged,
+		CreatedAt:       now,
+	}
+
+	schedule.NextRunAt, err = schedule.NextRunAfter(now)
+	if err != nil {
+		return nil, err
+	}
+
+	scheduleKey := commandScheduleKeyForPost(repo.ctx, post.Key)
+
+	var existing CommandSchedule
+	err = datastore.Get(repo.ctx, scheduleKey, &existing)
+	if err == nil {
+		schedule.CreatedAt = existing.CreatedAt
+		schedule.LastRunAt = existing.LastRunAt
+		schedule.LastOutput = existing.LastOutput
+		schedule.LastError = existing.LastError
+	} else if err != datastore.ErrNoSuchEntity {
+		return nil, err
+	}
+
+	scheduleKey, err = datastore.Put(repo.ctx, scheduleKey, &schedule)
+	if err != nil {
+		return nil, err
+	}
+
+	schedule.Key = scheduleKey
+	return &schedule, nil
+}
+
+// GetCommandScheduleForPost loads the schedule of a command post, returning nil if it has none
+func (repo ChannelsRepo) GetCommandScheduleForPost(postKey *datastore.Key) (*CommandSchedule, error) {
+	scheduleKey := commandScheduleKeyForPost(repo.ctx, postKey)
+
+	var schedule CommandSchedule
+	err := datastore.Get(repo.ctx, scheduleKey, &schedule)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	schedule.Key = scheduleKey
+	return &schedule, nil
+}
+
+// RemoveCommandScheduleForPost stops a command post from running periodically
+func (repo ChannelsRepo) RemoveCommandScheduleForPost(postKey *datastore.Key) error {
+	err := datastore.Delete(repo.ctx, commandScheduleKeyForPost(repo.ctx, postKey))
+	if err == datastore.ErrNoSuchEntity {
+		return nil
+	}
+	return err
+}
+
+// CommandSchedulesRepo lets you query schedules across every org
+type CommandSchedulesRepo struct {
+	ctx context.Context
+}
+
+// NewCommandSchedulesRepo makes a new command schedules repository
+func NewCommandSchedulesRepo(ctx context.Context) CommandSchedulesRepo {
+	return CommandSchedulesRepo{
+		ctx: ctx,
+	}
+}
+
+// ListDue lists the schedules that should have run by the provided time
+func (repo CommandSchedulesRepo) ListDue(now time.Time, maxCount int) ([]CommandSchedule, error) {
+	q := datastore.NewQuery(commandScheduleType).Filter("NextRunAt <=", now.UTC()).Limit(maxCount)
+
+	var schedules []CommandSchedule
+	for i := q.Run(repo.ctx); ; {
+		var schedule CommandSchedule
+		key, err := i.Next(&schedule)
+		if err == datastore.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		schedule.Key = key
+		schedules = append(schedules, schedule)
+	}
+
+	return schedules, nil
+}
+
+// Claim advances the schedule’s next run, returning false if another run already claimed it
+func (repo CommandSchedulesRepo) Claim(scheduleKey *datastore.Key, now time.Time) (*CommandSchedule, bool, error) {
+	var schedule CommandSchedule
+	claimed := false
+
+	err := datastore.RunInTransaction(repo.ctx, func(ctx context.Context) error {
+		claimed = false
+
+		err := datastore.Get(ctx, scheduleKey, &schedule)
+		if err != nil {
+			return err
+		}
+
+		if schedule.NextRunAt.After(now) {
+			return nil
+		}
+
+		schedule.NextRunAt, err = schedule.NextRunAfter(now)
+		if err != nil {
+			return err
+		}
+
+		_, err = datastore.Put(ctx, scheduleKey, &schedule)
+		if err != nil {
+			return err
+		}
+
+		claimed = true
+		return nil
+	}, nil)
+	if err != nil {
+		return nil, false, err
+	}
+
+	schedule.Key = scheduleKey
+	return &schedule, claimed, nil
+}
+
+// RecordRun stores the output of the latest run of a schedule
+func (repo CommandSchedulesRepo) RecordRun(scheduleKey *datastore.Key, ranAt time.Time, output string, runErr error) error {
+	return datastore.RunInTransaction(repo.ctx, func(ctx context.Context) error {
+		var schedule CommandSchedule
+		err := datastore.Get(ctx, scheduleKey, &schedule)
+		if err != nil {
+			return err
+		}
+
+		schedule.LastRunAt = ranAt.UTC()
+		if runErr != nil {
+			schedule.LastError = runErr.Error()
+		} else {
+			schedule.LastError = ""
+			schedule.LastOutput = output
+		}
+
+		_, err = datastore.Put(ctx, scheduleKey, &schedule)
+		return err
+	}, nil)
+}
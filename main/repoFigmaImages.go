@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/appengine/datastore"
+)
+
+const figmaRenderedImageType = "FigmaRenderedImage"
+
+// FigmaRenderedImage remembers the stored image a node was rendered to, and the version of the file it was rendered from.
+// Its key’s name is made from the file key, node ID, format, and scale.
+type FigmaRenderedImage struct {
+	FileVersion string    `datastore:",noindex"`
+	MediaType   string    `datastore:",noindex"`
+	SHA256      string    `datastore:",noindex"`
+	RenderedAt  time.Time `datastore:",noindex"`
+}
+
+func figmaRenderedImageKey(ctx context.Context, fileKey string, nodeID string, format string, scale float64) *datastore.Key {
+	name := strings.Join([]string{fileKey, nodeID, format, strconv.FormatFloat(scale, 'f', -1, 64)}, "/")
+	return datastore.NewKey(ctx, figmaRenderedImageType, name, 0, nil)
+}
+
+// getFigmaRenderedImage loads the node’s last rendered image, returning nil if it has not been rendered
+func getFigmaRenderedImage(ctx context.Context, key *datastore.Key) (*FigmaRenderedImage, error) {
+	var image FigmaRenderedImage
+	err := datastore.Get(ctx, key, &image)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &image, nil
+}
+
+// putFigmaRenderedImage remembers the node’s newly rendered image
+func putFigmaRenderedImage(ctx context.Context, key *datastore.Key, image *FigmaRenderedImage) error {
+	_, err := datastore.Put(ctx, key, image)
+	return err
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"google.golang.org/appengine/datastore"
+)
+
+const (
+	gitHubWebhookIntegrationType = "GitHubWebhookIntegration"
+	gitHubWebhookDeliveryType    = "GitHubWebhookDelivery"
+
+	gitHubWebhookSecretBytes = 32
+)
+
+// GitHubWebhookIntegration posts events delivered by a GitHub webhook into a channel
+type GitHubWebhookIntegration struct {
+	Key         *datastore.Key `datastore:"-" json:"id"`
+	OrgSlug     string         `json:"orgSlug"`
+	ChannelSlug string         `json:"channelSlug"`
+	Events      []string       `json:"events"`
+	Secret      string         `datastore:",noindex" json:"-"`
+	CreatedAt   time.Time      `json:"createdAt"`
+}
+
+// GitHubWebhookDelivery records that a delivery was received, so GitHub sending it again does not post twice.
+// Its key’s name is the X-GitHub-Delivery ID, within the integration.
+type GitHubWebhookDelivery struct {
+	ReceivedAt time.Time
+}
+
+var errGitHubWebhookDeliveryReceived = errors.New("Delivery was already received")
+
+// CreateGitHubWebhookIntegrationInput is used to create GitHub webhook integrations
+type CreateGitHubWebhookIntegrationInput struct {
+	ChannelSlug string
+	Events      []string
+	Secret      string
+}
+
+func newGitHubWebhookSecret() (string, error) {
+	secretBytes := make([]byte, gitHubWebhookSecretBytes)
+	_, err := rand.Read(secretBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(secretBytes), nil
+}
+
+// CreateGitHubWebhookIntegration creates an integration for a channel, generating a secret if none is provided
+func (repo ChannelsRepo) CreateGitHubWebhookIntegration(input CreateGitHubWebhookIntegrationInput) (*GitHubWebhookIntegration, error) {
+	if repo.channelContentKeyFor(input.ChannelSlug) == nil {
+		return nil, fmt.Errorf("No channel with slug: %s", input.ChannelSlug)
+	}
+
+	events := make([]string, 0, len(input.Events))
+	for _, event := range input.Events {
+		event = strings.ToLower(strings.TrimSpace(event))
+		if event == "" {
+			continue
+		}
+		if !isSupportedGitHubWebhookEventFilter(event) {
+			return nil, fmt.Errorf("Unsupported GitHub event %q, must be one of %s", event, strings.Join(supportedGitHubWebhookEvents, ", "))
+		}
+		events = append(events, event)
+	}
+
+	secret := strings.TrimSpace(input.Secret)
+	if secret == "" {
+		var err error
+		secret, err = newGitHubWebhookSecret()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	integration := GitHubWebhookIntegration{
+		OrgSlug:     repo.orgRepo.orgSlug,
+		ChannelSlug: input.ChannelSlug,
+		Events:      events,
+		Secret:      secret,
+		CreatedAt:   time.Now().UTC(),
+	}
+
+	key := datastore.NewIncompleteKey(repo.ctx, gitHubWebhookIntegrationType, repo.orgRepo.RootKey())
+	key, err := datastore.Put(repo.ctx, key, &integration)
+	if err != nil {
+		return nil, err
+	}
+
+	integration.Key = key
+	return &integration, nil
+}
+
+// ListGitHubWebhookIntegrations lists the integrations of the org
+func (repo ChannelsRepo) ListGitHubWebhookIntegrations() ([]GitHubWebhookIntegration, error) {
+	q := datastore.NewQuery(gitHubWebhookIntegrationType).Ancestor(repo.orgRepo.RootKey())
+
+	integrations := []GitHubWebhookIntegration{}
+	for i := q.Run(repo.ctx); ; {
+		var integration GitHubWebhookIntegration
+		key, err := i.Next(&integration)
+		if err == datastore.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		integration.Key = key
+		integrations = append(integrations, integration)
+	}
+
+	return integrations, nil
+}
+
+// DeleteGitHubWebhookIntegration stops an integration from posting into its channel
+func (repo ChannelsRepo) DeleteGitHubWebhookIntegration(integrationID string) error {
+	key, err := datastore.DecodeKey(integrationID)
+	if err != nil || key.Kind() != gitHubWebhookIntegrationType || !key.Parent().Equal(repo.orgRepo.RootKey()) {
+		return fmt.Errorf("Invalid integration id: %s", integrationID)
+	}
+
+	err = datastore.Delete(repo.ctx, key)
+	if err == datastore.ErrNoSuchEntity {
+		return nil
+	}
+	return err
+}
+
+// GetGitHubWebhookIntegration loads an integration from the ID in its delivery URL
+func GetGitHubWebhookIntegration(ctx context.Context, integrationID string) (*GitHubWebhookIntegration, error) {
+	key, err := datastore.DecodeKey(integrationID)
+	if err != nil || key.Kind() != gitHubWebhookIntegrationType {
+		return nil, fmt.Errorf("Invalid integration id: %s", integrationID)
+	}
+
+	var integration GitHubWebhookIntegration
+	err = datastore.Get(ctx, key, &integration)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, errors.New("No integration with id: " + integrationID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	integration.Key = key
+	return &integration, nil
+}
+
+func gitHubWebhookDeliveryKey(ctx context.Context, integration *GitHubWebhookIntegration, deliveryID string) *datastore.Key {
+	return datastore.NewKey(ctx, gitHubWebhookDeliveryType, deliveryID, 0, integration.Key)
+}
+
+// RecordGitHubWebhookDelivery records the delivery as received, returning errGitHubWebhookDeliveryReceived if it already was
+func RecordGitHubWebhookDelivery(ctx context.Context, integration *GitHubWebhookIntegration, deliveryID string) error {
+	key := gitHubWebhookDeliveryKey(ctx, integration, deliveryID)
+
+	return datastore.RunInTransaction(ctx, func(ctx context.Context) error {
+		var existing GitHubWebhookDelivery
+		err := datastore.Get(ctx, key, &existing)
+		if err == nil {
+			return errGitHubWebhookDeliveryReceived
+		}
+		if err != datastore.ErrNoSuchEntity {
+			return err
+		}
+
+		_, err = datastore.Put(ctx, key, &GitHubWebhookDelivery{ReceivedAt: time.Now().UTC()})
+		return err
+	}, nil)
+}
+
+// ForgetGitHubWebhookDelivery removes the record of a delivery that could not be handled, so GitHub can deliver it again
+func ForgetGitHubWebhookDelivery(ctx context.Context, integration *GitHubWebhookIntegration, deliveryID string) error {
+	err := datastore.Delete(ctx, gitHubWebhookDeliveryKey(ctx, integration, deliveryID))
+	if err == datastore.ErrNoSuchEntity {
+		return nil
+	}
+	return err
+}
@@ -4,18 +4,22 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"io"
 	"io/ioutil"
-	"log"
+	"strconv"
+	"strings"
 	"time"
 
-	"cloud.google.com/go/storage"
 	"google.golang.org/appengine/datastore"
-	"google.golang.org/appengine/file"
+	"google.golang.org/appengine/log"
 )
 
 const (
 	postType = "Post"
+
+	postContentStorageKeyPrefix = "posts/"
+
+	// postClaimDuration is how long a claim to create something from a post lasts, should its request never finish
+	postClaimDuration = time.Minute
 )
 
 // MarkdownDocument is a text/markdown document
@@ -31,16 +35,77 @@ func NewMarkdownDocument(source string) MarkdownDocument {
 	return markdownDocument
 }
 
+// markdownHeadingText returns the text of an ATX heading such as `## Title`, or false if the line is not a heading
+func markdownHeadingText(line string) (string, bool) {
+	trimmed := strings.TrimSpace(line)
+	level := 0
+	for level < len(trimmed) && trimmed[level] == '#' {
+		level++
+	}
+	if level == 0 || level > 6 {
+		return "", false
+	}
+
+	rest := trimmed[level:]
+	if rest != "" && rest[0] != ' ' && rest[0] != '\t' {
+		return "", false
+	}
+
+	return strings.TrimSpace(strings.TrimRight(strings.TrimSpace(rest), "#")), true
+}
+
+// TitleAndBody uses the first heading as the title and the rest as the body, falling back to the first line of text as the title
+func (markdownDocument MarkdownDocument) TitleAndBody() (string, string) {
+	source := markdownDocument.Source
+	lines := strings.Split(strings.Replace(source, "\r\n", "\n", -1), "\n")
+
+	titleIndex := -1
+	title := ""
+	inFence := false
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") {
+			inFence = !inFence
+			continue
+		}
+		if inFence || trimmed == "" {
+			continue
+		}
+
+		if headingText, ok := markdownHeadingText(line); ok && headingText != "" {
+			titleIndex, title = i, headingText
+			break
+		}
+		if titleIndex == -1 {
+			titleIndex, title = i, trimmed
+		}
+	}
+
+	if titleIndex == -1 {
+		return "", strings.TrimSpace(source)
+	}
+
+	bodyLines := append(append([]string{}, lines[:titleIndex]...), lines[titleIndex+1:]...)
+	return title, strings.TrimSpace(strings.Join(bodyLines, "\n"))
+}
+
 // Post has a markdown document
 type Post struct {
 	CreatedAt     time.Time      `json:"createdAt"`
 	Key           *datastore.Key `datastore:",omitempty" json:"id"`
 	ParentPostKey *datastore.Key `json:"parentPostID"`
 	//AuthorID string            `json:"authorID"`
-	Content           MarkdownDocument `json:"content"`
-	ContentStorageKey string           `json:"-"`
-	Replies           *[]Post          `datastore:"-" json:"replies,omitempty"`
-	CommandType       string           `json:"commandType"`
+	Content               MarkdownDocument `json:"content"`
+	ContentStorageKey     string           `json:"-"`
+	StorageKeys           []string         `json:"-"`
+	Replies               *[]Post          `datastore:"-" json:"replies,omitempty"`
+	CommandType           string           `json:"commandType"`
+	GitHubIssueRepo       string           `json:"githubIssueRepo,omitempty"`
+	GitHubIssueNumber     int              `json:"githubIssueNumber,omitempty"`
+	GitHubIssueClaimedAt  time.Time        `datastore:",noindex" json:"-"`
+	TrelloCardID          string           `json:"trelloCardID,omitempty"`
+	TrelloCardClaimedAt   time.Time        `datastore:",noindex" json:"-"`
+	MirrorRepliesToTrello bool             `json:"mirrorRepliesToTrello,omitempty"`
 }
 
 // CreatePostInput is used to create new posts
@@ -51,25 +116,7 @@ type CreatePostInput struct {
 	CommandType          string
 }
 
-func objectForPostContentStorage(ctx context.Context, contentStorageKey string) (*storage.ObjectHandle, error) {
-	bucketName, err := file.DefaultBucketName(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("No default bucket found")
-	}
-	log.Printf("BUCKET NAME %s\n", bucketName)
-
-	storageClient, err := storage.NewClient(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("Cannot make client to post content storage")
-	}
-
-	bucket := storageClient.Bucket(bucketName)
-	object := bucket.Object(contentStorageKey)
-
-	return object, nil
-}
-
-// CreatePost creates a new post
+// CreatePost creates a new post. Handlers use CreatePostMirroringReply, so replies are also mirrored to Trello.
 func (repo ChannelsRepo) CreatePost(input CreatePostInput) (*Post, error) {
 	if input.MarkdownSource == "" {
 		return nil, fmt.Errorf("Post content cannot be empty")
@@ -106,19 +153,15 @@ func (repo ChannelsRepo) CreatePost(input CreatePostInput) (*Post, error) {
 			return nil, fmt.Errorf("Cannot allocate ID for post content")
 		}
 
-		contentStorageKey := channelContentKey.Encode() + string(i)
-		object, err := objectForPostContentStorage(repo.ctx, contentStorageKey)
+		// Under posts/ so garbage collection knows what it is
+		contentStorageKey := postContentStorageKeyPrefix + channelContentKey.Encode() + "/" + strconv.FormatInt(i, 10)
+		store, err := SharedBlobStore(repo.ctx)
 		if err != nil {
 			return nil, err
 		}
 
-		writer := object.NewWriter(repo.ctx)
-		writer.ContentType = "text/markdown"
-		_, writeErr := io.WriteString(writer, post.Content.Source)
-		if writeErr != nil {
-			return nil, writeErr
-		}
-		if err := writer.Close(); err != nil {
+		err = writeBlob(repo.ctx, store, contentStorageKey, "text/markdown", strings.NewReader(post.Content.Source))
+		if err != nil {
 			return nil, err
 		}
 
@@ -126,6 +169,11 @@ func (repo ChannelsRepo) CreatePost(input CreatePostInput) (*Post, error) {
 		post.ContentStorageKey = contentStorageKey
 	}
 
+	post.StorageKeys = storageKeysLinkedFrom(markdownDocument.Source)
+	if post.ContentStorageKey != "" {
+		post.StorageKeys = append(post.StorageKeys, post.ContentStorageKey)
+	}
+
 	postKey, err = datastore.Put(repo.ctx, postKey, &post)
 	if err != nil {
 		return nil, err
@@ -133,20 +181,52 @@ func (repo ChannelsRepo) CreatePost(input CreatePostInput) (*Post, error) {
 
 	post.Key = postKey
 
+	// Content marked as unreferenced before this post linked to it must not be collected
+	storageRepo := NewStorageRepo(repo.ctx)
+	err = storageRepo.unmarkUnreferencedBlobs(post.StorageKeys)
+	if err != nil {
+		log.Warningf(repo.ctx, "Could not unmark blobs linked from post %v: %v", postKey, err)
+	}
+
 	return &post, nil
 }
 
+// postStorageKeys returns the keys of the blobs a post refers to, including its offloaded content.
+// Posts made before these were recorded have their content read to find them.
+func postStorageKeys(ctx context.Context, post *Post) []string {
+	if post.StorageKeys != nil {
+		return post.StorageKeys
+	}
+
+	keys := []string{}
+	if post.ContentStorageKey != "" {
+		keys = append(keys, post.ContentStorageKey)
+		readPostContentFromStorageIfNeeded(ctx, post)
+	}
+
+	return append(keys, storageKeysLinkedFrom(post.Content.Source)...)
+}
+
+// postKeysLinkingToStorage returns up to limit posts, in any org, whose StorageKeys include the key
+func postKeysLinkingToStorage(ctx context.Context, storageKey string, limit int) ([]*datastore.Key, error) {
+	return datastore.NewQuery(postType).
+		Filter("StorageKeys =", storageKey).
+		KeysOnly().
+		Limit(limit).
+		GetAll(ctx, nil)
+}
+
 func readPostContentFromStorageIfNeeded(ctx context.Context, post *Post) {
 	if post.ContentStorageKey == "" {
 		return
 	}
 
-	object, err := objectForPostContentStorage(ctx, post.ContentStorageKey)
+	store, err := SharedBlobStore(ctx)
 	if err != nil {
 		return
 	}
 
-	r, err := object.NewReader(ctx)
+	r, err := store.Read(ctx, post.ContentStorageKey)
 	if err != nil {
 		return
 	}
@@ -160,9 +240,27 @@ func readPostContentFromStorageIfNeeded(ctx context.Context, post *Post) {
 	post.Content.Source = string(bytes)
 }
 
+// PostKeyInChannel decodes the post’s ID, checking that the post belongs to the channel
+func (repo ChannelsRepo) PostKeyInChannel(channelSlug string, postID string) (*datastore.Key, error) {
+	postKey, err := datastore.DecodeKey(postID)
+	if err != nil || postKey.Kind() != postType {
+		return nil, errors.New("Invalid post id: " + postID)
+	}
+
+	channelContentKey := repo.channelContentKeyFor(channelSlug)
+	if channelContentKey == nil || !postKey.Parent().Equal(channelContentKey) {
+		return nil, errors.New("No post with id: " + postID)
+	}
+
+	return postKey, nil
+}
+
 // GetPostWithIDInChannel lists all post in a channel of a certain slug
 func (repo ChannelsRepo) GetPostWithIDInChannel(channelSlug string, postID string) (*Post, error) {
-	postKey, err := datastore.DecodeKey(postID)
+	postKey, err := repo.PostKeyInChannel(channelSlug, postID)
+	if err != nil {
+		return nil, err
+	}
 
 	var post Post
 	err = datastore.Get(repo.ctx, postKey, &post)
@@ -180,6 +278,124 @@ func (repo ChannelsRepo) GetPostWithIDInChannel(channelSlug string, postID strin
 	return &post, nil
 }
 
+func (repo ChannelsRepo) updatePost(postKey *datastore.Key, update func(post *Post) error) (*Post, error) {
+	var post Post
+	err := datastore.RunInTransaction(repo.ctx, func(ctx context.Context) error {
+		err := datastore.Get(ctx, postKey, &post)
+		if err != nil {
+			return err
+		}
+
+		err = update(&post)
+		if err != nil {
+			return err
+		}
+
+		_, err = datastore.Put(ctx, postKey, &post)
+		return err
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	post.Key = postKey
+	readPostContentFromStorageIfNeeded(repo.ctx, &post)
+
+	return &post, nil
+}
+
+// ClaimPostForGitHubIssue marks that an issue is being created from the post, so one made at the same time is refused
+func (repo ChannelsRepo) ClaimPostForGitHubIssue(postKey *datastore.Key) (*Post, error) {
+	return repo.updatePost(postKey, func(post *Post) error {
+		if post.GitHubIssueNumber != 0 {
+			return fmt.Errorf("Post already has GitHub issue %s#%d", post.GitHubIssueRepo, post.GitHubIssueNumber)
+		}
+
+		now := time.Now().UTC()
+		if now.Sub(post.GitHubIssueClaimedAt) < postClaimDuration {
+			return errors.New("A GitHub issue is already being created from this post")
+		}
+
+		post.GitHubIssueClaimedAt = now
+		return nil
+	})
+}
+
+// ReleasePostForGitHubIssue lets an issue be created from the post again, after creating one failed
+func (repo ChannelsRepo) ReleasePostForGitHubIssue(postKey *datastore.Key) error {
+	_, err := repo.updatePost(postKey, func(post *Post) error {
+		post.GitHubIssueClaimedAt = time.Time{}
+		return nil
+	})
+	return err
+}
+
+// SetGitHubIssueForPost records the GitHub issue that was created from a post
+func (repo ChannelsRepo) SetGitHubIssueForPost(postKey *datastore.Key, gitHubRepo string, issueNumber int) (*Post, error) {
+	return repo.updatePost(postKey, func(post *Post) error {
+		if post.GitHubIssueNumber != 0 {
+			return fmt.Errorf("Post already has GitHub issue %s#%d", post.GitHubIssueRepo, post.GitHubIssueNumber)
+		}
+
+		post.GitHubIssueRepo = gitHubRepo
+		post.GitHubIssueNumber = issueNumber
+		post.GitHubIssueClaimedAt = time.Time{}
+		return nil
+	})
+}
+
+// ClaimPostForTrelloCard marks that a card is being created from the post, so one made at the same time is refused
+func (repo ChannelsRepo) ClaimPostForTrelloCard(postKey *datastore.Key) (*Post, error) {
+	return repo.updatePost(postKey, func(post *Post) error {
+		if post.TrelloCardID != "" {
+			return fmt.Errorf("Post already has Trello card %s", post.TrelloCardID)
+		}
+
+		now := time.Now().UTC()
+		if now.Sub(post.TrelloCardClaimedAt) < postClaimDuration {
+			return errors.New("A Trello card is already being created from this post")
+		}
+
+		post.TrelloCardClaimedAt = now
+		return nil
+	})
+}
+
+// ReleasePostForTrelloCard lets a card be created from the post again, after creating one failed
+func (repo ChannelsRepo) ReleasePostForTrelloCard(postKey *datastore.Key) error {
+	_, err := repo.updatePost(postKey, func(post *Post) error {
+		post.TrelloCardClaimedAt = time.Time{}
+		return nil
+	})
+	return err
+}
+
+// SetTrelloCardForPost records the Trello card that was created from a post
+func (repo ChannelsRepo) SetTrelloCardForPost(postKey *datastore.Key, cardID string, mirrorReplies bool) (*Post, error) {
+	return repo.updatePost(postKey, func(post *Post) error {
+		if post.TrelloCardID != "" {
+			return fmt.Errorf("Post already has Trello card %s", post.TrelloCardID)
+		}
+
+		post.TrelloCardID = cardID
+		post.MirrorRepliesToTrello = mirrorReplies
+		post.TrelloCardClaimedAt = time.Time{}
+		return nil
+	})
+}
+
+// SetMirrorRepliesToTrelloForPost changes whether replies to a post are added as comments on its Trello card
+func (repo ChannelsRepo) SetMirrorRepliesToTrelloForPost(postKey *datastore.Key, mirrorReplies bool) (*Post, error) {
+	return repo.updatePost(postKey, func(post *Post) error {
+		if post.TrelloCardID == "" {
+			return errors.New("Post has no Trello card")
+		}
+
+		post.MirrorRepliesToTrello = mirrorReplies
+		return nil
+	})
+}
+
 // NewPostsConnection makes a new connection with the posts in a specific channel
 func (repo ChannelsRepo) NewPostsConnection(options PostsConnectionOptions) *PostsConnection {
 	connection := PostsConnection{repo: repo, options: options}
@@ -97,7 +97,7 @@ func (c *PostsConnection) WriteToCSV(w *csv.Writer) error {
 		if post.ParentPostKey != nil {
 			parentPostID = post.ParentPostKey.Encode()
 		}
-		w.Write([]string{post.Key.Encode(), post.CreatedAt.String(), parentPostID, post.CommandType, post.Content.Source})
+		w.Write([]string{post.Key.Encode(), post.CreatedAt.String(), parentPostID, post.CommandType, signStorageURLsIn(c.repo.ctx, post.Content.Source)})
 	})
 }
 
@@ -120,7 +120,7 @@ func (c *PostsConnection) MakeFeed(urlMaker FeedURLMaker) (*feeds.Feed, error) {
 			Link:  &feeds.Link{Href: urlMaker.itemURL(postID)},
 			// Author:  &feeds.Author{Name: "Jason Moiron", Email: "jmoiron@jmoiron.net"},
 			Id:      postID,
-			Content: post.Content.Source,
+			Content: signStorageURLsIn(c.repo.ctx, post.Content.Source),
 			Created: post.CreatedAt,
 		}
 		feedItems = append(feedItems, feedItem)
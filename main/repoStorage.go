@@ -2,13 +2,15 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"regexp"
+	"strings"
 
-	"cloud.google.com/go/storage"
-	"golang.org/x/oauth2/google"
-	"google.golang.org/api/option"
-	// "google.golang.org/appengine"
+	"google.golang.org/appengine/log"
 )
 
 // StorageRepo lets you upload content
@@ -23,50 +25,260 @@ func NewStorageRepo(ctx context.Context) StorageRepo {
 	}
 }
 
-func objectForStorageContent(ctx context.Context, key string) (*storage.ObjectHandle, error) {
-	bucketName := "staging-syrup-storage"
+// storableMediaTypes are the kinds of content that can be uploaded to storage
+var storableMediaTypes = map[string]bool{
+	"text/markdown":    true,
+	"text/plain":       true,
+	"text/csv":         true,
+	"application/json": true,
+	"application/pdf":  true,
+	"image/png":        true,
+	"image/jpeg":       true,
+	"image/gif":        true,
+	"image/webp":       true,
+	"image/svg+xml":    true,
+	"audio/mpeg":       true,
+	"audio/mp4":        true,
+	"audio/ogg":        true,
+	"audio/wav":        true,
+	"audio/webm":       true,
+	"audio/flac":       true,
+}
+
+// UnsupportedMediaTypeError is returned for content that storage does not accept
+type UnsupportedMediaTypeError struct {
+	MediaType string
+}
 
-	// if appengine.IsDevAppServer()
-	creds, err := google.FindDefaultCredentials(ctx, storage.ScopeReadWrite)
+func (e *UnsupportedMediaTypeError) Error() string {
+	return fmt.Sprintf("Media type %q cannot be stored", e.MediaType)
+}
+
+func checkStorableMediaType(mediaType string) error {
+	if !storableMediaTypes[mediaType] {
+		return &UnsupportedMediaTypeError{MediaType: mediaType}
+	}
+	return nil
+}
+
+// StoredContent is content kept in storage under the SHA-256 of its bytes
+type StoredContent struct {
+	MediaType string `json:"mediaType"`
+	SHA256    string `json:"sha256"`
+	Size      int64  `json:"size"`
+	URL       string `json:"url"`
+}
+
+func newStoredContent(mediaType string, sha256Hex string, size int64) *StoredContent {
+	return &StoredContent{
+		MediaType: mediaType,
+		SHA256:    sha256Hex,
+		Size:      size,
+		URL:       storageContentURL(mediaType, sha256Hex),
+	}
+}
 
-	storageClient, err := storage.NewClient(ctx, option.WithCredentials(creds))
+// storageContentURL is the content address that API routes serve the content from
+func storageContentURL(mediaType string, sha256Hex string) string {
+	return "/1/storage/" + mediaType + "/sha256/" + sha256Hex
+}
+
+var sha256HexPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// storageContentURLPattern finds content addresses, whether signed or not, within text such as Markdown
+var storageContentURLPattern = regexp.MustCompile(`/1/storage/([a-z]+/[a-z0-9.+-]+)/sha256/([0-9a-f]{64})`)
+
+// storageKeysLinkedFrom returns the keys of the stored content that the text links to
+func storageKeysLinkedFrom(text string) []string {
+	var keys []string
+	seen := make(map[string]bool)
+	for _, match := range storageContentURLPattern.FindAllStringSubmatch(text, -1) {
+		mediaType, sha256Hex := match[1], match[2]
+		if checkStorableMediaType(mediaType) != nil {
+			continue
+		}
+
+		key := storageKeyForContent(mediaType, sha256Hex)
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// errInvalidSHA256 is returned for hashes that are not 64 hex characters
+var errInvalidSHA256 = errors.New("SHA-256 must be 64 hex characters")
+
+// SHA256MismatchError is returned when uploaded content does not have the SHA-256 it was uploaded as
+type SHA256MismatchError struct {
+	Expected string
+	Actual   string
+}
+
+func (e *SHA256MismatchError) Error() string {
+	return fmt.Sprintf("Content has SHA-256 %s, not %s", e.Actual, e.Expected)
+}
+
+func storageKeyForContent(mediaType string, sha256 string) string {
+	return `mediaType/` + mediaType + `/sha256/` + sha256
+}
+
+// storedContentAt reads what is stored at the key, or returns nil if nothing is
+func storedContentAt(ctx context.Context, store BlobStore, key string, sha256Hex string) (*StoredContent, error) {
+	attrs, err := store.Stat(ctx, key)
+	if err == errBlobNotFound {
+		return nil, nil
+	}
 	if err != nil {
-		return nil, fmt.Errorf("Cannot make storage client")
+		return nil, err
 	}
 
-	bucket := storageClient.Bucket(bucketName)
-	object := bucket.Object(key)
+	return newStoredContent(attrs.ContentType, sha256Hex, attrs.Size), nil
+}
+
+// unmarkUploadedContent stops garbage collection sweeping content that was uploaded again, as it is about to be used
+func (repo *StorageRepo) unmarkUploadedContent(key string, existing *StoredContent) {
+	if existing == nil {
+		return
+	}
 
-	return object, nil
+	err := repo.unmarkUnreferencedBlobs([]string{key})
+	if err != nil {
+		log.Warningf(repo.ctx, "Could not unmark uploaded blob %s: %v", key, err)
+	}
 }
 
-func (repo *StorageRepo) addContentWithMediaTypeAndSHA256(mediaType string, sha256 string, r io.ReadCloser) error {
-	key := `mediaType/` + mediaType + `/sha256/` + sha256
-	object, err := objectForStorageContent(repo.ctx, key)
-	if object == nil {
-		return err
+// addContentWithMediaTypeAndSHA256 stores the content if it has the SHA-256, hashing it as it is uploaded
+func (repo *StorageRepo) addContentWithMediaTypeAndSHA256(mediaType string, sha256Hex string, r io.Reader) (*StoredContent, error) {
+	err := checkStorableMediaType(mediaType)
+	if err != nil {
+		return nil, err
+	}
+
+	sha256Hex = strings.ToLower(sha256Hex)
+	if !sha256HexPattern.MatchString(sha256Hex) {
+		return nil, errInvalidSHA256
+	}
+
+	store, err := SharedBlobStore(repo.ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	writer := object.NewWriter(repo.ctx)
-	writer.ContentType = mediaType
+	key := storageKeyForContent(mediaType, sha256Hex)
 
-	_, err = io.Copy(writer, r)
+	// Only content with this SHA-256 is ever stored here, so uploading it again changes nothing
+	existing, err := storedContentAt(repo.ctx, store, key, sha256Hex)
+	if existing != nil || err != nil {
+		repo.unmarkUploadedContent(key, existing)
+		return existing, err
+	}
+
+	writer, err := store.NewWriter(repo.ctx, key, mediaType)
 	if err != nil {
-		return err
+		return nil, err
 	}
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(writer, hash), r)
+	if err != nil {
+		writer.Abort()
+		return nil, err
+	}
+
+	// Aborting leaves no partial or mismatched blob
+	actualSHA256Hex := hex.EncodeToString(hash.Sum(nil))
+	if actualSHA256Hex != sha256Hex {
+		writer.Abort()
+		return nil, &SHA256MismatchError{Expected: sha256Hex, Actual: actualSHA256Hex}
+	}
+
 	if err := writer.Close(); err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return newStoredContent(mediaType, sha256Hex, size), nil
+}
+
+// addContentWithMediaType stores the content under the SHA-256 it is found to have.
+// As the key is only known once it is all read, it is uploaded to a temporary blob first and then copied.
+func (repo *StorageRepo) addContentWithMediaType(mediaType string, r io.Reader) (*StoredContent, error) {
+	err := checkStorableMediaType(mediaType)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := SharedBlobStore(repo.ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	uploadID, err := randomURLSafeString(16)
+	if err != nil {
+		return nil, err
+	}
+	uploadKey := `uploads/` + uploadID
+
+	writer, err := store.NewWriter(repo.ctx, uploadKey, mediaType)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(writer, hash), r)
+	if err != nil {
+		writer.Abort()
+		return nil, err
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := store.Delete(repo.ctx, uploadKey); err != nil {
+			log.Warningf(repo.ctx, "Could not delete uploaded blob %s: %v", uploadKey, err)
+		}
+	}()
+
+	sha256Hex := hex.EncodeToString(hash.Sum(nil))
+	key := storageKeyForContent(mediaType, sha256Hex)
+
+	existing, err := storedContentAt(repo.ctx, store, key, sha256Hex)
+	if existing != nil || err != nil {
+		repo.unmarkUploadedContent(key, existing)
+		return existing, err
+	}
+
+	err = store.Copy(repo.ctx, uploadKey, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return newStoredContent(mediaType, sha256Hex, size), nil
+}
+
+// statContentWithMediaTypeAndSHA256 returns the stored content without reading it, or errBlobNotFound
+func (repo *StorageRepo) statContentWithMediaTypeAndSHA256(mediaType string, sha256 string) (*StoredContent, error) {
+	store, err := SharedBlobStore(repo.ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stored, err := storedContentAt(repo.ctx, store, storageKeyForContent(mediaType, sha256), sha256)
+	if stored == nil && err == nil {
+		return nil, errBlobNotFound
+	}
+
+	return stored, err
 }
 
-func (repo *StorageRepo) readContentWithMediaTypeAndSHA256(mediaType string, sha256 string) (io.ReadCloser, error) {
-	key := `mediaType/` + mediaType + `/sha256/` + sha256
-	object, err := objectForStorageContent(repo.ctx, key)
-	if object == nil {
+// readContentWithMediaTypeAndSHA256 reads length bytes from offset, or to the end if length is negative
+func (repo *StorageRepo) readContentWithMediaTypeAndSHA256(mediaType string, sha256 string, offset int64, length int64) (io.ReadCloser, error) {
+	store, err := SharedBlobStore(repo.ctx)
+	if err != nil {
 		return nil, err
 	}
 
-	return object.NewReader(repo.ctx)
+	return store.ReadRange(repo.ctx, storageKeyForContent(mediaType, sha256), offset, length)
 }
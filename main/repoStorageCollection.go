@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+const (
+	unreferencedBlobType  = "UnreferencedBlob"
+	blobCollectionRunType = "BlobCollectionRun"
+
+	// datastoreMaxMultiKeys is the most keys that can be put or deleted at once
+	datastoreMaxMultiKeys = 500
+)
+
+// UnreferencedBlob records when garbage collection first found a blob that nothing refers to.
+// Its key’s name is the blob’s key.
+type UnreferencedBlob struct {
+	MarkedAt time.Time `json:"markedAt"`
+	Size     int64     `datastore:",noindex" json:"size"`
+}
+
+// BlobCollectionRun is what a run of garbage collection found and reclaimed
+type BlobCollectionRun struct {
+	StartedAt       time.Time `json:"startedAt"`
+	FinishedAt      time.Time `json:"finishedAt"`
+	DryRun          bool      `datastore:",noindex" json:"dryRun"`
+	ScannedCount    int       `datastore:",noindex" json:"scannedCount"`
+	ScannedBytes    int64     `datastore:",noindex" json:"scannedBytes"`
+	ReferencedCount int       `datastore:",noindex" json:"referencedCount"`
+	MarkedCount     int       `datastore:",noindex" json:"markedCount"`
+	SweptCount      int       `datastore:",noindex" json:"sweptCount"`
+	ReclaimedBytes  int64     `datastore:",noindex" json:"reclaimedBytes"`
+	ErrorCount      int       `datastore:",noindex" json:"errorCount"`
+	// Phase is what an unfinished run does next: scan blobs, then posts, then sweep
+	Phase string `datastore:",noindex" json:"phase,omitempty"`
+	// PrefixIndex and PageToken are where the scan of blobs continues from
+	PrefixIndex int    `datastore:",noindex" json:"-"`
+	PageToken   string `datastore:",noindex" json:"-"`
+	// Cursor is where the scan of posts, or the sweep of marks, continues from
+	Cursor string `datastore:",noindex" json:"-"`
+	// ClaimedUntil stops two requests continuing the run at once
+	ClaimedUntil time.Time `datastore:",noindex" json:"-"`
+	// Swept lists what was deleted by this request, or with a dry run what would be
+	Swept []SweptBlob `datastore:"-" json:"swept"`
+
+	key *datastore.Key
+}
+
+var (
+	errBlobCollectionNotDue    = errors.New("Blob collection has already run today")
+	errBlobCollectionClaimed   = errors.New("Blob collection is already being run by another request")
+	errUnreferencedBlobChanged = errors.New("Blob was unmarked or marked again")
+)
+
+// SweptBlob is a blob deleted by garbage collection
+type SweptBlob struct {
+	Key      string    `json:"key"`
+	Size     int64     `json:"size"`
+	MarkedAt time.Time `json:"markedAt"`
+}
+
+func (repo *StorageRepo) unreferencedBlobKey(blobKey string) *datastore.Key {
+	return datastore.NewKey(repo.ctx, unreferencedBlobType, blobKey, 0, nil)
+}
+
+// getUnreferencedBlobs loads the marks of those blobs that have them, by the key of the blob
+func (repo *StorageRepo) getUnreferencedBlobs(blobKeys []string) (map[string]UnreferencedBlob, error) {
+	marksByBlobKey := make(map[string]UnreferencedBlob)
+
+	for start := 0; start < len(blobKeys); start += datastoreMaxMultiKeys {
+		end := start + datastoreMaxMultiKeys
+		if end > len(blobKeys) {
+			end = len(blobKeys)
+		}
+
+		keys := make([]*datastore.Key, 0, end-start)
+		for _, blobKey := range blobKeys[start:end] {
+			keys = append(keys, repo.unreferencedBlobKey(blobKey))
+		}
+
+		marks := make([]UnreferencedBlob, len(keys))
+		err := datastore.GetMulti(repo.ctx, keys, marks)
+		multiErr, isMultiErr := err.(appengine.MultiError)
+		if err != nil && !isMultiErr {
+			return nil, err
+		}
+
+		for i, key := range keys {
+			if isMultiErr && multiErr[i] != nil {
+				if multiErr[i] == datastore.ErrNoSuchEntity {
+					continue
+				}
+				return nil, multiErr[i]
+			}
+			marksByBlobKey[key.StringID()] = marks[i]
+		}
+	}
+
+	return marksByBlobKey, nil
+}
+
+// listUnreferencedBlobsMarkedBefore loads a page of the marks made before the time, continuing from a cursor if it is not empty.
+// It returns the cursor of the next page, which is empty after the last.
+func (repo *StorageRepo) listUnreferencedBlobsMarkedBefore(markedBefore time.Time, cursor string, limit int) ([]string, []UnreferencedBlob, string, error) {
+	q := datastore.NewQuery(unreferencedBlobType).Filter("MarkedAt <", markedBefore).Order("MarkedAt").Limit(limit)
+	if cursor != "" {
+		decodedCursor, err := datastore.DecodeCursor(cursor)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		q = q.Start(decodedCursor)
+	}
+
+	var blobKeys []string
+	var marks []UnreferencedBlob
+	t := q.Run(repo.ctx)
+	for {
+		var mark UnreferencedBlob
+		key, err := t.Next(&mark)
+		if err == datastore.Done {
+			break
+		}
+		if err != nil {
+			return nil, nil, "", err
+		}
+
+		blobKeys = append(blobKeys, key.StringID())
+		marks = append(marks, mark)
+	}
+
+	if len(blobKeys) < limit {
+		return blobKeys, marks, "", nil
+	}
+
+	nextCursor, err := t.Cursor()
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	return blobKeys, marks, nextCursor.String(), nil
+}
+
+// claimUnreferencedBlobForSweep removes the blob’s mark if it is still the one that was loaded, so the blob can be deleted.
+// Uploading or linking to the blob since removes or replaces its mark, which fails this with errUnreferencedBlobChanged.
+func (repo *StorageRepo) claimUnreferencedBlobForSweep(blobKey string, loadedMark UnreferencedBlob) error {
+	key := repo.unreferencedBlobKey(blobKey)
+	return datastore.RunInTransaction(repo.ctx, func(ctx context.Context) error {
+		var mark UnreferencedBlob
+		err := datastore.Get(ctx, key, &mark)
+		if err == datastore.ErrNoSuchEntity {
+			return errUnreferencedBlobChanged
+		}
+		if err != nil {
+			return err
+		}
+
+		if !mark.MarkedAt.Equal(loadedMark.MarkedAt) {
+			return errUnreferencedBlobChanged
+		}
+
+		return datastore.Delete(ctx, key)
+	}, nil)
+}
+
+// markUnreferencedBlobs records the blobs as found to be unreferenced
+func (repo *StorageRepo) markUnreferencedBlobs(marksByBlobKey map[string]UnreferencedBlob) error {
+	keys := make([]*datastore.Key, 0, len(marksByBlobKey))
+	marks := make([]UnreferencedBlob, 0, len(marksByBlobKey))
+	for blobKey, mark := range marksByBlobKey {
+		keys = append(keys, repo.unreferencedBlobKey(blobKey))
+		marks = append(marks, mark)
+	}
+
+	for start := 0; start < len(keys); start += datastoreMaxMultiKeys {
+		end := start + datastoreMaxMultiKeys
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		_, err := datastore.PutMulti(repo.ctx, keys[start:end], marks[start:end])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// unmarkUnreferencedBlobs removes any marks of the blobs, as they are referenced again or have been deleted
+func (repo *StorageRepo) unmarkUnreferencedBlobs(blobKeys []string) error {
+	keys := make([]*datastore.Key, 0, len(blobKeys))
+	for _, blobKey := range blobKeys {
+		keys = append(keys, repo.unreferencedBlobKey(blobKey))
+	}
+
+	for start := 0; start < len(keys); start += datastoreMaxMultiKeys {
+		end := start + datastoreMaxMultiKeys
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		err := datastore.DeleteMulti(repo.ctx, keys[start:end])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// claimBlobCollectionRun claims the unfinished run to continue it, or starts a run if none has this interval.
+// Runs are named after the interval they start in, so requests at once do not start two.
+func (repo *StorageRepo) claimBlobCollectionRun(now time.Time, interval time.Duration, claimDuration time.Duration) (*BlobCollectionRun, error) {
+	var latestRuns []BlobCollectionRun
+	keys, err := datastore.NewQuery(blobCollectionRunType).Order("-StartedAt").Limit(1).GetAll(repo.ctx, &latestRuns)
+	if err != nil {
+		return nil, err
+	}
+
+	intervalStart := now.Truncate(interval)
+	key := datastore.NewKey(repo.ctx, blobCollectionRunType, intervalStart.Format(time.RFC3339), 0, nil)
+	if len(keys) > 0 {
+		latestRun := latestRuns[0]
+		if latestRun.FinishedAt.IsZero() && !latestRun.DryRun {
+			key = keys[0]
+		} else if !latestRun.StartedAt.Before(intervalStart) {
+			return nil, errBlobCollectionNotDue
+		}
+	}
+
+	var run BlobCollectionRun
+	err = datastore.RunInTransaction(repo.ctx, func(ctx context.Context) error {
+		err := datastore.Get(ctx, key, &run)
+		if err == datastore.ErrNoSuchEntity {
+			run = BlobCollectionRun{StartedAt: now, Phase: blobCollectionPhaseBlobs}
+		} else if err != nil {
+			return err
+		} else if !run.FinishedAt.IsZero() {
+			return errBlobCollectionNotDue
+		} else if run.ClaimedUntil.After(now) {
+			return errBlobCollectionClaimed
+		}
+
+		run.ClaimedUntil = now.Add(claimDuration)
+		_, err = datastore.Put(ctx, key, &run)
+		return err
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	run.key = key
+	run.Swept = []SweptBlob{}
+	return &run, nil
+}
+
+// saveBlobCollectionRun keeps the run’s progress and counts, releasing its claim so the next request can continue it
+func (repo *StorageRepo) saveBlobCollectionRun(run *BlobCollectionRun) error {
+	run.ClaimedUntil = time.Time{}
+	_, err := datastore.Put(repo.ctx, run.key, run)
+	return err
+}
+
+// listBlobCollectionRuns loads the most recent runs, newest first
+func (repo *StorageRepo) listBlobCollectionRuns(limit int) ([]BlobCollectionRun, error) {
+	runs := []BlobCollectionRun{}
+	_, err := datastore.NewQuery(blobCollectionRunType).Order("-StartedAt").Limit(limit).GetAll(repo.ctx, &runs)
+	if err != nil {
+		return nil, err
+	}
+
+	return runs, nil
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/icza/session"
+	"github.com/mrjones/oauth"
+	"golang.org/x/oauth2"
+	"google.golang.org/appengine/datastore"
+)
+
+const (
+	userAccountType         = "UserAccount"
+	userAccountIdentityType = "UserAccountIdentity"
+	connectedServiceType    = "ConnectedService"
+
+	userAccountKeySessionKey = "userAccountKey"
+)
+
+var errServiceNotConnected = errors.New("Service is not connected")
+
+// UserAccount is who is signed in, across every service they have connected
+type UserAccount struct {
+	Key       *datastore.Key `datastore:"-" json:"-"`
+	CreatedAt time.Time      `json:"createdAt"`
+}
+
+// UserAccountIdentity maps a service’s user, keyed by e.g. github:1234, to the account they connected it to
+type UserAccountIdentity struct {
+	UserAccountKey *datastore.Key
+	CreatedAt      time.Time
+}
+
+// ConnectedService is a service signed into by an account, with its encrypted token
+type ConnectedService struct {
+	Key            *datastore.Key `datastore:"-" json:"-"`
+	ProviderID     string         `json:"provider"`
+	Identity       string         `json:"-"`
+	AccountLogin   string         `json:"accountLogin"`
+	Scopes         []string       `datastore:",noindex" json:"scopes"`
+	Expiry         time.Time      `json:"expiry,omitempty"`
+	EncryptedToken []byte         `datastore:",noindex" json:"-"`
+	ConnectedAt    time.Time      `json:"connectedAt"`
+	UpdatedAt      time.Time      `json:"updatedAt"`
+}
+
+// ConnectedServiceToken is the token a provider granted, using the field for its version of OAuth
+type ConnectedServiceToken struct {
+	OAuth2 *oauth2.Token      `json:"oauth2,omitempty"`
+	OAuth1 *oauth.AccessToken `json:"oauth1,omitempty"`
+	Scopes []string           `json:"-"`
+}
+
+// expiry is when the token stops working, or zero if it does not expire
+func (token *ConnectedServiceToken) expiry() time.Time {
+	if token.OAuth2 != nil {
+		return token.OAuth2.Expiry
+	}
+	return time.Time{}
+}
+
+// UserAccountsRepo stores accounts and the services connected to them
+type UserAccountsRepo struct {
+	ctx context.Context
+}
+
+// NewUserAccountsRepo makes a new user accounts repository
+func NewUserAccountsRepo(ctx context.Context) UserAccountsRepo {
+	return UserAccountsRepo{ctx: ctx}
+}
+
+// UserAccountKeyFromSession returns the key of the account signed into the session, if there is one
+func UserAccountKeyFromSession(sess session.Session) *datastore.Key {
+	if sess == nil {
+		return nil
+	}
+
+	encoded, ok := sess.Attr(userAccountKeySessionKey).(string)
+	if !ok || encoded == "" {
+		return nil
+	}
+
+	key, err := datastore.DecodeKey(encoded)
+	if err != nil || key.Kind() != userAccountType {
+		return nil
+	}
+
+	return key
+}
+
+// FindOrCreateUserAccountForIdentity returns the account the service’s user previously connected to,
+// otherwise links them to the session’s account, creating one if the session has none
+func (repo UserAccountsRepo) FindOrCreateUserAccountForIdentity(sessionAccountKey *datastore.Key, identity string) (*datastore.Key, error) {
+	identityKey := datastore.NewKey(repo.ctx, userAccountIdentityType, identity, 0, nil)
+
+	var accountKey *datastore.Key
+	err := datastore.RunInTransaction(repo.ctx, func(ctx context.Context) error {
+		var existing UserAccountIdentity
+		err := datastore.Get(ctx, identityKey, &existing)
+		if err == nil {
+			accountKey = existing.UserAccountKey
+			return nil
+		}
+		if err != datastore.ErrNoSuchEntity {
+			return err
+		}
+
+		now := time.Now().UTC()
+
+		accountKey = sessionAccountKey
+		if accountKey == nil {
+			id, _, err := datastore.AllocateIDs(ctx, userAccountType, nil, 1)
+			if err != nil {
+				return err
+			}
+			accountKey = datastore.NewKey(ctx, userAccountType, "", id, nil)
+
+			_, err = datastore.Put(ctx, accountKey, &UserAccount{CreatedAt: now})
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = datastore.Put(ctx, identityKey, &UserAccountIdentity{
+			UserAccountKey: accountKey,
+			CreatedAt:      now,
+		})
+		return err
+	}, &datastore.TransactionOptions{XG: true})
+	if err != nil {
+		return nil, err
+	}
+
+	return accountKey, nil
+}
+
+func (repo UserAccountsRepo) connectedServiceKey(accountKey *datastore.Key, providerID string) *datastore.Key {
+	return datastore.NewKey(repo.ctx, connectedServiceType, providerID, 0, accountKey)
+}
+
+// SaveConnectedService encrypts and stores the token, replacing any previous connection to the provider
+func (repo UserAccountsRepo) SaveConnectedService(accountKey *datastore.Key, service ConnectedService, token *ConnectedServiceToken) (*ConnectedService, error) {
+	encryptedToken, err := encryptConnectedServiceToken(token, accountKey, service.ProviderID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	service.Scopes = token.Scopes
+	service.Expiry = token.expiry()
+	service.EncryptedToken = encryptedToken
+	service.ConnectedAt = now
+	service.UpdatedAt = now
+
+	key := repo.connectedServiceKey(accountKey, service.ProviderID)
+	err = datastore.RunInTransaction(repo.ctx, func(ctx context.Context) error {
+		// A different user of the service may have been connected before, who should no longer sign into this account
+		var previous ConnectedService
+		err := datastore.Get(ctx, key, &previous)
+		if err == nil && previous.Identity != "" && previous.Identity != service.Identity {
+			err = datastore.Delete(ctx, datastore.NewKey(ctx, userAccountIdentityType, previous.Identity, 0, nil))
+		}
+		if err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+
+		_, err = datastore.Put(ctx, key, &service)
+		return err
+	}, &datastore.TransactionOptions{XG: true})
+	if err != nil {
+		return nil, err
+	}
+
+	service.Key = key
+	return &service, nil
+}
+
+// UpdateConnectedServiceOAuth2Token stores a refreshed token, keeping the rest of the connection
+func (repo UserAccountsRepo) UpdateConnectedServiceOAuth2Token(accountKey *datastore.Key, providerID string, token *oauth2.Token) error {
+	key := repo.connectedServiceKey(accountKey, providerID)
+
+	return datastore.RunInTransaction(repo.ctx, func(ctx context.Context) error {
+		var service ConnectedService
+		err := datastore.Get(ctx, key, &service)
+		if err != nil {
+			return err
+		}
+
+		service.EncryptedToken, err = encryptConnectedServiceToken(&ConnectedServiceToken{OAuth2: token}, accountKey, providerID)
+		if err != nil {
+			return err
+		}
+		service.Expiry = token.Expiry
+		service.UpdatedAt = time.Now().UTC()
+
+		_, err = datastore.Put(ctx, key, &service)
+		return err
+	}, nil)
+}
+
+// GetConnectedService loads the provider’s connection and decrypted token
+func (repo UserAccountsRepo) GetConnectedService(accountKey *datastore.Key, providerID string) (*ConnectedService, *ConnectedServiceToken, error) {
+	if accountKey == nil {
+		return nil, nil, errServiceNotConnected
+	}
+
+	key := repo.connectedServiceKey(accountKey, providerID)
+
+	var service ConnectedService
+	err := datastore.Get(repo.ctx, key, &service)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, nil, errServiceNotConnected
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	service.Key = key
+
+	token, err := decryptConnectedServiceToken(service.EncryptedToken, accountKey, providerID)
+	if err != nil {
+		return nil, nil, err
+	}
+	token.Scopes = service.Scopes
+
+	return &service, token, nil
+}
+
+// ListConnectedServices lists the services connected to the account
+func (repo UserAccountsRepo) ListConnectedServices(accountKey *datastore.Key) ([]ConnectedService, error) {
+	services := []ConnectedService{}
+	if accountKey == nil {
+		return services, nil
+	}
+
+	q := datastore.NewQuery(connectedServiceType).Ancestor(accountKey)
+	for i := q.Run(repo.ctx); ; {
+		var service ConnectedService
+		key, err := i.Next(&service)
+		if err == datastore.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		service.Key = key
+		services = append(services, service)
+	}
+
+	return services, nil
+}
+
+// DeleteConnectedService forgets the provider’s token, and which of the service’s users connected it
+func (repo UserAccountsRepo) DeleteConnectedService(accountKey *datastore.Key, providerID string) error {
+	key := repo.connectedServiceKey(accountKey, providerID)
+
+	return datastore.RunInTransaction(repo.ctx, func(ctx context.Context) error {
+		var service ConnectedService
+		err := datastore.Get(ctx, key, &service)
+		if err == datastore.ErrNoSuchEntity {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		keys := []*datastore.Key{key}
+		if service.Identity != "" {
+			keys = append(keys, datastore.NewKey(ctx, userAccountIdentityType, service.Identity, 0, nil))
+		}
+		return datastore.DeleteMulti(ctx, keys)
+	}, &datastore.TransactionOptions{XG: true})
+}
+
+// connectedServiceTokenAssociatedData binds an encrypted token to its account and provider,
+// so it cannot be copied to another account’s connection and still decrypt
+func connectedServiceTokenAssociatedData(accountKey *datastore.Key, providerID string) []byte {
+	return []byte(accountKey.Encode() + providerID)
+}
+
+func encryptConnectedServiceToken(token *ConnectedServiceToken, accountKey *datastore.Key, providerID string) ([]byte, error) {
+	plaintext, err := json.Marshal(token)
+	if err != nil {
+		return nil, err
+	}
+
+	return encryptSecret(plaintext, connectedServiceTokenAssociatedData(accountKey, providerID))
+}
+
+func decryptConnectedServiceToken(encryptedToken []byte, accountKey *datastore.Key, providerID string) (*ConnectedServiceToken, error) {
+	plaintext, err := decryptSecret(encryptedToken, connectedServiceTokenAssociatedData(accountKey, providerID))
+	if err != nil {
+		return nil, err
+	}
+
+	var token ConnectedServiceToken
+	err = json.Unmarshal(plaintext, &token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
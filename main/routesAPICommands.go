@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/gorilla/mux"
+)
+
+const (
+	maxRunCommandBodySize = 1 << 20 // 1 MB
+
+	commandResultMediaTypeJSON = "application/json"
+	commandResultMediaTypeText = "text/plain"
+	commandResultMediaTypeHTML = "text/html"
+)
+
+var (
+	errRunCommandNotSignedIn   = errors.New("Sign in to run commands")
+	errRunCommandWithServerKey = errors.New("Commands that use the server’s credentials, such as /aws, can only be run from posts")
+)
+
+var commandResultFormats = map[string]string{
+	"json": commandResultMediaTypeJSON,
+	"text": commandResultMediaTypeText,
+	"html": commandResultMediaTypeHTML,
+}
+
+// AddAPICommandsRoutes adds routes for running commands
+func AddAPICommandsRoutes(r *mux.Router) {
+	r.Path("/1/commands:run").Methods("POST").
+		HandlerFunc(WithCSRFHeader(WithViewer(runCommandHandle)))
+	r.Path("/1/org:{orgSlug}/channel:{channelSlug}/posts/{postID}/commandResult").Methods("GET").
+		HandlerFunc(WithViewer(runCommandInPostHandle))
+}
+
+// negotiateCommandResultMediaType picks the best rendering of a command result for the request’s ?format= or Accept header
+func negotiateCommandResultMediaType(r *http.Request) string {
+	if mediaType, ok := commandResultFormats[r.URL.Query().Get("format")]; ok {
+		return mediaType
+	}
+
+	offered := []string{commandResultMediaTypeJSON, commandResultMediaTypeText, commandResultMediaTypeHTML}
+
+	accept := r.Header.Get("Accept")
+	if strings.TrimSpace(accept) == "" {
+		return commandResultMediaTypeJSON
+	}
+
+	best := ""
+	bestQuality := 0.0
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		accepted := strings.ToLower(strings.TrimSpace(fields[0]))
+		quality := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q, err := strconv.ParseFloat(param[2:], 64)
+				if err == nil {
+					quality = q
+				}
+			}
+		}
+
+		for _, mediaType := range offered {
+			baseType := strings.SplitN(mediaType, "/", 2)[0]
+			matches := accepted == mediaType || accepted == "*/*" || accepted == baseType+"/*"
+			if matches && quality > bestQuality {
+				best = mediaType
+				bestQuality = quality
+			}
+		}
+	}
+
+	if best == "" {
+		return commandResultMediaTypeJSON
+	}
+
+	return best
+}
+
+// WriteCommandResultToHTTP writes the result of a command as JSON, plain text, or HTML depending on what the request accepts
+func WriteCommandResultToHTTP(result CommandResult, w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Accept")
+
+	switch negotiateCommandResultMediaType(r) {
+	case commandResultMediaTypeText:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		io.WriteString(w, result.PlainText())
+	case commandResultMediaTypeHTML:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		io.WriteString(w, SafeHTMLForCommandResult(result))
+	default:
+		writeJSON(w, &struct {
+			WantsFullWidth bool        `json:"wantsFullWidth"`
+			Result         interface{} `json:"result"`
+		}{
+			WantsFullWidth: result.WantsFullWidth(),
+			Result:         result.JSON(),
+		})
+	}
+}
+
+func runCommandInPostHandle(ctx context.Context, v *Viewer, w http.ResponseWriter, r *http.Request) {
+	vars := routeVarsFrom(r)
+
+	orgRepo := NewOrgRepo(ctx, vars.orgSlug())
+	channelsRepo := NewChannelsRepo(ctx, orgRepo)
+
+	post, err := channelsRepo.GetPostWithIDInChannel(vars.channelSlug(), vars.postID())
+	if err != nil {
+		writeErrorJSON(w, err)
+		return
+	}
+
+	if post.CommandType != "v0" {
+		writeErrorJSON(w, fmt.Errorf("Post is not a command"))
+		return
+	}
+
+	result, err := runCommandPost(ctx, post, v.GetCommandParamVariables())
+	if err != nil {
+		writeErrorJSON(w, err)
+		return
+	}
+
+	WriteCommandResultToHTTP(result, w, r)
+}
+
+// runCommandBody is the JSON form of a /1/commands:run request.
+// Either Input has the full `/command subcommand\n<toml params>` text,
+// or Command has the `/command subcommand` line and Params has a TOML string or a JSON object.
+type runCommandBody struct {
+	Input   *string         `json:"input"`
+	Command string          `json:"command"`
+	Params  json.RawMessage `json:"params"`
+}
+
+func (body runCommandBody) commandInput() (string, error) {
+	if body.Input != nil {
+		return *body.Input, nil
+	}
+
+	if strings.TrimSpace(body.Command) == "" {
+		return "", fmt.Errorf("Must provide input or command")
+	}
+
+	if len(body.Params) == 0 || string(body.Params) == "null" {
+		return body.Command, nil
+	}
+
+	var paramsString string
+	err := json.Unmarshal(body.Params, &paramsString)
+	if err == nil {
+		return body.Command + "\n" + paramsString, nil
+	}
+
+	var paramsObject map[string]interface{}
+	err = json.Unmarshal(body.Params, &paramsObject)
+	if err != nil {
+		return "", fmt.Errorf("Params must be a TOML string or a JSON object")
+	}
+
+	var paramsBuffer bytes.Buffer
+	err = toml.NewEncoder(&paramsBuffer).Encode(paramsObject)
+	if err != nil {
+		return "", fmt.Errorf("Cannot convert params to TOML: %s", err.Error())
+	}
+
+	return body.Command + "\n" + paramsBuffer.String(), nil
+}
+
+func readRunCommandInput(r *http.Request) (string, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, maxRunCommandBodySize))
+	if err != nil {
+		return "", err
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/json" {
+		return string(data), nil
+	}
+
+	var body runCommandBody
+	err = json.Unmarshal(data, &body)
+	if err != nil {
+		return "", fmt.Errorf("Invalid JSON body: %s", err.Error())
+	}
+
+	return body.commandInput()
+}
+
+// runCommandHandle runs a command for the signed in viewer, who must send X-CSRF-Token so other sites cannot run commands as them
+func runCommandHandle(ctx context.Context, v *Viewer, w http.ResponseWriter, r *http.Request) {
+	if v.UserAccountKey() == nil {
+		writeErrorJSONWithStatus(w, http.StatusUnauthorized, errRunCommandNotSignedIn)
+		return
+	}
+
+	input, err := readRunCommandInput(r)
+	if err != nil {
+		writeErrorJSONWithStatus(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if strings.TrimSpace(input) == "" {
+		writeErrorJSONWithStatus(w, http.StatusBadRequest, fmt.Errorf("No command passed"))
+		return
+	}
+
+	commandParamVars := v.GetCommandParamVariables()
+	command, err := ParseCommandInput(input, MakeCommandParamsPreprocessor(commandParamVars))
+	if err != nil {
+		writeErrorJSONWithStatus(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if usesServerCredentials(command) {
+		writeErrorJSONWithStatus(w, http.StatusForbidden, errRunCommandWithServerKey)
+		return
+	}
+
+	result, err := command.Run(ContextWithCommandParamVariables(ctx, commandParamVars))
+	if err != nil {
+		writeErrorJSONWithStatus(w, http.StatusBadGateway, err)
+		return
+	}
+
+	WriteCommandResultToHTTP(result, w, r)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/csv"
 	"encoding/json"
 	"net/http"
@@ -18,13 +19,13 @@ func AddAPIPostsRoutes(r *mux.Router) {
 		HandlerFunc(createChannelHandle)
 
 	r.Path("/1/org:{orgSlug}/channel:{channelSlug}/posts").Methods("GET").
-		HandlerFunc(listPostsInChannelHandle)
+		HandlerFunc(WithViewer(listPostsInChannelHandle))
 	r.Path("/1/org:{orgSlug}/channel:{channelSlug}/posts.csv").Methods("GET").
-		HandlerFunc(listPostsCSVInChannelHandle)
+		HandlerFunc(WithViewer(listPostsCSVInChannelHandle))
 	r.Path("/1/org:{orgSlug}/channel:{channelSlug}/posts/{postID}").Methods("GET").
-		HandlerFunc(getPostInChannelHandle)
+		HandlerFunc(WithViewer(getPostInChannelHandle))
 	r.Path("/1/org:{orgSlug}/channel:{channelSlug}/posts").Methods("POST").
-		HandlerFunc(createPostInChannelHandle)
+		HandlerFunc(WithCSRFHeader(WithViewer(createPostInChannelHandle)))
 }
 
 func getChannelInfoHandle(w http.ResponseWriter, r *http.Request) {
@@ -59,8 +60,8 @@ func createChannelHandle(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, channel)
 }
 
-func listPostsInChannelHandle(w http.ResponseWriter, r *http.Request) {
-	ctx := appengine.NewContext(r)
+func listPostsInChannelHandle(ctx context.Context, v *Viewer, w http.ResponseWriter, r *http.Request) {
+	ctx = ContextSigningStorageURLsFor(ctx, v)
 	vars := routeVarsFrom(r)
 
 	orgRepo := NewOrgRepo(ctx, vars.orgSlug())
@@ -80,11 +81,15 @@ func listPostsInChannelHandle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	for i := range posts {
+		signStorageURLsInPost(ctx, &posts[i])
+	}
+
 	writeJSON(w, posts)
 }
 
-func listPostsCSVInChannelHandle(w http.ResponseWriter, r *http.Request) {
-	ctx := appengine.NewContext(r)
+func listPostsCSVInChannelHandle(ctx context.Context, v *Viewer, w http.ResponseWriter, r *http.Request) {
+	ctx = ContextSigningStorageURLsFor(ctx, v)
 	vars := routeVarsFrom(r)
 
 	orgRepo := NewOrgRepo(ctx, vars.orgSlug())
@@ -108,28 +113,30 @@ func listPostsCSVInChannelHandle(w http.ResponseWriter, r *http.Request) {
 	csvWriter.Flush()
 }
 
-func getPostInChannelHandle(w http.ResponseWriter, r *http.Request) {
-	ctx := appengine.NewContext(r)
+func getPostInChannelHandle(ctx context.Context, v *Viewer, w http.ResponseWriter, r *http.Request) {
+	ctx = ContextSigningStorageURLsFor(ctx, v)
 	vars := routeVarsFrom(r)
 
 	orgRepo := NewOrgRepo(ctx, vars.orgSlug())
 	channelsRepo := NewChannelsRepo(ctx, orgRepo)
 
-	posts, err := channelsRepo.GetPostWithIDInChannel(vars.channelSlug(), vars.postID())
+	post, err := channelsRepo.GetPostWithIDInChannel(vars.channelSlug(), vars.postID())
 	if err != nil {
 		writeErrorJSON(w, err)
 		return
 	}
 
-	writeJSON(w, posts)
+	signStorageURLsInPost(ctx, post)
+
+	writeJSON(w, post)
 }
 
 type createPostBody struct {
-	MarkdownSource string `json:"markdownSource"`
+	MarkdownSource string  `json:"markdownSource"`
+	ParentPostID   *string `json:"parentPostID"`
 }
 
-func createPostInChannelHandle(w http.ResponseWriter, r *http.Request) {
-	ctx := appengine.NewContext(r)
+func createPostInChannelHandle(ctx context.Context, v *Viewer, w http.ResponseWriter, r *http.Request) {
 	vars := routeVarsFrom(r)
 
 	orgRepo := NewOrgRepo(ctx, vars.orgSlug())
@@ -145,15 +152,29 @@ func createPostInChannelHandle(w http.ResponseWriter, r *http.Request) {
 
 	input := CreatePostInput{
 		ChannelSlug:          vars.channelSlug(),
-		ParentPostKeyEncoded: nil,
+		ParentPostKeyEncoded: body.ParentPostID,
 		MarkdownSource:       body.MarkdownSource,
 	}
 
-	post, err := channelsRepo.CreatePost(input)
-	if err != nil {
+	post, err := CreatePostMirroringReply(ctx, channelsRepo, v.GetTrelloAPI(), input)
+	if post == nil {
 		writeErrorJSON(w, err)
 		return
 	}
 
-	writeJSON(w, post)
+	signStorageURLsInPost(ContextSigningStorageURLsFor(ctx, v), post)
+
+	// The post was still created when only mirroring it to Trello failed
+	var trelloMirrorError string
+	if err != nil {
+		trelloMirrorError = err.Error()
+	}
+
+	writeJSON(w, &struct {
+		*Post
+		TrelloMirrorError string `json:"trelloMirrorError,omitempty"`
+	}{
+		Post:              post,
+		TrelloMirrorError: trelloMirrorError,
+	})
 }
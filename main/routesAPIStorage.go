@@ -1,101 +1,328 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
-	"google.golang.org/appengine"
+)
+
+const (
+	storageContentPath = "/1/storage/{type}/{subtype}/sha256/{sha256}"
+	// storageUploadMaxBytes is App Engine’s limit on request bodies
+	storageUploadMaxBytes = 32 << 20 // 32 MB
+)
+
+var (
+	errStorageWriteNotSignedIn = errors.New("Sign in to upload content to storage")
+	errStorageUploadTooLarge   = fmt.Errorf("Content must be at most %d bytes to be stored", storageUploadMaxBytes)
 )
 
 // AddAPIStorageRoutes adds routes for working with storage
 func AddAPIStorageRoutes(r *mux.Router) {
-	// Text
-	r.Path("/1/storage/text/markdown/sha256/{sha256}").Methods("POST").
-		HandlerFunc(createTextMarkdownInStorageHandle)
-	r.Path("/1/storage/text/markdown/sha256/{sha256}").Methods("GET").
-		HandlerFunc(readTextMarkdownInStorageHandle)
-	// Images
-	r.Path("/1/storage/image/png/sha256/{sha256}").Methods("POST").
-		HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			createImageInStorageHandle("image/png", w, r)
-		})
-	r.Path("/1/storage/image/png/sha256/{sha256}").Methods("GET").
-		HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			readImageInStorageHandle("image/png", w, r)
-		})
-	r.Path("/1/storage/image/jpeg/sha256/{sha256}").Methods("POST").
-		HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			createImageInStorageHandle("image/jpeg", w, r)
-		})
-	r.Path("/1/storage/image/jpeg/sha256/{sha256}").Methods("GET").
-		HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			readImageInStorageHandle("image/jpeg", w, r)
-		})
+	r.Path("/1/storage").Methods("POST").
+		HandlerFunc(WithCSRFHeader(WithViewer(createContentInStorageHandle)))
+	r.Path(storageContentPath).Methods("POST").
+		HandlerFunc(WithCSRFHeader(WithViewer(createContentWithSHA256InStorageHandle)))
+	r.Path(storageContentPath).Methods("GET", "HEAD").
+		HandlerFunc(WithViewer(readContentInStorageHandle))
 }
 
-func createTextMarkdownInStorageHandle(w http.ResponseWriter, r *http.Request) {
-	ctx := appengine.NewContext(r)
-	vars := routeVarsFrom(r)
+// writeStoredContentJSON responds with the stored content, or why it could not be stored
+func writeStoredContentJSON(w http.ResponseWriter, stored *StoredContent, err error) {
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		if _, ok := err.(*UnsupportedMediaTypeError); ok {
+			statusCode = http.StatusUnsupportedMediaType
+		} else if _, ok := err.(*SHA256MismatchError); ok || err == errInvalidSHA256 {
+			statusCode = http.StatusBadRequest
+		} else if err == errStorageUploadTooLarge {
+			statusCode = http.StatusRequestEntityTooLarge
+		}
 
-	storageRepo := NewStorageRepo(ctx)
+		writeErrorJSONWithStatus(w, statusCode, err)
+		return
+	}
+
+	writeJSON(w, stored)
+}
+
+// storageUploadBody reads a request body of at most storageUploadMaxBytes, failing with errStorageUploadTooLarge past that
+type storageUploadBody struct {
+	reader io.Reader
+	read   int64
+}
+
+func newStorageUploadBody(w http.ResponseWriter, r *http.Request) *storageUploadBody {
+	return &storageUploadBody{
+		reader: http.MaxBytesReader(w, r.Body, storageUploadMaxBytes),
+	}
+}
+
+func (body *storageUploadBody) Read(p []byte) (int, error) {
+	n, err := body.reader.Read(p)
+	body.read += int64(n)
+	// MaxBytesReader reads up to the limit and then fails
+	if err != nil && err != io.EOF && body.read >= storageUploadMaxBytes {
+		return n, errStorageUploadTooLarge
+	}
+	return n, err
+}
+
+// authorizeStorageWrite allows those signed in to upload content of at most storageUploadMaxBytes
+func authorizeStorageWrite(v *Viewer, w http.ResponseWriter, r *http.Request) bool {
+	if v.UserAccountKey() == nil {
+		writeErrorJSONWithStatus(w, http.StatusUnauthorized, errStorageWriteNotSignedIn)
+		return false
+	}
+
+	if r.ContentLength > storageUploadMaxBytes {
+		writeErrorJSONWithStatus(w, http.StatusRequestEntityTooLarge, errStorageUploadTooLarge)
+		return false
+	}
 
-	err := storageRepo.addContentWithMediaTypeAndSHA256("text/markdown", vars.sha256(), r.Body)
+	return true
+}
+
+// createContentInStorageHandle stores the body with the media type of its Content-Type, responding with its content address
+func createContentInStorageHandle(ctx context.Context, v *Viewer, w http.ResponseWriter, r *http.Request) {
+	if !authorizeStorageWrite(v, w, r) {
+		return
+	}
+
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
 	if err != nil {
-		writeErrorJSON(w, err)
+		writeErrorJSONWithStatus(w, http.StatusUnsupportedMediaType, &UnsupportedMediaTypeError{MediaType: r.Header.Get("Content-Type")})
 		return
 	}
 
-	writeJSON(w, "success")
+	storageRepo := NewStorageRepo(ctx)
+
+	stored, err := storageRepo.addContentWithMediaType(mediaType, newStorageUploadBody(w, r))
+	writeStoredContentJSON(w, stored, err)
 }
 
-func readTextMarkdownInStorageHandle(w http.ResponseWriter, r *http.Request) {
-	ctx := appengine.NewContext(r)
+// createContentWithSHA256InStorageHandle stores the body if it has the SHA-256 in the path
+func createContentWithSHA256InStorageHandle(ctx context.Context, v *Viewer, w http.ResponseWriter, r *http.Request) {
+	if !authorizeStorageWrite(v, w, r) {
+		return
+	}
+
 	vars := routeVarsFrom(r)
 
 	storageRepo := NewStorageRepo(ctx)
 
-	reader, err := storageRepo.readContentWithMediaTypeAndSHA256("text/markdown", vars.sha256())
+	stored, err := storageRepo.addContentWithMediaTypeAndSHA256(vars.mediaType(), vars.sha256(), newStorageUploadBody(w, r))
+	writeStoredContentJSON(w, stored, err)
+}
+
+// etagMatches checks an If-None-Match or If-Range header, which may list several ETags, against ours
+func etagMatches(header string, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+var errUnsatisfiableRange = errors.New("Requested range is not within the content")
+
+// parseByteRange reads a Range header of a single range, e.g. bytes=0-499, bytes=500- or bytes=-500.
+// Several ranges are not supported and invalid ones are ignored, so ok is false and all the content should be sent instead.
+func parseByteRange(header string, size int64) (offset int64, length int64, ok bool, err error) {
+	if !strings.HasPrefix(header, "bytes=") {
+		return 0, 0, false, nil
+	}
+
+	spec := strings.TrimSpace(strings.TrimPrefix(header, "bytes="))
+	if strings.Contains(spec, ",") {
+		return 0, 0, false, nil
+	}
+
+	dash := strings.Index(spec, "-")
+	if dash == -1 {
+		return 0, 0, false, nil
+	}
+	startSpec, endSpec := strings.TrimSpace(spec[:dash]), strings.TrimSpace(spec[dash+1:])
+
+	if startSpec == "" {
+		// The last bytes
+		suffixLength, err := strconv.ParseInt(endSpec, 10, 64)
+		if err != nil {
+			return 0, 0, false, nil
+		}
+		if suffixLength <= 0 || size == 0 {
+			return 0, 0, false, errUnsatisfiableRange
+		}
+		if suffixLength > size {
+			suffixLength = size
+		}
+		return size - suffixLength, suffixLength, true, nil
+	}
+
+	start, err := strconv.ParseInt(startSpec, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, false, nil
+	}
+
+	end := size - 1
+	if endSpec != "" {
+		end, err = strconv.ParseInt(endSpec, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, false, nil
+		}
+		if end > size-1 {
+			end = size - 1
+		}
+	}
+
+	if start >= size {
+		return 0, 0, false, errUnsatisfiableRange
+	}
+
+	return start, end - start + 1, true, nil
+}
+
+var errStorageReadNotLinked = errors.New("Stored content can only be read from a post that links to it, or with a signed link")
+
+// authorizeStorageRead allows signed URLs until they expire, and unsigned ones to those who can read what posts link to
+// if a post links to the content, returning how long the response may be cached for
+func authorizeStorageRead(ctx context.Context, v *Viewer, r *http.Request, storageKey string) (maxAge time.Duration, err error) {
+	if hasStorageURLSignature(r) {
+		now := time.Now()
+		expiresAt, err := verifyStorageURLSignature(r, now)
+		if err != nil {
+			return 0, err
+		}
+		return expiresAt.Sub(now), nil
+	}
+
+	if !v.CanReadLinkedStorage() {
+		return 0, errStorageReadNotSignedIn
+	}
+
+	postKeys, err := postKeysLinkingToStorage(ctx, storageKey, 1)
 	if err != nil {
-		writeErrorJSON(w, err)
-		return
+		return 0, err
+	}
+	if len(postKeys) == 0 {
+		return 0, errStorageReadNotLinked
 	}
 
-	w.Header().Set("Content-Type", "text/markdown")
-	w.WriteHeader(http.StatusOK)
-	io.Copy(w, reader)
-	reader.Close()
+	// Access can be lost, so this is cached no longer than a signed URL
+	return storageURLLifetime, nil
 }
 
-func createImageInStorageHandle(mediaType string, w http.ResponseWriter, r *http.Request) {
-	ctx := appengine.NewContext(r)
+// storageReadErrorStatusCode is the status for why reading stored content was not authorized
+func storageReadErrorStatusCode(err error) int {
+	switch err {
+	case errStorageReadNotSignedIn:
+		return http.StatusUnauthorized
+	case errStorageReadNotLinked, errStorageURLSignature, errStorageURLExpired:
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
+
+// readContentInStorageHandle serves stored content, which never changes as its URL has its SHA-256
+func readContentInStorageHandle(ctx context.Context, v *Viewer, w http.ResponseWriter, r *http.Request) {
 	vars := routeVarsFrom(r)
+	mediaType := vars.mediaType()
+
+	maxAge, err := authorizeStorageRead(ctx, v, r, storageKeyForContent(mediaType, vars.sha256()))
+	if err != nil {
+		writeErrorJSONWithStatus(w, storageReadErrorStatusCode(err), err)
+		return
+	}
+
+	err = checkStorableMediaType(mediaType)
+	if err != nil {
+		writeErrorJSONWithStatus(w, http.StatusNotFound, err)
+		return
+	}
 
 	storageRepo := NewStorageRepo(ctx)
 
-	err := storageRepo.addContentWithMediaTypeAndSHA256(mediaType, vars.sha256(), r.Body)
+	stored, err := storageRepo.statContentWithMediaTypeAndSHA256(mediaType, vars.sha256())
+	if err == errBlobNotFound {
+		writeErrorJSONWithStatus(w, http.StatusNotFound, err)
+		return
+	}
 	if err != nil {
-		writeErrorJSON(w, err)
+		writeErrorJSONWithStatus(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	writeJSON(w, "success")
-}
+	etag := `"` + stored.SHA256 + `"`
 
-func readImageInStorageHandle(mediaType string, w http.ResponseWriter, r *http.Request) {
-	ctx := appengine.NewContext(r)
-	vars := routeVarsFrom(r)
+	header := w.Header()
+	header.Set("ETag", etag)
+	// Private, so shared caches do not serve it to those without a signed URL or session
+	header.Set("Cache-Control", "private, max-age="+strconv.FormatInt(int64(maxAge/time.Second), 10)+", immutable")
+	header.Set("Accept-Ranges", "bytes")
+	header.Set("X-Content-Type-Options", "nosniff")
+	// Uploaded SVGs can contain scripts, which must not run as this site
+	header.Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; sandbox")
 
-	storageRepo := NewStorageRepo(ctx)
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" && etagMatches(ifNoneMatch, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	statusCode := http.StatusOK
+	offset, length := int64(0), stored.Size
+
+	// A Range with an If-Range for other content is for a download that must start again
+	rangeHeader := r.Header.Get("Range")
+	if ifRange := r.Header.Get("If-Range"); ifRange != "" && !etagMatches(ifRange, etag) {
+		rangeHeader = ""
+	}
+	if rangeHeader != "" {
+		rangeOffset, rangeLength, ok, err := parseByteRange(rangeHeader, stored.Size)
+		if err != nil {
+			header.Del("Cache-Control")
+			header.Set("Content-Range", fmt.Sprintf("bytes */%d", stored.Size))
+			writeErrorJSONWithStatus(w, http.StatusRequestedRangeNotSatisfiable, err)
+			return
+		}
+		if ok {
+			statusCode = http.StatusPartialContent
+			offset, length = rangeOffset, rangeLength
+			header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, stored.Size))
+		}
+	}
 
-	reader, err := storageRepo.readContentWithMediaTypeAndSHA256(mediaType, vars.sha256())
+	header.Set("Content-Type", mediaType)
+	header.Set("Content-Length", strconv.FormatInt(length, 10))
+
+	if r.Method == "HEAD" {
+		w.WriteHeader(statusCode)
+		return
+	}
+
+	readLength := length
+	if statusCode == http.StatusOK {
+		readLength = -1
+	}
+	reader, err := storageRepo.readContentWithMediaTypeAndSHA256(mediaType, stored.SHA256, offset, readLength)
 	if err != nil {
-		writeErrorJSON(w, err)
+		header.Del("Cache-Control")
+		header.Del("Content-Length")
+		header.Del("Content-Range")
+		writeErrorJSONWithStatus(w, http.StatusInternalServerError, err)
 		return
 	}
+	defer reader.Close()
 
-	w.Header().Set("Content-Type", mediaType)
-	w.WriteHeader(http.StatusOK)
+	w.WriteHeader(statusCode)
 	io.Copy(w, reader)
-	reader.Close()
 }
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStorageUploadBody(t *testing.T) {
+	tests := []struct {
+		size int
+		err  error
+	}{
+		{0, nil},
+		{1024, nil},
+		{storageUploadMaxBytes, nil},
+		{storageUploadMaxBytes + 1, errStorageUploadTooLarge},
+		{storageUploadMaxBytes + 4096, errStorageUploadTooLarge},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("POST", "/1/storage", bytes.NewReader(make([]byte, test.size)))
+		body := newStorageUploadBody(httptest.NewRecorder(), r)
+
+		n, err := io.Copy(ioutil.Discard, body)
+		if err != test.err {
+			t.Errorf("Reading %d bytes = %v, want %v", test.size, err, test.err)
+		}
+		if n > storageUploadMaxBytes {
+			t.Errorf("Reading %d bytes read %d, more than the limit", test.size, n)
+		}
+	}
+}
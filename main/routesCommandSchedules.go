@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+var errCommandScheduleNotSignedIn = errors.New("Sign in to schedule commands")
+
+// AddCommandSchedulesRoutes adds routes for scheduling command posts, and for cron to run them
+func AddCommandSchedulesRoutes(r *mux.Router) {
+	r.Path(commandSchedulesRunPath).Methods("GET").
+		HandlerFunc(runDueCommandSchedulesHandle)
+
+	r.Path("/1/org:{orgSlug}/channel:{channelSlug}/posts/{postID}/schedule").Methods("GET").
+		HandlerFunc(getCommandScheduleHandle)
+	r.Path("/1/org:{orgSlug}/channel:{channelSlug}/posts/{postID}/schedule").Methods("PUT").
+		HandlerFunc(WithCSRFHeader(WithViewer(setCommandScheduleHandle)))
+	r.Path("/1/org:{orgSlug}/channel:{channelSlug}/posts/{postID}/schedule").Methods("DELETE").
+		HandlerFunc(WithCSRFHeader(WithViewer(removeCommandScheduleHandle)))
+
+	r.Path("/org:{orgSlug}/channel:{channelSlug}/posts/{postID}/schedule").Methods("POST").
+		HandlerFunc(WithCSRF(WithViewerInSession(setCommandScheduleHTMLHandle)))
+}
+
+// isCronRequest is true for requests made by App Engine cron, which strips this header from outside requests
+func isCronRequest(r *http.Request) bool {
+	return r.Header.Get("X-Appengine-Cron") == "true"
+}
+
+func runDueCommandSchedulesHandle(w http.ResponseWriter, r *http.Request) {
+	if !isCronRequest(r) {
+		http.Error(w, "Only cron can run schedules.", http.StatusForbidden)
+		return
+	}
+
+	ctx := appengine.NewContext(r)
+
+	ranCount, err := RunDueCommandSchedules(ctx, time.Now().UTC())
+	if err != nil {
+		writeErrorJSONWithStatus(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, &struct {
+		RanCount int `json:"ranCount"`
+	}{
+		RanCount: ranCount,
+	})
+}
+
+func getCommandScheduleHandle(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+	vars := routeVarsFrom(r)
+
+	orgRepo := NewOrgRepo(ctx, vars.orgSlug())
+	channelsRepo := NewChannelsRepo(ctx, orgRepo)
+
+	postKey, err := channelsRepo.PostKeyInChannel(vars.channelSlug(), vars.postID())
+	if err != nil {
+		writeErrorJSONWithStatus(w, http.StatusNotFound, err)
+		return
+	}
+
+	schedule, err := channelsRepo.GetCommandScheduleForPost(postKey)
+	if err != nil {
+		writeErrorJSON(w, err)
+		return
+	}
+	if schedule == nil {
+		writeErrorJSONWithStatus(w, http.StatusNotFound, fmt.Errorf("Post has no schedule"))
+		return
+	}
+
+	writeJSON(w, schedule)
+}
+
+type setCommandScheduleBody struct {
+	Cron            string `json:"cron"`
+	OnlyWhenChanged bool   `json:"onlyWhenChanged"`
+}
+
+func setCommandScheduleHandle(ctx context.Context, v *Viewer, w http.ResponseWriter, r *http.Request) {
+	if v.UserAccountKey() == nil {
+		writeErrorJSONWithStatus(w, http.StatusUnauthorized, errCommandScheduleNotSignedIn)
+		return
+	}
+
+	vars := routeVarsFrom(r)
+
+	orgRepo := NewOrgRepo(ctx, vars.orgSlug())
+	channelsRepo := NewChannelsRepo(ctx, orgRepo)
+
+	var body setCommandScheduleBody
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		writeErrorJSONWithStatus(w, http.StatusBadRequest, err)
+		return
+	}
+
+	schedule, err := channelsRepo.SetCommandScheduleForPost(SetCommandScheduleInput{
+		ChannelSlug:     vars.channelSlug(),
+		PostKeyEncoded:  vars.postID(),
+		Cron:            body.Cron,
+		OnlyWhenChanged: body.OnlyWhenChanged,
+		UserAccountKey:  v.UserAccountKey(),
+	})
+	if err != nil {
+		writeErrorJSONWithStatus(w, http.StatusBadRequest, err)
+		return
+	}
+
+	writeJSON(w, schedule)
+}
+
+func removeCommandScheduleHandle(ctx context.Context, v *Viewer, w http.ResponseWriter, r *http.Request) {
+	if v.UserAccountKey() == nil {
+		writeErrorJSONWithStatus(w, http.StatusUnauthorized, errCommandScheduleNotSignedIn)
+		return
+	}
+
+	vars := routeVarsFrom(r)
+
+	orgRepo := NewOrgRepo(ctx, vars.orgSlug())
+	channelsRepo := NewChannelsRepo(ctx, orgRepo)
+
+	postKey, err := channelsRepo.PostKeyInChannel(vars.channelSlug(), vars.postID())
+	if err != nil {
+		writeErrorJSONWithStatus(w, http.StatusNotFound, err)
+		return
+	}
+
+	err = channelsRepo.RemoveCommandScheduleForPost(postKey)
+	if err != nil {
+		writeErrorJSON(w, err)
+		return
+	}
+
+	writeJSON(w, "success")
+}
+
+func setCommandScheduleHTMLHandle(ctx context.Context, v *Viewer, w http.ResponseWriter, r *http.Request) {
+	vars := routeVarsFrom(r)
+	channelViewModel := vars.ToChannelViewModel()
+
+	orgRepo := NewOrgRepo(ctx, vars.orgSlug())
+	channelsRepo := NewChannelsRepo(ctx, orgRepo)
+
+	var err error
+	if v.UserAccountKey() == nil {
+		err = errCommandScheduleNotSignedIn
+	} else if r.PostFormValue("action") == "removeSchedule" {
+		var postKey *datastore.Key
+		postKey, err = channelsRepo.PostKeyInChannel(vars.channelSlug(), vars.postID())
+		if err == nil {
+			err = channelsRepo.RemoveCommandScheduleForPost(postKey)
+		}
+	} else {
+		_, err = channelsRepo.SetCommandScheduleForPost(SetCommandScheduleInput{
+			ChannelSlug:     vars.channelSlug(),
+			PostKeyEncoded:  vars.postID(),
+			Cron:            r.PostFormValue("cron"),
+			OnlyWhenChanged: r.PostFormValue("onlyWhenChanged") == "true",
+			UserAccountKey:  v.UserAccountKey(),
+		})
+	}
+	if err != nil {
+		v.SetAlertFor(postFormAlert("schedule", vars.postID()), err.Error())
+	}
+
+	http.Redirect(w, r, channelViewModel.HTMLPostURL(vars.postID()), http.StatusFound)
+}
+
+var commandScheduleFormTemplate = template.Must(template.New("commandScheduleForm").Parse(`
+<form method="post" action="{{.ActionURL}}" class="my-4 p-4 bg-white border-t-2 border-green rounded-sm">
+	<input type="hidden" name="csrfToken" value="{{.CSRFToken}}">
+	<h3 class="mb-2">Repeat</h3>
+	{{if .Alert}}
+	<p class="px-3 py-2 bg-white border-t-4 border-red rounded-sm shadow"><span class="text-red-dark">Error: </span>{{.Alert}}</p>
+	{{end}}
+	{{with .Schedule}}
+	<p class="mb-2 text-grey-darker">Next run {{.NextRunAt.Format "02 Jan 06 15:04 MST"}}{{if not .LastRunAt.IsZero}}, last ran {{.LastRunAt.Format "02 Jan 06 15:04 MST"}}{{end}}</p>
+	{{if .LastError}}<p class="mb-2 text-red-dark">{{.LastError}}</p>{{end}}
+	{{end}}
+	<label class="block my-2">
+		<span class="font-bold">Cron schedule</span>
+		<input name="cron" value="{{with .Schedule}}{{.Cron}}{{end}}" placeholder="e.g. @hourly or 0 9 * * 1-5" class="block w-full mt-1 p-2 font-mono bg-grey-lightest border border-grey rounded shadow-inner">
+	</label>
+	<label class="block my-2">
+		<input type="checkbox" name="onlyWhenChanged" value="true"{{with .Schedule}}{{if .OnlyWhenChanged}} checked{{end}}{{end}}>
+		Only reply when the output changes
+	</label>
+	<div class="flex flex-row-reverse">
+		<button type="submit" name="action" value="setSchedule" class="mt-2 px-4 py-2 font-bold text-green-dark bg-white border border-green-dark rounded shadow">Save Schedule</button>
+		{{if .Schedule}}
+		<button type="submit" name="action" value="removeSchedule" class="mt-2 mr-2 px-4 py-2 text-grey-darkest bg-grey-lighter border border-grey rounded shadow">Stop Repeating</button>
+		{{end}}
+	</div>
+</form>
+`))
+
+func viewCommandScheduleFormHTML(ctx context.Context, v *Viewer, post Post, m ChannelViewModel, channelsRepo ChannelsRepo, w *bufio.Writer) {
+	if post.CommandType != "v0" {
+		return
+	}
+
+	schedule, err := channelsRepo.GetCommandScheduleForPost(post.Key)
+	if err != nil {
+		viewErrorMessage(err.Error(), w)
+		return
+	}
+
+	commandScheduleFormTemplate.Execute(w, &struct {
+		ActionURL string
+		CSRFToken string
+		Alert     *string
+		Schedule  *CommandSchedule
+	}{
+		ActionURL: m.HTMLPostURL(post.Key.Encode()) + "/schedule",
+		CSRFToken: v.CSRFToken(),
+		Alert:     v.ReadAlertFor(postFormAlert("schedule", post.Key.Encode())),
+		Schedule:  schedule,
+	})
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/appengine/log"
+)
+
+// AddConnectedServicesRoutes adds routes for listing and disconnecting the viewer’s services
+func AddConnectedServicesRoutes(r *mux.Router) {
+	r.Path("/connected-services").Methods("GET").
+		HandlerFunc(WithHTMLHeaders(WithViewer(listConnectedServicesHTMLHandle)))
+
+	for _, provider := range OAuthProviders() {
+		r.Path("/signin/" + provider.ID() + "/disconnect").Methods("POST").
+			HandlerFunc(WithCSRF(WithViewer(disconnectServiceHandle(provider))))
+	}
+}
+
+type connectedServiceRow struct {
+	ID      string
+	Name    string
+	Service *ConnectedService
+}
+
+func listConnectedServicesHTMLHandle(ctx context.Context, v *Viewer, w http.ResponseWriter, r *http.Request) {
+	services, err := NewUserAccountsRepo(ctx).ListConnectedServices(v.UserAccountKey())
+	if err != nil {
+		http.Error(w, "Could not list connected services. "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	servicesByProvider := make(map[string]*ConnectedService, len(services))
+	for i := range services {
+		servicesByProvider[services[i].ProviderID] = &services[i]
+	}
+
+	providers := OAuthProviders()
+	rows := make([]connectedServiceRow, 0, len(providers))
+	for _, provider := range providers {
+		rows = append(rows, connectedServiceRow{
+			ID:      provider.ID(),
+			Name:    provider.Name(),
+			Service: servicesByProvider[provider.ID()],
+		})
+	}
+
+	vm := ViewModel{
+		Title: "Connected Services · Collected",
+	}
+
+	vm.ViewPage(w,
+		func(addSection func(outerTagName string) *viewSectionWriter) {
+			addSection("header").
+				class("mt-8 mb-8").
+				innerSlim().
+				writeHTMLString(`<a href="/" class="text-2xl font-bold text-black no-underline">Collected</a>`)
+		},
+		func(addSection func(outerTagName string) *viewSectionWriter) {
+			addSection("section").
+				innerSlim().
+				writeTemplate(`
+<h1 class="mb-4">Connected Services</h1>
+{{with .Alert}}
+<p class="mb-4 px-4 py-3 text-red-dark bg-red-lightest border border-red-light rounded">{{.}}</p>
+{{end}}
+{{range .Rows}}
+<article class="mb-4 px-4 py-3 bg-white border border-grey-lighter rounded">
+	<h2 class="text-lg">{{.Name}}</h2>
+	{{with .Service}}
+	<dl class="my-2 leading-normal">
+		<dt class="font-bold">Account</dt>
+		<dd>{{.AccountLogin}}</dd>
+		<dt class="font-bold">Scopes</dt>
+		<dd>{{range $i, $scope := .Scopes}}{{if $i}}, {{end}}<code>{{$scope}}</code>{{else}}None{{end}}</dd>
+		<dt class="font-bold">Token expires</dt>
+		<dd>{{if .Expiry.IsZero}}Never{{else}}{{.Expiry.Format "2 Jan 2006 15:04 MST"}}{{end}}</dd>
+		<dt class="font-bold">Connected</dt>
+		<dd>{{.ConnectedAt.Format "2 Jan 2006"}}</dd>
+	</dl>
+	<form method="post" action="/signin/{{.ProviderID}}/disconnect">
+		{{csrfInput}}
+		{{props | setIsSubmit | setText "Disconnect" | setColor "red" | button }}
+	</form>
+	{{else}}
+	<p class="my-2">Not connected</p>
+	{{props | setURL (printf "/signin/%s" .ID) | setText (printf "Connect %s" .Name) | setColor "purple" | buttonLink }}
+	{{end}}
+</article>
+{{end}}
+{{if .SignedIn}}
+<form method="post" action="/signout" class="mt-8">
+	{{csrfInput}}
+	{{props | setIsSubmit | setText "Sign Out" | setColor "grey" | button }}
+</form>
+{{end}}
+`, struct {
+					Alert    *string
+					Rows     []connectedServiceRow
+					SignedIn bool
+				}{
+					Alert:    v.ReadAlert(),
+					Rows:     rows,
+					SignedIn: v.UserAccountKey() != nil,
+				})
+		},
+	)
+}
+
+func disconnectServiceHandle(provider OAuthProvider) func(ctx context.Context, v *Viewer, w http.ResponseWriter, r *http.Request) {
+	return func(ctx context.Context, v *Viewer, w http.ResponseWriter, r *http.Request) {
+		if v.sess == nil {
+			http.Redirect(w, r, "/connected-services", http.StatusFound)
+			return
+		}
+
+		err := DisconnectOAuthProvider(ctx, provider, v.sess)
+		if err != nil {
+			log.Warningf(ctx, "Could not disconnect %s: %v", provider.Name(), err)
+			v.SetAlert(err.Error())
+		}
+
+		http.Redirect(w, r, "/connected-services", http.StatusFound)
+	}
+}
@@ -1,16 +1,16 @@
 package main
 
 import (
+	"context"
 	"net/http"
 
 	"github.com/gorilla/mux"
-	"google.golang.org/appengine"
 )
 
 // AddFeedPostsRoutes adds routes for posts’ RSS/Atom feeds
 func AddFeedPostsRoutes(r *mux.Router) {
 	r.Path("/1/org:{orgSlug}/channel:{channelSlug}/posts.rss").Methods("GET").
-		HandlerFunc(listPostsRSSInChannelHandle)
+		HandlerFunc(WithViewer(listPostsRSSInChannelHandle))
 }
 
 type postsFeedURLMaker struct {
@@ -35,8 +35,10 @@ func schemeForRequest(r *http.Request) string {
 	return "https"
 }
 
-func listPostsRSSInChannelHandle(w http.ResponseWriter, r *http.Request) {
-	ctx := appengine.NewContext(r)
+// listPostsRSSInChannelHandle signs links to stored content for those signed in, though they expire within two hours.
+// Feed readers without a session are given links that need signing in.
+func listPostsRSSInChannelHandle(ctx context.Context, v *Viewer, w http.ResponseWriter, r *http.Request) {
+	ctx = ContextSigningStorageURLsFor(ctx, v)
 	vars := routeVarsFrom(r)
 
 	orgRepo := NewOrgRepo(ctx, vars.orgSlug())
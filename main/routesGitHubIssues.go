@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"html/template"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// AddGitHubIssuesRoutes adds routes for turning posts into GitHub issues
+func AddGitHubIssuesRoutes(r *mux.Router) {
+	r.Path("/1/org:{orgSlug}/channel:{channelSlug}/posts/{postID}/githubIssue").Methods("GET").
+		HandlerFunc(WithViewer(getGitHubIssueForPostHandle))
+	r.Path("/1/org:{orgSlug}/channel:{channelSlug}/posts/{postID}/githubIssue").Methods("POST").
+		HandlerFunc(WithCSRFHeader(WithViewer(createGitHubIssueFromPostHandle)))
+
+	r.Path("/org:{orgSlug}/channel:{channelSlug}/posts/{postID}/githubIssue").Methods("POST").
+		HandlerFunc(WithCSRF(WithViewerInSession(createGitHubIssueFromPostHTMLHandle)))
+}
+
+func getGitHubIssueForPostHandle(ctx context.Context, v *Viewer, w http.ResponseWriter, r *http.Request) {
+	vars := routeVarsFrom(r)
+
+	orgRepo := NewOrgRepo(ctx, vars.orgSlug())
+	channelsRepo := NewChannelsRepo(ctx, orgRepo)
+
+	post, err := channelsRepo.GetPostWithIDInChannel(vars.channelSlug(), vars.postID())
+	if err != nil {
+		writeErrorJSONWithStatus(w, http.StatusNotFound, err)
+		return
+	}
+
+	status, err := GetGitHubIssueStatusForPost(ctx, v.GetGitHubClient(), post)
+	if err != nil {
+		writeErrorJSONWithStatus(w, http.StatusBadGateway, err)
+		return
+	}
+	if status == nil {
+		writeErrorJSONWithStatus(w, http.StatusNotFound, errPostHasNoGitHubIssue)
+		return
+	}
+
+	writeJSON(w, status)
+}
+
+type createGitHubIssueFromPostBody struct {
+	Repo string `json:"repo"`
+}
+
+func createGitHubIssueFromPostHandle(ctx context.Context, v *Viewer, w http.ResponseWriter, r *http.Request) {
+	vars := routeVarsFrom(r)
+
+	orgRepo := NewOrgRepo(ctx, vars.orgSlug())
+	channelsRepo := NewChannelsRepo(ctx, orgRepo)
+
+	var body createGitHubIssueFromPostBody
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		writeErrorJSONWithStatus(w, http.StatusBadRequest, err)
+		return
+	}
+
+	client := v.GetGitHubClient()
+	if client == nil {
+		writeErrorJSONWithStatus(w, http.StatusUnauthorized, errSignInWithGitHub)
+		return
+	}
+
+	post, status, err := CreateGitHubIssueFromPost(ctx, channelsRepo, client, CreateGitHubIssueFromPostInput{
+		ChannelSlug:    vars.channelSlug(),
+		PostKeyEncoded: vars.postID(),
+		Repo:           body.Repo,
+	})
+	if err != nil {
+		writeErrorJSONWithStatus(w, http.StatusBadRequest, err)
+		return
+	}
+
+	writeJSON(w, &struct {
+		Post        *Post              `json:"post"`
+		GitHubIssue *GitHubIssueStatus `json:"githubIssue"`
+	}{
+		Post:        post,
+		GitHubIssue: status,
+	})
+}
+
+func createGitHubIssueFromPostHTMLHandle(ctx context.Context, v *Viewer, w http.ResponseWriter, r *http.Request) {
+	vars := routeVarsFrom(r)
+	channelViewModel := vars.ToChannelViewModel()
+
+	orgRepo := NewOrgRepo(ctx, vars.orgSlug())
+	channelsRepo := NewChannelsRepo(ctx, orgRepo)
+
+	_, _, err := CreateGitHubIssueFromPost(ctx, channelsRepo, v.GetGitHubClient(), CreateGitHubIssueFromPostInput{
+		ChannelSlug:    vars.channelSlug(),
+		PostKeyEncoded: vars.postID(),
+		Repo:           r.PostFormValue("repo"),
+	})
+	if err != nil {
+		v.SetAlertFor(postFormAlert("githubIssue", vars.postID()), err.Error())
+	}
+
+	http.Redirect(w, r, channelViewModel.HTMLPostURL(vars.postID()), http.StatusFound)
+}
+
+var gitHubIssueForPostTemplate = template.Must(template.New("gitHubIssueForPost").Parse(`
+{{if .Status}}
+<div class="my-4 p-4 bg-white border-t-2 border-grey-darkest rounded-sm">
+	<h3 class="mb-2">GitHub Issue</h3>
+	{{with .Status}}
+	<p>
+		<a href="{{.URL}}" class="text-blue-dark">{{.Repo}}#{{.Number}}</a>
+		{{if .State}}<span class="ml-1 px-2 py-1 text-sm font-bold rounded {{if eq .State "open"}}text-white bg-green-dark{{else}}text-white bg-red-dark{{end}}">{{.State}}</span>{{end}}
+		{{if .Title}}<span class="ml-1">{{.Title}}</span>{{end}}
+	</p>
+	{{end}}
+	{{if .Error}}<p class="mt-2 text-red-dark">Could not load the issue’s state: {{.Error}}</p>{{end}}
+</div>
+{{else if .CanCreate}}
+<form method="post" action="{{.ActionURL}}" class="my-4 p-4 bg-white border-t-2 border-grey-darkest rounded-sm">
+	<input type="hidden" name="csrfToken" value="{{.CSRFToken}}">
+	<h3 class="mb-2">GitHub Issue</h3>
+	{{if .Alert}}
+	<p class="px-3 py-2 bg-white border-t-4 border-red rounded-sm shadow"><span class="text-red-dark">Error: </span>{{.Alert}}</p>
+	{{end}}
+	<label class="block my-2">
+		<span class="font-bold">Repo</span>
+		<input name="repo" placeholder="owner/name" class="block w-full mt-1 p-2 font-mono bg-grey-lightest border border-grey rounded shadow-inner">
+	</label>
+	<div class="flex flex-row-reverse">
+		<button type="submit" class="mt-2 px-4 py-2 font-bold text-white bg-grey-darkest border border-grey-darkest rounded shadow">Create Issue</button>
+	</div>
+</form>
+{{end}}
+`))
+
+func viewGitHubIssueForPostHTML(ctx context.Context, v *Viewer, post Post, m ChannelViewModel, w *bufio.Writer) {
+	client := v.GetGitHubClient()
+
+	status, err := GetGitHubIssueStatusForPost(ctx, client, &post)
+
+	var errorMessage string
+	if err != nil {
+		errorMessage = err.Error()
+	}
+
+	// Read even when the form is not shown, so an alert for an issue since created is not kept
+	alert := v.ReadAlertFor(postFormAlert("githubIssue", post.Key.Encode()))
+
+	gitHubIssueForPostTemplate.Execute(w, &struct {
+		ActionURL string
+		CSRFToken string
+		Alert     *string
+		CanCreate bool
+		Status    *GitHubIssueStatus
+		Error     string
+	}{
+		ActionURL: m.HTMLPostURL(post.Key.Encode()) + "/githubIssue",
+		CSRFToken: v.CSRFToken(),
+		Alert:     alert,
+		CanCreate: client != nil,
+		Status:    status,
+		Error:     errorMessage,
+	})
+}
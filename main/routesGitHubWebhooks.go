@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+)
+
+// GitHub caps webhook payloads at 25 MB
+const maxGitHubWebhookPayloadSize = 25 << 20
+
+var errGitHubWebhooksNotSignedIn = errors.New("Sign in to manage GitHub webhooks")
+
+// AddGitHubWebhooksRoutes adds routes for managing GitHub webhook integrations, and for GitHub to deliver to.
+// Managing needs a signed in account and X-CSRF-Token, as an integration’s secret lets anyone post into its channel.
+// Deliveries are only checked by their signature.
+func AddGitHubWebhooksRoutes(r *mux.Router) {
+	r.Path("/1/org:{orgSlug}/githubWebhooks").Methods("GET").
+		HandlerFunc(WithCSRFHeader(WithViewer(listGitHubWebhookIntegrationsHandle)))
+	r.Path("/1/org:{orgSlug}/githubWebhooks").Methods("POST").
+		HandlerFunc(WithCSRFHeader(WithViewer(createGitHubWebhookIntegrationHandle)))
+	r.Path("/1/org:{orgSlug}/githubWebhooks/{integrationID}").Methods("DELETE").
+		HandlerFunc(WithCSRFHeader(WithViewer(deleteGitHubWebhookIntegrationHandle)))
+
+	r.Path("/github/webhooks/{integrationID}").Methods("POST").
+		HandlerFunc(receiveGitHubWebhookHandle)
+}
+
+func gitHubWebhookDeliveryPath(integration *GitHubWebhookIntegration) string {
+	return "/github/webhooks/" + integration.Key.Encode()
+}
+
+func listGitHubWebhookIntegrationsHandle(ctx context.Context, v *Viewer, w http.ResponseWriter, r *http.Request) {
+	if v.UserAccountKey() == nil {
+		writeErrorJSONWithStatus(w, http.StatusUnauthorized, errGitHubWebhooksNotSignedIn)
+		return
+	}
+
+	vars := routeVarsFrom(r)
+
+	orgRepo := NewOrgRepo(ctx, vars.orgSlug())
+	channelsRepo := NewChannelsRepo(ctx, orgRepo)
+
+	integrations, err := channelsRepo.ListGitHubWebhookIntegrations()
+	if err != nil {
+		writeErrorJSON(w, err)
+		return
+	}
+
+	writeJSON(w, integrations)
+}
+
+type createGitHubWebhookIntegrationBody struct {
+	ChannelSlug string   `json:"channelSlug"`
+	Events      []string `json:"events"`
+	Secret      string   `json:"secret"`
+}
+
+func createGitHubWebhookIntegrationHandle(ctx context.Context, v *Viewer, w http.ResponseWriter, r *http.Request) {
+	if v.UserAccountKey() == nil {
+		writeErrorJSONWithStatus(w, http.StatusUnauthorized, errGitHubWebhooksNotSignedIn)
+		return
+	}
+
+	vars := routeVarsFrom(r)
+
+	orgRepo := NewOrgRepo(ctx, vars.orgSlug())
+	channelsRepo := NewChannelsRepo(ctx, orgRepo)
+
+	var body createGitHubWebhookIntegrationBody
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		writeErrorJSONWithStatus(w, http.StatusBadRequest, err)
+		return
+	}
+
+	integration, err := channelsRepo.CreateGitHubWebhookIntegration(CreateGitHubWebhookIntegrationInput{
+		ChannelSlug: body.ChannelSlug,
+		Events:      body.Events,
+		Secret:      body.Secret,
+	})
+	if err != nil {
+		writeErrorJSONWithStatus(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// The secret is only ever shown here, for pasting into GitHub’s webhook settings
+	writeJSON(w, &struct {
+		*GitHubWebhookIntegration
+		Secret      string `json:"secret"`
+		PayloadURL  string `json:"payloadURL"`
+		ContentType string `json:"contentType"`
+	}{
+		GitHubWebhookIntegration: integration,
+		Secret:                   integration.Secret,
+		PayloadURL:               absoluteHTMLURL(ctx, gitHubWebhookDeliveryPath(integration)),
+		ContentType:              "application/json",
+	})
+}
+
+func deleteGitHubWebhookIntegrationHandle(ctx context.Context, v *Viewer, w http.ResponseWriter, r *http.Request) {
+	if v.UserAccountKey() == nil {
+		writeErrorJSONWithStatus(w, http.StatusUnauthorized, errGitHubWebhooksNotSignedIn)
+		return
+	}
+
+	vars := routeVarsFrom(r)
+
+	orgRepo := NewOrgRepo(ctx, vars.orgSlug())
+	channelsRepo := NewChannelsRepo(ctx, orgRepo)
+
+	err := channelsRepo.DeleteGitHubWebhookIntegration(vars.integrationID())
+	if err != nil {
+		writeErrorJSONWithStatus(w, http.StatusBadRequest, err)
+		return
+	}
+
+	writeJSON(w, "success")
+}
+
+func receiveGitHubWebhookHandle(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+	vars := routeVarsFrom(r)
+
+	integration, err := GetGitHubWebhookIntegration(ctx, vars.integrationID())
+	if err != nil {
+		writeErrorJSONWithStatus(w, http.StatusNotFound, err)
+		return
+	}
+
+	payload, err := ioutil.ReadAll(io.LimitReader(r.Body, maxGitHubWebhookPayloadSize+1))
+	if err != nil {
+		writeErrorJSONWithStatus(w, http.StatusBadRequest, err)
+		return
+	}
+	if len(payload) > maxGitHubWebhookPayloadSize {
+		writeErrorJSONWithStatus(w, http.StatusRequestEntityTooLarge, fmt.Errorf("Payload is larger than %d bytes", maxGitHubWebhookPayloadSize))
+		return
+	}
+
+	err = integration.VerifySignature(r.Header.Get("X-Hub-Signature-256"), payload)
+	if err != nil {
+		log.Warningf(ctx, "GitHub webhook delivery %s to %s: %v", r.Header.Get("X-GitHub-Delivery"), vars.integrationID(), err)
+		writeErrorJSONWithStatus(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	event := r.Header.Get("X-GitHub-Event")
+	if event == "ping" {
+		writeJSON(w, &struct {
+			Pong bool `json:"pong"`
+		}{
+			Pong: true,
+		})
+		return
+	}
+
+	deliveryID := r.Header.Get("X-GitHub-Delivery")
+	if deliveryID == "" {
+		writeErrorJSONWithStatus(w, http.StatusBadRequest, errors.New("Missing X-GitHub-Delivery header"))
+		return
+	}
+
+	// Recorded before posting, so a delivery GitHub retries is only posted once
+	err = RecordGitHubWebhookDelivery(ctx, integration, deliveryID)
+	if err == errGitHubWebhookDeliveryReceived {
+		writeJSON(w, &struct {
+			Duplicate string `json:"duplicate"`
+		}{
+			Duplicate: deliveryID,
+		})
+		return
+	}
+	if err != nil {
+		writeErrorJSONWithStatus(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	post, err := HandleGitHubWebhookDelivery(ctx, integration, event, payload)
+	if err != nil {
+		log.Errorf(ctx, "GitHub webhook delivery %s: %v", deliveryID, err)
+		if forgetErr := ForgetGitHubWebhookDelivery(ctx, integration, deliveryID); forgetErr != nil {
+			log.Errorf(ctx, "Could not forget GitHub webhook delivery %s: %v", deliveryID, forgetErr)
+		}
+		writeErrorJSONWithStatus(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if post == nil {
+		writeJSONWithStatus(w, http.StatusAccepted, &struct {
+			Ignored string `json:"ignored"`
+		}{
+			Ignored: event,
+		})
+		return
+	}
+
+	writeJSON(w, post)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
 	"html/template"
 	"io"
@@ -20,7 +21,7 @@ const (
 )
 
 func viewErrorMessage(errorMessage string, w *bufio.Writer) {
-	w.WriteString(`<p class="py-1 px-2 bg-white text-red">` + errorMessage + "</p>")
+	executeHTMLPartial(w, "errorMessage", errorMessage)
 }
 
 type htmlHandlerOptions struct {
@@ -34,12 +35,22 @@ func writeDynamicElementsScript(w http.ResponseWriter, dynamicElementsEnabled ma
 		return
 	}
 
+	data := struct {
+		Enabled   map[string]bool
+		CSPNonce  string
+		CSRFToken string
+	}{
+		Enabled:   dynamicElementsEnabled,
+		CSPNonce:  cspNonceFromWriter(w),
+		CSRFToken: csrfTokenFromWriter(w),
+	}
+
 	t := template.Must(template.New("dynamicElementsScript").Parse(`
-<script>
+<script nonce="{{.CSPNonce}}">
 document.addEventListener("DOMContentLoaded", () => {
 const app = Stimulus.Application.start();
 
-{{if .posts}}
+{{if .Enabled.posts}}
 app.register('posts', class extends Stimulus.Controller {
 	static get targets() {
 		return [ 'post', 'replyHolder' ];
@@ -73,7 +84,7 @@ app.register('posts', class extends Stimulus.Controller {
 	}
 });
 {{end}}
-{{if .developer}}
+{{if .Enabled.developer}}
 app.register('developer', class extends Stimulus.Controller {
 	static get targets() {
 		return [ 'queryCode' ];
@@ -85,6 +96,11 @@ app.register('developer', class extends Stimulus.Controller {
 		resultEl.textContent = "Loading…";
 		fetch('/graphql', {
 			method: 'POST',
+			credentials: 'same-origin',
+			headers: {
+				'Content-Type': 'application/json',
+				'X-CSRF-Token': {{.CSRFToken}}
+			},
 			body: JSON.stringify({
 				query: queryCodeEl.textContent
 			})
@@ -101,62 +117,29 @@ app.register('developer', class extends Stimulus.Controller {
 </script>
 `))
 
-	t.Execute(w, dynamicElementsEnabled)
+	t.Execute(w, data)
 }
 
-// WithHTMLHeaders adds HTTP headers
+// WithHTMLHeaders adds HTTP and security headers, and checks posted forms with WithCSRF
 func WithHTMLHeaders(f http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		header := w.Header()
 		header.Set("Content-Type", "text/html; charset=utf-8")
 		header.Set("X-Content-Type-Options", "nosniff")
 
-		f(w, r)
+		WithCSRF(f)(setHTMLSecurityHeaders(w, r), r)
 	})
 }
 
-func htmlHeadStart(w io.Writer) {
-	io.WriteString(w, `<!doctype html>
-<html>
-<head>
-<meta charset="utf-8">
-<meta name="viewport" content="width=device-width, initial-scale=1">
-`)
-
-	io.WriteString(w, assetsHeadHTML)
-
-}
-
-func htmlHeadEndBodyStart(w io.Writer, options struct{ bodyClass string }) {
-	io.WriteString(w, `
-<style>
-.grid-1\/3-2\/3 {
-	display: grid;
-	grid-template-columns: 33.333% 66.667%;
+// htmlLayoutStart writes the document head and opens the body
+func htmlLayoutStart(w io.Writer, layout htmlLayout) {
+	layout.AssetsHeadHTML = template.HTML(assetsHeadHTML)
+	executeHTMLPartial(w, "layoutStart", layout)
 }
-.grid-column-gap-1 {
-	grid-column-gap: 0.25rem;
-}
-.grid-row-gap-1 {
-	grid-row-gap: 0.25rem;
-}
-</style>
-`)
-
-	io.WriteString(w, `
-<script>
-window.collectedTasks = [];
-</script>
-`)
-
-	io.WriteString(w, `</head>`)
 
-	io.WriteString(w, `<body class="`+options.bodyClass+`">`)
-}
-
-func htmlBodyEnd(w io.Writer) {
-	io.WriteString(w, assetsBeforeBodyCloseHTML)
-	io.WriteString(w, "</body></html>")
+// htmlLayoutEnd adds the scripts and closes the body
+func htmlLayoutEnd(w io.Writer) {
+	executeHTMLPartial(w, "layoutEnd", template.HTML(assetsBeforeBodyCloseHTML))
 }
 
 func WithHTMLTemplate(f http.HandlerFunc, options htmlHandlerOptions) http.HandlerFunc {
@@ -170,20 +153,18 @@ func WithHTMLTemplate(f http.HandlerFunc, options htmlHandlerOptions) http.Handl
 			formErr = r.ParseForm()
 		}
 
-		htmlHeadStart(w)
-		io.WriteString(w, "<title>Collected</title>")
-		htmlHeadEndBodyStart(w, struct{ bodyClass string }{bodyClass: ""})
+		htmlLayoutStart(w, htmlLayout{Title: "Collected", BodyClass: options.bodyClass, CSPNonce: cspNonceFromWriter(w)})
 
 		if formErr != nil {
 			w.WriteHeader(400)
-			io.WriteString(w, "Invalid form request: "+formErr.Error())
+			executeHTMLPartial(w, "errorMessage", "Invalid form request: "+formErr.Error())
 		} else {
 			f(w, r)
 		}
 
 		writeDynamicElementsScript(w, options.dynamicElementsEnabled)
 
-		htmlBodyEnd(w)
+		htmlLayoutEnd(w)
 	}))
 }
 
@@ -197,6 +178,8 @@ type viewSectionWriter struct {
 	outerTagName string
 	outerClasses []string
 	innerClasses []string
+	// csrfToken is added to forms by the csrfInput template func
+	csrfToken string
 }
 
 func (section *viewSectionWriter) class(class string) *viewSectionWriter {
@@ -213,12 +196,17 @@ func (section *viewSectionWriter) innerSlim() *viewSectionWriter {
 	return section.innerClass("max-w-md mx-auto")
 }
 
+// write renders the section partial around the HTML written by f, which must escape what it writes
 func (section *viewSectionWriter) write(f func(w io.Writer)) {
-	section.w.WriteString(`<` + section.outerTagName + ` class="` + strings.Join(section.outerClasses, " ") + `">`)
-	section.w.WriteString(`<div class="` + strings.Join(section.innerClasses, " ") + `">`)
-	f(section.w)
-	section.w.WriteString("</div>")
-	section.w.WriteString(`</` + section.outerTagName + `>`)
+	var content bytes.Buffer
+	f(&content)
+
+	executeHTMLPartial(section.w, "section", htmlSection{
+		OuterTagName: section.outerTagName,
+		OuterClass:   strings.Join(section.outerClasses, " "),
+		InnerClass:   strings.Join(section.innerClasses, " "),
+		Content:      template.HTML(content.String()),
+	})
 }
 
 func (section *viewSectionWriter) writeHTMLString(html string) {
@@ -261,9 +249,11 @@ func (section *viewSectionWriter) writeTemplate(source string, data interface{})
 				buttonType = "button"
 			}
 
-			return template.HTML(`
-<button type="` + buttonType + `" class="mt-2 px-4 py-2 font-bold text-white bg-` + color + `-dark border border-` + color + `-darker rounded shadow no-underline hover:bg-` + color + ` hover:border-` + color + `-dark">` + text + `</button>
-`)
+			return renderHTMLPartial("button", htmlButtonProps{
+				Text:       text,
+				Color:      color,
+				ButtonType: buttonType,
+			})
 		},
 		"buttonLink": func(props map[string]interface{}) template.HTML {
 			url := props["URL"].(string)
@@ -273,9 +263,11 @@ func (section *viewSectionWriter) writeTemplate(source string, data interface{})
 			}
 			text := props["Text"].(string)
 
-			return template.HTML(`
-<a href="` + url + `" class="mt-2 px-4 py-2 font-bold text-white bg-` + color + `-dark border border-` + color + `-darker rounded shadow no-underline hover:bg-` + color + ` hover:border-` + color + `-dark">` + text + `</a>
-`)
+			return renderHTMLPartial("buttonLink", htmlButtonProps{
+				Text:  text,
+				Color: color,
+				URL:   url,
+			})
 		},
 		"setInputFormName": func(name string, props map[string]interface{}) map[string]interface{} {
 			props["InputFormName"] = name
@@ -289,35 +281,37 @@ func (section *viewSectionWriter) writeTemplate(source string, data interface{})
 			inputFormName := props["InputFormName"].(string)
 			label := props["Label"].(string)
 
-			return template.HTML(`
-<label class="block my-2">
-	<span class="font-bold">` + label + `</span>
-	<input name="` + inputFormName + `" class="block w-full mt-1 p-2 bg-grey-lightest border border-grey rounded shadow-inner">
-</label>
-`)
+			return renderHTMLPartial("field", htmlFieldProps{
+				Label:         label,
+				InputFormName: inputFormName,
+			})
+		},
+		// csrfInput must be added once to each form that is posted
+		"csrfInput": func() template.HTML {
+			return renderHTMLPartial("csrfInput", section.csrfToken)
 		},
 	})
 
 	t = template.Must(t.Parse(source))
 
 	section.write(func(w io.Writer) {
-		t.Execute(section.w, data)
+		t.Execute(w, data)
 	})
 }
 
 // ViewPage renders a naked HTML page with provided main content
 func (vm ViewModel) ViewPage(w io.Writer, viewHeader func(addSection func(outerTagName string) *viewSectionWriter), viewMainContent func(addSection func(outerTagName string) *viewSectionWriter)) {
+	csrfToken := csrfTokenFromWriter(w)
 	bw := bufio.NewWriter(w)
 	defer bw.Flush()
 
-	htmlHeadStart(bw)
-	io.WriteString(bw, "<title>"+template.HTMLEscapeString(vm.Title)+"</title>")
-	htmlHeadEndBodyStart(bw, struct{ bodyClass string }{bodyClass: ""})
+	htmlLayoutStart(bw, htmlLayout{Title: vm.Title, CSPNonce: cspNonceFromWriter(w)})
 
 	addSection := func(outerTagName string) *viewSectionWriter {
 		return &viewSectionWriter{
 			w:            bw,
 			outerTagName: outerTagName,
+			csrfToken:    csrfToken,
 		}
 	}
 
@@ -327,7 +321,7 @@ func (vm ViewModel) ViewPage(w io.Writer, viewHeader func(addSection func(outerT
 	viewMainContent(addSection)
 	bw.WriteString(`</main>`)
 
-	htmlBodyEnd(bw)
+	htmlLayoutEnd(bw)
 }
 
 // OrgViewModel models viewing an org
@@ -346,17 +340,7 @@ func (m OrgViewModel) HTMLChannelsURL() string {
 }
 
 func (m OrgViewModel) viewNav(w *bufio.Writer) {
-	t := template.Must(template.New("nav").Parse(`
-<nav class="text-white bg-black">
-<div class="max-w-md mx-auto flex flex-col sm:flex-row items-center sm:items-start leading-normal">
-<strong class="py-1">
-	<a href="{{.HTMLURL}}" class="no-underline hover:underline text-white">{{.OrgSlug}}</a>
-</strong>
-</div>
-</nav>
-`))
-
-	t.Execute(w, m)
+	executeHTMLPartial(w, "orgNav", m)
 }
 
 // ViewPage renders a page with navigation and provided main content
@@ -412,18 +396,13 @@ func (m ChannelViewModel) HTMLPostChildPostsURL(postID string) string {
 
 // ViewHeader renders the nav for a channel
 func (m ChannelViewModel) ViewHeader(fontSize string, w *bufio.Writer) {
-	w.WriteString(fmt.Sprintf(`
-<header class="pt-4 pb-3 bg-indigo-darker">
-	<div class="max-w-md mx-auto">
-		<div class="mx-2 md:mx-0 flex flex-wrap flex-col sm:flex-row items-center sm:items-start sm:justify-between">
-			<h1 class="%s min-w-full sm:min-w-0 mb-2 sm:mb-0">
-				<a href="%s" class="text-white no-underline hover:underline">💬 %s</a>
-			</h1>
-			<input type="search" placeholder="Search %s" class="w-64 px-2 py-2 bg-indigo rounded">
-		</div>
-	</div>
-</header>
-`, fontSize, m.HTMLPostsURL(), m.ChannelSlug, m.ChannelSlug))
+	executeHTMLPartial(w, "channelHeader", struct {
+		FontSize string
+		Channel  ChannelViewModel
+	}{
+		FontSize: fontSize,
+		Channel:  m,
+	})
 }
 
 // ViewPage renders a page with navigation and provided main content
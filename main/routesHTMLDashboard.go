@@ -61,11 +61,12 @@ func showDashboardHTMLViewMain(v *Viewer, vm *ViewModel, addSection func(outerTa
 		innerClass("mt-16 border-b border-purple").
 		writeHTMLString("")
 
-	addSection("section").
+	createSection := addSection("section").
 		class("mt-16").
-		innerSlim().
-		writeHTMLString(`
+		innerSlim()
+	createSection.writeHTMLString(`
 <form method="post" action="/org" class="my-4">
+	` + csrfInputHTML(createSection.csrfToken) + `
 	<h2 class="text-purple-dark">Create your team</h2>
 	<label class="block my-2">
 		<span class="font-bold">Team name</span>
@@ -93,6 +94,7 @@ func showDashboardHTMLHandle(ctx context.Context, v *Viewer, w http.ResponseWrit
 	{{if .GetGitHubClient}}
 		<article class="px-4 py-3 bg-white border border-grey-lighter rounded">
 			<p class="text-lg">Signed into GitHub</p>
+			<a href="/connected-services" class="text-sm">Connected services</a>
 		</article>
 	{{else}}
 		{{props | setURL "/signin/github" | setText "Sign in with GitHub" | setColor "purple" | buttonLink }}
@@ -123,6 +125,7 @@ func showDashboardHTMLHandle(ctx context.Context, v *Viewer, w http.ResponseWrit
 				innerSlim().
 				writeTemplate(`
 <form method="post" action="/org" class="my-4">
+	{{csrfInput}}
 	<h2 class="text-purple-dark">Create your team</h2>
 	{{props | setInputFormName "orgSlug" | setLabel "Team name" | fieldWithLabel }}
 	{{props | setIsSubmit | setText "Create Team" | setColor "purple" | button }}
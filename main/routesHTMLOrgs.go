@@ -17,11 +17,12 @@ func AddHTMLOrgsRoutes(r *mux.Router) {
 	r.Path("/org:{orgSlug}").Methods("GET").
 		HandlerFunc(WithHTMLTemplate(WithViewer(showOrgHTMLHandle), htmlHandlerOptions{}))
 	r.Path("/org:{orgSlug}/channels").Methods("POST").
-		HandlerFunc(WithViewerInSession(createChannelInOrgHTMLHandle))
+		HandlerFunc(WithCSRF(WithViewerInSession(createChannelInOrgHTMLHandle)))
 }
 
 func showOrgHTMLHandle(ctx context.Context, v *Viewer, w http.ResponseWriter, r *http.Request) {
 	orgViewModel := routeVarsFrom(r).ToOrgViewModel()
+	csrfToken := csrfTokenFromWriter(w)
 
 	orgRepo := NewOrgRepo(ctx, orgViewModel.OrgSlug)
 	channelsRepo := NewChannelsRepo(ctx, orgRepo)
@@ -37,10 +38,7 @@ func showOrgHTMLHandle(ctx context.Context, v *Viewer, w http.ResponseWriter, r
 			sw.WriteString(`<h2>Channels</h2>`)
 			sw.WriteString(`<ul class="list-reset mt-4 rounded shadow">`)
 			err := channelsConnections.Enumerate(func(channel ChannelContent) {
-				channelViewModel := orgViewModel.Channel(channel.Slug)
-
-				sw.WriteString(`<li class="text-xl">
-				<a href="` + channelViewModel.HTMLPostsURL() + `" class="block px-3 py-2 no-underline text-indigo-dark bg-white hover:text-white hover:bg-indigo">#` + channel.Slug + `</a></li>`)
+				executeHTMLPartial(sw, "channelListItem", orgViewModel.Channel(channel.Slug))
 			})
 			sw.WriteString(`</ul>`)
 			if err != nil {
@@ -55,19 +53,16 @@ func showOrgHTMLHandle(ctx context.Context, v *Viewer, w http.ResponseWriter, r
 
 			alert := v.ReadAlert()
 			if alert != nil {
-				sw.WriteString(`<p class="px-3 py-2 bg-white border-t-4 border-red rounded-sm shadow"><span class="text-red-dark">Error: </span>` + *alert + `</p>`)
+				executeHTMLPartial(sw, "alert", *alert)
 			}
 
-			sw.WriteString(`
-<form method="post" action="` + orgViewModel.HTMLChannelsURL() + `" class="my-4">
-<h2>New Channel</h2>
-<label class="block my-2">
-	Slug
-	<input name="channelSlug" placeholder="e.g. design, engineering, marketing" class="block w-full mt-1 p-2 bg-white border border-grey rounded shadow-inner">
-</label>
-<button type="submit" class="mt-2 px-4 py-2 font-bold text-white bg-indigo-darker border border-indigo-darker rounded shadow">Create Channel</button>
-</form>
-`)
+			executeHTMLPartial(sw, "newChannelForm", struct {
+				Org       OrgViewModel
+				CSRFToken string
+			}{
+				Org:       orgViewModel,
+				CSRFToken: csrfToken,
+			})
 			sw.WriteString(`</div>`)
 		})
 	})
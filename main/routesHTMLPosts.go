@@ -2,22 +2,17 @@ package main
 
 import (
 	"bufio"
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
-	"log"
 	"net/http"
 	"strings"
 	"time"
 
 	"golang.org/x/net/html"
 	"html/template"
-	textTemplate "text/template"
 
 	"github.com/gorilla/mux"
-	"google.golang.org/appengine"
 )
 
 // AddHTMLPostsRoutes adds user-facing routes for channel posts
@@ -25,13 +20,13 @@ func AddHTMLPostsRoutes(r *mux.Router) {
 	dynamicElementsEnabled := map[string]bool{"posts": true, "developer": true}
 
 	r.Path("/org:{orgSlug}/channel:{channelSlug}/posts").Methods("GET").
-		HandlerFunc(WithHTMLTemplate(listPostsInChannelHTMLHandle, htmlHandlerOptions{dynamicElementsEnabled: dynamicElementsEnabled}))
+		HandlerFunc(WithHTMLTemplate(WithViewer(listPostsInChannelHTMLHandle), htmlHandlerOptions{dynamicElementsEnabled: dynamicElementsEnabled}))
 	r.Path("/org:{orgSlug}/channel:{channelSlug}/posts/{postID}").Methods("GET").
 		HandlerFunc(WithHTMLTemplate(WithViewerInSession(showPostInChannelHTMLHandle), htmlHandlerOptions{dynamicElementsEnabled: dynamicElementsEnabled}))
 	r.Path("/org:{orgSlug}/channel:{channelSlug}/posts").Methods("POST").
-		HandlerFunc(WithHTMLTemplate(createPostInChannelHTMLHandle, htmlHandlerOptions{form: true, dynamicElementsEnabled: dynamicElementsEnabled}))
+		HandlerFunc(WithHTMLTemplate(WithViewer(createPostInChannelHTMLHandle), htmlHandlerOptions{form: true, dynamicElementsEnabled: dynamicElementsEnabled}))
 	r.Path("/org:{orgSlug}/channel:{channelSlug}/posts/{postID}/posts").Methods("POST").
-		HandlerFunc(WithHTMLTemplate(createPostInChannelHTMLHandle, htmlHandlerOptions{form: true, dynamicElementsEnabled: dynamicElementsEnabled}))
+		HandlerFunc(WithHTMLTemplate(WithViewer(createPostInChannelHTMLHandle), htmlHandlerOptions{form: true, dynamicElementsEnabled: dynamicElementsEnabled}))
 }
 
 func htmlError(err error) template.HTML {
@@ -39,26 +34,6 @@ func htmlError(err error) template.HTML {
 }
 
 func makeViewPostTemplate(ctx context.Context, m ChannelViewModel, commandParamVars CommandParamVariables) *template.Template {
-	postsPreprocessCommandParams := func(params string) (string, error) {
-		t := textTemplate.New("commandParams")
-		t, err := t.Parse(params)
-		if err != nil {
-			return "", err
-		}
-
-		log.Println("commandParamVars")
-		log.Println(commandParamVars.GitHubOAuthToken())
-
-		var buffer bytes.Buffer
-		err = t.Execute(&buffer, commandParamVars)
-		if err != nil {
-			return "", err
-		}
-
-		// gitHubClient := GetGitHubClientFromSession(ctx, sess)
-		return buffer.String(), nil
-	}
-
 	t := template.New("post").Funcs(template.FuncMap{
 		"postURL": func(postID string) string {
 			return m.HTMLPostURL(postID)
@@ -67,7 +42,7 @@ func makeViewPostTemplate(ctx context.Context, m ChannelViewModel, commandParamV
 			return m.HTMLPostChildPostsURL(postID)
 		},
 		"formatMarkdown": func(markdownSource string) string {
-			return strings.TrimSpace(markdownSource)
+			return signStorageURLsIn(ctx, strings.TrimSpace(markdownSource))
 		},
 		"formatTimeRFC3339": func(t time.Time) string {
 			return t.Format(time.RFC3339)
@@ -89,21 +64,15 @@ func makeViewPostTemplate(ctx context.Context, m ChannelViewModel, commandParamV
 						return template.HTML(`<div class="p-2 border-t-2 border-purple bg-purple-lightest rounded-sm"><pre>` + html.EscapeString(string(responseJSON)) + `</pre></div>`)
 					}
 				} else {
-					command, err := ParseCommandInput(post.Content.Source, postsPreprocessCommandParams)
-					if err == nil {
-						result, err := command.Run(ctx)
-						wantsFullWidth := result.WantsFullWidth()
-						if err != nil {
-							return htmlError(err)
-						} else {
-							classes := ""
-							if !wantsFullWidth {
-								classes = "p-2 border-t-2 border-green bg-green-lightest rounded-sm"
-							}
-							return template.HTML(`<div class="` + classes + `">` + SafeHTMLForCommandResult(result) + `</div>`)
-						}
-					} else {
+					result, err := RunCommandInput(ctx, post.Content.Source, commandParamVars)
+					if err != nil {
 						return htmlError(err)
+					} else {
+						classes := ""
+						if !result.WantsFullWidth() {
+							classes = "p-2 border-t-2 border-green bg-green-lightest rounded-sm"
+						}
+						return template.HTML(`<div class="` + classes + `">` + SafeHTMLForCommandResult(result) + `</div>`)
 					}
 				}
 			}
@@ -226,25 +195,15 @@ func viewPostsInChannelHTMLHandle(ctx context.Context, posts []Post, m ChannelVi
 	}
 }
 
-func viewCreatePostFormInChannelHTMLHandle(channelViewModel ChannelViewModel, w *bufio.Writer) {
-	w.WriteString(`
-<form data-target="posts.createForm" method="post" action="` + channelViewModel.HTMLPostsURL() + `" class="my-4">
-<textarea
-	data-target="posts.mainTextarea"
-	data-action="input->posts#markdownInputChanged"
-	name="markdownSource"
-	rows="4"
-	placeholder="Write…"
-	class="block w-full p-2 bg-white border border-grey rounded-sm shadow focus:h-64 focus:shadow-lg"
-></textarea>
-<div class="flex flex-row-reverse">
-<button type="submit" name="action" value="submitPost" data-target="posts.submitPostButton" class="mt-2 px-4 py-2 font-bold text-white bg-indigo-darker border border-indigo-darker rounded shadow">Post</button>
-<button type="submit" name="action" value="runCommand" data-target="posts.runCommandButton" class="mt-2 px-4 py-2 font-bold text-green-dark bg-white border border-green-dark rounded shadow hidden">Run</button>
-<button type="submit" name="action" value="beginDraft" data-target="posts.beginDraftButton" class="mt-2 px-4 py-2 font-bold text-white bg-purple-dark border border-purple-dark rounded shadow hidden">Begin Draft</button>
-<button type="submit" name="action" value="runGraphQLQuery" data-target="posts.runGraphQLQueryButton" class="mt-2 px-4 py-2 font-bold text-white bg-pink-dark border border-pink-dark rounded shadow hidden">Run GraphQL Query</button>
-</div>
-</form>
-`)
+func viewCreatePostFormInChannelHTMLHandle(channelViewModel ChannelViewModel, csrfToken string, w *bufio.Writer) {
+	// Reply forms copy this form’s HTML, so they post the token too
+	executeHTMLPartial(w, "createPostForm", struct {
+		Channel   ChannelViewModel
+		CSRFToken string
+	}{
+		Channel:   channelViewModel,
+		CSRFToken: csrfToken,
+	})
 }
 
 func viewDeveloperSectionForPostsInChannelHTMLHandle(channelViewModel ChannelViewModel, w *bufio.Writer) {
@@ -269,25 +228,10 @@ func viewDeveloperSectionForPostsInChannelHTMLHandle(channelViewModel ChannelVie
 	}
 }`, "\t", "  ", -1)
 
-	w.WriteString(`
-<div data-controller="developer">
-<details class="mb-4 bg-indigo-darker">
-	<summary class="max-w-md mx-auto p-1 italic cursor-pointer text-center sm:text-right text-sm text-indigo-lighter bg-indigo-darker select-none">Developer</summary>
-	<div class="sm:max-h-screen overflow-auto bg-yellow-lightest">
-		<div class="flex flex-col sm:flex-row">
-			<pre class="sm:w-1/2 p-2 bg-indigo-lightest text-indigo-darkest"><code data-target="developer.queryCode">` + query + `</code></pre>
-			<div class="sm:w-1/2">
-				<button data-action="developer#runQuery" class="w-full mb-1 px-4 py-2 whitespace-pre-wrap break-words font-bold text-white bg-green-darker border border-green-darker">►</button>
-				<pre class="p-2 whitespace-pre-wrap break-words text-green-darkest"><code data-target="developer.result"></code></pre>
-			</div>
-		</div>
-	</div>
-</details>
-</div>
-`)
+	executeHTMLPartial(w, "developerSection", query)
 }
 
-func viewPostsInChannelHTMLPartial(ctx context.Context, errs []error, channelViewModel ChannelViewModel, posts []Post, viewSection func(wide bool, viewInner func(sw *bufio.Writer))) {
+func viewPostsInChannelHTMLPartial(ctx context.Context, errs []error, channelViewModel ChannelViewModel, csrfToken string, posts []Post, viewSection func(wide bool, viewInner func(sw *bufio.Writer))) {
 	viewSection(false, func(sw *bufio.Writer) {
 		for _, err := range errs {
 			viewErrorMessage(err.Error(), sw)
@@ -296,7 +240,7 @@ func viewPostsInChannelHTMLPartial(ctx context.Context, errs []error, channelVie
 		sw.WriteString(`<div data-controller="posts">`)
 
 		sw.WriteString(`<div class="mx-2 md:mx-0">`)
-		viewCreatePostFormInChannelHTMLHandle(channelViewModel, sw)
+		viewCreatePostFormInChannelHTMLHandle(channelViewModel, csrfToken, sw)
 		sw.WriteString(`</div>`)
 
 		sw.WriteString(`<div class="mb-6">`)
@@ -307,8 +251,8 @@ func viewPostsInChannelHTMLPartial(ctx context.Context, errs []error, channelVie
 	})
 }
 
-func listPostsInChannelHTMLHandle(w http.ResponseWriter, r *http.Request) {
-	ctx := appengine.NewContext(r)
+func listPostsInChannelHTMLHandle(ctx context.Context, v *Viewer, w http.ResponseWriter, r *http.Request) {
+	ctx = ContextSigningStorageURLsFor(ctx, v)
 	channelViewModel := routeVarsFrom(r).ToChannelViewModel()
 
 	orgRepo := NewOrgRepo(ctx, channelViewModel.Org.OrgSlug)
@@ -316,7 +260,7 @@ func listPostsInChannelHTMLHandle(w http.ResponseWriter, r *http.Request) {
 
 	posts, err := channelsRepo.ListPostsInChannel(channelViewModel.ChannelSlug)
 	if err != nil {
-		io.WriteString(w, "Error loading posts: "+err.Error())
+		executeHTMLPartial(w, "errorMessage", "Error loading posts: "+err.Error())
 		return
 	}
 
@@ -331,7 +275,7 @@ func listPostsInChannelHTMLHandle(w http.ResponseWriter, r *http.Request) {
 			})
 			return
 		}
-		viewPostsInChannelHTMLPartial(ctx, nil, channelViewModel, posts, viewSection)
+		viewPostsInChannelHTMLPartial(ctx, nil, channelViewModel, csrfTokenFromWriter(w), posts, viewSection)
 	})
 }
 
@@ -344,11 +288,14 @@ func showPostInChannelHTMLHandle(ctx context.Context, viewer *Viewer, w http.Res
 	post, err := channelsRepo.GetPostWithIDInChannel(vars.channelSlug(), vars.postID())
 	if err != nil {
 		w.WriteHeader(500)
-		io.WriteString(w, "Error loading post: "+err.Error())
+		executeHTMLPartial(w, "errorMessage", "Error loading post: "+err.Error())
 		return
 	}
 
 	commandParamsVars := viewer.GetCommandParamVariables()
+	// Commands such as /graphiql add inline scripts to the page
+	ctx = ContextWithCSPNonce(ctx, cspNonceFromWriter(w))
+	ctx = ContextSigningStorageURLsFor(ctx, viewer)
 
 	w.WriteHeader(200)
 
@@ -361,8 +308,14 @@ func showPostInChannelHTMLHandle(ctx context.Context, viewer *Viewer, w http.Res
 			viewPostInChannelHTMLHandle(ctx, *post, channelViewModel, commandParamsVars, sw)
 			sw.WriteString(`</div>`)
 
+			sw.WriteString(`<div class="max-w-md mx-auto">`)
+			viewCommandScheduleFormHTML(ctx, viewer, *post, channelViewModel, channelsRepo, sw)
+			viewGitHubIssueForPostHTML(ctx, viewer, *post, channelViewModel, sw)
+			viewTrelloCardForPostHTML(ctx, viewer, *post, channelViewModel, sw)
+			sw.WriteString(`</div>`)
+
 			sw.WriteString(`<div hidden class="hidden">`)
-			viewCreatePostFormInChannelHTMLHandle(channelViewModel, sw)
+			viewCreatePostFormInChannelHTMLHandle(channelViewModel, csrfTokenFromWriter(w), sw)
 			sw.WriteString(`</div>`)
 
 			sw.WriteString(`</div>`)
@@ -370,8 +323,7 @@ func showPostInChannelHTMLHandle(ctx context.Context, viewer *Viewer, w http.Res
 	})
 }
 
-func createPostInChannelHTMLHandle(w http.ResponseWriter, r *http.Request) {
-	ctx := appengine.NewContext(r)
+func createPostInChannelHTMLHandle(ctx context.Context, viewer *Viewer, w http.ResponseWriter, r *http.Request) {
 	vars := routeVarsFrom(r)
 
 	orgRepo := NewOrgRepo(ctx, vars.orgSlug())
@@ -395,9 +347,11 @@ func createPostInChannelHTMLHandle(w http.ResponseWriter, r *http.Request) {
 
 	var errs []error
 
-	_, err := channelsRepo.CreatePost(input)
-	if err != nil {
+	post, err := CreatePostMirroringReply(ctx, channelsRepo, viewer.GetTrelloAPI(), input)
+	if post == nil {
 		errs = append(errs, fmt.Errorf("Error creating post: %s", err.Error()))
+	} else if err != nil {
+		errs = append(errs, err)
 	}
 
 	posts, err := channelsRepo.ListPostsInChannel(vars.channelSlug())
@@ -411,6 +365,6 @@ func createPostInChannelHTMLHandle(w http.ResponseWriter, r *http.Request) {
 			viewDeveloperSectionForPostsInChannelHTMLHandle(channelViewModel, sw)
 		})
 
-		viewPostsInChannelHTMLPartial(ctx, errs, channelViewModel, posts, viewSection)
+		viewPostsInChannelHTMLPartial(ctx, errs, channelViewModel, csrfTokenFromWriter(w), posts, viewSection)
 	})
 }
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/user"
+)
+
+const maxListedBlobCollectionRuns = 30
+
+// AddStorageCollectionRoutes adds routes for cron to garbage collect storage, and for admins to see what it has reclaimed
+func AddStorageCollectionRoutes(r *mux.Router) {
+	r.Path(storageCollectPath).Methods("GET").
+		HandlerFunc(collectUnreferencedBlobsHandle)
+	r.Path(storageCollectPath + "/runs").Methods("GET").
+		HandlerFunc(listBlobCollectionRunsHandle)
+}
+
+// collectUnreferencedBlobsHandle continues garbage collection, or with ?dryRun=true reports what it would sweep
+func collectUnreferencedBlobsHandle(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+
+	if !isCronRequest(r) && !user.IsAdmin(ctx) {
+		http.Error(w, "Only cron and admins can collect storage.", http.StatusForbidden)
+		return
+	}
+
+	options := DefaultBlobCollectionOptions()
+	options.DryRun = r.URL.Query().Get("dryRun") == "true"
+
+	run, err := CollectUnreferencedBlobs(ctx, time.Now().UTC(), options)
+	if err == errBlobCollectionNotDue {
+		// Cron treats anything but success as a failure to retry
+		writeJSON(w, &struct {
+			Message string `json:"message"`
+		}{
+			Message: err.Error(),
+		})
+		return
+	}
+	if err == errBlobCollectionClaimed {
+		writeErrorJSONWithStatus(w, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		writeErrorJSONWithStatus(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, run)
+}
+
+// listBlobCollectionRunsHandle lists recent runs, with the total bytes they reclaimed
+func listBlobCollectionRunsHandle(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+
+	if !user.IsAdmin(ctx) {
+		http.Error(w, "Only admins can see storage collection.", http.StatusForbidden)
+		return
+	}
+
+	storageRepo := NewStorageRepo(ctx)
+
+	runs, err := storageRepo.listBlobCollectionRuns(maxListedBlobCollectionRuns)
+	if err != nil {
+		writeErrorJSONWithStatus(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	var reclaimedBytes int64
+	for _, run := range runs {
+		reclaimedBytes += run.ReclaimedBytes
+	}
+
+	writeJSON(w, &struct {
+		Runs           []BlobCollectionRun `json:"runs"`
+		ReclaimedBytes int64               `json:"reclaimedBytes"`
+	}{
+		Runs:           runs,
+		ReclaimedBytes: reclaimedBytes,
+	})
+}
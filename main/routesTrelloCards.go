@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"html/template"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/appengine/datastore"
+)
+
+// AddTrelloCardsRoutes adds routes for turning posts into Trello cards
+func AddTrelloCardsRoutes(r *mux.Router) {
+	r.Path("/1/org:{orgSlug}/channel:{channelSlug}/posts/{postID}/trelloCard").Methods("GET").
+		HandlerFunc(WithViewer(getTrelloCardForPostHandle))
+	r.Path("/1/org:{orgSlug}/channel:{channelSlug}/posts/{postID}/trelloCard").Methods("POST").
+		HandlerFunc(WithCSRFHeader(WithViewer(createTrelloCardFromPostHandle)))
+	r.Path("/1/org:{orgSlug}/channel:{channelSlug}/posts/{postID}/trelloCard").Methods("PATCH").
+		HandlerFunc(WithCSRFHeader(WithViewer(updateTrelloCardForPostHandle)))
+
+	r.Path("/org:{orgSlug}/channel:{channelSlug}/posts/{postID}/trelloCard").Methods("POST").
+		HandlerFunc(WithCSRF(WithViewerInSession(trelloCardForPostHTMLHandle)))
+}
+
+func getTrelloCardForPostHandle(ctx context.Context, v *Viewer, w http.ResponseWriter, r *http.Request) {
+	vars := routeVarsFrom(r)
+
+	orgRepo := NewOrgRepo(ctx, vars.orgSlug())
+	channelsRepo := NewChannelsRepo(ctx, orgRepo)
+
+	post, err := channelsRepo.GetPostWithIDInChannel(vars.channelSlug(), vars.postID())
+	if err != nil {
+		writeErrorJSONWithStatus(w, http.StatusNotFound, err)
+		return
+	}
+
+	status, err := GetTrelloCardStatusForPost(v.GetTrelloAPI(), post)
+	if err != nil {
+		writeErrorJSONWithStatus(w, http.StatusBadGateway, err)
+		return
+	}
+	if status == nil {
+		writeErrorJSONWithStatus(w, http.StatusNotFound, errPostHasNoTrelloCard)
+		return
+	}
+
+	writeJSON(w, status)
+}
+
+type createTrelloCardFromPostBody struct {
+	List          string `json:"list"`
+	MirrorReplies bool   `json:"mirrorReplies"`
+}
+
+func createTrelloCardFromPostHandle(ctx context.Context, v *Viewer, w http.ResponseWriter, r *http.Request) {
+	vars := routeVarsFrom(r)
+
+	orgRepo := NewOrgRepo(ctx, vars.orgSlug())
+	channelsRepo := NewChannelsRepo(ctx, orgRepo)
+
+	var body createTrelloCardFromPostBody
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		writeErrorJSONWithStatus(w, http.StatusBadRequest, err)
+		return
+	}
+
+	trello := v.GetTrelloAPI()
+	if trello == nil {
+		writeErrorJSONWithStatus(w, http.StatusUnauthorized, errSignInWithTrello)
+		return
+	}
+
+	post, status, err := CreateTrelloCardFromPost(ctx, channelsRepo, trello, CreateTrelloCardFromPostInput{
+		ChannelSlug:    vars.channelSlug(),
+		PostKeyEncoded: vars.postID(),
+		ListID:         body.List,
+		MirrorReplies:  body.MirrorReplies,
+	})
+	if err != nil {
+		writeErrorJSONWithStatus(w, http.StatusBadRequest, err)
+		return
+	}
+
+	writeJSON(w, &struct {
+		Post       *Post             `json:"post"`
+		TrelloCard *TrelloCardStatus `json:"trelloCard"`
+	}{
+		Post:       post,
+		TrelloCard: status,
+	})
+}
+
+type updateTrelloCardForPostBody struct {
+	MirrorReplies bool `json:"mirrorReplies"`
+}
+
+func updateTrelloCardForPostHandle(ctx context.Context, v *Viewer, w http.ResponseWriter, r *http.Request) {
+	vars := routeVarsFrom(r)
+
+	orgRepo := NewOrgRepo(ctx, vars.orgSlug())
+	channelsRepo := NewChannelsRepo(ctx, orgRepo)
+
+	var body updateTrelloCardForPostBody
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		writeErrorJSONWithStatus(w, http.StatusBadRequest, err)
+		return
+	}
+
+	post, err := channelsRepo.GetPostWithIDInChannel(vars.channelSlug(), vars.postID())
+	if err != nil {
+		writeErrorJSONWithStatus(w, http.StatusNotFound, err)
+		return
+	}
+
+	post, err = channelsRepo.SetMirrorRepliesToTrelloForPost(post.Key, body.MirrorReplies)
+	if err != nil {
+		writeErrorJSONWithStatus(w, http.StatusBadRequest, err)
+		return
+	}
+
+	status, err := GetTrelloCardStatusForPost(v.GetTrelloAPI(), post)
+	if err != nil {
+		writeErrorJSONWithStatus(w, http.StatusBadGateway, err)
+		return
+	}
+
+	writeJSON(w, status)
+}
+
+func trelloCardForPostHTMLHandle(ctx context.Context, v *Viewer, w http.ResponseWriter, r *http.Request) {
+	vars := routeVarsFrom(r)
+	channelViewModel := vars.ToChannelViewModel()
+
+	orgRepo := NewOrgRepo(ctx, vars.orgSlug())
+	channelsRepo := NewChannelsRepo(ctx, orgRepo)
+
+	mirrorReplies := r.PostFormValue("mirrorReplies") == "true"
+
+	var err error
+	if r.PostFormValue("action") == "setMirrorReplies" {
+		var postKey *datastore.Key
+		postKey, err = channelsRepo.PostKeyInChannel(vars.channelSlug(), vars.postID())
+		if err == nil {
+			_, err = channelsRepo.SetMirrorRepliesToTrelloForPost(postKey, mirrorReplies)
+		}
+	} else {
+		_, _, err = CreateTrelloCardFromPost(ctx, channelsRepo, v.GetTrelloAPI(), CreateTrelloCardFromPostInput{
+			ChannelSlug:    vars.channelSlug(),
+			PostKeyEncoded: vars.postID(),
+			ListID:         r.PostFormValue("list"),
+			MirrorReplies:  mirrorReplies,
+		})
+	}
+	if err != nil {
+		v.SetAlertFor(postFormAlert("trelloCard", vars.postID()), err.Error())
+	}
+
+	http.Redirect(w, r, channelViewModel.HTMLPostURL(vars.postID()), http.StatusFound)
+}
+
+var trelloCardForPostTemplate = template.Must(template.New("trelloCardForPost").Parse(`
+{{if .Status}}
+<form method="post" action="{{.ActionURL}}" class="my-4 p-4 bg-white border-t-2 border-blue rounded-sm">
+	<input type="hidden" name="csrfToken" value="{{.CSRFToken}}">
+	<h3 class="mb-2">Trello Card</h3>
+	{{with .Status}}
+	<p>
+		{{if .URL}}<a href="{{.URL}}" class="text-blue-dark">{{.Name}}</a>{{else}}<span class="font-mono">{{.ID}}</span>{{end}}
+		{{if .ListName}}<span class="ml-1 px-2 py-1 text-sm font-bold text-white bg-blue-dark rounded">{{.ListName}}</span>{{end}}
+		{{if .BoardName}}<span class="ml-1 text-grey-darker">on {{.BoardName}}</span>{{end}}
+	</p>
+	{{if .Due}}<p class="mt-1 text-grey-darker">Due {{.Due}}</p>{{end}}
+	{{end}}
+	{{if .Error}}<p class="mt-2 text-red-dark">Could not load the card: {{.Error}}</p>{{end}}
+	{{if .Alert}}
+	<p class="px-3 py-2 bg-white border-t-4 border-red rounded-sm shadow"><span class="text-red-dark">Error: </span>{{.Alert}}</p>
+	{{end}}
+	<input type="hidden" name="action" value="setMirrorReplies">
+	<label class="block my-2">
+		<input type="checkbox" name="mirrorReplies" value="true"{{if .Status.MirrorReplies}} checked{{end}}>
+		Add replies as comments on the card
+	</label>
+	<div class="flex flex-row-reverse">
+		<button type="submit" class="mt-2 px-4 py-2 text-grey-darkest bg-grey-lighter border border-grey rounded shadow">Save</button>
+	</div>
+</form>
+{{else if .Boards}}
+<form method="post" action="{{.ActionURL}}" class="my-4 p-4 bg-white border-t-2 border-blue rounded-sm">
+	<input type="hidden" name="csrfToken" value="{{.CSRFToken}}">
+	<h3 class="mb-2">Trello Card</h3>
+	{{if .Alert}}
+	<p class="px-3 py-2 bg-white border-t-4 border-red rounded-sm shadow"><span class="text-red-dark">Error: </span>{{.Alert}}</p>
+	{{end}}
+	<label class="block my-2">
+		<span class="font-bold">List</span>
+		<select name="list" class="block w-full mt-1 p-2 bg-grey-lightest border border-grey rounded shadow-inner">
+			{{range .Boards}}
+			<optgroup label="{{.Name}}">
+				{{range .Lists}}<option value="{{.ID}}">{{.Name}}</option>{{end}}
+			</optgroup>
+			{{end}}
+		</select>
+	</label>
+	<label class="block my-2">
+		<input type="checkbox" name="mirrorReplies" value="true">
+		Add replies as comments on the card
+	</label>
+	<div class="flex flex-row-reverse">
+		<button type="submit" name="action" value="createCard" class="mt-2 px-4 py-2 font-bold text-white bg-blue-dark border border-blue-dark rounded shadow">Create Card</button>
+	</div>
+</form>
+{{end}}
+`))
+
+func viewTrelloCardForPostHTML(ctx context.Context, v *Viewer, post Post, m ChannelViewModel, w *bufio.Writer) {
+	trello := v.GetTrelloAPI()
+
+	status, err := GetTrelloCardStatusForPost(trello, &post)
+
+	var errorMessage string
+	if err != nil {
+		errorMessage = err.Error()
+	}
+
+	alert := v.ReadAlertFor(postFormAlert("trelloCard", post.Key.Encode()))
+
+	var boards []TrelloBoard
+	if status == nil && trello != nil {
+		boards, err = trello.BoardsWithLists()
+		if err != nil {
+			viewErrorMessage("Could not load Trello boards: "+err.Error(), w)
+			return
+		}
+	}
+
+	trelloCardForPostTemplate.Execute(w, &struct {
+		ActionURL string
+		CSRFToken string
+		Alert     *string
+		Boards    []TrelloBoard
+		Status    *TrelloCardStatus
+		Error     string
+	}{
+		ActionURL: m.HTMLPostURL(post.Key.Encode()) + "/trelloCard",
+		CSRFToken: v.CSRFToken(),
+		Alert:     alert,
+		Boards:    boards,
+		Status:    status,
+		Error:     errorMessage,
+	})
+}
@@ -39,3 +39,12 @@ func (v RouteVars) optionalPostID() *string {
 func (v RouteVars) sha256() string {
 	return v.vars["sha256"]
 }
+
+// mediaType joins the {type} and {subtype} vars, e.g. image/png
+func (v RouteVars) mediaType() string {
+	return v.vars["type"] + "/" + v.vars["subtype"]
+}
+
+func (v RouteVars) integrationID() string {
+	return v.vars["integrationID"]
+}
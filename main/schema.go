@@ -160,6 +160,7 @@ type Post implements Node {
 
   content: MarkdownDocument
   author: Actor
+  githubIssue: GitHubIssue
   #title: String
   #createdAt: UTCTime
 	#updatedAt: UTCTime
@@ -175,17 +176,20 @@ type Channel implements Node {
 
 	posts: PostsConnection
 }
-` + commandsSchemaString + awsSchemaString + `
+` + commandsSchemaString + awsSchemaString + gitHubSchemaString + trelloSchemaString + figmaSchemaString + `
 type Query {
 	hello: String!
 	channel(slug: String): Channel
 	#channel(): Channel
 	channels(): [Channel]
 	aws(region: String!): AWSService
+	trello: TrelloService
+	figma: FigmaService
 }
 
 type Mutation {
 	commands: Commands!
+	createGitHubIssueFromPost(orgSlug: String!, channelSlug: String!, postID: ID!, repo: String!): Post
 }
 
 
@@ -43,10 +43,17 @@ func (channel *Channel) Posts(ctx context.Context) (*PostsConnection2, error) {
 	}
 
 	postEdges := make([]*PostEdge, 0, len(posts))
+	postPointers := make([]*Post, 0, len(posts))
 	for _, post := range posts {
 		localPost := post
 		postEdge := NewPostEdge(&localPost, "-")
 		postEdges = append(postEdges, postEdge)
+		postPointers = append(postPointers, &localPost)
+	}
+
+	gitHubIssues := newGitHubIssueStatusLoader(postPointers)
+	for _, postEdge := range postEdges {
+		postEdge.gitHubIssues = gitHubIssues
 	}
 
 	c := NewPostsConnectionWithEdges(&postEdges)
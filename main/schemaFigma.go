@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	graphql "github.com/graph-gophers/graphql-go"
+)
+
+const figmaSchemaString = `
+type FigmaService {
+	file(file: ID!): FigmaFile
+	components(file: ID!): [FigmaComponent!]!
+	styles(file: ID!): [FigmaStyle!]!
+	images(file: ID!, nodeIds: [ID!]!, format: String, scale: Float): [FigmaImage!]!
+}
+
+type FigmaFile {
+	key: ID!
+	name: String!
+	lastModified: String!
+	thumbnailUrl: String
+	version: String!
+	pages: [FigmaNode!]!
+}
+
+type FigmaNode {
+	id: ID!
+	name: String!
+	type: String!
+	children: [FigmaNode!]!
+}
+
+type FigmaComponent {
+	key: ID!
+	nodeId: ID!
+	name: String!
+	description: String
+	thumbnailUrl: String
+	frameName: String
+	pageName: String
+}
+
+type FigmaStyle {
+	key: ID!
+	nodeId: ID!
+	styleType: String!
+	name: String!
+	description: String
+	thumbnailUrl: String
+}
+
+type FigmaImage {
+	nodeId: ID!
+	url: String!
+}
+`
+
+type schemaFigmaService struct {
+	figma *FigmaAPI
+}
+
+// Figma resolved, for the viewer signed in with Figma
+func (r DataStoreResolver) Figma(ctx context.Context) (*schemaFigmaService, error) {
+	figma := GetFigmaAPIFromContext(ctx)
+	if figma == nil {
+		return nil, errors.New("Sign in with Figma to query Figma")
+	}
+
+	return &schemaFigmaService{figma}, nil
+}
+
+// File resolved
+func (service *schemaFigmaService) File(args struct{ File graphql.ID }) (*schemaFigmaFile, error) {
+	file, err := service.figma.File(string(args.File))
+	if err != nil {
+		return nil, err
+	}
+
+	return &schemaFigmaFile{file}, nil
+}
+
+// Components resolved
+func (service *schemaFigmaService) Components(args struct{ File graphql.ID }) ([]*schemaFigmaComponent, error) {
+	components, err := service.figma.Components(string(args.File))
+	if err != nil {
+		return nil, err
+	}
+
+	resolvers := make([]*schemaFigmaComponent, 0, len(components))
+	for i := range components {
+		resolvers = append(resolvers, &schemaFigmaComponent{&components[i]})
+	}
+	return resolvers, nil
+}
+
+// Styles resolved
+func (service *schemaFigmaService) Styles(args struct{ File graphql.ID }) ([]*schemaFigmaStyle, error) {
+	styles, err := service.figma.Styles(string(args.File))
+	if err != nil {
+		return nil, err
+	}
+
+	resolvers := make([]*schemaFigmaStyle, 0, len(styles))
+	for i := range styles {
+		resolvers = append(resolvers, &schemaFigmaStyle{&styles[i]})
+	}
+	return resolvers, nil
+}
+
+// Images resolved, with URLs hosted by Figma that expire after a while
+func (service *schemaFigmaService) Images(args struct {
+	File    graphql.ID
+	NodeIDs []graphql.ID
+	Format  *string
+	Scale   *float64
+}) ([]*schemaFigmaImage, error) {
+	format := "png"
+	if args.Format != nil {
+		format = *args.Format
+	}
+	if _, ok := figmaImageMediaTypes[format]; !ok {
+		return nil, errors.New("Figma image format must be png or jpg")
+	}
+
+	scale := float64(defaultFigmaImageScale)
+	if args.Scale != nil {
+		scale = *args.Scale
+	}
+	if scale < minFigmaImageScale || scale > maxFigmaImageScale {
+		return nil, errors.New("Figma image scale is out of range")
+	}
+
+	nodeIDs := make([]string, 0, len(args.NodeIDs))
+	for _, nodeID := range args.NodeIDs {
+		nodeIDs = append(nodeIDs, string(nodeID))
+	}
+
+	images, err := service.figma.Images(string(args.File), nodeIDs, format, scale)
+	if err != nil {
+		return nil, err
+	}
+
+	resolvers := make([]*schemaFigmaImage, 0, len(images))
+	for _, image := range images {
+		resolvers = append(resolvers, &schemaFigmaImage{image})
+	}
+	return resolvers, nil
+}
+
+type schemaFigmaFile struct {
+	file *FigmaFile
+}
+
+// Key resolved
+func (r *schemaFigmaFile) Key() graphql.ID {
+	return graphql.ID(r.file.Key)
+}
+
+// Name resolved
+func (r *schemaFigmaFile) Name() string {
+	return r.file.Name
+}
+
+// LastModified resolved
+func (r *schemaFigmaFile) LastModified() string {
+	return r.file.LastModified.UTC().Format(time.RFC3339)
+}
+
+// ThumbnailURL resolved
+func (r *schemaFigmaFile) ThumbnailURL() *string {
+	return optionalString(r.file.ThumbnailURL)
+}
+
+// Version resolved
+func (r *schemaFigmaFile) Version() string {
+	return r.file.Version
+}
+
+// Pages resolved
+func (r *schemaFigmaFile) Pages() []*schemaFigmaNode {
+	return schemaFigmaNodes(r.file.Pages)
+}
+
+func schemaFigmaNodes(nodes []FigmaNode) []*schemaFigmaNode {
+	resolvers := make([]*schemaFigmaNode, 0, len(nodes))
+	for i := range nodes {
+		resolvers = append(resolvers, &schemaFigmaNode{&nodes[i]})
+	}
+	return resolvers
+}
+
+type schemaFigmaNode struct {
+	node *FigmaNode
+}
+
+// ID resolved
+func (r *schemaFigmaNode) ID() graphql.ID {
+	return graphql.ID(r.node.ID)
+}
+
+// Name resolved
+func (r *schemaFigmaNode) Name() string {
+	return r.node.Name
+}
+
+// Type resolved
+func (r *schemaFigmaNode) Type() string {
+	return r.node.Type
+}
+
+// Children resolved
+func (r *schemaFigmaNode) Children() []*schemaFigmaNode {
+	return schemaFigmaNodes(r.node.Children)
+}
+
+type schemaFigmaComponent struct {
+	component *FigmaComponent
+}
+
+// Key resolved
+func (r *schemaFigmaComponent) Key() graphql.ID {
+	return graphql.ID(r.component.Key)
+}
+
+// NodeID resolved
+func (r *schemaFigmaComponent) NodeID() graphql.ID {
+	return graphql.ID(r.component.NodeID)
+}
+
+// Name resolved
+func (r *schemaFigmaComponent) Name() string {
+	return r.component.Name
+}
+
+// Description resolved
+func (r *schemaFigmaComponent) Description() *string {
+	return optionalString(r.component.Description)
+}
+
+// ThumbnailURL resolved
+func (r *schemaFigmaComponent) ThumbnailURL() *string {
+	return optionalString(r.component.ThumbnailURL)
+}
+
+// FrameName resolved
+func (r *schemaFigmaComponent) FrameName() *string {
+	if r.component.ContainingFrame == nil {
+		return nil
+	}
+	return optionalString(r.component.ContainingFrame.Name)
+}
+
+// PageName resolved
+func (r *schemaFigmaComponent) PageName() *string {
+	if r.component.ContainingFrame == nil {
+		return nil
+	}
+	return optionalString(r.component.ContainingFrame.PageName)
+}
+
+type schemaFigmaStyle struct {
+	style *FigmaStyle
+}
+
+// Key resolved
+func (r *schemaFigmaStyle) Key() graphql.ID {
+	return graphql.ID(r.style.Key)
+}
+
+// NodeID resolved
+func (r *schemaFigmaStyle) NodeID() graphql.ID {
+	return graphql.ID(r.style.NodeID)
+}
+
+// StyleType resolved
+func (r *schemaFigmaStyle) StyleType() string {
+	return r.style.StyleType
+}
+
+// Name resolved
+func (r *schemaFigmaStyle) Name() string {
+	return r.style.Name
+}
+
+// Description resolved
+func (r *schemaFigmaStyle) Description() *string {
+	return optionalString(r.style.Description)
+}
+
+// ThumbnailURL resolved
+func (r *schemaFigmaStyle) ThumbnailURL() *string {
+	return optionalString(r.style.ThumbnailURL)
+}
+
+type schemaFigmaImage struct {
+	image FigmaImage
+}
+
+// NodeID resolved
+func (r *schemaFigmaImage) NodeID() graphql.ID {
+	return graphql.ID(r.image.NodeID)
+}
+
+// URL resolved
+func (r *schemaFigmaImage) URL() string {
+	return r.image.URL
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	graphql "github.com/graph-gophers/graphql-go"
+	"google.golang.org/appengine/log"
+)
+
+const gitHubSchemaString = `
+type GitHubIssue {
+	repo: String!
+	number: Int!
+	url: String!
+	title: String
+	state: String
+}
+`
+
+type schemaGitHubIssue struct {
+	status *GitHubIssueStatus
+}
+
+// Repo resolved
+func (r *schemaGitHubIssue) Repo() string {
+	return r.status.Repo
+}
+
+// Number resolved
+func (r *schemaGitHubIssue) Number() int32 {
+	return int32(r.status.Number)
+}
+
+// URL resolved
+func (r *schemaGitHubIssue) URL() string {
+	return r.status.URL
+}
+
+// Title resolved
+func (r *schemaGitHubIssue) Title() *string {
+	if r.status.Title == "" {
+		return nil
+	}
+	return &r.status.Title
+}
+
+// State resolved
+func (r *schemaGitHubIssue) State() *string {
+	if r.status.State == "" {
+		return nil
+	}
+	return &r.status.State
+}
+
+// gitHubIssueStatusLoader loads the GitHub issues of a list of posts together, the first time one of them is resolved
+type gitHubIssueStatusLoader struct {
+	posts    []*Post
+	once     sync.Once
+	statuses map[string]*GitHubIssueStatus
+	err      error
+}
+
+func newGitHubIssueStatusLoader(posts []*Post) *gitHubIssueStatusLoader {
+	return &gitHubIssueStatusLoader{posts: posts}
+}
+
+// statusForPost returns the state of the post’s issue, or nil if it has none
+func (l *gitHubIssueStatusLoader) statusForPost(ctx context.Context, post *Post) (*GitHubIssueStatus, error) {
+	if post.GitHubIssueNumber == 0 {
+		return nil, nil
+	}
+
+	l.once.Do(func() {
+		l.statuses, l.err = GetGitHubIssueStatusesForPosts(ctx, GetGitHubClientFromContext(ctx), l.posts)
+	})
+
+	status := l.statuses[gitHubIssueURL(post.GitHubIssueRepo, post.GitHubIssueNumber)]
+	if status == nil {
+		status = &GitHubIssueStatus{
+			Repo:   post.GitHubIssueRepo,
+			Number: post.GitHubIssueNumber,
+			URL:    gitHubIssueURL(post.GitHubIssueRepo, post.GitHubIssueNumber),
+		}
+	}
+
+	return status, l.err
+}
+
+// GitHubIssue resolved, with its live state if the viewer is signed in to GitHub.
+// Posts listed together have their issues loaded together.
+func (r *PostResolver) GitHubIssue(ctx context.Context) *schemaGitHubIssue {
+	loader := r.gitHubIssues
+	if loader == nil {
+		loader = newGitHubIssueStatusLoader([]*Post{&r.Post})
+	}
+
+	status, err := loader.statusForPost(ctx, &r.Post)
+	if err != nil {
+		log.Warningf(ctx, "Could not load GitHub issue %s#%d: %v", r.GitHubIssueRepo, r.GitHubIssueNumber, err)
+	}
+	if status == nil {
+		return nil
+	}
+
+	return &schemaGitHubIssue{status}
+}
+
+// CreateGitHubIssueFromPostArgs is the arguments taken by the CreateGitHubIssueFromPost mutation
+type CreateGitHubIssueFromPostArgs struct {
+	OrgSlug     string
+	ChannelSlug string
+	PostID      graphql.ID
+	Repo        string
+}
+
+// CreateGitHubIssueFromPost resolved
+func (r DataStoreResolver) CreateGitHubIssueFromPost(ctx context.Context, args CreateGitHubIssueFromPostArgs) (*PostResolver, error) {
+	orgRepo := NewOrgRepo(ctx, args.OrgSlug)
+	channelsRepo := NewChannelsRepo(ctx, orgRepo)
+
+	post, _, err := CreateGitHubIssueFromPost(ctx, channelsRepo, GetGitHubClientFromContext(ctx), CreateGitHubIssueFromPostInput{
+		ChannelSlug:    args.ChannelSlug,
+		PostKeyEncoded: string(args.PostID),
+		Repo:           args.Repo,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &PostResolver{Post: *post}, nil
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+
 	graphql "github.com/graph-gophers/graphql-go"
 )
 
@@ -14,9 +16,9 @@ func (markdownDocument *MarkdownDocument) source() string {
 	return markdownDocument.Source
 }
 
-// Source resolved
-func (r *MarkdownDocumentResolver) Source() *string {
-	s := r.source()
+// Source resolved, with its links to stored content signed
+func (r *MarkdownDocumentResolver) Source(ctx context.Context) *string {
+	s := signStorageURLsIn(ctx, r.source())
 	return &s
 }
 
@@ -30,6 +32,7 @@ func (r *MarkdownDocumentResolver) MediaType() MediaType {
 // PostResolver decorates a Post for GraphQL
 type PostResolver struct {
 	Post
+	gitHubIssues *gitHubIssueStatusLoader
 }
 
 // ID resolved
@@ -55,8 +58,9 @@ func (post *Post) Author() *ActorResolver {
 
 // PostEdge is a reference to a post within a connection
 type PostEdge struct {
-	post   *Post
-	cursor string
+	post         *Post
+	cursor       string
+	gitHubIssues *gitHubIssueStatusLoader
 }
 
 // NewPostEdge makes a post edge with the provided values
@@ -73,7 +77,7 @@ func (postEdge *PostEdge) Node() *PostResolver {
 	if postEdge.post == nil {
 		return nil
 	}
-	return &PostResolver{*postEdge.post}
+	return &PostResolver{Post: *postEdge.post, gitHubIssues: postEdge.gitHubIssues}
 }
 
 // Cursor resolved
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	graphql "github.com/graph-gophers/graphql-go"
+)
+
+const trelloSchemaString = `
+type TrelloService {
+	boards: [TrelloBoard!]!
+	board(id: ID!): TrelloBoard
+	card(id: ID!): TrelloCard
+	search(query: String!, limit: Int): [TrelloCard!]!
+}
+
+type TrelloBoard {
+	id: ID!
+	name: String!
+	desc: String
+	url: String!
+	closed: Boolean!
+	lists: [TrelloList!]!
+}
+
+type TrelloList {
+	id: ID!
+	name: String!
+	cards: [TrelloCard!]!
+}
+
+type TrelloLabel {
+	name: String
+	color: String
+}
+
+type TrelloCard {
+	id: ID!
+	name: String!
+	desc: String
+	url: String!
+	due: String
+	dueComplete: Boolean!
+	labels: [TrelloLabel!]!
+	listName: String
+	boardName: String
+}
+`
+
+type schemaTrelloService struct {
+	trello *TrelloAPI
+}
+
+// Trello resolved, for the viewer signed in with Trello
+func (r DataStoreResolver) Trello(ctx context.Context) (*schemaTrelloService, error) {
+	trello := GetTrelloAPIFromContext(ctx)
+	if trello == nil {
+		return nil, errors.New("Sign in with Trello to query Trello")
+	}
+
+	return &schemaTrelloService{trello}, nil
+}
+
+// Boards resolved
+func (service *schemaTrelloService) Boards() ([]*schemaTrelloBoard, error) {
+	boards, err := service.trello.Boards()
+	if err != nil {
+		return nil, err
+	}
+
+	resolvers := make([]*schemaTrelloBoard, 0, len(boards))
+	for i := range boards {
+		resolvers = append(resolvers, &schemaTrelloBoard{service.trello, &boards[i]})
+	}
+	return resolvers, nil
+}
+
+// Board resolved
+func (service *schemaTrelloService) Board(args struct{ ID graphql.ID }) (*schemaTrelloBoard, error) {
+	board, err := service.trello.Board(string(args.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	return &schemaTrelloBoard{service.trello, board}, nil
+}
+
+// Card resolved
+func (service *schemaTrelloService) Card(args struct{ ID graphql.ID }) (*schemaTrelloCard, error) {
+	card, err := service.trello.Card(string(args.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	return &schemaTrelloCard{card}, nil
+}
+
+// Search resolved
+func (service *schemaTrelloService) Search(args struct {
+	Query string
+	Limit *int32
+}) ([]*schemaTrelloCard, error) {
+	limit := 0
+	if args.Limit != nil {
+		limit = int(*args.Limit)
+	}
+
+	cards, err := service.trello.SearchCards(args.Query, trelloSearchLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+
+	return schemaTrelloCards(cards), nil
+}
+
+func schemaTrelloCards(cards []TrelloCard) []*schemaTrelloCard {
+	resolvers := make([]*schemaTrelloCard, 0, len(cards))
+	for i := range cards {
+		resolvers = append(resolvers, &schemaTrelloCard{&cards[i]})
+	}
+	return resolvers
+}
+
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+type schemaTrelloBoard struct {
+	trello *TrelloAPI
+	board  *TrelloBoard
+}
+
+// ID resolved
+func (r *schemaTrelloBoard) ID() graphql.ID {
+	return graphql.ID(r.board.ID)
+}
+
+// Name resolved
+func (r *schemaTrelloBoard) Name() string {
+	return r.board.Name
+}
+
+// Desc resolved
+func (r *schemaTrelloBoard) Desc() *string {
+	return optionalString(r.board.Desc)
+}
+
+// URL resolved
+func (r *schemaTrelloBoard) URL() string {
+	return r.board.URL
+}
+
+// Closed resolved
+func (r *schemaTrelloBoard) Closed() bool {
+	return r.board.Closed
+}
+
+// Lists resolved, loading them if the board was listed without them
+func (r *schemaTrelloBoard) Lists() ([]*schemaTrelloList, error) {
+	if r.board.Lists == nil {
+		board, err := r.trello.Board(r.board.ID)
+		if err != nil {
+			return nil, err
+		}
+		r.board = board
+	}
+
+	resolvers := make([]*schemaTrelloList, 0, len(r.board.Lists))
+	for i := range r.board.Lists {
+		resolvers = append(resolvers, &schemaTrelloList{&r.board.Lists[i]})
+	}
+	return resolvers, nil
+}
+
+type schemaTrelloList struct {
+	list *TrelloList
+}
+
+// ID resolved
+func (r *schemaTrelloList) ID() graphql.ID {
+	return graphql.ID(r.list.ID)
+}
+
+// Name resolved
+func (r *schemaTrelloList) Name() string {
+	return r.list.Name
+}
+
+// Cards resolved
+func (r *schemaTrelloList) Cards() []*schemaTrelloCard {
+	return schemaTrelloCards(r.list.Cards)
+}
+
+type schemaTrelloLabel struct {
+	label TrelloLabel
+}
+
+// Name resolved
+func (r *schemaTrelloLabel) Name() *string {
+	return optionalString(r.label.Name)
+}
+
+// Color resolved
+func (r *schemaTrelloLabel) Color() *string {
+	return optionalString(r.label.Color)
+}
+
+type schemaTrelloCard struct {
+	card *TrelloCard
+}
+
+// ID resolved
+func (r *schemaTrelloCard) ID() graphql.ID {
+	return graphql.ID(r.card.ID)
+}
+
+// Name resolved
+func (r *schemaTrelloCard) Name() string {
+	return r.card.Name
+}
+
+// Desc resolved
+func (r *schemaTrelloCard) Desc() *string {
+	return optionalString(r.card.Desc)
+}
+
+// URL resolved
+func (r *schemaTrelloCard) URL() string {
+	return r.card.URL
+}
+
+// Due resolved
+func (r *schemaTrelloCard) Due() *string {
+	if r.card.Due == nil {
+		return nil
+	}
+	due := r.card.Due.UTC().Format(time.RFC3339)
+	return &due
+}
+
+// DueComplete resolved
+func (r *schemaTrelloCard) DueComplete() bool {
+	return r.card.DueComplete
+}
+
+// Labels resolved
+func (r *schemaTrelloCard) Labels() []*schemaTrelloLabel {
+	resolvers := make([]*schemaTrelloLabel, 0, len(r.card.Labels))
+	for _, label := range r.card.Labels {
+		resolvers = append(resolvers, &schemaTrelloLabel{label})
+	}
+	return resolvers
+}
+
+// ListName resolved
+func (r *schemaTrelloCard) ListName() *string {
+	if r.card.List == nil {
+		return nil
+	}
+	return optionalString(r.card.List.Name)
+}
+
+// BoardName resolved
+func (r *schemaTrelloCard) BoardName() *string {
+	if r.card.Board == nil {
+		return nil
+	}
+	return optionalString(r.card.Board.Name)
+}
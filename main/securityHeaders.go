@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+)
+
+const (
+	cspReportPath = "/csp-report"
+	// cspReportEndpointName is the Reporting-Endpoints name that report-to refers to
+	cspReportEndpointName = "csp-endpoint"
+	cspReportMaxBytes     = 16 * 1024
+)
+
+// contentSecurityPolicy allows our own scripts, the CDNs pages load from, and inline scripts with the page’s nonce
+func contentSecurityPolicy(nonce string) string {
+	return strings.Join([]string{
+		"default-src 'self'",
+		"script-src 'self' 'nonce-" + nonce + "' https://unpkg.com",
+		// Commands such as /color set style attributes, which nonces cannot allow
+		"style-src 'self' 'unsafe-inline' https://cdn.jsdelivr.net https://cdnjs.cloudflare.com",
+		"img-src 'self' data: https:",
+		"font-src 'self' data: https://cdn.jsdelivr.net https://cdnjs.cloudflare.com",
+		// /graphiql queries the endpoint it was given from the browser
+		"connect-src 'self' https:",
+		"object-src 'none'",
+		"base-uri 'self'",
+		"form-action 'self'",
+		"frame-ancestors 'none'",
+		"report-uri " + cspReportPath,
+		"report-to " + cspReportEndpointName,
+	}, "; ")
+}
+
+// cspReportOnly is set with CSP_REPORT_ONLY, to collect violations before enforcing the policy
+func cspReportOnly() bool {
+	reportOnly, _ := strconv.ParseBool(os.Getenv("CSP_REPORT_ONLY"))
+	return reportOnly
+}
+
+// cspResponseWriter carries the nonce to the helpers that render inline scripts
+type cspResponseWriter struct {
+	http.ResponseWriter
+	cspNonce string
+}
+
+func (w *cspResponseWriter) CSPNonce() string {
+	return w.cspNonce
+}
+
+func (w *cspResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// findInResponseWriter calls found with w and each writer it wraps, until found returns true
+func findInResponseWriter(w io.Writer, found func(w io.Writer) bool) bool {
+	for {
+		if found(w) {
+			return true
+		}
+
+		unwrapper, ok := w.(interface {
+			Unwrap() http.ResponseWriter
+		})
+		if !ok {
+			return false
+		}
+		w = unwrapper.Unwrap()
+	}
+}
+
+// cspNonceFromWriter returns the nonce of a writer passed through WithHTMLHeaders, or "" otherwise
+func cspNonceFromWriter(w io.Writer) string {
+	nonce := ""
+	findInResponseWriter(w, func(w io.Writer) bool {
+		nonceWriter, ok := w.(interface {
+			CSPNonce() string
+		})
+		if ok {
+			nonce = nonceWriter.CSPNonce()
+		}
+		return ok
+	})
+	return nonce
+}
+
+type cspNonceKey struct{}
+
+// ContextWithCSPNonce lets commands add inline scripts to the page they are shown in
+func ContextWithCSPNonce(ctx context.Context, nonce string) context.Context {
+	return context.WithValue(ctx, cspNonceKey{}, nonce)
+}
+
+// CSPNonceFromContext returns the nonce for inline scripts, or "" if the result is not shown in a page
+func CSPNonceFromContext(ctx context.Context) string {
+	nonce, _ := ctx.Value(cspNonceKey{}).(string)
+	return nonce
+}
+
+// setHTMLSecurityHeaders adds the Content Security Policy with a new nonce, and headers that limit framing, referrers, and browser features
+func setHTMLSecurityHeaders(w http.ResponseWriter, r *http.Request) *cspResponseWriter {
+	nonce, err := randomURLSafeString(16)
+	if err != nil {
+		// Without a nonce the policy still applies, so inline scripts are just blocked
+		log.Errorf(appengine.NewContext(r), "Could not create CSP nonce: %v", err)
+	}
+
+	header := w.Header()
+
+	cspHeaderName := "Content-Security-Policy"
+	if cspReportOnly() {
+		cspHeaderName = "Content-Security-Policy-Report-Only"
+	}
+	header.Set(cspHeaderName, contentSecurityPolicy(nonce))
+	header.Set("Reporting-Endpoints", cspReportEndpointName+`="`+cspReportPath+`"`)
+
+	header.Set("Referrer-Policy", "strict-origin-when-cross-origin")
+	header.Set("Permissions-Policy", "camera=(), microphone=(), geolocation=(), payment=(), usb=()")
+	header.Set("X-Frame-Options", "DENY")
+	if !IsDev() {
+		// app.yaml redirects all requests to HTTPS, but the local server is only HTTP
+		header.Set("Strict-Transport-Security", "max-age=31536000; includeSubDomains")
+	}
+
+	return &cspResponseWriter{ResponseWriter: w, cspNonce: nonce}
+}
+
+// AddSecurityRoutes adds the route browsers send Content Security Policy violations to
+func AddSecurityRoutes(r *mux.Router) {
+	r.Path(cspReportPath).Methods("POST").
+		HandlerFunc(cspReportHandle)
+}
+
+// cspReportHandle logs violation reports, both the older application/csp-report and newer application/reports+json kinds
+func cspReportHandle(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+
+	contentType := strings.ToLower(r.Header.Get("Content-Type"))
+	if !strings.HasPrefix(contentType, "application/csp-report") && !strings.HasPrefix(contentType, "application/reports+json") && !strings.HasPrefix(contentType, "application/json") {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+
+	report, err := ioutil.ReadAll(io.LimitReader(r.Body, cspReportMaxBytes))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	log.Warningf(ctx, "Content Security Policy violation: %s", report)
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/google/go-github/github"
 	"github.com/icza/session"
+	"google.golang.org/appengine/datastore"
 )
 
 // Viewer represents the current signed in user
@@ -21,21 +22,32 @@ func NewViewer(ctx context.Context, sess session.Session) *Viewer {
 
 // SetAlert stores an error message to show the user
 func (v *Viewer) SetAlert(errorMessage string) {
+	v.SetAlertFor("", errorMessage)
+}
+
+// ReadAlert reads the previously set error message, if one exists
+func (v *Viewer) ReadAlert() *string {
+	return v.ReadAlertFor("")
+}
+
+// SetAlertFor stores an error message to show in one form, so other forms on the page neither show it nor clear it
+func (v *Viewer) SetAlertFor(form string, errorMessage string) {
 	if v.sess == nil {
 		return
 	}
 
-	v.sess.SetAttr("alert", errorMessage)
+	v.sess.SetAttr(alertAttrName(form), errorMessage)
 }
 
-// ReadAlert reads the previously set error message, if one exists
-func (v *Viewer) ReadAlert() *string {
+// ReadAlertFor reads the error message previously set for the form, if one exists
+func (v *Viewer) ReadAlertFor(form string) *string {
 	if v.sess == nil {
 		return nil
 	}
 
-	errorMessage, ok := v.sess.Attr("alert").(string)
-	v.sess.SetAttr("alert", nil)
+	attrName := alertAttrName(form)
+	errorMessage, ok := v.sess.Attr(attrName).(string)
+	v.sess.SetAttr(attrName, nil)
 	if ok {
 		return &errorMessage
 	}
@@ -43,6 +55,29 @@ func (v *Viewer) ReadAlert() *string {
 	return nil
 }
 
+func alertAttrName(form string) string {
+	if form == "" {
+		return "alert"
+	}
+	return "alert:" + form
+}
+
+// postFormAlert names the alert of a form shown with a post
+func postFormAlert(form string, postKeyEncoded string) string {
+	return form + ":" + postKeyEncoded
+}
+
+// UserAccountKey returns the key of the signed in account, if there is one
+func (v *Viewer) UserAccountKey() *datastore.Key {
+	return UserAccountKeyFromSession(v.sess)
+}
+
+// CanReadLinkedStorage is whether the viewer may read stored content that a post links to without a signed link.
+// Channels do not have members yet, so this is anyone signed in, whatever channel the post is in.
+func (v *Viewer) CanReadLinkedStorage() bool {
+	return v.UserAccountKey() != nil
+}
+
 // GetGitHubClient returns the github.Client for the signed in user, if there is one
 func (v *Viewer) GetGitHubClient() *github.Client {
 	if v.sess == nil {
@@ -52,6 +87,24 @@ func (v *Viewer) GetGitHubClient() *github.Client {
 	return GetGitHubClientFromSession(v.ctx, v.sess)
 }
 
+// GetTrelloAPI returns the TrelloAPI for the signed in user, if there is one
+func (v *Viewer) GetTrelloAPI() *TrelloAPI {
+	if v.sess == nil {
+		return nil
+	}
+
+	return GetTrelloAPIFromSession(v.ctx, v.sess)
+}
+
+// GetFigmaAPI returns the FigmaAPI for the signed in user, if there is one
+func (v *Viewer) GetFigmaAPI() *FigmaAPI {
+	if v.sess == nil {
+		return nil
+	}
+
+	return GetFigmaAPIFromSession(v.ctx, v.sess)
+}
+
 type ViewerCommandParamVariables struct {
 	viewer *Viewer
 }
@@ -70,6 +123,31 @@ func (vars *ViewerCommandParamVariables) GitHubOAuthToken() string {
 	return token.AccessToken
 }
 
+// GitLabOAuthToken returns the viewer’s GitLab access token, if they have signed in with GitLab
+func (vars *ViewerCommandParamVariables) GitLabOAuthToken() string {
+	v := vars.viewer
+	if v.sess == nil {
+		return ""
+	}
+
+	token := GetGitLabTokenFromSession(v.ctx, v.sess)
+	if token == nil {
+		return ""
+	}
+
+	return token.AccessToken
+}
+
+// TrelloAPI returns the Trello API for the viewer, if they have signed in with Trello
+func (vars *ViewerCommandParamVariables) TrelloAPI() *TrelloAPI {
+	return vars.viewer.GetTrelloAPI()
+}
+
+// FigmaAPI returns the Figma API for the viewer, if they have signed in with Figma
+func (vars *ViewerCommandParamVariables) FigmaAPI() *FigmaAPI {
+	return vars.viewer.GetFigmaAPI()
+}
+
 func (v *Viewer) GetCommandParamVariables() *ViewerCommandParamVariables {
 	vars := ViewerCommandParamVariables{v}
 	return &vars
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/icza/session"
+)
+
+// testSession keeps attributes in memory
+type testSession struct {
+	session.Session
+	attrs map[string]interface{}
+}
+
+func (s *testSession) Attr(name string) interface{} {
+	return s.attrs[name]
+}
+
+func (s *testSession) SetAttr(name string, value interface{}) {
+	if value == nil {
+		delete(s.attrs, name)
+		return
+	}
+	s.attrs[name] = value
+}
+
+func TestAlertsForForms(t *testing.T) {
+	v := NewViewer(context.Background(), &testSession{attrs: map[string]interface{}{}})
+
+	v.SetAlertFor(postFormAlert("githubIssue", "a"), "Repo not found")
+	v.SetAlert("Channel exists")
+
+	if alert := v.ReadAlertFor(postFormAlert("schedule", "a")); alert != nil {
+		t.Errorf("Schedule form read %q, want no alert", *alert)
+	}
+	if alert := v.ReadAlertFor(postFormAlert("githubIssue", "b")); alert != nil {
+		t.Errorf("Another post’s form read %q, want no alert", *alert)
+	}
+
+	alert := v.ReadAlertFor(postFormAlert("githubIssue", "a"))
+	if alert == nil || *alert != "Repo not found" {
+		t.Errorf("GitHub issue form read %v, want its alert", alert)
+	}
+	if alert := v.ReadAlertFor(postFormAlert("githubIssue", "a")); alert != nil {
+		t.Errorf("GitHub issue form read %q again, want it cleared", *alert)
+	}
+
+	alert = v.ReadAlert()
+	if alert == nil || *alert != "Channel exists" {
+		t.Errorf("ReadAlert() = %v, want the page’s alert", alert)
+	}
+}
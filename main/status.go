@@ -3,50 +3,108 @@ package main
 import (
 	"context"
 	"net/http"
+	"time"
 
 	"github.com/icza/session"
 	// "google.golang.org/appengine"
 )
 
+// authServiceStatus is a provider and the account connected to it, if there is one
+type authServiceStatus struct {
+	Provider     string     `json:"provider"`
+	Name         string     `json:"name"`
+	Connected    bool       `json:"connected"`
+	AccountLogin string     `json:"accountLogin,omitempty"`
+	Scopes       []string   `json:"scopes"`
+	Expiry       *time.Time `json:"expiry"`
+}
+
 type authStatusData struct {
-	Session bool `json:"session"`
-	GitHub  bool `json:"github"`
-	Trello  bool `json:"trello"`
-	Figma   bool `json:"figma"`
+	Session   bool                `json:"session"`
+	CSRFToken string              `json:"csrfToken,omitempty"`
+	GitHub    bool                `json:"github"`
+	GitLab    bool                `json:"gitlab"`
+	Trello    bool                `json:"trello"`
+	Figma     bool                `json:"figma"`
+	Services  []authServiceStatus `json:"services"`
 }
 
-// AuthStatusHandle returns json for which services are signed in
+// AuthStatusHandle returns json for which services are signed in, and the accounts they are signed in as
 func AuthStatusHandle(w http.ResponseWriter, r *http.Request) {
-	WithSessionMgr(func(ctx context.Context, w http.ResponseWriter, r *http.Request, sessmgr session.Manager) {
-		data := &authStatusData{}
-		defer writeJSON(w, data)
+	WithViewer(func(ctx context.Context, v *Viewer, w http.ResponseWriter, r *http.Request) {
+		data := &authStatusData{Services: []authServiceStatus{}}
 
-		sess := sessmgr.Get(r)
-		if sess == nil {
+		if v.sess == nil {
 			data.Session = false
+			writeJSON(w, data)
 			return
 		}
 
 		data.Session = true
+		data.CSRFToken = v.CSRFToken()
+
+		services, err := NewUserAccountsRepo(ctx).ListConnectedServices(v.UserAccountKey())
+		if err != nil {
+			writeErrorJSON(w, err)
+			return
+		}
 
-		gitHubClient := GetGitHubClientFromSession(ctx, sess)
-		data.GitHub = gitHubClient != nil
+		servicesByProvider := make(map[string]ConnectedService, len(services))
+		for _, service := range services {
+			servicesByProvider[service.ProviderID] = service
+		}
 
-		trelloClient := GetTrelloClientFromSession(ctx, sess)
-		data.Trello = trelloClient != nil
+		for _, provider := range OAuthProviders() {
+			status := authServiceStatus{
+				Provider: provider.ID(),
+				Name:     provider.Name(),
+				Scopes:   []string{},
+			}
 
-		figmaAPI := GetFigmaAPIFromSession(ctx, sess)
-		data.Figma = figmaAPI != nil
+			if service, ok := servicesByProvider[provider.ID()]; ok {
+				status.Connected = true
+				status.AccountLogin = service.AccountLogin
+				if service.Scopes != nil {
+					status.Scopes = service.Scopes
+				}
+				if !service.Expiry.IsZero() {
+					expiry := service.Expiry
+					status.Expiry = &expiry
+				}
+			}
+
+			data.Services = append(data.Services, status)
+		}
+
+		data.GitHub = servicesByProvider[gitHubOAuthProvider.ID()].ProviderID != ""
+		data.GitLab = servicesByProvider[gitLabOAuthProvider.ID()].ProviderID != ""
+		data.Trello = servicesByProvider[trelloOAuthProvider.ID()].ProviderID != ""
+		data.Figma = servicesByProvider[figmaOAuthProvider.ID()].ProviderID != ""
+
+		writeJSON(w, data)
 	})(w, r)
 }
 
 // SignOutHandle destroys the current session, if there is one
 func SignOutHandle(w http.ResponseWriter, r *http.Request) {
 	WithSessionMgr(func(ctx context.Context, w http.ResponseWriter, r *http.Request, sessmgr session.Manager) {
-		data := &authStatusData{}
-		defer writeJSON(w, data)
-
 		sess := sessmgr.Get(r)
-		sessmgr.Remove(sess, w)
+		if sess != nil {
+			err := verifyCSRFToken(sess, r)
+			if err != nil {
+				writeErrorJSONWithStatus(w, http.StatusForbidden, err)
+				return
+			}
+
+			sessmgr.Remove(sess, w)
+		}
+
+		// Forms are sent back to the dashboard, while scripts that sent the header get the new status
+		if r.Header.Get(csrfTokenHeader) == "" {
+			http.Redirect(w, r, "/", http.StatusFound)
+			return
+		}
+
+		writeJSON(w, &authStatusData{Services: []authServiceStatus{}})
 	})(w, r)
 }
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+)
+
+const (
+	storageCollectPath = "/_storage/collect"
+
+	defaultBlobCollectionGracePeriod = 7 * 24 * time.Hour
+	defaultBlobCollectionMaxSwept    = 1000
+
+	// blobCollectionInterval is how often a run starts. Cron requests continue it a page at a time until it finishes.
+	blobCollectionInterval = 24 * time.Hour
+	// blobCollectionRequestBudget is how long one request spends on a run, well within its deadline
+	blobCollectionRequestBudget = 4 * time.Minute
+	// blobCollectionClaimDuration outlasts a request, so one that stopped without saving only holds up the run until the next cron request
+	blobCollectionClaimDuration = 8 * time.Minute
+
+	blobCollectionBlobsPageSize = 200
+	blobCollectionPostsPageSize = 100
+	blobCollectionSweepPageSize = 100
+
+	// A run scans blobs, marking those not linked to, then scans posts for links to marked blobs made before StorageKeys were indexed,
+	// then sweeps blobs marked for longer than the grace period
+	blobCollectionPhaseBlobs = "blobs"
+	blobCollectionPhasePosts = "posts"
+	blobCollectionPhaseSweep = "sweep"
+)
+
+// collectableBlobKeyPrefixes are where blobs that can be garbage collected are kept.
+// Post content offloaded before it was kept under posts/ is never collected, nor is anything else sharing the bucket.
+var collectableBlobKeyPrefixes = []string{
+	"mediaType/",
+	"uploads/",
+	postContentStorageKeyPrefix,
+}
+
+// BlobCollectionOptions changes how garbage collection of unreferenced blobs runs
+type BlobCollectionOptions struct {
+	// GracePeriod is how long a blob must stay unreferenced before it is swept
+	GracePeriod time.Duration
+	// MaxSwept is the most blobs swept in one run, with the rest left for the next
+	MaxSwept int
+	// DryRun reports what would be swept without changing anything
+	DryRun bool
+}
+
+// DefaultBlobCollectionOptions reads STORAGE_GC_GRACE_PERIOD and STORAGE_GC_MAX_SWEPT
+func DefaultBlobCollectionOptions() BlobCollectionOptions {
+	options := BlobCollectionOptions{
+		GracePeriod: defaultBlobCollectionGracePeriod,
+		MaxSwept:    defaultBlobCollectionMaxSwept,
+	}
+
+	if gracePeriod, err := time.ParseDuration(os.Getenv("STORAGE_GC_GRACE_PERIOD")); err == nil && gracePeriod > 0 {
+		options.GracePeriod = gracePeriod
+	}
+
+	if maxSwept, err := strconv.Atoi(os.Getenv("STORAGE_GC_MAX_SWEPT")); err == nil && maxSwept > 0 {
+		options.MaxSwept = maxSwept
+	}
+
+	return options
+}
+
+// referencedBlobKeysPage finds the blobs that a page of posts refer to, continuing from a cursor if it is not empty.
+// It returns the cursor of the next page, which is empty after the last.
+func referencedBlobKeysPage(ctx context.Context, cursor string, limit int) ([]string, string, error) {
+	q := datastore.NewQuery(postType).Limit(limit)
+	if cursor != "" {
+		decodedCursor, err := datastore.DecodeCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		q = q.Start(decodedCursor)
+	}
+
+	var referenced []string
+	count := 0
+	t := q.Run(ctx)
+	for {
+		var post Post
+		_, err := t.Next(&post)
+		if err == datastore.Done {
+			break
+		}
+		if err != nil {
+			return nil, "", err
+		}
+
+		count++
+		referenced = append(referenced, postStorageKeys(ctx, &post)...)
+	}
+
+	if count < limit {
+		return referenced, "", nil
+	}
+
+	nextCursor, err := t.Cursor()
+	if err != nil {
+		return nil, "", err
+	}
+
+	return referenced, nextCursor.String(), nil
+}
+
+// isBlobLinkedFromPost is whether a post’s StorageKeys include the blob
+func isBlobLinkedFromPost(ctx context.Context, blobKey string) (bool, error) {
+	postKeys, err := postKeysLinkingToStorage(ctx, blobKey, 1)
+	return len(postKeys) > 0, err
+}
+
+// scanBlobsPage marks the blobs in the next page that no post links to, and unmarks those that one now does
+func scanBlobsPage(ctx context.Context, store BlobStore, storageRepo *StorageRepo, run *BlobCollectionRun) error {
+	var page []*BlobAttrs
+	nextPageToken, err := store.ListPage(ctx, collectableBlobKeyPrefixes[run.PrefixIndex], run.PageToken, blobCollectionBlobsPageSize, func(attrs *BlobAttrs) error {
+		page = append(page, attrs)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	blobKeys := make([]string, 0, len(page))
+	for _, attrs := range page {
+		blobKeys = append(blobKeys, attrs.Key)
+	}
+
+	marks, err := storageRepo.getUnreferencedBlobs(blobKeys)
+	if err != nil {
+		return err
+	}
+
+	newMarks := make(map[string]UnreferencedBlob)
+	var unmarkKeys []string
+	for _, attrs := range page {
+		run.ScannedCount++
+		run.ScannedBytes += attrs.Size
+
+		_, marked := marks[attrs.Key]
+
+		linked, err := isBlobLinkedFromPost(ctx, attrs.Key)
+		if err != nil {
+			return err
+		}
+		if linked {
+			run.ReferencedCount++
+			if marked {
+				unmarkKeys = append(unmarkKeys, attrs.Key)
+			}
+			continue
+		}
+
+		// Blobs already marked are swept, or not, once posts have been scanned
+		if !marked {
+			newMarks[attrs.Key] = UnreferencedBlob{MarkedAt: time.Now().UTC(), Size: attrs.Size}
+			run.MarkedCount++
+		}
+	}
+
+	err = storageRepo.markUnreferencedBlobs(newMarks)
+	if err != nil {
+		return err
+	}
+
+	err = storageRepo.unmarkUnreferencedBlobs(unmarkKeys)
+	if err != nil {
+		return err
+	}
+
+	run.PageToken = nextPageToken
+	if nextPageToken == "" {
+		run.PrefixIndex++
+		if run.PrefixIndex >= len(collectableBlobKeyPrefixes) {
+			run.PrefixIndex = 0
+			run.Phase = blobCollectionPhasePosts
+		}
+	}
+
+	return nil
+}
+
+// scanPostsPage unmarks the blobs that the next page of posts refer to
+func scanPostsPage(ctx context.Context, storageRepo *StorageRepo, run *BlobCollectionRun) error {
+	referenced, nextCursor, err := referencedBlobKeysPage(ctx, run.Cursor, blobCollectionPostsPageSize)
+	if err != nil {
+		return err
+	}
+
+	marks, err := storageRepo.getUnreferencedBlobs(referenced)
+	if err != nil {
+		return err
+	}
+
+	unmarkKeys := make([]string, 0, len(marks))
+	for blobKey := range marks {
+		unmarkKeys = append(unmarkKeys, blobKey)
+	}
+
+	err = storageRepo.unmarkUnreferencedBlobs(unmarkKeys)
+	if err != nil {
+		return err
+	}
+	run.ReferencedCount += len(unmarkKeys)
+
+	run.Cursor = nextCursor
+	if nextCursor == "" {
+		run.Phase = blobCollectionPhaseSweep
+	}
+
+	return nil
+}
+
+// sweepPage deletes the blobs in the next page of those marked for longer than the grace period.
+// Each is checked again just before it is deleted, as posts can link to it while the run goes on.
+func sweepPage(ctx context.Context, store BlobStore, storageRepo *StorageRepo, run *BlobCollectionRun, options BlobCollectionOptions) error {
+	blobKeys, marks, nextCursor, err := storageRepo.listUnreferencedBlobsMarkedBefore(run.StartedAt.Add(-options.GracePeriod), run.Cursor, blobCollectionSweepPageSize)
+	if err != nil {
+		return err
+	}
+
+	for i, blobKey := range blobKeys {
+		if run.SweptCount >= options.MaxSwept {
+			// The rest are left for the next run
+			nextCursor = ""
+			break
+		}
+
+		mark := marks[i]
+
+		linked, err := isBlobLinkedFromPost(ctx, blobKey)
+		if err != nil {
+			return err
+		}
+		if linked {
+			run.ReferencedCount++
+			if !options.DryRun {
+				err = storageRepo.unmarkUnreferencedBlobs([]string{blobKey})
+				if err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if !options.DryRun {
+			err = storageRepo.claimUnreferencedBlobForSweep(blobKey, mark)
+			if err == errUnreferencedBlobChanged {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			err = store.Delete(ctx, blobKey)
+			if err == errBlobNotFound {
+				// Deleted some other way, so only its mark needed removing
+				continue
+			}
+			if err != nil {
+				log.Errorf(ctx, "Could not sweep unreferenced blob %s: %v", blobKey, err)
+				run.ErrorCount++
+
+				// Marked again as it was, so the next run tries again
+				err = storageRepo.markUnreferencedBlobs(map[string]UnreferencedBlob{blobKey: mark})
+				if err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		run.SweptCount++
+		run.ReclaimedBytes += mark.Size
+		run.Swept = append(run.Swept, SweptBlob{Key: blobKey, Size: mark.Size, MarkedAt: mark.MarkedAt})
+	}
+
+	run.Cursor = nextCursor
+	if nextCursor == "" {
+		run.Phase = ""
+	}
+
+	return nil
+}
+
+// continueBlobCollectionRun works through the run’s phases a page at a time, until it finishes or the deadline passes
+func continueBlobCollectionRun(ctx context.Context, store BlobStore, storageRepo *StorageRepo, run *BlobCollectionRun, options BlobCollectionOptions, deadline time.Time) error {
+	for run.Phase != "" && time.Now().Before(deadline) {
+		var err error
+		switch run.Phase {
+		case blobCollectionPhaseBlobs:
+			err = scanBlobsPage(ctx, store, storageRepo, run)
+		case blobCollectionPhasePosts:
+			err = scanPostsPage(ctx, storageRepo, run)
+		case blobCollectionPhaseSweep:
+			err = sweepPage(ctx, store, storageRepo, run, options)
+		default:
+			return fmt.Errorf("Unknown blob collection phase %q", run.Phase)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CollectUnreferencedBlobs marks blobs that no post refers to, and sweeps those that have stayed marked for the grace period.
+// Blobs are only marked once a run has seen them, so anything uploaded and not yet posted has the grace period to be used.
+// A run starts once a day and is continued by each request until it finishes, with its progress kept in between.
+// A dry run only reports what would be swept of the blobs already marked, without changing anything.
+func CollectUnreferencedBlobs(ctx context.Context, now time.Time, options BlobCollectionOptions) (*BlobCollectionRun, error) {
+	deadline := time.Now().Add(blobCollectionRequestBudget)
+
+	store, err := SharedBlobStore(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	storageRepo := NewStorageRepo(ctx)
+
+	if options.DryRun {
+		run := &BlobCollectionRun{
+			StartedAt: now,
+			DryRun:    true,
+			Phase:     blobCollectionPhaseSweep,
+			Swept:     []SweptBlob{},
+		}
+
+		err := continueBlobCollectionRun(ctx, store, &storageRepo, run, options, deadline)
+		if err != nil {
+			return nil, err
+		}
+
+		run.FinishedAt = time.Now().UTC()
+		return run, nil
+	}
+
+	run, err := storageRepo.claimBlobCollectionRun(now, blobCollectionInterval, blobCollectionClaimDuration)
+	if err != nil {
+		return nil, err
+	}
+
+	err = continueBlobCollectionRun(ctx, store, &storageRepo, run, options, deadline)
+	if err != nil {
+		// What was done is kept, and the next request tries the failed page again
+		if saveErr := storageRepo.saveBlobCollectionRun(run); saveErr != nil {
+			log.Errorf(ctx, "Could not save blob collection run: %v", saveErr)
+		}
+		return nil, err
+	}
+
+	if run.Phase == "" {
+		run.FinishedAt = time.Now().UTC()
+
+		log.Infof(ctx, "Blob collection scanned %d blobs of %d bytes, %d referenced, %d marked, swept %d reclaiming %d bytes, with %d errors",
+			run.ScannedCount, run.ScannedBytes, run.ReferencedCount, run.MarkedCount, run.SweptCount, run.ReclaimedBytes, run.ErrorCount)
+	}
+
+	err = storageRepo.saveBlobCollectionRun(run)
+	if err != nil {
+		return nil, err
+	}
+
+	return run, nil
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/appengine/log"
+)
+
+const (
+	// storageURLLifetime is how long signed URLs work for at least.
+	// Expiry is rounded up to a whole lifetime, so pages rendered close together share URLs and the browser’s cache.
+	storageURLLifetime = time.Hour
+	// storageURLMinSecretBytes is the shortest secret accepted in STORAGE_URL_SIGNING_KEYS
+	storageURLMinSecretBytes  = 32
+	storageURLKeyIDCharacters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_"
+)
+
+var (
+	errNoStorageURLSigningKey = errors.New("STORAGE_URL_SIGNING_KEYS must be set to id:secret pairs, with secrets of at least 32 base64-encoded bytes")
+	errStorageURLExpired      = errors.New("This link to stored content has expired. Reload the page it was on to get a new one.")
+	errStorageURLSignature    = errors.New("This link to stored content is not valid")
+	errStorageReadNotSignedIn = errors.New("Sign in, or use a signed link, to read stored content")
+)
+
+// storageURLSignatureParams are the query parameters signStorageURL adds
+var storageURLSignatureParams = []string{"expires", "keyID", "signature"}
+
+type storageURLSigningKey struct {
+	id     string
+	secret []byte
+}
+
+// storageURLSigningKeys reads STORAGE_URL_SIGNING_KEYS, a comma-separated list of id:secret pairs.
+// The first key signs new URLs while all are accepted, so keys can be rotated by adding a new one first,
+// and removing the old one once URLs signed with it have expired.
+func storageURLSigningKeys() ([]storageURLSigningKey, error) {
+	var keys []storageURLSigningKey
+	for _, pair := range strings.Split(os.Getenv("STORAGE_URL_SIGNING_KEYS"), ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || strings.Trim(parts[0], storageURLKeyIDCharacters) != "" {
+			return nil, errNoStorageURLSigningKey
+		}
+
+		secret, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil || len(secret) < storageURLMinSecretBytes {
+			return nil, errNoStorageURLSigningKey
+		}
+
+		keys = append(keys, storageURLSigningKey{id: parts[0], secret: secret})
+	}
+
+	if len(keys) == 0 {
+		return nil, errNoStorageURLSigningKey
+	}
+
+	return keys, nil
+}
+
+func storageURLSignature(secret []byte, path string, expires int64) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(path + "\n" + strconv.FormatInt(expires, 10)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// signStorageURL adds an expiry and a signature of the path and expiry to the path
+func signStorageURL(path string, now time.Time) (string, error) {
+	keys, err := storageURLSigningKeys()
+	if err != nil {
+		return "", err
+	}
+
+	lifetime := int64(storageURLLifetime / time.Second)
+	expires := (now.Unix()/lifetime + 2) * lifetime
+
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expires, 10))
+	query.Set("keyID", keys[0].id)
+	query.Set("signature", storageURLSignature(keys[0].secret, path, expires))
+
+	return path + "?" + query.Encode(), nil
+}
+
+// signedStorageContentURL is the content address signed to be readable by anyone who is shown it, until it expires
+func signedStorageContentURL(ctx context.Context, mediaType string, sha256Hex string) string {
+	contentURL := storageContentURL(mediaType, sha256Hex)
+
+	signedURL, err := signStorageURL(contentURL, time.Now())
+	if err != nil {
+		// Still readable by those who can read a post linking to it
+		log.Warningf(ctx, "Could not sign storage URL: %v", err)
+		return contentURL
+	}
+
+	return signedURL
+}
+
+// storageContentLinkPattern finds content addresses in text, along with the signature they were given before if any
+var storageContentLinkPattern = regexp.MustCompile(storageContentURLPattern.String() + `(?:\?expires=[0-9]+&keyID=[A-Za-z0-9_-]+&signature=[A-Za-z0-9_-]+)?`)
+
+type signsStorageURLsKey struct{}
+
+// ContextSigningStorageURLsFor signs links to stored content in what is rendered with the context, if the viewer can read it without them.
+// Others are shown links that are not signed, so a signed link never lets anyone read what they could not already.
+func ContextSigningStorageURLsFor(ctx context.Context, v *Viewer) context.Context {
+	if !v.CanReadLinkedStorage() {
+		return ctx
+	}
+	return contextSigningStorageURLs(ctx)
+}
+
+func contextSigningStorageURLs(ctx context.Context) context.Context {
+	return context.WithValue(ctx, signsStorageURLsKey{}, true)
+}
+
+func signsStorageURLs(ctx context.Context) bool {
+	signs, _ := ctx.Value(signsStorageURLsKey{}).(bool)
+	return signs
+}
+
+// signStorageURLsIn signs each content address in the text, such as a post’s Markdown, so whoever is shown it can read the content.
+// The text is left as it is unless the context is from ContextSigningStorageURLsFor a viewer who can read the content.
+func signStorageURLsIn(ctx context.Context, text string) string {
+	if !signsStorageURLs(ctx) || !strings.Contains(text, "/1/storage/") {
+		return text
+	}
+
+	return storageContentLinkPattern.ReplaceAllStringFunc(text, func(link string) string {
+		match := storageContentURLPattern.FindStringSubmatch(link)
+		mediaType, sha256Hex := match[1], match[2]
+		if checkStorableMediaType(mediaType) != nil {
+			return link
+		}
+
+		return signedStorageContentURL(ctx, mediaType, sha256Hex)
+	})
+}
+
+// signStorageURLsInPost signs the content addresses in the post and its replies, before it is responded with
+func signStorageURLsInPost(ctx context.Context, post *Post) {
+	post.Content.Source = signStorageURLsIn(ctx, post.Content.Source)
+
+	if post.Replies != nil {
+		replies := *post.Replies
+		for i := range replies {
+			signStorageURLsInPost(ctx, &replies[i])
+		}
+	}
+}
+
+// hasStorageURLSignature is whether the request is for a signed URL, whether its signature is valid or not
+func hasStorageURLSignature(r *http.Request) bool {
+	query := r.URL.Query()
+	for _, param := range storageURLSignatureParams {
+		if query.Get(param) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyStorageURLSignature checks the request is for a signed URL that has not expired, returning when it expires
+func verifyStorageURLSignature(r *http.Request, now time.Time) (time.Time, error) {
+	query := r.URL.Query()
+
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil {
+		return time.Time{}, errStorageURLSignature
+	}
+
+	keys, err := storageURLSigningKeys()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	keyID := query.Get("keyID")
+	signature := query.Get("signature")
+	for _, key := range keys {
+		if key.id != keyID {
+			continue
+		}
+
+		if !hmac.Equal([]byte(signature), []byte(storageURLSignature(key.secret, r.URL.Path, expires))) {
+			return time.Time{}, errStorageURLSignature
+		}
+
+		expiresAt := time.Unix(expires, 0)
+		if !now.Before(expiresAt) {
+			return time.Time{}, errStorageURLExpired
+		}
+
+		return expiresAt, nil
+	}
+
+	// Signed with a key that has since been removed
+	return time.Time{}, errStorageURLExpired
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func setTestStorageURLSigningKeys() func() {
+	secret := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("s", storageURLMinSecretBytes)))
+	os.Setenv("STORAGE_URL_SIGNING_KEYS", "test:"+secret)
+	return func() { os.Unsetenv("STORAGE_URL_SIGNING_KEYS") }
+}
+
+func TestSignStorageURLsIn(t *testing.T) {
+	defer setTestStorageURLSigningKeys()()
+
+	sha256Hex := strings.Repeat("ab", 32)
+	contentURL := "/1/storage/image/png/sha256/" + sha256Hex
+	oldSignedURL := contentURL + "?expires=1530000000&keyID=old&signature=abc-_123"
+	unstorableURL := "/1/storage/text/html/sha256/" + sha256Hex
+
+	text := "![Diagram](" + contentURL + ")\n[Again](" + oldSignedURL + ") and " + unstorableURL
+	signed := signStorageURLsIn(contextSigningStorageURLs(context.Background()), text)
+
+	if strings.Contains(signed, "keyID=old") {
+		t.Errorf("signStorageURLsIn() kept the old signature:\n%s", signed)
+	}
+	if !strings.HasSuffix(signed, " and "+unstorableURL) {
+		t.Errorf("signStorageURLsIn() changed a URL to content that cannot be stored:\n%s", signed)
+	}
+
+	links := storageContentLinkPattern.FindAllString(signed, -1)
+	if len(links) != 3 {
+		t.Fatalf("Found links %v in:\n%s", links, signed)
+	}
+	for _, link := range links[:2] {
+		if !strings.HasPrefix(link, contentURL+"?") {
+			t.Errorf("Link %s is not to the content", link)
+			continue
+		}
+
+		r := httptest.NewRequest("GET", link, nil)
+		if _, err := verifyStorageURLSignature(r, time.Now()); err != nil {
+			t.Errorf("Link %s = %v, want a valid signature", link, err)
+		}
+	}
+}
+
+func TestSignStorageURLsInPost(t *testing.T) {
+	defer setTestStorageURLSigningKeys()()
+
+	contentURL := "/1/storage/image/png/sha256/" + strings.Repeat("cd", 32)
+	post := Post{
+		Content: NewMarkdownDocument("See " + contentURL),
+		Replies: &[]Post{
+			{Content: NewMarkdownDocument("Also " + contentURL)},
+		},
+	}
+
+	signStorageURLsInPost(contextSigningStorageURLs(context.Background()), &post)
+
+	if !strings.HasPrefix(post.Content.Source, "See "+contentURL+"?expires=") {
+		t.Errorf("Post content = %s, want its link signed", post.Content.Source)
+	}
+	if reply := (*post.Replies)[0]; !strings.HasPrefix(reply.Content.Source, "Also "+contentURL+"?expires=") {
+		t.Errorf("Reply content = %s, want its link signed", reply.Content.Source)
+	}
+}
+
+func TestSignStorageURLsOnlyForViewersWhoCanRead(t *testing.T) {
+	defer setTestStorageURLSigningKeys()()
+
+	text := "![Diagram](/1/storage/image/png/sha256/" + strings.Repeat("ef", 32) + ")"
+
+	anonymous := NewViewer(context.Background(), nil)
+	if signed := signStorageURLsIn(ContextSigningStorageURLsFor(context.Background(), anonymous), text); signed != text {
+		t.Errorf("Signed for an anonymous viewer:\n%s", signed)
+	}
+
+	if signed := signStorageURLsIn(context.Background(), text); signed != text {
+		t.Errorf("Signed without a viewer:\n%s", signed)
+	}
+
+	// The key of an account in the app dev~test, as it cannot be made without App Engine
+	signedIn := NewViewer(context.Background(), &testSession{attrs: map[string]interface{}{
+		userAccountKeySessionKey: "aghkZXZ-dGVzdHIRCxILVXNlckFjY291bnQYAQw",
+	}})
+	if signed := signStorageURLsIn(ContextSigningStorageURLsFor(context.Background(), signedIn), text); !strings.Contains(signed, "?expires=") {
+		t.Errorf("Not signed for a signed in viewer:\n%s", signed)
+	}
+}
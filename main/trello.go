@@ -2,28 +2,267 @@ package main
 
 import (
 	"context"
-	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/icza/session"
 	"github.com/mrjones/oauth"
-	"google.golang.org/appengine"
 	"google.golang.org/appengine/urlfetch"
 	// "google.golang.org/appengine/log"
 )
 
 const (
-	trelloScope           = "read,write"
-	trelloRequestTokenKey = "trelloRequestToken"
-	trelloAccessTokenKey  = "trelloAccessToken"
+	trelloScope = "read,write"
+
+	trelloAPIBaseURL = "https://api.trello.com"
 )
 
+// TrelloAPI allows retrieving data from the Trello API
+type TrelloAPI struct {
+	client *http.Client
+}
+
+// TrelloLabel is a colored label on a card
+type TrelloLabel struct {
+	Name  string `json:"name"`
+	Color string `json:"color"`
+}
+
+// TrelloCard is a card within a list
+type TrelloCard struct {
+	ID          string        `json:"id"`
+	Name        string        `json:"name"`
+	Desc        string        `json:"desc,omitempty"`
+	URL         string        `json:"url"`
+	Due         *time.Time    `json:"due"`
+	DueComplete bool          `json:"dueComplete"`
+	IDList      string        `json:"idList"`
+	Labels      []TrelloLabel `json:"labels"`
+	List        *TrelloList   `json:"list,omitempty"`
+	Board       *TrelloBoard  `json:"board,omitempty"`
+}
+
+// TrelloList is a column of cards on a board
+type TrelloList struct {
+	ID    string       `json:"id"`
+	Name  string       `json:"name"`
+	Cards []TrelloCard `json:"cards,omitempty"`
+}
+
+// TrelloBoard is a board of lists
+type TrelloBoard struct {
+	ID     string       `json:"id"`
+	Name   string       `json:"name"`
+	Desc   string       `json:"desc,omitempty"`
+	URL    string       `json:"url"`
+	Closed bool         `json:"closed"`
+	Lists  []TrelloList `json:"lists,omitempty"`
+	Cards  []TrelloCard `json:"cards,omitempty"`
+}
+
+const trelloCardFields = "name,desc,url,due,dueComplete,idList,labels"
+
+func (trello *TrelloAPI) getJSON(path string, query url.Values, out interface{}) error {
+	res, err := trello.client.Get(trelloAPIBaseURL + path + "?" + query.Encode())
+	if err != nil {
+		return errors.New("Unable to communicate with Trello. " + err.Error())
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("Trello responded with %d: %s", res.StatusCode, strings.TrimSpace(string(message)))
+	}
+
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+func (trello *TrelloAPI) postForm(path string, form url.Values, out interface{}) error {
+	res, err := trello.client.PostForm(trelloAPIBaseURL+path, form)
+	if err != nil {
+		return errors.New("Unable to communicate with Trello. " + err.Error())
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("Trello responded with %d: %s", res.StatusCode, strings.TrimSpace(string(message)))
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+// trelloIDFromURL accepts an ID, or a board or card URL such as https://trello.com/b/{shortLink}/name
+func trelloIDFromURL(idOrURL string) string {
+	idOrURL = strings.TrimSpace(idOrURL)
+	u, err := url.Parse(idOrURL)
+	if err != nil || u.Host == "" {
+		return idOrURL
+	}
+
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) >= 2 && (parts[0] == "b" || parts[0] == "c") {
+		return parts[1]
+	}
+
+	return idOrURL
+}
+
+// Boards lists the open boards of the signed in member
+func (trello *TrelloAPI) Boards() ([]TrelloBoard, error) {
+	query := url.Values{}
+	query.Set("filter", "open")
+	query.Set("fields", "name,desc,url,closed")
+
+	var boards []TrelloBoard
+	err := trello.getJSON("/1/members/me/boards", query, &boards)
+	return boards, err
+}
+
+// BoardsWithLists lists the open boards of the signed in member, each with their open lists but no cards
+func (trello *TrelloAPI) BoardsWithLists() ([]TrelloBoard, error) {
+	query := url.Values{}
+	query.Set("filter", "open")
+	query.Set("fields", "name,url,closed")
+	query.Set("lists", "open")
+
+	var boards []TrelloBoard
+	err := trello.getJSON("/1/members/me/boards", query, &boards)
+	return boards, err
+}
+
+// Board loads a board with its open lists, each with their open cards
+func (trello *TrelloAPI) Board(idOrURL string) (*TrelloBoard, error) {
+	id := trelloIDFromURL(idOrURL)
+	if id == "" {
+		return nil, errors.New("Trello board cannot be empty")
+	}
+
+	query := url.Values{}
+	query.Set("fields", "name,desc,url,closed")
+	query.Set("lists", "open")
+	query.Set("cards", "open")
+	query.Set("card_fields", trelloCardFields)
+
+	var board TrelloBoard
+	err := trello.getJSON("/1/boards/"+url.PathEscape(id), query, &board)
+	if err != nil {
+		return nil, err
+	}
+
+	listIndexes := make(map[string]int, len(board.Lists))
+	for i, list := range board.Lists {
+		listIndexes[list.ID] = i
+		board.Lists[i].Cards = []TrelloCard{}
+	}
+	for _, card := range board.Cards {
+		if i, ok := listIndexes[card.IDList]; ok {
+			board.Lists[i].Cards = append(board.Lists[i].Cards, card)
+		}
+	}
+	board.Cards = nil
+
+	return &board, nil
+}
+
+// Card loads a card with its list and board
+func (trello *TrelloAPI) Card(idOrURL string) (*TrelloCard, error) {
+	id := trelloIDFromURL(idOrURL)
+	if id == "" {
+		return nil, errors.New("Trello card cannot be empty")
+	}
+
+	query := url.Values{}
+	query.Set("fields", trelloCardFields)
+	query.Set("list", "true")
+	query.Set("board", "true")
+	query.Set("board_fields", "name,url")
+
+	var card TrelloCard
+	err := trello.getJSON("/1/cards/"+url.PathEscape(id), query, &card)
+	if err != nil {
+		return nil, err
+	}
+
+	return &card, nil
+}
+
+// CreateCard adds a card to the bottom of a list
+func (trello *TrelloAPI) CreateCard(listID string, name string, desc string) (*TrelloCard, error) {
+	if strings.TrimSpace(listID) == "" {
+		return nil, errors.New("Trello list cannot be empty")
+	}
+
+	form := url.Values{}
+	form.Set("idList", listID)
+	form.Set("name", name)
+	form.Set("desc", desc)
+	form.Set("pos", "bottom")
+
+	var card TrelloCard
+	err := trello.postForm("/1/cards", form, &card)
+	if err != nil {
+		return nil, err
+	}
+
+	return &card, nil
+}
+
+// AddCommentToCard comments on a card as the signed in member
+func (trello *TrelloAPI) AddCommentToCard(cardID string, text string) error {
+	form := url.Values{}
+	form.Set("text", text)
+
+	return trello.postForm("/1/cards/"+url.PathEscape(cardID)+"/actions/comments", form, nil)
+}
+
+// SearchCards finds cards matching the query, with their lists and boards
+func (trello *TrelloAPI) SearchCards(searchQuery string, limit int) ([]TrelloCard, error) {
+	if strings.TrimSpace(searchQuery) == "" {
+		return nil, errors.New("Trello search query cannot be empty")
+	}
+
+	query := url.Values{}
+	query.Set("query", searchQuery)
+	query.Set("modelTypes", "cards")
+	query.Set("cards_limit", strconv.Itoa(limit))
+	query.Set("card_fields", trelloCardFields)
+	query.Set("card_list", "true")
+	query.Set("card_board", "true")
+	query.Set("board_fields", "name,url")
+
+	var result struct {
+		Cards []TrelloCard `json:"cards"`
+	}
+	err := trello.getJSON("/1/search", query, &result)
+	return result.Cards, err
+}
+
+var trelloOAuthProvider = &OAuth1Provider{
+	id:          "trello",
+	name:        "Trello",
+	callbackURL: os.Getenv("TRELLO_REDIRECT_URL"),
+	scopes:      strings.Split(trelloScope, ","),
+	consumer:    makeTrelloConsumer,
+	identify:    identifyTrelloMember,
+	revoke:      revokeTrelloToken,
+}
+
 func init() {
-	gob.Register(oauth.RequestToken{})
-	gob.Register(oauth.AccessToken{})
+	registerOAuthProvider(trelloOAuthProvider)
 }
 
 func makeTrelloConsumer(ctx context.Context) *oauth.Consumer {
@@ -45,74 +284,68 @@ func makeTrelloConsumer(ctx context.Context) *oauth.Consumer {
 	return consumer
 }
 
-func trelloOauthStartHandle(w http.ResponseWriter, r *http.Request) {
-	ctx := appengine.NewContext(r)
+// identifyTrelloMember reads the signed in member’s ID and username
+func identifyTrelloMember(ctx context.Context, client *http.Client) (string, string, error) {
+	var member struct {
+		ID       string `json:"id"`
+		Username string `json:"username"`
+	}
+	err := getOAuthJSON(client, trelloAPIBaseURL+"/1/members/me?fields=id,username", &member)
+	if err != nil {
+		return "", "", err
+	}
 
-	consumer := makeTrelloConsumer(ctx)
+	return member.ID, member.Username, nil
+}
 
-	requestToken, url, err := consumer.GetRequestTokenAndUrl(os.Getenv("TRELLO_REDIRECT_URL"))
+// revokeTrelloToken deletes the token, so Trello no longer lists it as authorized
+func revokeTrelloToken(ctx context.Context, client *http.Client, accessToken *oauth.AccessToken) error {
+	req, err := http.NewRequest("DELETE", trelloAPIBaseURL+"/1/tokens/"+url.PathEscape(accessToken.Token), nil)
 	if err != nil {
-		http.Error(w, "Could not sign into Trello "+err.Error(), http.StatusFailedDependency)
-		return
+		return err
 	}
 
-	sessmgr := GetSessionManager(ctx)
-	defer sessmgr.Close()
+	res, err := client.Do(req)
+	if err != nil {
+		return errors.New("Unable to communicate with Trello. " + err.Error())
+	}
+	defer res.Body.Close()
 
-	sess := sessmgr.Get(r)
-	if sess != nil {
-		sess.SetAttr(trelloRequestTokenKey, requestToken)
-	} else {
-		sess = session.NewSessionOptions(&session.SessOptions{
-			CAttrs: map[string]interface{}{},
-			Attrs:  map[string]interface{}{trelloRequestTokenKey: requestToken},
-		})
-		sessmgr.Add(sess, w)
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("Trello responded with %d when revoking token", res.StatusCode)
 	}
 
-	http.Redirect(w, r, url, 302)
+	return nil
 }
 
-func trelloOauthCallbackHandle(w http.ResponseWriter, r *http.Request) {
-	ctx := appengine.NewContext(r)
-
-	sessmgr := GetSessionManager(ctx)
-	defer sessmgr.Close()
-
-	sess := sessmgr.Get(r)
-	if sess == nil {
-		http.Error(w, "No session present for signing in. Please try again.", http.StatusExpectationFailed)
-		return
+// GetTrelloAPIFromSession returns a TrelloAPI from a session
+func GetTrelloAPIFromSession(ctx context.Context, sess session.Session) *TrelloAPI {
+	client := GetTrelloClientFromSession(ctx, sess)
+	if client == nil {
+		return nil
 	}
 
-	requestToken, ok := sess.Attr(trelloRequestTokenKey).(oauth.RequestToken)
-	if !ok {
-		http.Error(w, "No request token present for signing into Trello. Please try again.", http.StatusExpectationFailed)
-		return
-	}
+	return &TrelloAPI{client: client}
+}
 
-	verificationCode := r.URL.Query().Get("oauth_verifier")
-	consumer := makeTrelloConsumer(ctx)
-	accessToken, err := consumer.AuthorizeToken(&requestToken, verificationCode)
-	if err != nil {
-		http.Error(w, "Could not get Trello token. Please try again.", http.StatusExpectationFailed)
-		return
+// GetTrelloAPIFromContext returns a TrelloAPI for the viewer whose command param variables are in the context
+func GetTrelloAPIFromContext(ctx context.Context) *TrelloAPI {
+	commandParamVars := CommandParamVariablesFromContext(ctx)
+	if commandParamVars == nil {
+		return nil
 	}
 
-	sess.SetAttr(trelloAccessTokenKey, accessToken)
-
-	afterSignInHandle(w, r)
+	return commandParamVars.TrelloAPI()
 }
 
-// GetTrelloClientFromSession returns a http.Client from a session
+// GetTrelloClientFromSession returns a http.Client for the session’s account
 func GetTrelloClientFromSession(ctx context.Context, sess session.Session) *http.Client {
-	accessToken, ok := sess.Attr(trelloAccessTokenKey).(oauth.AccessToken)
-	if !ok {
+	accessToken := trelloOAuthProvider.AccessToken(ctx, sess)
+	if accessToken == nil {
 		return nil
 	}
 
-	consumer := makeTrelloConsumer(ctx)
-	client, err := consumer.MakeHttpClient(&accessToken)
+	client, err := trelloOAuthProvider.Client(ctx, accessToken)
 	if err != nil {
 		return nil
 	}
@@ -147,49 +380,27 @@ func readProfileHandle(ctx context.Context, w http.ResponseWriter, r *http.Reque
 	w.Write(data)
 }
 
-// func listBoardsHandle(w http.ResponseWriter, r *http.Request) {
-// 	ctx := appengine.NewContext(r)
-
-// 	sessmgr := GetSessionManager(ctx)
-// 	defer sessmgr.Close()
-
-// 	sess := sessmgr.Get(r)
-// 	if sess == nil {
-// 		http.Error(w, "You must first sign in.", http.StatusUnauthorized)
-// 		return
-// 	}
-
-// 	token, ok := sess.Attr(trelloAccessTokenKey).(oauth2.Token)
-// 	if !ok {
-// 		http.Error(w, "You need to sign in with Github.", http.StatusUnauthorized)
-// 		return
-// 	}
-
-// 	client := github.NewClient(oauthCfg.Client(ctx, &token))
-
-// 	user, _, err := client.Users.Get(ctx, "")
-// 	if err != nil {
-// 		http.Error(w, "No GitHub user found. "+err.Error(), http.StatusExpectationFailed)
-// 	}
+func trelloListBoardsHandle(ctx context.Context, v *Viewer, w http.ResponseWriter, r *http.Request) {
+	trello := v.GetTrelloAPI()
+	if trello == nil {
+		http.Error(w, "You need to sign in with Trello.", http.StatusUnauthorized)
+		return
+	}
 
-// 	opt := &github.RepositoryListOptions{Type: "all", Sort: "full_name"}
-// 	repos, _, err := client.Repositories.List(ctx, user.GetLogin(), opt)
-// 	if err != nil {
-// 		writeErrorJSON(w, err)
-// 		return
-// 	}
+	boards, err := trello.Boards()
+	if err != nil {
+		writeErrorJSONWithStatus(w, http.StatusFailedDependency, err)
+		return
+	}
 
-// 	writeJSON(w, repos)
-// }
+	writeJSON(w, boards)
+}
 
-// AddTrelloRoutes adds routes for signing in and reading from GitHub
+// AddTrelloRoutes adds routes for reading from Trello
 func AddTrelloRoutes(r *mux.Router) {
-	r.Path("/signin/trello").Methods("GET").
-		HandlerFunc(trelloOauthStartHandle)
-
-	r.Path("/signin/trello/callback").Methods("GET").
-		HandlerFunc(trelloOauthCallbackHandle)
-
 	r.Path("/trello/profile").Methods("GET").
 		HandlerFunc(WithSessionMgr(readProfileHandle))
+
+	r.Path("/trello/boards").Methods("GET").
+		HandlerFunc(WithViewer(trelloListBoardsHandle))
 }
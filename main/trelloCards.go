@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"google.golang.org/appengine/log"
+)
+
+// Trello allows card names of up to 16384 characters, but shorter ones read better on a board
+const maxTrelloCardNameLength = 200
+
+var (
+	errSignInWithTrello    = errors.New("Sign in with Trello to create cards")
+	errPostHasNoTrelloCard = errors.New("Post has no Trello card")
+)
+
+// TrelloCardStatus is the current state of the Trello card created from a post
+type TrelloCardStatus struct {
+	ID            string `json:"id"`
+	Name          string `json:"name,omitempty"`
+	URL           string `json:"url,omitempty"`
+	ListName      string `json:"listName,omitempty"`
+	BoardName     string `json:"boardName,omitempty"`
+	Due           string `json:"due,omitempty"`
+	MirrorReplies bool   `json:"mirrorReplies"`
+}
+
+// CreateTrelloCardFromPostInput is used to create a Trello card from a post
+type CreateTrelloCardFromPostInput struct {
+	ChannelSlug    string
+	PostKeyEncoded string
+	ListID         string
+	MirrorReplies  bool
+}
+
+// CreateTrelloCardFromPost creates a card from the post using the viewer’s Trello token, and records it on the post
+func CreateTrelloCardFromPost(ctx context.Context, channelsRepo ChannelsRepo, trello *TrelloAPI, input CreateTrelloCardFromPostInput) (*Post, *TrelloCardStatus, error) {
+	if trello == nil {
+		return nil, nil, errSignInWithTrello
+	}
+
+	post, err := channelsRepo.GetPostWithIDInChannel(input.ChannelSlug, input.PostKeyEncoded)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if post.TrelloCardID != "" {
+		return nil, nil, fmt.Errorf("Post already has Trello card %s", post.TrelloCardID)
+	}
+
+	name, desc := post.Content.TitleAndBody()
+	name = truncateText(name, maxTrelloCardNameLength)
+	if name == "" {
+		return nil, nil, errors.New("Post has no content to make a card from")
+	}
+
+	if desc != "" {
+		desc += "\n\n---\n"
+	}
+	desc += "Created from [this post](" + postHTMLURL(ctx, channelsRepo, input.ChannelSlug, post.Key) + ")"
+
+	// Claimed first, so submitting twice cannot create two cards
+	_, err = channelsRepo.ClaimPostForTrelloCard(post.Key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	card, err := trello.CreateCard(input.ListID, name, desc)
+	if err != nil {
+		if releaseErr := channelsRepo.ReleasePostForTrelloCard(post.Key); releaseErr != nil {
+			log.Warningf(ctx, "Could not release post %v for Trello card: %v", post.Key, releaseErr)
+		}
+		return nil, nil, err
+	}
+
+	post, err = channelsRepo.SetTrelloCardForPost(post.Key, card.ID, input.MirrorReplies)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	status, err := GetTrelloCardStatusForPost(trello, post)
+	return post, status, err
+}
+
+// GetTrelloCardStatusForPost loads the card’s current list and due date, returning nil if the post has no card
+func GetTrelloCardStatusForPost(trello *TrelloAPI, post *Post) (*TrelloCardStatus, error) {
+	if post.TrelloCardID == "" {
+		return nil, nil
+	}
+
+	status := TrelloCardStatus{
+		ID:            post.TrelloCardID,
+		MirrorReplies: post.MirrorRepliesToTrello,
+	}
+
+	// Without a token, all that is known is which card it is
+	if trello == nil {
+		return &status, nil
+	}
+
+	card, err := trello.Card(post.TrelloCardID)
+	if err != nil {
+		return &status, err
+	}
+
+	status.Name = card.Name
+	status.URL = card.URL
+	status.Due = formatTrelloDue(card)
+	if card.List != nil {
+		status.ListName = card.List.Name
+	}
+	if card.Board != nil {
+		status.BoardName = card.Board.Name
+	}
+
+	return &status, nil
+}
+
+// CreatePostMirroringReply creates the post, and if it replies to a post with mirroring turned on, comments on that post’s Trello card.
+// Should only mirroring fail, the created post is returned along with the error.
+// Posts are created through this from the API, HTML forms, schedules and webhooks, so every reply is mirrored.
+func CreatePostMirroringReply(ctx context.Context, channelsRepo ChannelsRepo, trello *TrelloAPI, input CreatePostInput) (*Post, error) {
+	post, err := channelsRepo.CreatePost(input)
+	if err != nil {
+		return nil, err
+	}
+
+	return post, MirrorReplyToTrello(ctx, channelsRepo, trello, input.ChannelSlug, post)
+}
+
+// MirrorReplyToTrello comments on the parent post’s Trello card with the reply, if the parent has mirroring turned on
+func MirrorReplyToTrello(ctx context.Context, channelsRepo ChannelsRepo, trello *TrelloAPI, channelSlug string, reply *Post) error {
+	if reply.ParentPostKey == nil {
+		return nil
+	}
+
+	parent, err := channelsRepo.GetPostWithIDInChannel(channelSlug, reply.ParentPostKey.Encode())
+	if err != nil {
+		return err
+	}
+
+	if parent.TrelloCardID == "" || !parent.MirrorRepliesToTrello {
+		return nil
+	}
+
+	if trello == nil {
+		return errors.New("Reply was not added to the Trello card, as you are not signed in with Trello")
+	}
+
+	readPostContentFromStorageIfNeeded(ctx, reply)
+
+	text := reply.Content.Source + "\n\n— [Reply](" + postHTMLURL(ctx, channelsRepo, channelSlug, reply.Key) + ")"
+	return trello.AddCommentToCard(parent.TrelloCardID, text)
+}
@@ -1,10 +1,38 @@
 package main
 
 import (
+	"context"
+
 	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
 )
 
 // IsDev Returns true if in development, otherwise we are deployed somewhere real
 func IsDev() bool {
 	return appengine.IsDevAppServer()
 }
+
+// absoluteHTMLURL makes a path on this app into a full URL that can be linked to from elsewhere
+func absoluteHTMLURL(ctx context.Context, path string) string {
+	scheme := "https"
+	if IsDev() {
+		scheme = "http"
+	}
+
+	return scheme + "://" + appengine.DefaultVersionHostname(ctx) + path
+}
+
+// postHTMLURL makes the full URL of a post’s web page
+func postHTMLURL(ctx context.Context, channelsRepo ChannelsRepo, channelSlug string, postKey *datastore.Key) string {
+	channelViewModel := OrgViewModel{OrgSlug: channelsRepo.orgRepo.orgSlug}.Channel(channelSlug)
+	return absoluteHTMLURL(ctx, channelViewModel.HTMLPostURL(postKey.Encode()))
+}
+
+// truncateText shortens text to at most maxLength characters, ending it with an ellipsis if shortened
+func truncateText(text string, maxLength int) string {
+	runes := []rune(text)
+	if len(runes) <= maxLength {
+		return text
+	}
+	return string(runes[:maxLength-1]) + "…"
+}